@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const anomaliesStateName = "anomalies.json"
+
+// What counts as unusual merging. The bot can push to every repository it
+// serves, so a compromised account asking it to merge is worth noticing.
+const (
+	anomalyWindow    = 24 * time.Hour      // merges are looked at over this long
+	anomalyBaseline  = 30 * 24 * time.Hour // compared with their rate over this long before
+	anomalyRate      = 10                  // times the usual rate that is unusual, or merges in a window for new users
+	anomalyOverrides = 3                   // merges with overrides in a window that are unusual
+)
+
+// An anomaly is an unusual pattern in who merges what when.
+type anomaly struct {
+	Kind   string // "rate", "hours" or "overrides"
+	User   string
+	Repo   string // for anomalies about a single merge
+	PR     int
+	Detail string
+}
+
+// key identifies the anomaly, so it's only reported once.
+func (a anomaly) key() string {
+	return fmt.Sprintf("%s/%s/%s#%d", a.Kind, strings.ToLower(a.User), a.Repo, a.PR)
+}
+
+// oddHours are the hours of the day, in local time, that merges are
+// unusual in; From may be after To to wrap around midnight.
+type oddHours struct {
+	From, To int
+}
+
+// parseOddHours parses hours such as "22-6", with "" for none.
+func parseOddHours(s string) (*oddHours, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("odd hours %q are not on the form FROM-TO", s)
+	}
+	from, err1 := strconv.Atoi(parts[0])
+	to, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || from < 0 || from > 23 || to < 0 || to > 24 || from == to {
+		return nil, fmt.Errorf("odd hours %q are not hours of the day", s)
+	}
+	return &oddHours{From: from, To: to}, nil
+}
+
+func (o *oddHours) contain(t time.Time) bool {
+	h := t.Local().Hour()
+	if o.From < o.To {
+		return h >= o.From && h < o.To
+	}
+	return h >= o.From || h < o.To
+}
+
+// findAnomalies returns the anomalies in the merges up to now, oldest
+// first.
+func findAnomalies(records []mergeRecord, now time.Time, odd *oddHours) []anomaly {
+	var res []anomaly
+	recent := make(map[string]int)
+	usual := make(map[string]int)
+	overrides := make(map[string]int)
+	var users []string
+	for _, r := range records {
+		if r.Time.After(now) || r.Time.Before(now.Add(-anomalyWindow-anomalyBaseline)) {
+			continue
+		}
+		user := strings.ToLower(r.Requester)
+		if r.Time.Before(now.Add(-anomalyWindow)) {
+			usual[user]++
+			continue
+		}
+		if recent[user] == 0 {
+			users = append(users, r.Requester)
+		}
+		recent[user]++
+		if len(r.Overrides) > 0 {
+			overrides[user]++
+		}
+		if odd != nil && odd.contain(r.Time) {
+			res = append(res, anomaly{Kind: "hours", User: r.Requester, Repo: r.Repo, PR: r.PR,
+				Detail: fmt.Sprintf("merged %s#%d at %s", r.Repo, r.PR, r.Time.Local().Format("15:04 on Jan 2"))})
+		}
+	}
+	for _, u := range users {
+		user := strings.ToLower(u)
+		rate := float64(usual[user]) / float64(anomalyBaseline/anomalyWindow)
+		if float64(recent[user]) >= anomalyRate*math.Max(rate, 1) {
+			res = append(res, anomaly{Kind: "rate", User: u,
+				Detail: fmt.Sprintf("merged %d PRs in the last day, against %.1f a day usually", recent[user], rate)})
+		}
+		if overrides[user] >= anomalyOverrides {
+			res = append(res, anomaly{Kind: "overrides", User: u,
+				Detail: fmt.Sprintf("overrode checks or statuses in %d merges in the last day", overrides[user])})
+		}
+	}
+	return res
+}
+
+// anomalyDetector looks for anomalies in the merge log now and then, and
+// tells the admins of the ones it hasn't told them of.
+type anomalyDetector struct {
+	h   *handler
+	odd *oddHours
+
+	mut      sync.Mutex
+	Reported map[string]time.Time `json:"reported"` // anomaly key -> when reported
+}
+
+func loadAnomalyDetector(h *handler, odd *oddHours) (*anomalyDetector, error) {
+	d := &anomalyDetector{h: h, odd: odd, Reported: make(map[string]time.Time)}
+	if err := loadState(anomaliesStateName, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// check reports the anomalies in the merge log that are new.
+func (d *anomalyDetector) check() {
+	records, err := readMerges(nil)
+	if err != nil {
+		log.Println("Merge log:", err)
+		return
+	}
+	now := time.Now()
+	var fresh []anomaly
+	d.mut.Lock()
+	for k, t := range d.Reported {
+		if now.Sub(t) > anomalyWindow {
+			delete(d.Reported, k)
+		}
+	}
+	for _, a := range findAnomalies(records, now, d.odd) {
+		if _, ok := d.Reported[a.key()]; !ok {
+			d.Reported[a.key()] = now
+			fresh = append(fresh, a)
+		}
+	}
+	if err := saveState(anomaliesStateName, d); err != nil {
+		log.Println("Saving anomalies:", err)
+	}
+	d.mut.Unlock()
+
+	for _, a := range fresh {
+		d.report(a)
+	}
+}
+
+// report raises the anomaly with the operator, the audit log and the
+// admins who have linked a chat handle.
+func (d *anomalyDetector) report(a anomaly) {
+	metrics.add("anomalies_reported", 1)
+	log.Printf("ALERT: unusual merging by %s: %s", a.User, a.Detail)
+	d.h.audit.record(auditEvent{Kind: "anomaly", Repo: a.Repo, PR: a.PR, User: a.User, Detail: a.Detail})
+	if d.h.chat == nil {
+		return
+	}
+	admins := append([]string(nil), d.h.permissions.alwaysAllowed...)
+	sort.Strings(admins)
+	for _, admin := range admins {
+		if chat := d.h.prefs.get(admin).Chat; chat != "" {
+			d.h.chat.send(chat, fmt.Sprintf("Unusual merging by %s: %s.", a.User, a.Detail))
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFindAnomalies(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.Local)
+	merges := func(user string, n int, start time.Time, every time.Duration, overrides ...string) []mergeRecord {
+		var res []mergeRecord
+		for i := 0; i < n; i++ {
+			res = append(res, mergeRecord{Requester: user, Repo: "foo/bar", PR: i + 1, Time: start.Add(time.Duration(i) * every), Overrides: overrides})
+		}
+		return res
+	}
+	tests := []struct {
+		name    string
+		records []mergeRecord
+		odd     *oddHours
+		want    []string // kinds
+	}{
+		{"usual", append(merges("alice", 60, now.Add(-30*24*time.Hour), 12*time.Hour), merges("alice", 2, now.Add(-3*time.Hour), time.Hour)...), nil, nil},
+		{"ten times usual", append(merges("alice", 30, now.Add(-30*24*time.Hour), 24*time.Hour), merges("alice", 10, now.Add(-10*time.Hour), time.Hour)...), nil, []string{"rate"}},
+		{"new and busy", merges("bob", 10, now.Add(-10*time.Hour), time.Hour), nil, []string{"rate"}},
+		{"new and not so busy", merges("bob", 9, now.Add(-10*time.Hour), time.Hour), nil, nil},
+		{"overrides", merges("carol", 3, now.Add(-3*time.Hour), time.Hour, "Skipped checks ci"), nil, []string{"overrides"}},
+		{"odd hours", merges("dave", 2, now.Add(-11*time.Hour), 5*time.Hour), &oddHours{22, 6}, []string{"hours"}},
+		{"too old", merges("bob", 10, now.Add(-48*time.Hour), time.Hour, "Skipped checks ci"), &oddHours{0, 24}, nil},
+	}
+	for _, test := range tests {
+		var kinds []string
+		for _, a := range findAnomalies(test.records, now, test.odd) {
+			kinds = append(kinds, a.Kind)
+		}
+		if !reflect.DeepEqual(kinds, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, kinds, test.want)
+		}
+	}
+}
+
+func TestParseOddHours(t *testing.T) {
+	for _, s := range []string{"22", "a-b", "3-3", "25-2"} {
+		if _, err := parseOddHours(s); err == nil {
+			t.Errorf("Expected %q to be refused", s)
+		}
+	}
+	odd, err := parseOddHours("22-6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(hour int) time.Time { return time.Date(2026, 10, 15, hour, 30, 0, 0, time.Local) }
+	if !odd.contain(at(23)) || !odd.contain(at(5)) || odd.contain(at(6)) || odd.contain(at(12)) {
+		t.Error("Unexpected odd hours")
+	}
+}
+
+func TestAnomalyDetector(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+	for i := 0; i < 3; i++ {
+		recordMerge(mergeRecord{Time: time.Now().Add(-time.Hour), Repo: "foo/bar", PR: i, Requester: "carol", Overrides: []string{"note"}})
+	}
+	before := metrics.get("anomalies_reported")
+	d, err := loadAnomalyDetector(newHandler(nil, "bot", "", false), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.check()
+	d.check()
+	if reported := metrics.get("anomalies_reported") - before; reported != 1 {
+		t.Errorf("Expected the overrides to be reported once, got %d", reported)
+	}
+	if d, _ = loadAnomalyDetector(d.h, nil); len(d.Reported) != 1 {
+		t.Errorf("Expected the report to be persisted, got %v", d.Reported)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// githubAPI is the base URL for API requests that are not made against URLs
+// given to us in event payloads.
+var githubAPI = "https://api.github.com"
+
+// githubUploads is the base URL for uploads, which GitHub serves apart from
+// the API.
+var githubUploads = "https://uploads.github.com"
+
+// uploadsURL returns the base URL for uploads given the API URL. GitHub
+// Enterprise Server serves them next to its API, at /api/uploads.
+func uploadsURL(apiURL string) string {
+	if gitHost(apiURL) == "github.com" {
+		return "https://uploads.github.com"
+	}
+	return strings.TrimSuffix(apiURL, "/api/v3") + "/api/uploads"
+}
+
+// noreplyEmail returns the no-reply email address of the user on the
+// GitHub the API URL is of.
+func noreplyEmail(login string) string {
+	return login + "@users.noreply." + gitHost(githubAPI)
+}
+
+// apiRequest performs an authenticated request against the GitHub API. The
+// in value, if not nil, is sent JSON encoded as the request body. The response
+// is decoded into out, if not nil.
+func apiRequest(method, url string, in, out interface{}, username, token string) error {
+	return sendAPIRequest(method, url, in, out, func(req *http.Request) { req.SetBasicAuth(username, token) })
+}
+
+// sendAPIRequest performs a request against an API, with the credentials
+// set by auth.
+func sendAPIRequest(method, url string, in, out interface{}, auth func(*http.Request)) error {
+	_, err := doAPIRequest(method, url, in, out, auth)
+	return err
+}
+
+// apiGetPage gets one page of a list from the GitHub API into out, and
+// returns the URL of the next page from the Link header, if there is one.
+func apiGetPage(url string, out interface{}, username, token string) (string, error) {
+	header, err := doAPIRequest("GET", url, nil, out, func(req *http.Request) { req.SetBasicAuth(username, token) })
+	if err != nil {
+		return "", err
+	}
+	return nextPageURL(header.Get("Link")), nil
+}
+
+// nextPageURL returns the URL of the next page from a Link header, as in
+// <https://api.github.com/...&page=2>; rel="next", or nothing.
+func nextPageURL(link string) string {
+	for _, part := range strings.Split(link, ",") {
+		fields := strings.Split(part, ";")
+		for _, param := range fields[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(fields[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
+
+func doAPIRequest(method, url string, in, out interface{}, auth func(*http.Request)) (http.Header, error) {
+	var body io.Reader
+	if in != nil {
+		buf := new(bytes.Buffer)
+		if err := json.NewEncoder(buf).Encode(in); err != nil {
+			return nil, err
+		}
+		body = buf
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		log.Println("Request:", err)
+		return nil, err
+	}
+	auth(req)
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		log.Println(method+":", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		log.Println(method+":", url, resp.Status)
+		return nil, &apiError{method: method, url: url, status: resp.Status, code: resp.StatusCode}
+	}
+
+	if out == nil {
+		return resp.Header, nil
+	}
+	return resp.Header, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// An apiError is returned for requests that got a non successful response.
+type apiError struct {
+	method string
+	url    string
+	status string
+	code   int
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.method, e.url, e.status)
+}
+
+// isNotFound returns true if err is an API 404 Not Found error.
+func isNotFound(err error) bool {
+	aerr, ok := err.(*apiError)
+	return ok && aerr.code == http.StatusNotFound
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+)
+
+// An artifactHandoff posts on merged PRs what the build of the merge
+// produced, once it's done: the artifacts of the Actions runs for the merge
+// commit, or a link from a template for other build systems, posted once
+// it stops answering 404.
+type artifactHandoff struct {
+	Branches []string // the base branches whose builds are reported; any if unset
+	Workflow string   // file name of the Actions workflow building them, like "build.yml"; any if unset
+	URL      string   // posted instead of Actions artifacts, expanding {repo}, {owner}, {name}, {number} and {sha}
+	MaxWait  duration `json:"max_wait"` // how long to wait for the build; an hour if unset
+}
+
+// appliesTo returns true if merges into the branch are handed off.
+func (a *artifactHandoff) appliesTo(branch string) bool {
+	if a == nil {
+		return false
+	}
+	if len(a.Branches) == 0 {
+		return true
+	}
+	for _, b := range a.Branches {
+		if b == branch {
+			return true
+		}
+	}
+	return false
+}
+
+// artifactPoll is how often to look at the build of a merge, unless CI
+// events wake us up sooner.
+var artifactPoll = 30 * time.Second
+
+type workflowRun struct {
+	ID           int
+	Name         string
+	Status       string // queued, in_progress or completed
+	Conclusion   string // success, failure, cancelled, ...
+	HTMLURL      string `json:"html_url"`
+	ArtifactsURL string `json:"artifacts_url"`
+}
+
+type artifact struct {
+	ID      int
+	Name    string
+	Digest  string // like "sha256:..."
+	Expired bool
+}
+
+// A buildResult is what the build of a merge came to.
+type buildResult struct {
+	artifacts []string // links to what was built
+	failed    []string // links to the runs that failed
+}
+
+// handOffArtifacts waits for the build of the merge of the PR as sha and
+// reports what it produced on the PR.
+func (h *handler) handOffArtifacts(c comment, pr pr, sha string) {
+	repo := c.Repository.FullName
+	a := h.settings.forRepo(repo).Artifacts
+	if !a.appliesTo(pr.Base.Ref) || !h.featureEnabled(repo, "artifacts", true) {
+		return
+	}
+	maxWait := a.MaxWait.Duration
+	if maxWait == 0 {
+		maxWait = time.Hour
+	}
+	deadline := time.Now().Add(maxWait)
+
+	events, stop := h.ci.watch(repo, sha)
+	defer stop()
+	for {
+		report, err := h.buildResult(a, repo, pr, sha)
+		switch {
+		case err != nil:
+			log.Printf("Artifacts of %s on %s: %v", sha, repo, err)
+		case report != nil && len(report.failed) > 0:
+			c.post(withNotes(buildFailedResponse(c, sha), report.failed), h.username, h.token)
+			return
+		case report != nil:
+			c.post(withNotes(artifactsResponse(c, sha), report.artifacts), h.username, h.token)
+			return
+		}
+		if time.Now().After(deadline) {
+			c.post(artifactsTimeoutResponse(c, sha, maxWait), h.username, h.token)
+			return
+		}
+		waitForCI(events, artifactPoll)
+	}
+}
+
+// buildResult returns what the build of the commit came to, or nil while
+// it's still going.
+func (h *handler) buildResult(a *artifactHandoff, repo string, p pr, sha string) (*buildResult, error) {
+	if a.URL != "" {
+		p.Head.SHA = sha
+		u := expandPRURL(a.URL, repo, p)
+		if !available(u) {
+			return nil, nil
+		}
+		return &buildResult{artifacts: []string{u}}, nil
+	}
+
+	runs, err := h.workflowRuns(a, repo, p.Base.Ref, sha)
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+	for _, run := range runs {
+		if run.Status != "completed" {
+			return nil, nil
+		}
+	}
+	report := &buildResult{}
+	for _, run := range runs {
+		switch {
+		case run.Conclusion == "success":
+			var res struct {
+				Artifacts []artifact
+			}
+			if err := apiRequest("GET", run.ArtifactsURL, nil, &res, h.username, h.token); err != nil {
+				return nil, err
+			}
+			for _, art := range res.Artifacts {
+				if !art.Expired {
+					report.artifacts = append(report.artifacts, artifactLink(run, art))
+				}
+			}
+		case run.Conclusion != "skipped" && run.Conclusion != "neutral":
+			report.failed = append(report.failed, fmt.Sprintf("[%s](%s): %s", run.Name, run.HTMLURL, run.Conclusion))
+		}
+	}
+	return report, nil
+}
+
+// workflowRuns returns the Actions runs for the push of the commit to the
+// branch, of the workflow of the hand-off if it names one.
+func (h *handler) workflowRuns(a *artifactHandoff, repo, branch, sha string) ([]workflowRun, error) {
+	u := fmt.Sprintf("%s/repos/%s/actions/runs", githubAPI, repo)
+	if a.Workflow != "" {
+		u = fmt.Sprintf("%s/repos/%s/actions/workflows/%s/runs", githubAPI, repo, url.PathEscape(a.Workflow))
+	}
+	u += fmt.Sprintf("?event=push&branch=%s&head_sha=%s", url.QueryEscape(branch), sha)
+	var res struct {
+		WorkflowRuns []workflowRun `json:"workflow_runs"`
+	}
+	if err := apiRequest("GET", u, nil, &res, h.username, h.token); err != nil {
+		return nil, err
+	}
+	return res.WorkflowRuns, nil
+}
+
+// artifactLink returns a markdown link to the artifact of the run, along
+// with its digest.
+func artifactLink(run workflowRun, art artifact) string {
+	link := fmt.Sprintf("[%s](%s/artifacts/%d)", art.Name, run.HTMLURL, art.ID)
+	if art.Digest != "" {
+		link += " " + codeSpan(art.Digest)
+	}
+	return link
+}
+
+// available returns true if the URL answers with something other than an
+// error.
+func available(u string) bool {
+	resp, err := apiClient.Get(u)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < 400
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestBuildResult(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/foo/bar/actions/runs":
+			if r.URL.Query().Get("branch") != "main" {
+				w.Write([]byte(`{"workflow_runs": []}`))
+				return
+			}
+			switch r.URL.Query().Get("head_sha") {
+			case "running":
+				w.Write([]byte(`{"workflow_runs": [{"id": 1, "status": "completed", "conclusion": "success"}, {"id": 2, "status": "in_progress"}]}`))
+			case "built":
+				w.Write([]byte(`{"workflow_runs": [{"id": 1, "name": "build", "status": "completed", "conclusion": "success", "html_url": "https://example.com/runs/1", "artifacts_url": "http://` + r.Host + `/runs/1/artifacts"}, {"id": 2, "status": "completed", "conclusion": "skipped"}]}`))
+			case "broken":
+				w.Write([]byte(`{"workflow_runs": [{"id": 3, "name": "build", "status": "completed", "conclusion": "failure", "html_url": "https://example.com/runs/3"}]}`))
+			default:
+				w.Write([]byte(`{"workflow_runs": []}`))
+			}
+		case "/repos/foo/bar/actions/workflows/release.yml/runs":
+			w.Write([]byte(`{"workflow_runs": []}`))
+		case "/runs/1/artifacts":
+			w.Write([]byte(`{"artifacts": [{"id": 7, "name": "bin", "digest": "sha256:abc"}, {"id": 8, "name": "old", "expired": true}, {"id": 9, "name": "docs"}]}`))
+		case "/files/built":
+			w.Write([]byte(`ok`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	h := newHandler(nil, "bot", "", false)
+	var p pr
+	p.Number = 4
+	p.Base.Ref = "main"
+	actions := &artifactHandoff{}
+	tests := []struct {
+		a    *artifactHandoff
+		sha  string
+		want *buildResult
+	}{
+		{actions, "unknown", nil},
+		{actions, "running", nil},
+		{actions, "built", &buildResult{artifacts: []string{"[bin](https://example.com/runs/1/artifacts/7) `sha256:abc`", "[docs](https://example.com/runs/1/artifacts/9)"}}},
+		{actions, "broken", &buildResult{failed: []string{"[build](https://example.com/runs/3): failure"}}},
+		{&artifactHandoff{Workflow: "release.yml"}, "built", nil},
+		{&artifactHandoff{URL: srv.URL + "/files/{sha}"}, "pending", nil},
+		{&artifactHandoff{URL: srv.URL + "/files/{sha}"}, "built", &buildResult{artifacts: []string{srv.URL + "/files/built"}}},
+	}
+	for _, test := range tests {
+		got, err := h.buildResult(test.a, "foo/bar", p, test.sha)
+		if err != nil {
+			t.Errorf("%+v %s: %v", test.a, test.sha, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%+v %s: got %+v, want %+v", test.a, test.sha, got, test.want)
+		}
+	}
+
+	if (*artifactHandoff)(nil).appliesTo("main") || !actions.appliesTo("main") || (&artifactHandoff{Branches: []string{"release"}}).appliesTo("main") {
+		t.Error("appliesTo is wrong")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	statementType     = "https://in-toto.io/Statement/v1"
+	mergePredicate    = "https://github.com/gonsie/mergebot/merge/v1"
+	inTotoPayloadType = "application/vnd.in-toto+json"
+	attestLogName     = "attestations.jsonl"
+)
+
+// A statement is an in-toto attestation statement about the merge commit.
+type statement struct {
+	Type          string          `json:"_type"`
+	Subject       []subject       `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     mergeProvenance `json:"predicate"`
+}
+
+type subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// mergeProvenance records what the merge was based on.
+type mergeProvenance struct {
+	Repo      string        `json:"repo"`
+	PR        int           `json:"pr"`
+	URL       string        `json:"url"`
+	Base      string        `json:"base"`
+	Head      string        `json:"head"`
+	Author    string        `json:"author"`
+	Requester string        `json:"requester"`
+	OnBehalf  string        `json:"onBehalfOf,omitempty"`
+	Approvers []string      `json:"approvers"`
+	Checks    []checkResult `json:"checks"`
+	MergedAt  time.Time     `json:"mergedAt"`
+	Builder   string        `json:"builder"`
+}
+
+type checkResult struct {
+	Context string  `json:"context"`
+	State   prState `json:"state"`
+}
+
+// An envelope is a DSSE envelope carrying a signed statement.
+type envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     string      `json:"payload"`
+	Signatures  []signature `json:"signatures"`
+}
+
+type signature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// The attestor signs statements about merges, storing them in the state
+// directory and optionally uploading them to a transparency endpoint.
+type attestor struct {
+	key       ed25519.PrivateKey
+	keyID     string
+	uploadURL string
+	mut       sync.Mutex
+}
+
+// loadAttestor reads a PEM encoded PKCS #8 Ed25519 private key, as made by
+// "openssl genpkey -algorithm ed25519".
+func loadAttestor(path, uploadURL string) (*attestor, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bs)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return newAttestor(key, uploadURL), nil
+}
+
+func newAttestor(key ed25519.PrivateKey, uploadURL string) *attestor {
+	return &attestor{key: key, keyID: keyID(key.Public().(ed25519.PublicKey)), uploadURL: uploadURL}
+}
+
+// keyID identifies a public key by the hex SHA-256 of its bytes.
+func keyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:])
+}
+
+// pae is the DSSE pre-authentication encoding of the payload, which is what
+// gets signed.
+func pae(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+func (a *attestor) sign(st statement) (envelope, error) {
+	payload, err := json.Marshal(st)
+	if err != nil {
+		return envelope{}, err
+	}
+	sig := ed25519.Sign(a.key, pae(inTotoPayloadType, payload))
+	return envelope{
+		PayloadType: inTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []signature{{KeyID: a.keyID, Sig: base64.StdEncoding.EncodeToString(sig)}},
+	}, nil
+}
+
+// verifyEnvelope checks the signature on the envelope against the public key
+// and returns the statement it carries.
+func verifyEnvelope(env envelope, pub ed25519.PublicKey) (statement, error) {
+	payload, err := base64.StdEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return statement{}, err
+	}
+	id := keyID(pub)
+	for _, s := range env.Signatures {
+		if s.KeyID != id {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err != nil {
+			return statement{}, err
+		}
+		if !ed25519.Verify(pub, pae(env.PayloadType, payload), sig) {
+			return statement{}, fmt.Errorf("bad signature")
+		}
+		var st statement
+		err = json.Unmarshal(payload, &st)
+		return st, err
+	}
+	return statement{}, fmt.Errorf("not signed by key %s", id)
+}
+
+// record signs and stores the statement, and uploads it if so configured.
+func (a *attestor) record(st statement) {
+	env, err := a.sign(st)
+	if err != nil {
+		log.Println("Attestation:", err)
+		return
+	}
+
+	a.mut.Lock()
+	err = appendState(attestLogName, env)
+	a.mut.Unlock()
+	if err != nil {
+		log.Println("Attestation:", err)
+	}
+
+	if a.uploadURL == "" {
+		return
+	}
+	bs, _ := json.Marshal(env)
+	resp, err := apiClient.Post(a.uploadURL, "application/json", bytes.NewReader(bs))
+	if err != nil {
+		log.Println("Attestation upload:", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode > 299 {
+		log.Println("Attestation upload:", resp.Status)
+	}
+}
+
+// attestMerge records a signed statement about a completed merge, with the
+// approvals and check results as they were at the time of the merge.
+func (h *handler) attestMerge(pr pr, rec mergeRecord) {
+	if h.attestor == nil {
+		return
+	}
+
+	prov := mergeProvenance{
+		Repo:      rec.Repo,
+		PR:        rec.PR,
+		URL:       rec.URL,
+		Base:      rec.Base,
+		Head:      pr.Head.SHA,
+		Author:    rec.Author,
+		Requester: rec.Requester,
+		OnBehalf:  rec.OnBehalfOf,
+		MergedAt:  rec.Time.UTC(),
+		Builder:   h.username,
+	}
+	if rs, err := pr.getReviews(h.username, h.token); err == nil {
+		prov.Approvers = approvers(rs)
+	}
+	for _, st := range h.getStatuses(rec.Repo, pr) {
+		prov.Checks = append(prov.Checks, checkResult{Context: st.Context, State: st.State})
+	}
+
+	h.attestor.record(statement{
+		Type: statementType,
+		Subject: []subject{{
+			Name:   fmt.Sprintf("git+%s@%s", rec.Repo, rec.Base),
+			Digest: map[string]string{"gitCommit": rec.SHA},
+		}},
+		PredicateType: mergePredicate,
+		Predicate:     prov,
+	})
+}
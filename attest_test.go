@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestAttestationSignature(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newAttestor(key, "")
+
+	st := statement{
+		Type:          statementType,
+		Subject:       []subject{{Name: "git+foo/bar@main", Digest: map[string]string{"gitCommit": "abc123"}}},
+		PredicateType: mergePredicate,
+		Predicate:     mergeProvenance{Repo: "foo/bar", PR: 42, Approvers: []string{"alice"}},
+	}
+	env, err := a.sign(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := verifyEnvelope(env, pub)
+	if err != nil {
+		t.Fatal("Verifying:", err)
+	}
+	if got.Predicate.PR != 42 || got.Subject[0].Digest["gitCommit"] != "abc123" {
+		t.Errorf("Unexpected statement %+v", got)
+	}
+
+	env.Payload = env.Payload[:len(env.Payload)-4] + "AAAA"
+	if _, err := verifyEnvelope(env, pub); err == nil {
+		t.Error("Expected tampered envelope to fail verification")
+	}
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	if _, err := verifyEnvelope(env, other); err == nil {
+		t.Error("Expected verification with another key to fail")
+	}
+}
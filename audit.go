@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/syslog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An auditEvent is something security teams may want to know about the bot
+// acting as a privileged user.
+type auditEvent struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"` // "command", "allowed", "denied", "override", "toggle", "retarget", "link", "anomaly", "failed" or "merge"
+	Severity int       `json:"severity"`
+	Repo     string    `json:"repo,omitempty"`
+	PR       int       `json:"pr,omitempty"`
+	User     string    `json:"user,omitempty"`
+	SHA      string    `json:"sha,omitempty"` // what a merge resulted in
+	Detail   string    `json:"detail,omitempty"`
+}
+
+// The CEF severity for each kind of event.
+var auditSeverity = map[string]int{
+	"command":  1,
+	"allowed":  1,
+	"denied":   7,
+	"override": 5,
+	"toggle":   5,
+	"retarget": 3,
+	"link":     3,
+	"anomaly":  6,
+	"failed":   2,
+	"merge":    3,
+}
+
+// The auditor appends audit events to a log in the state directory, where
+// the admin API can query them, and exports them to syslog and/or a SIEM
+// webhook, in CEF or JSON format. A nil auditor discards all events.
+type auditor struct {
+	format  string // "cef" or "json"
+	syslog  *syslog.Writer
+	webhook string
+	logName string // state file to append events to, if any
+}
+
+const auditLogName = "audit.jsonl"
+
+// auditLogMut serializes access to the audit log.
+var auditLogMut sync.Mutex
+
+// newAuditor connects to syslog at the given address, which is a URL like
+// udp://host:514 or tcp://host:601, or "local" for the local syslog daemon.
+func newAuditor(format, syslogAddr, webhook, logName string) (*auditor, error) {
+	switch format {
+	case "cef", "json":
+	default:
+		return nil, fmt.Errorf("unknown audit format %q", format)
+	}
+
+	a := &auditor{format: format, webhook: webhook, logName: logName}
+	switch syslogAddr {
+	case "":
+	case "local":
+		w, err := syslog.New(syslog.LOG_AUTH|syslog.LOG_NOTICE, "mergebot")
+		if err != nil {
+			return nil, err
+		}
+		a.syslog = w
+	default:
+		u, err := url.Parse(syslogAddr)
+		if err != nil {
+			return nil, err
+		}
+		w, err := syslog.Dial(u.Scheme, u.Host, syslog.LOG_AUTH|syslog.LOG_NOTICE, "mergebot")
+		if err != nil {
+			return nil, err
+		}
+		a.syslog = w
+	}
+	return a, nil
+}
+
+func (a *auditor) record(ev auditEvent) {
+	if a == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	ev.Severity = auditSeverity[ev.Kind]
+
+	if a.logName != "" {
+		auditLogMut.Lock()
+		err := appendState(a.logName, ev)
+		auditLogMut.Unlock()
+		if err != nil {
+			log.Println("Audit log:", err)
+		}
+	}
+
+	var line string
+	if a.format == "cef" {
+		line = ev.cef()
+	} else {
+		bs, _ := json.Marshal(ev)
+		line = string(bs)
+	}
+
+	if a.syslog != nil {
+		if err := a.syslog.Notice(line); err != nil {
+			log.Println("Audit syslog:", err)
+		}
+	}
+	if a.webhook != "" {
+		go a.post(line)
+	}
+}
+
+func (a *auditor) post(line string) {
+	contentType := "application/json"
+	if a.format == "cef" {
+		contentType = "text/plain"
+	}
+	resp, err := apiClient.Post(a.webhook, contentType, bytes.NewBufferString(line))
+	if err != nil {
+		log.Println("Audit webhook:", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode > 299 {
+		log.Println("Audit webhook:", resp.Status)
+	}
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+// cef formats the event in ArcSight Common Event Format.
+func (ev auditEvent) cef() string {
+	ext := []string{"rt=" + fmt.Sprint(ev.Time.UnixNano()/int64(time.Millisecond))}
+	add := func(key, value string) {
+		if value != "" {
+			ext = append(ext, key+"="+cefExtensionEscaper.Replace(value))
+		}
+	}
+	add("suser", ev.User)
+	if ev.Repo != "" {
+		add("cs1Label", "repo")
+		add("cs1", ev.Repo)
+	}
+	if ev.PR != 0 {
+		add("cn1Label", "pr")
+		add("cn1", fmt.Sprint(ev.PR))
+	}
+	if ev.SHA != "" {
+		add("cs2Label", "sha")
+		add("cs2", ev.SHA)
+	}
+	add("msg", ev.Detail)
+
+	return fmt.Sprintf("CEF:0|gonsie|mergebot|1.0|%s|%s|%d|%s",
+		cefHeaderEscaper.Replace(ev.Kind),
+		cefHeaderEscaper.Replace(auditNames[ev.Kind]),
+		ev.Severity,
+		strings.Join(ext, " "))
+}
+
+var auditNames = map[string]string{
+	"command":  "Command received",
+	"allowed":  "Authorization success",
+	"denied":   "Authorization failure",
+	"override": "Check override",
+	"toggle":   "Bot toggled",
+	"retarget": "Base branch changed",
+	"link":     "Chat handle linked",
+	"anomaly":  "Unusual merging",
+	"failed":   "Merge failed",
+	"merge":    "Merge",
+}
+
+// auditDenied records a rejected request by an unauthorized user.
+func (h *handler) auditDenied(c comment, detail string) {
+	h.audit.record(auditEvent{Kind: "denied", Repo: c.Repository.FullName, PR: c.Issue.Number, User: c.Sender.Login, Detail: detail})
+}
+
+// readAudit returns the events in the audit log for which keep returns
+// true, oldest first.
+func (a *auditor) readAudit(keep func(auditEvent) bool) ([]auditEvent, error) {
+	if a == nil || a.logName == "" {
+		return nil, nil
+	}
+	auditLogMut.Lock()
+	defer auditLogMut.Unlock()
+
+	var res []auditEvent
+	err := readStateLines(a.logName, func(line []byte) {
+		var ev auditEvent
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return
+		}
+		if keep == nil || keep(ev) {
+			res = append(res, ev)
+		}
+	})
+	return res, err
+}
+
+// serveAudit answers /admin/audit?repo=foo/bar&pr=42&user=alice&kind=merge,
+// accepting since= and until= as RFC 3339 times, with the matching events
+// oldest first, so that compliance teams can tell who merged what, when, and
+// why it was allowed.
+func (a *adminAPI) serveAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "GET Expected", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	if a.h.audit == nil || a.h.audit.logName == "" {
+		http.Error(w, "The audit log is off", http.StatusNotFound)
+		return
+	}
+
+	var since, until time.Time
+	for name, dst := range map[string]*time.Time{"since": &since, "until": &until} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			*dst = t
+		}
+	}
+	pr := 0
+	if v := q.Get("pr"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pr = n
+	}
+	repo, user, kind := q.Get("repo"), q.Get("user"), q.Get("kind")
+
+	events, err := a.h.audit.readAudit(func(ev auditEvent) bool {
+		return (repo == "" || strings.EqualFold(ev.Repo, repo)) &&
+			(pr == 0 || ev.PR == pr) &&
+			(user == "" || strings.EqualFold(ev.User, user)) &&
+			(kind == "" || ev.Kind == kind) &&
+			!ev.Time.Before(since) &&
+			(until.IsZero() || ev.Time.Before(until))
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if events == nil {
+		events = []auditEvent{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
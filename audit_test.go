@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAuditCEF(t *testing.T) {
+	ev := auditEvent{
+		Time:     time.Unix(1500000000, 0),
+		Kind:     "denied",
+		Severity: 7,
+		Repo:     "foo/bar",
+		PR:       42,
+		User:     "mallory",
+		Detail:   "merge a=b\nnext",
+	}
+	expected := `CEF:0|gonsie|mergebot|1.0|denied|Authorization failure|7|rt=1500000000000 suser=mallory cs1Label=repo cs1=foo/bar cn1Label=pr cn1=42 msg=merge a\=b\nnext`
+	if s := ev.cef(); s != expected {
+		t.Errorf("Unexpected CEF\n%s\nexpected\n%s", s, expected)
+	}
+
+	// A nil auditor discards events.
+	var a *auditor
+	a.record(ev)
+}
+
+func TestAuditLog(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+
+	a, err := newAuditor("cef", "", "", auditLogName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2021, 3, 4, 5, 0, 0, 0, time.UTC)
+	a.record(auditEvent{Time: start, Kind: "command", Repo: "o/r", PR: 1, User: "alice", Detail: "merge"})
+	a.record(auditEvent{Time: start.Add(time.Second), Kind: "allowed", Repo: "o/r", PR: 1, User: "alice", Detail: "merge: among those allowed to merge"})
+	a.record(auditEvent{Time: start.Add(2 * time.Second), Kind: "merge", Repo: "o/r", PR: 1, User: "alice", SHA: "abc123", Detail: "Merged into master as abc123"})
+	a.record(auditEvent{Time: start.Add(time.Hour), Kind: "denied", Repo: "o/other", PR: 2, User: "mallory", Detail: "merge"})
+
+	api := &adminAPI{h: &handler{audit: a}, token: "t"}
+	cases := []struct {
+		query string
+		code  int
+		kinds []string
+	}{
+		{"", http.StatusOK, []string{"command", "allowed", "merge", "denied"}},
+		{"?repo=O/R&pr=1", http.StatusOK, []string{"command", "allowed", "merge"}},
+		{"?user=mallory", http.StatusOK, []string{"denied"}},
+		{"?kind=merge", http.StatusOK, []string{"merge"}},
+		{"?since=2021-03-04T05:00:01Z&until=2021-03-04T05:00:02Z", http.StatusOK, []string{"allowed"}},
+		{"?pr=abc", http.StatusBadRequest, nil},
+		{"?since=yesterday", http.StatusBadRequest, nil},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/admin/audit"+tc.query, nil)
+		req.Header.Set("Authorization", "Bearer t")
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		if rec.Code != tc.code {
+			t.Errorf("%q: expected %d, got %d", tc.query, tc.code, rec.Code)
+			continue
+		}
+		if tc.code != http.StatusOK {
+			continue
+		}
+		var events []auditEvent
+		if err := json.NewDecoder(rec.Body).Decode(&events); err != nil {
+			t.Fatal(err)
+		}
+		var kinds []string
+		for _, ev := range events {
+			kinds = append(kinds, ev.Kind)
+		}
+		if !reflect.DeepEqual(kinds, tc.kinds) {
+			t.Errorf("%q: expected %v, got %v", tc.query, tc.kinds, kinds)
+		}
+		if tc.query == "?kind=merge" && (events[0].SHA != "abc123" || events[0].Severity != 3) {
+			t.Errorf("Expected the merge with its SHA and severity, got %+v", events[0])
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// awayDateFormat is how the day someone is back is given, as in "away
+// until 2021-03-04".
+const awayDateFormat = "2006-01-02"
+
+// How reviews are requested from users who are away.
+const (
+	awaySkip       = "skip"       // not from them
+	awaySubstitute = "substitute" // from their backups instead
+)
+
+// isAway returns whether the user is away at now, and their backup.
+func (p userPrefs) isAway(now time.Time) (string, bool) {
+	back, err := time.ParseInLocation(awayDateFormat, p.Away, time.Local)
+	return p.Backup, err == nil && now.Before(back)
+}
+
+// awayReviewers returns the users to request reviews from in place of the
+// users, skipping or substituting those who are away as the repository's
+// AwayReviewers says.
+func (h *handler) awayReviewers(repo string, users []string, now time.Time) []string {
+	mode := h.settings.forRepo(repo).AwayReviewers
+	if h.prefs == nil || mode == "" {
+		return users
+	}
+	var res []string
+	for _, u := range users {
+		backup, away := h.prefs.get(u).isAway(now)
+		if !away {
+			res = append(res, u)
+			continue
+		}
+		if mode == awaySubstitute && backup != "" {
+			if _, backupAway := h.prefs.get(backup).isAway(now); !backupAway {
+				res = append(res, backup)
+			}
+		}
+	}
+	return dedupe(res)
+}
+
+// handleAway marks the sender as away until a day, handing their pending
+// merges to a backup, as in "away until 2021-03-04 backup bob", or as back
+// with "away off".
+func (h *handler) handleAway(c comment) {
+	fields := strings.Fields(c.parseBody().command)
+	if len(fields) == 2 && strings.ToLower(fields[1]) == "off" {
+		prefs, err := h.prefs.update(c.Sender.Login, func(p *userPrefs) error {
+			p.Away, p.Backup = "", ""
+			return nil
+		})
+		if err != nil {
+			log.Println("Saving preferences:", err)
+		}
+		c.post(prefsResponse(c, prefs.lines()), h.username, h.token)
+		return
+	}
+	if len(fields) != 3 && len(fields) != 5 || strings.ToLower(fields[1]) != "until" || len(fields) == 5 && strings.ToLower(fields[3]) != "backup" {
+		c.post(badOptionResponse(c, "say `away until YYYY-MM-DD [backup LOGIN]` or `away off`"), h.username, h.token)
+		return
+	}
+	until, err := time.ParseInLocation(awayDateFormat, fields[2], time.Local)
+	if err != nil || !until.After(time.Now()) {
+		c.post(badOptionResponse(c, fmt.Sprintf("%s is not a day in the future", codeSpan(fields[2]))), h.username, h.token)
+		return
+	}
+	backup := ""
+	if len(fields) == 5 {
+		backup = strings.TrimPrefix(fields[4], "@")
+		if strings.EqualFold(backup, c.Sender.Login) || !h.isAllowed(c.Repository.FullName, backup) {
+			c.post(badOptionResponse(c, fmt.Sprintf("%s can't be your backup, not being someone else allowed to merge here", backup)), h.username, h.token)
+			return
+		}
+	}
+
+	prefs, err := h.prefs.update(c.Sender.Login, func(p *userPrefs) error {
+		p.Away, p.Backup = until.Format(awayDateFormat), backup
+		return nil
+	})
+	if err != nil {
+		log.Println("Saving preferences:", err)
+	}
+	if backup != "" {
+		h.handOffPending(c.Sender.Login, backup, until)
+	}
+	c.post(prefsResponse(c, prefs.lines()), h.username, h.token)
+}
+
+// handOffPending assigns the pending merges the user asked for to their
+// backup, telling the PRs and, if linked, the backup in chat.
+func (h *handler) handOffPending(login, backup string, until time.Time) {
+	var handed []string
+	for _, m := range h.pendingStore.all() {
+		c := m.Comment
+		if !strings.EqualFold(c.Sender.Login, login) {
+			continue
+		}
+		u := fmt.Sprintf("%s/repos/%s/issues/%d/assignees", githubAPI, c.Repository.FullName, c.Issue.Number)
+		if err := apiRequest("POST", u, map[string][]string{"assignees": {backup}}, nil, h.username, h.token); err != nil {
+			log.Printf("Assigning PR %d on %s to %s: %v", c.Issue.Number, c.Repository.FullName, backup, err)
+		}
+		c.post(handedOffResponse(c, backup, until.Format(awayDateFormat)), h.username, h.token)
+		handed = append(handed, fmt.Sprintf("%s#%d", c.Repository.FullName, c.Issue.Number))
+	}
+	if len(handed) == 0 || h.chat == nil {
+		return
+	}
+	if chat := h.prefs.get(backup).Chat; chat != "" {
+		go h.chat.send(chat, fmt.Sprintf("%s is away until %s and handed you their pending merges: %s.", login, until.Format(awayDateFormat), strings.Join(handed, ", ")))
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAway(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+
+	var mut sync.Mutex
+	var posted []string
+	var assigned []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		defer mut.Unlock()
+		switch r.URL.Path {
+		case "/comments":
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			posted = append(posted, body.Body)
+		case "/repos/foo/bar/issues/7/assignees":
+			var body struct{ Assignees []string }
+			json.NewDecoder(r.Body).Decode(&body)
+			assigned = append(assigned, body.Assignees...)
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	h := newHandler(nil, "bot", "", false)
+	h.settings.defaults.AllowedUsers = []string{"alice", "bob", "dave"}
+	comment := func(login string, number int, body string) comment {
+		var c comment
+		c.Repository.FullName = "foo/bar"
+		c.Sender.Login = login
+		c.Issue.Number = number
+		c.Comment.Body = body
+		c.Issue.CommentsURL = srv.URL + "/comments"
+		return c
+	}
+	h.pendingStore.add(pendingMerge{Comment: comment("alice", 7, "@bot merge when approved"), Started: time.Now()})
+	h.pendingStore.add(pendingMerge{Comment: comment("dave", 8, "@bot merge"), Started: time.Now()})
+
+	back := time.Now().AddDate(0, 0, 14).Format(awayDateFormat)
+	for _, body := range []string{"@bot away until yesterday", "@bot away until 2001-01-01", "@bot away until " + back + " backup carol", "@bot away until " + back + " backup alice", "@bot away"} {
+		h.handleAway(comment("alice", 3, body))
+	}
+	if len(posted) != 5 || len(assigned) != 0 {
+		t.Fatalf("Expected only complaints, got %q", posted)
+	}
+	for _, p := range posted {
+		if !strings.HasPrefix(p, "@alice: I don't understand") {
+			t.Errorf("Unexpected response %q", p)
+		}
+	}
+
+	posted = nil
+	h.handleAway(comment("alice", 3, "@bot away until "+back+" backup @bob"))
+	if !reflect.DeepEqual(assigned, []string{"bob"}) {
+		t.Errorf("Expected the pending merge to be assigned to bob, got %v", assigned)
+	}
+	if len(posted) != 2 || !strings.HasPrefix(posted[0], "@bob: alice is away until "+back) || !strings.Contains(posted[1], "away until: `"+back+"`") {
+		t.Errorf("Unexpected responses %q", posted)
+	}
+
+	now := time.Now()
+	users := []string{"alice", "carol", "bob"}
+	if got := h.awayReviewers("foo/bar", users, now); !reflect.DeepEqual(got, users) {
+		t.Errorf("Expected reviewers to be left alone by default, got %v", got)
+	}
+	h.settings.defaults.AwayReviewers = awaySkip
+	if got := h.awayReviewers("foo/bar", users, now); !reflect.DeepEqual(got, []string{"carol", "bob"}) {
+		t.Errorf("Expected alice to be skipped, got %v", got)
+	}
+	h.settings.defaults.AwayReviewers = awaySubstitute
+	if got := h.awayReviewers("foo/bar", []string{"alice", "carol"}, now); !reflect.DeepEqual(got, []string{"bob", "carol"}) {
+		t.Errorf("Expected bob in place of alice, got %v", got)
+	}
+	if got := h.awayReviewers("foo/bar", users, now.AddDate(0, 0, 15)); !reflect.DeepEqual(got, users) {
+		t.Errorf("Expected alice once back, got %v", got)
+	}
+
+	h.handleAway(comment("alice", 3, "@bot away off"))
+	if _, away := h.prefs.get("alice").isAway(now); away {
+		t.Error("Expected alice to be back")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"regexp"
 	"strings"
+	"unicode"
 )
 
 type body struct {
@@ -44,3 +45,42 @@ func parseBody(s string) body {
 
 	return res
 }
+
+// option returns the value of a "--name=value" option given in the command,
+// which may also be written "name=value", and whether it was present at all.
+func (b body) option(name string) (string, bool) {
+	prefix := name + "="
+	for _, field := range strings.Fields(b.command) {
+		field = strings.TrimPrefix(field, "--")
+		if strings.HasPrefix(strings.ToLower(field), prefix) {
+			return field[len(prefix):], true
+		}
+	}
+	return "", false
+}
+
+// flag returns whether a "--name" option was given in the command.
+func (b body) flag(name string) bool {
+	for _, field := range strings.Fields(b.command) {
+		if strings.ToLower(field) == "--"+name {
+			return true
+		}
+	}
+	return false
+}
+
+// verbatimMessage returns the commit message given after the command
+// exactly as written, only without the surrounding blank lines and trailing
+// whitespace.
+func verbatimMessage(s string) string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	idx := strings.Index(s, "\n")
+	if idx < 0 {
+		return ""
+	}
+	lines := strings.Split(s[idx+1:], "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	return strings.TrimRightFunc(strings.Join(lines, "\n"), unicode.IsSpace)
+}
@@ -25,3 +25,34 @@ func TestParseBody(t *testing.T) {
 		}
 	}
 }
+
+func TestBodyOption(t *testing.T) {
+	b := parseBody("@st-review: merge --wait=3h --Foo=bar")
+	if v, ok := b.option("wait"); !ok || v != "3h" {
+		t.Errorf("Unexpected wait option %q, %v", v, ok)
+	}
+	if v, ok := b.option("foo"); !ok || v != "bar" {
+		t.Errorf("Unexpected foo option %q, %v", v, ok)
+	}
+	if _, ok := b.option("other"); ok {
+		t.Error("Unexpected other option")
+	}
+
+	// The dashes may be left out.
+	if v, ok := parseBody("@st-review: merge wait=2h").option("wait"); !ok || v != "2h" {
+		t.Errorf("Unexpected wait option %q, %v", v, ok)
+	}
+}
+
+func TestVerbatimMessage(t *testing.T) {
+	cases := [][2]string{
+		{"@st-review: merge", ""},
+		{"@st-review: merge\r\n\r\nSubject\r\n\r\n# Heading\r\n\r\n\r\n| a | b |\r\n|---|---|  \r\n\r\n", "Subject\n\n# Heading\n\n\n| a | b |\n|---|---|"},
+		{"@st-review: merge\nSubject\n    indented", "Subject\n    indented"},
+	}
+	for _, tc := range cases {
+		if actual := verbatimMessage(tc[0]); actual != tc[1] {
+			t.Errorf("verbatimMessage(%q) = %q, expected %q", tc[0], actual, tc[1])
+		}
+	}
+}
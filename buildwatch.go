@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// buildWatchPoll is how often to look at the statuses of a merge, unless
+// CI events wake us up sooner.
+var buildWatchPoll = time.Minute
+
+// watchBuild follows the statuses of the merge of the PR as sha, onto
+// before, for as long as the repository's WatchBuild says, and tells the PR
+// if the build of its base branch breaks there, so that whoever decides on
+// reverting knows which merge to look at. Where the failure started with
+// the merge, it may also propose the revert.
+func (h *handler) watchBuild(c comment, pr pr, before, sha string) {
+	repo := c.Repository.FullName
+	rs := h.settings.forRepo(repo)
+	if rs.WatchBuild.Duration <= 0 || rs.Artifacts.appliesTo(pr.Base.Ref) {
+		return // artifact hand-offs report broken builds themselves
+	}
+	deadline := time.Now().Add(rs.WatchBuild.Duration)
+	merged := pr
+	pr.Head.SHA = sha
+	pr.PullRequest.Head.SHA = sha
+	pr.StatusesURL = fmt.Sprintf("%s/repos/%s/commits/%s/statuses", githubAPI, repo, sha)
+
+	events, stop := h.ci.watch(repo, sha)
+	defer stop()
+	for time.Now().Before(deadline) {
+		statuses, _ := inGrace(rs.FailureGrace, h.reportedStatuses(repo, pr), time.Now())
+		failed, pending := brokenStatuses(statuses)
+		if len(failed) > 0 {
+			log.Printf("The build of %s on %s broke at %s, merged from PR %d", pr.Base.Ref, repo, sha, c.Issue.Number)
+			metrics.add("merges_broke_build", 1)
+			c.post(withNotes(buildBrokeResponse(c, pr.Base.Ref, sha), failed), h.username, h.token)
+			h.notifyOutcome(c, pr, fmt.Sprintf("The build of %s broke at %s.", pr.Base.Ref, sha))
+			if h.proposingReverts(repo) && h.pointsAtMerge(repo, merged, before, failedStatuses(statuses)) {
+				if url, err := h.revertPR(c, merged, before, sha, failed); err != nil {
+					log.Printf("Revert of PR %d on %s: %v", c.Issue.Number, repo, err)
+				} else {
+					c.post(revertProposedResponse(c, url), h.username, h.token)
+				}
+			}
+			return
+		}
+		if len(statuses) > 0 && !pending {
+			return // it passed
+		}
+		waitForCI(events, buildWatchPoll)
+	}
+}
+
+// failedStatuses returns the statuses that failed or errored.
+func failedStatuses(ss []status) []status {
+	var res []status
+	for _, s := range ss {
+		if s.State == stateFailure || s.State == stateError {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// brokenStatuses returns the failed statuses as links, and whether any are
+// still pending.
+func brokenStatuses(ss []status) ([]string, bool) {
+	var failed []string
+	pending := false
+	for _, s := range ss {
+		switch s.State {
+		case stateFailure, stateError:
+			line := fmt.Sprintf("`%s`: %s", s.Context, s.Description)
+			if s.TargetURL != "" {
+				line = fmt.Sprintf("[`%s`](%s): %s", s.Context, s.TargetURL, s.Description)
+			}
+			failed = append(failed, line)
+		case statePending:
+			pending = true
+		}
+	}
+	sort.Strings(failed)
+	return failed, pending
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatchBuild(t *testing.T) {
+	var mut sync.Mutex
+	polls := 0
+	var posted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		defer mut.Unlock()
+		switch r.URL.Path {
+		case "/repos/foo/bar/commits/m1/statuses":
+			polls++
+			if polls == 1 {
+				w.Write([]byte(`[{"state": "pending", "context": "ci"}]`))
+			} else {
+				w.Write([]byte(`[{"state": "failure", "context": "ci", "description": "Tests failed", "target_url": "https://ci/9"}, {"state": "success", "context": "lint"}]`))
+			}
+		case "/repos/foo/bar/commits/m2/statuses":
+			w.Write([]byte(`[{"state": "success", "context": "ci"}]`))
+		case "/comments":
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			posted = append(posted, body.Body)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+	defer func(d time.Duration) { buildWatchPoll = d }(buildWatchPoll)
+	buildWatchPoll = time.Millisecond
+
+	h := newHandler(nil, "bot", "", false)
+	h.settings.defaults.WatchBuild = duration{time.Minute}
+	var c comment
+	c.Repository.FullName = "foo/bar"
+	c.Sender.Login = "alice"
+	c.Issue.User.Login = "carol"
+	c.Issue.CommentsURL = srv.URL + "/comments"
+	var p pr
+	p.Base.Ref = "main"
+
+	h.watchBuild(c, p, "", "m2")
+	h.watchBuild(c, p, "", "m1")
+	if len(posted) != 1 || !strings.HasPrefix(posted[0], ":rotating_light: @alice @carol: The build of `main` broke at m1") ||
+		!strings.Contains(posted[0], "[`ci`](https://ci/9): Tests failed") || strings.Contains(posted[0], "lint") {
+		t.Errorf("Unexpected responses %q", posted)
+	}
+
+	h.settings.defaults.Artifacts = &artifactHandoff{}
+	h.watchBuild(c, p, "", "m1")
+	if len(posted) != 1 {
+		t.Error("Expected artifact hand-offs to report broken builds instead")
+	}
+}
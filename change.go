@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// changeTicketPattern is what change tickets look like. Anything else could
+// change the meaning of the query the ticket is looked up with.
+var changeTicketPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// A changeGate requires an approved change ticket, given as --change=ID in
+// the merge command, before merging into any of the listed branches. The
+// ticket is looked up either in ServiceNow or at a generic HTTP endpoint.
+type changeGate struct {
+	Branches []string
+	Type     string // "servicenow" or "http"
+	URL      string // ServiceNow instance, or template expanding {ticket}, {repo} and {branch}
+	User     string
+	Token    string
+}
+
+// appliesTo returns true if merges into the branch need a change ticket.
+func (g *changeGate) appliesTo(branch string) bool {
+	if g == nil {
+		return false
+	}
+	for _, b := range g.Branches {
+		if b == branch {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns nil if the ticket is approved, or an error explaining why
+// it isn't.
+func (g *changeGate) check(ticket, repo, branch string) error {
+	if !changeTicketPattern.MatchString(ticket) {
+		return fmt.Errorf("%q is not a change ticket", ticket)
+	}
+	var reqURL string
+	switch g.Type {
+	case "servicenow":
+		q := url.Values{
+			"sysparm_query":  {"number=" + ticket},
+			"sysparm_fields": {"number,approval,state"},
+		}
+		reqURL = strings.TrimRight(g.URL, "/") + "/api/now/table/change_request?" + q.Encode()
+	case "http":
+		reqURL = strings.NewReplacer(
+			"{ticket}", url.QueryEscape(ticket),
+			"{repo}", repo,
+			"{branch}", url.QueryEscape(branch),
+		).Replace(g.URL)
+	default:
+		return fmt.Errorf("unknown change gate type %q", g.Type)
+	}
+
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	switch {
+	case g.User != "":
+		req.SetBasicAuth(g.User, g.Token)
+	case g.Token != "":
+		req.Header.Set("Authorization", "Bearer "+g.Token)
+	}
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("change %s does not exist", ticket)
+	}
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("looking up change %s: %s", ticket, resp.Status)
+	}
+
+	if g.Type == "servicenow" {
+		return serviceNowApproved(json.NewDecoder(resp.Body), ticket)
+	}
+	return httpApproved(json.NewDecoder(resp.Body), ticket)
+}
+
+// serviceNowApproved checks the result of a change_request table query.
+func serviceNowApproved(dec *json.Decoder, ticket string) error {
+	var res struct {
+		Result []struct {
+			Number   string
+			Approval string
+		}
+	}
+	if err := dec.Decode(&res); err != nil {
+		return err
+	}
+	if len(res.Result) == 0 || res.Result[0].Number != ticket {
+		return fmt.Errorf("change %s does not exist", ticket)
+	}
+	if a := res.Result[0].Approval; a != "approved" {
+		return fmt.Errorf("change %s is %s, not approved", ticket, a)
+	}
+	return nil
+}
+
+// httpApproved checks a generic answer on the form {"approved": true} with
+// an optional "reason" for a change not being approved.
+func httpApproved(dec *json.Decoder, ticket string) error {
+	var res struct {
+		Approved bool
+		Reason   string
+	}
+	if err := dec.Decode(&res); err != nil {
+		return err
+	}
+	if !res.Approved {
+		if res.Reason != "" {
+			return fmt.Errorf("change %s is not approved: %s", ticket, res.Reason)
+		}
+		return fmt.Errorf("change %s is not approved", ticket)
+	}
+	return nil
+}
+
+// changeTicket returns the change ticket given with the comment, if any.
+func (c *comment) changeTicket() string {
+	v, _ := c.parseBody().option("change")
+	return v
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChangeGate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("sysparm_query") {
+		case "number=CHG1":
+			w.Write([]byte(`{"result": [{"number": "CHG1", "approval": "approved"}]}`))
+		case "number=CHG2":
+			w.Write([]byte(`{"result": [{"number": "CHG2", "approval": "requested"}]}`))
+		case "number=CHG4":
+			w.Write([]byte(`{"result": [{"number": "CHG1", "approval": "approved"}]}`))
+		case "number=CHG3^ORnumberISNOTEMPTY":
+			w.Write([]byte(`{"result": [{"number": "CHG1", "approval": "approved"}]}`))
+		default:
+			w.Write([]byte(`{"result": []}`))
+		}
+	}))
+	defer srv.Close()
+
+	g := &changeGate{Branches: []string{"production"}, Type: "servicenow", URL: srv.URL}
+	if !g.appliesTo("production") || g.appliesTo("main") {
+		t.Error("Unexpected branch matching")
+	}
+	if (*changeGate)(nil).appliesTo("production") {
+		t.Error("Nil gate should not apply")
+	}
+
+	if err := g.check("CHG1", "foo/bar", "production"); err != nil {
+		t.Error("Unexpected error for approved change:", err)
+	}
+	if err := g.check("CHG2", "foo/bar", "production"); err == nil {
+		t.Error("Expected error for unapproved change")
+	}
+	if err := g.check("CHG3", "foo/bar", "production"); err == nil {
+		t.Error("Expected error for missing change")
+	}
+
+	// Tickets can't widen the query to find some other approved change.
+	if err := g.check("CHG3^ORnumberISNOTEMPTY", "foo/bar", "production"); err == nil {
+		t.Error("Expected error for a ticket extending the query")
+	}
+	if err := g.check("CHG4", "foo/bar", "production"); err == nil {
+		t.Error("Expected error for a result that's another change")
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// A checkRun is a result reported through the checks API, which is what
+// GitHub Actions and most apps use instead of commit statuses.
+type checkRun struct {
+	ID          int64
+	Name        string
+	Status      string    // queued, in_progress or completed
+	Conclusion  string    // set when completed
+	DetailsURL  string    `json:"details_url"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	Output      struct {
+		Title string
+	}
+}
+
+// A checkSuite groups the check runs of one app for a commit.
+type checkSuite struct {
+	Status               string
+	Conclusion           string
+	LatestCheckRunsCount int       `json:"latest_check_runs_count"`
+	UpdatedAt            time.Time `json:"updated_at"`
+	App                  struct {
+		Slug string
+		Name string
+	}
+}
+
+// checkState maps the status and conclusion of a check run or suite to the
+// corresponding commit status state.
+func checkState(st, conclusion string) prState {
+	if st != "completed" {
+		return statePending
+	}
+	switch conclusion {
+	case "success", "neutral", "skipped":
+		return stateSuccess
+	case "cancelled":
+		return stateError
+	default: // failure, timed_out, action_required, stale, startup_failure
+		return stateFailure
+	}
+}
+
+// checkStatuses converts the check runs to statuses, keeping only the
+// latest run for each name as reruns add new runs under the same name.
+// Suites without any check runs are included only if they concluded
+// unsuccessfully, which is how broken workflow files show up; queued suites
+// without runs are created for every installed app and never progress.
+func checkStatuses(runs []checkRun, suites []checkSuite) []status {
+	latest := make(map[string]checkRun)
+	var order []string
+	for _, r := range runs {
+		prev, ok := latest[r.Name]
+		if !ok {
+			order = append(order, r.Name)
+		}
+		if !ok || r.ID > prev.ID {
+			latest[r.Name] = r
+		}
+	}
+
+	var res []status
+	for _, name := range order {
+		r := latest[name]
+		st := status{
+			State:       checkState(r.Status, r.Conclusion),
+			Context:     r.Name,
+			Description: r.Output.Title,
+			TargetURL:   r.DetailsURL,
+			UpdatedAt:   r.StartedAt,
+		}
+		if !r.CompletedAt.IsZero() {
+			st.UpdatedAt = r.CompletedAt
+		}
+		if st.Description == "" {
+			st.Description = r.Status
+			if r.Conclusion != "" {
+				st.Description = r.Conclusion
+			}
+		}
+		res = append(res, st)
+	}
+	for _, s := range suites {
+		if s.LatestCheckRunsCount > 0 || s.Status != "completed" {
+			continue
+		}
+		if state := checkState(s.Status, s.Conclusion); state != stateSuccess {
+			res = append(res, status{
+				State:       state,
+				Context:     s.App.Slug,
+				Description: fmt.Sprintf("%s check suite: %s", s.App.Name, s.Conclusion),
+				UpdatedAt:   s.UpdatedAt,
+			})
+		}
+	}
+	return res
+}
+
+// checksContext is the context of the pending status standing in for the
+// check runs when they can't be fetched, so that failing runs that weren't
+// seen hold up merges rather than let them through.
+const checksContext = "st-review/checks"
+
+// getChecks returns the check runs and suites of the PR head as statuses,
+// following every page of each.
+func (p *pr) getChecks(repo, username, token string) ([]status, error) {
+	sha := p.Head.SHA
+	if sha == "" {
+		sha = p.PullRequest.Head.SHA
+	}
+	base := fmt.Sprintf("%s/repos/%s/commits/%s", githubAPI, repo, sha)
+
+	var runs []checkRun
+	for u := base + "/check-runs?filter=all&per_page=100"; u != ""; {
+		var page struct {
+			CheckRuns []checkRun `json:"check_runs"`
+		}
+		next, err := apiGetPage(u, &page, username, token)
+		if err != nil {
+			return nil, fmt.Errorf("check runs: %v", err)
+		}
+		runs = append(runs, page.CheckRuns...)
+		u = next
+	}
+	var suites []checkSuite
+	for u := base + "/check-suites?per_page=100"; u != ""; {
+		var page struct {
+			CheckSuites []checkSuite `json:"check_suites"`
+		}
+		next, err := apiGetPage(u, &page, username, token)
+		if err != nil {
+			return nil, fmt.Errorf("check suites: %v", err)
+		}
+		suites = append(suites, page.CheckSuites...)
+		u = next
+	}
+	return checkStatuses(runs, suites), nil
+}
+
+// checksUnknown is the status for check runs that couldn't be fetched.
+func checksUnknown(err error) status {
+	log.Println("Checks:", err)
+	return status{
+		State:       statePending,
+		Context:     checksContext,
+		Description: "The check runs couldn't be fetched; trying again.",
+	}
+}
+
+// withChecks adds the check results to the statuses. Commit statuses win
+// over check runs of the same name.
+func withChecks(statuses, checks []status) []status {
+	seen := make(map[string]bool)
+	for _, st := range statuses {
+		seen[st.Context] = true
+	}
+	for _, st := range checks {
+		if !seen[st.Context] {
+			statuses = append(statuses, st)
+		}
+	}
+	return statuses
+}
+
+// createCheckRun reports on the PR head as a check run, which only GitHub
+// Apps can do. Pending states leave the run in progress.
+func (p *pr) createCheckRun(repo, name string, state prState, title, summary, detailsURL, username, token string) error {
+	sha := p.Head.SHA
+	if sha == "" {
+		sha = p.PullRequest.Head.SHA
+	}
+	run := map[string]interface{}{
+		"name":     name,
+		"head_sha": sha,
+		"output":   map[string]string{"title": title, "summary": summary},
+	}
+	switch state {
+	case statePending:
+		run["status"] = "in_progress"
+	case stateSuccess:
+		run["conclusion"] = "success"
+	default:
+		run["conclusion"] = "failure"
+	}
+	if detailsURL != "" {
+		run["details_url"] = detailsURL
+	}
+	return apiRequest("POST", fmt.Sprintf("%s/repos/%s/check-runs", githubAPI, repo), run, nil, username, token)
+}
+
+// withoutContext returns the statuses except those for the context.
+func withoutContext(statuses []status, context string) []status {
+	var res []status
+	for _, st := range statuses {
+		if st.Context != context {
+			res = append(res, st)
+		}
+	}
+	return res
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckStatuses(t *testing.T) {
+	run := func(id int64, name, st, conclusion string) checkRun {
+		return checkRun{ID: id, Name: name, Status: st, Conclusion: conclusion}
+	}
+	suite := func(slug, st, conclusion string, runs int) checkSuite {
+		s := checkSuite{Status: st, Conclusion: conclusion, LatestCheckRunsCount: runs}
+		s.App.Slug = slug
+		return s
+	}
+	cases := []struct {
+		runs   []checkRun
+		suites []checkSuite
+		exp    prState
+		n      int
+	}{
+		{nil, nil, stateSuccess, 0},
+		{[]checkRun{run(1, "build", "completed", "success"), run(2, "lint", "completed", "neutral")}, nil, stateSuccess, 2},
+		{[]checkRun{run(1, "build", "in_progress", "")}, nil, statePending, 1},
+		{[]checkRun{run(1, "build", "completed", "timed_out")}, nil, stateFailure, 1},
+		{[]checkRun{run(1, "build", "completed", "cancelled")}, nil, stateError, 1},
+		// A rerun replaces the earlier result, whichever order they're listed in.
+		{[]checkRun{run(2, "build", "completed", "success"), run(1, "build", "completed", "failure")}, nil, stateSuccess, 1},
+		{[]checkRun{run(1, "build", "completed", "failure"), run(2, "build", "queued", "")}, nil, statePending, 1},
+		// Suites without runs count only when they failed.
+		{nil, []checkSuite{suite("dependabot", "queued", "", 0)}, stateSuccess, 0},
+		{nil, []checkSuite{suite("github-actions", "completed", "startup_failure", 0)}, stateFailure, 1},
+		{[]checkRun{run(1, "build", "completed", "success")}, []checkSuite{suite("github-actions", "completed", "success", 1)}, stateSuccess, 1},
+	}
+	for i, c := range cases {
+		ss := checkStatuses(c.runs, c.suites)
+		if len(ss) != c.n {
+			t.Errorf("%d: expected %d statuses, got %v", i, c.n, ss)
+		}
+		if st := overallStatus(ss, nil); st != c.exp {
+			t.Errorf("%d: expected %s, got %s", i, c.exp, st)
+		}
+	}
+}
+
+func TestWithChecks(t *testing.T) {
+	statuses := []status{{Context: "build", State: stateSuccess}}
+	checks := []status{{Context: "build", State: stateFailure}, {Context: "lint", State: statePending}}
+	res := withChecks(statuses, checks)
+	if len(res) != 2 || res[0].State != stateSuccess || res[1].Context != "lint" {
+		t.Errorf("Unexpected statuses %v", res)
+	}
+}
+
+func TestGetChecks(t *testing.T) {
+	failing := false
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/statuses":
+			w.Write([]byte(`[{"state": "success", "context": "ci"}]`))
+		case failing:
+			http.Error(w, "rate limited", http.StatusForbidden)
+		case r.URL.Path == "/repos/foo/bar/commits/abc/check-runs" && r.URL.Query().Get("page") == "":
+			w.Header().Set("Link", `<`+srv.URL+`/repos/foo/bar/commits/abc/check-runs?page=2>; rel="next", <`+srv.URL+`/repos/foo/bar/commits/abc/check-runs?page=2>; rel="last"`)
+			w.Write([]byte(`{"check_runs": [{"id": 1, "name": "build", "status": "completed", "conclusion": "success"}]}`))
+		case r.URL.Path == "/repos/foo/bar/commits/abc/check-runs":
+			w.Write([]byte(`{"check_runs": [{"id": 2, "name": "test", "status": "completed", "conclusion": "failure"}]}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	var p pr
+	p.Head.SHA = "abc"
+	p.StatusesURL = srv.URL + "/statuses"
+	// A failing run on the second page isn't missed.
+	checks, err := p.getChecks("foo/bar", "bot", "token")
+	if err != nil || len(checks) != 2 || checks[1].Context != "test" || checks[1].State != stateFailure {
+		t.Errorf("Expected the checks of both pages, got %v, %v", checks, err)
+	}
+	if st := overallStatus(p.getStatuses("foo/bar", "bot", "token"), nil); st != stateFailure {
+		t.Errorf("Expected failure, got %s", st)
+	}
+
+	// Checks that can't be fetched aren't taken as no checks.
+	failing = true
+	if _, err := p.getChecks("foo/bar", "bot", "token"); err == nil {
+		t.Error("Expected an error")
+	}
+	if st := overallStatus(p.getStatuses("foo/bar", "bot", "token"), nil); st != statePending {
+		t.Errorf("Expected pending, got %s", st)
+	}
+}
+
+func TestNextPageURL(t *testing.T) {
+	cases := []struct {
+		link, next string
+	}{
+		{`<https://api.github.com/x?page=2>; rel="next", <https://api.github.com/x?page=5>; rel="last"`, "https://api.github.com/x?page=2"},
+		{`<https://api.github.com/x?page=1>; rel="prev", <https://api.github.com/x?page=3>; rel="next"`, "https://api.github.com/x?page=3"},
+		{`<https://api.github.com/x?page=1>; rel="first"`, ""},
+		{"", ""},
+	}
+	for _, tc := range cases {
+		if next := nextPageURL(tc.link); next != tc.next {
+			t.Errorf("nextPageURL(%q) = %q, expected %q", tc.link, next, tc.next)
+		}
+	}
+}
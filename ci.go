@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// A ciSource is an external CI system consulted directly for the result of
+// a build, for systems that don't reliably report statuses to GitHub. The
+// result is reported as a status with the given context, replacing any
+// status GitHub has for the same context.
+type ciSource struct {
+	Type    string // "jenkins", "buildkite" or "teamcity"
+	Context string
+	URL     string // expanding {repo}, {owner}, {name}, {number} and {sha}
+	User    string // for Jenkins
+	Token   string
+}
+
+// ciParsers extract the build state and a description from the response of
+// each kind of CI system.
+var ciParsers = map[string]func(io.Reader) (prState, string, error){
+	"jenkins":   parseJenkins,
+	"buildkite": parseBuildkite,
+	"teamcity":  parseTeamCity,
+}
+
+// status returns the state of the build for the PR. A CI system that can't
+// be reached results in a pending status, so that we keep waiting for it.
+func (s ciSource) status(repo string, p pr) status {
+	st := status{Context: s.Context, State: statePending}
+	state, descr, err := s.fetch(expandPRURL(s.URL, repo, p))
+	if err != nil {
+		log.Printf("CI status %s for %s: %v", s.Context, repo, err)
+		st.Description = err.Error()
+		return st
+	}
+	st.State = state
+	st.Description = descr
+	return st
+}
+
+func (s ciSource) fetch(url string) (prState, string, error) {
+	parse, ok := ciParsers[s.Type]
+	if !ok {
+		return "", "", fmt.Errorf("unknown CI type %q", s.Type)
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	switch {
+	case s.Type == "jenkins" && s.User != "":
+		req.SetBasicAuth(s.User, s.Token)
+	case s.Token != "":
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return "", "", fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return parse(resp.Body)
+}
+
+// parseJenkins handles the JSON API of a single build, as in
+// https://jenkins/job/name/job/PR-{number}/lastBuild/api/json.
+func parseJenkins(r io.Reader) (prState, string, error) {
+	var build struct {
+		Building bool
+		Result   string
+	}
+	if err := json.NewDecoder(r).Decode(&build); err != nil {
+		return "", "", err
+	}
+	switch {
+	case build.Building || build.Result == "":
+		return statePending, "Build in progress", nil
+	case build.Result == "SUCCESS":
+		return stateSuccess, "Build succeeded", nil
+	case build.Result == "FAILURE" || build.Result == "UNSTABLE":
+		return stateFailure, "Build result " + build.Result, nil
+	default:
+		return stateError, "Build result " + build.Result, nil
+	}
+}
+
+// parseBuildkite handles a list of builds, newest first, as in
+// https://api.buildkite.com/v2/organizations/org/pipelines/name/builds?commit={sha}.
+func parseBuildkite(r io.Reader) (prState, string, error) {
+	var builds []struct {
+		State string
+	}
+	if err := json.NewDecoder(r).Decode(&builds); err != nil {
+		return "", "", err
+	}
+	if len(builds) == 0 {
+		return statePending, "No build yet", nil
+	}
+	switch state := builds[0].State; state {
+	case "passed":
+		return stateSuccess, "Build passed", nil
+	case "failed", "failing":
+		return stateFailure, "Build " + state, nil
+	case "canceled", "canceling", "skipped", "not_run":
+		return stateError, "Build " + state, nil
+	default:
+		return statePending, "Build " + state, nil
+	}
+}
+
+// parseTeamCity handles a list of builds, newest first, as in
+// https://teamcity/app/rest/builds?locator=revision:{sha}.
+func parseTeamCity(r io.Reader) (prState, string, error) {
+	var res struct {
+		Build []struct {
+			State  string
+			Status string
+		}
+	}
+	if err := json.NewDecoder(r).Decode(&res); err != nil {
+		return "", "", err
+	}
+	if len(res.Build) == 0 {
+		return statePending, "No build yet", nil
+	}
+	b := res.Build[0]
+	if b.State != "finished" {
+		return statePending, "Build " + b.State, nil
+	}
+	switch b.Status {
+	case "SUCCESS":
+		return stateSuccess, "Build succeeded", nil
+	case "FAILURE":
+		return stateFailure, "Build failed", nil
+	default:
+		return stateError, "Build status " + b.Status, nil
+	}
+}
+
+// withCIStatuses returns the statuses with those from the CI sources added,
+// replacing any existing status with the same context.
+func withCIStatuses(statuses []status, sources []ciSource, repo string, p pr) []status {
+	if len(sources) == 0 {
+		return statuses
+	}
+	replaced := make(map[string]bool)
+	var ci []status
+	for _, src := range sources {
+		ci = append(ci, src.status(repo, p))
+		replaced[src.Context] = true
+	}
+	var res []status
+	for _, st := range statuses {
+		if !replaced[st.Context] {
+			res = append(res, st)
+		}
+	}
+	return append(res, ci...)
+}
+
+// expandPRURL expands {repo}, {owner}, {name}, {number} and {sha} in the
+// URL template.
+func expandPRURL(tmpl, repo string, p pr) string {
+	owner, name := repo, ""
+	if idx := strings.Index(repo, "/"); idx >= 0 {
+		owner, name = repo[:idx], repo[idx+1:]
+	}
+	sha := p.Head.SHA
+	if sha == "" {
+		sha = p.PullRequest.Head.SHA
+	}
+	return strings.NewReplacer(
+		"{repo}", repo,
+		"{owner}", owner,
+		"{name}", name,
+		"{number}", fmt.Sprint(p.Number),
+		"{sha}", sha,
+	).Replace(tmpl)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCIParsers(t *testing.T) {
+	cases := []struct {
+		kind  string
+		body  string
+		state prState
+	}{
+		{"jenkins", `{"building": true, "result": null}`, statePending},
+		{"jenkins", `{"building": false, "result": "SUCCESS"}`, stateSuccess},
+		{"jenkins", `{"building": false, "result": "UNSTABLE"}`, stateFailure},
+		{"jenkins", `{"building": false, "result": "ABORTED"}`, stateError},
+		{"buildkite", `[]`, statePending},
+		{"buildkite", `[{"state": "running"}, {"state": "failed"}]`, statePending},
+		{"buildkite", `[{"state": "passed"}]`, stateSuccess},
+		{"buildkite", `[{"state": "failed"}]`, stateFailure},
+		{"teamcity", `{"build": [{"state": "running", "status": "SUCCESS"}]}`, statePending},
+		{"teamcity", `{"build": [{"state": "finished", "status": "SUCCESS"}]}`, stateSuccess},
+		{"teamcity", `{"build": [{"state": "finished", "status": "FAILURE"}]}`, stateFailure},
+	}
+	for _, tc := range cases {
+		state, _, err := ciParsers[tc.kind](strings.NewReader(tc.body))
+		if err != nil {
+			t.Errorf("%s %s: %v", tc.kind, tc.body, err)
+			continue
+		}
+		if state != tc.state {
+			t.Errorf("%s %s: got %s, expected %s", tc.kind, tc.body, state, tc.state)
+		}
+	}
+}
+
+func TestExpandPRURL(t *testing.T) {
+	var p pr
+	p.Number = 42
+	p.Head.SHA = "abc123"
+	u := expandPRURL("https://ci/{owner}/{name}/PR-{number}/{sha}?r={repo}", "foo/bar", p)
+	if u != "https://ci/foo/bar/PR-42/abc123?r=foo/bar" {
+		t.Error("Unexpected URL", u)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A ciEvent is a status, check_run or check_suite event, telling that CI
+// has something new to say about a commit.
+type ciEvent struct {
+	SHA      string // status events
+	Context  string // status events
+	CheckRun struct {
+		HeadSHA string `json:"head_sha"`
+	} `json:"check_run"`
+	CheckSuite struct {
+		HeadSHA string `json:"head_sha"`
+	} `json:"check_suite"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	}
+}
+
+// headSHA returns the commit the event is about.
+func (e ciEvent) headSHA() string {
+	switch {
+	case e.SHA != "":
+		return e.SHA
+	case e.CheckRun.HeadSHA != "":
+		return e.CheckRun.HeadSHA
+	}
+	return e.CheckSuite.HeadSHA
+}
+
+// ciWatchers wakes up those waiting for the statuses of a commit when CI
+// events arrive for it, so that polling is only a fallback for missed
+// events.
+type ciWatchers struct {
+	mut      sync.Mutex
+	watchers map[string]map[chan struct{}]bool // "owner/name@sha" -> channels
+}
+
+// watch returns a channel that receives when there are events for any of
+// the commits, along with a function to stop watching.
+func (w *ciWatchers) watch(repo string, shas ...string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if w.watchers == nil {
+		w.watchers = make(map[string]map[chan struct{}]bool)
+	}
+	for _, sha := range shas {
+		key := repo + "@" + sha
+		if w.watchers[key] == nil {
+			w.watchers[key] = make(map[chan struct{}]bool)
+		}
+		w.watchers[key][ch] = true
+	}
+	return ch, func() {
+		w.mut.Lock()
+		defer w.mut.Unlock()
+		for _, sha := range shas {
+			key := repo + "@" + sha
+			delete(w.watchers[key], ch)
+			if len(w.watchers[key]) == 0 {
+				delete(w.watchers, key)
+			}
+		}
+	}
+}
+
+// notify wakes up those watching the commit. It returns whether anyone
+// was.
+func (w *ciWatchers) notify(repo, sha string) bool {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	chs := w.watchers[repo+"@"+sha]
+	for ch := range chs {
+		select {
+		case ch <- struct{}{}:
+		default: // already woken up
+		}
+	}
+	return len(chs) > 0
+}
+
+// handleCIEvent re-evaluates pending merges waiting for the commit of the
+// event.
+func (h *handler) handleCIEvent(e ciEvent) {
+	if strings.HasPrefix(e.Context, "st-review") {
+		return // our own
+	}
+	if h.ci.notify(e.Repository.FullName, e.headSHA()) {
+		log.Printf("CI update for %s@%s, re-evaluating", e.Repository.FullName, e.headSHA())
+	}
+}
+
+// waitForCI waits for a CI event or for the poll interval to pass,
+// returning whether it was an event.
+func waitForCI(events <-chan struct{}, poll time.Duration) bool {
+	t := time.NewTimer(poll)
+	defer t.Stop()
+	select {
+	case <-events:
+		return true
+	case <-t.C:
+		return false
+	}
+}
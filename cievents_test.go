@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCIEventSHA(t *testing.T) {
+	for _, tc := range []struct {
+		payload, sha string
+	}{
+		{`{"sha": "abc", "context": "ci"}`, "abc"},
+		{`{"check_run": {"head_sha": "def"}}`, "def"},
+		{`{"check_suite": {"head_sha": "123"}}`, "123"},
+	} {
+		var e ciEvent
+		if err := json.Unmarshal([]byte(tc.payload), &e); err != nil {
+			t.Fatal(err)
+		}
+		if sha := e.headSHA(); sha != tc.sha {
+			t.Errorf("Expected %q, got %q for %s", tc.sha, sha, tc.payload)
+		}
+	}
+}
+
+func TestCIWatchers(t *testing.T) {
+	var w ciWatchers
+	events, stop := w.watch("foo/bar", "abc", "def")
+
+	if w.notify("foo/bar", "123") || w.notify("foo/baz", "abc") {
+		t.Error("Unexpected watchers for other commits")
+	}
+	if waitForCI(events, time.Millisecond) {
+		t.Error("Unexpected event")
+	}
+
+	// Several events before the watcher gets to it wake it up once.
+	w.notify("foo/bar", "abc")
+	w.notify("foo/bar", "def")
+	if !waitForCI(events, time.Second) {
+		t.Error("Expected an event")
+	}
+	if waitForCI(events, time.Millisecond) {
+		t.Error("Unexpected second event")
+	}
+
+	stop()
+	if w.notify("foo/bar", "abc") || len(w.watchers) != 0 {
+		t.Error("Expected no watchers after stopping")
+	}
+}
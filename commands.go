@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Who may use a command.
+const (
+	whoAnyone     = "anyone"
+	whoMergers    = "those allowed to merge"
+	whoRepoAdmins = "repository admins"
+	whoAdmins     = "bot admins"
+)
+
+// A botCommand is a comment command we understand, with what the help
+// command says about it. Adding one here is all it takes for the webhook
+// to route it.
+type botCommand struct {
+	name  string
+	args  string // as shown in the help
+	who   string // checked before the command runs
+	help  string
+	run   func(*handler, comment)
+	gated bool // can be disabled as a feature, and is ignored where the bot is
+}
+
+// botCommands returns the comment commands, in the order the help lists
+// them.
+func botCommands() []botCommand {
+	return []botCommand{
+		{"merge", "[when approved] [--wait=DURATION] [--poll=DURATION] [--message=reflow|verbatim] [--no-squash] [--change=TICKET]", whoMergers,
+			"Merge once the checks pass; squashed unless configured otherwise. A subject and description may follow on the next lines, and `Skip-Check:` or `On-Behalf-Of:` lines as trailers.", (*handler).handleMerge, true},
+		{"squash", "[same as merge]", whoMergers, "Squash and merge once the checks pass.", (*handler).handleMerge, true},
+		{"rebase", "[same as merge]", whoMergers, "Rebase the commits onto the base once the checks pass.", (*handler).handleMerge, true},
+		{"stop", "", whoMergers, "Cancel a waiting merge and mark the PR as not to be merged as is.", (*handler).handleStop, true},
+		{"don't", "", whoMergers, "Same as stop.", (*handler).handleStop, true},
+		{"prevent", "", whoMergers, "Same as stop.", (*handler).handleStop, true},
+		{"lgtm", "", whoMergers, "Approve the PR, credited in the merged commit.", (*handler).handleLGTM, true},
+		{"status", "", whoAnyone, "Tell how the checks stand, where the PR is in the queue and who may merge it.", (*handler).handleStatus, true},
+		{"history", "", whoAnyone, "List who asked for what on this PR and when, with how it went, from the audit log.", (*handler).handleHistory, true},
+		{"retarget", "BRANCH", whoMergers, "Change the base branch of the PR.", (*handler).handleRetarget, true},
+		{"config", "[set KEY VALUE | unset KEY]", whoAnyone,
+			"Show or set your preferences, for all repositories: `notify` mention, quiet, chat or digest for how you're told about merges you ask for or author; `verbosity` full, terse or silent; `chat` the handle to message, once linked.", (*handler).handleConfig, true},
+		{"away", "until YYYY-MM-DD [backup LOGIN] | off", whoMergers,
+			"Mark yourself away until a day, handing the merges you're waiting on to a backup, or back again.", (*handler).handleAway, true},
+		{"link", "CODE", whoAnyone, "Link your chat handle, with the code sent there.", (*handler).handleLink, true},
+		{"release-notes", "", whoMergers, "Open a PR with release notes for what was merged since the last tag.", (*handler).handleReleaseNotes, true},
+		{"onboard", "OWNER/NAME", whoAdmins, "Set up the webhook and clone of a repository.", (*handler).handleOnboard, true},
+		{"disable", "", whoRepoAdmins, "Have the bot ignore this repository.", (*handler).handleDisable, false},
+		{"enable", "", whoRepoAdmins, "Have the bot serve this repository again.", (*handler).handleEnable, false},
+		{"help", "", whoAnyone, "List these commands.", (*handler).handleHelp, false},
+	}
+}
+
+// gatedCommand returns whether the command can be disabled.
+func gatedCommand(name string) bool {
+	for _, cmd := range botCommands() {
+		if cmd.name == name {
+			return cmd.gated
+		}
+	}
+	return false
+}
+
+// registerCommands registers the comment commands with the webhook.
+func (h *handler) registerCommands(w *webhook) {
+	for _, cmd := range botCommands() {
+		w.handleComment(cmd.name, h.command(cmd))
+	}
+}
+
+// command returns the comment handler running the command, where it's
+// enabled and for those who may use it.
+func (h *handler) command(cmd botCommand) commentHandler {
+	return func(c comment) {
+		repo := c.Repository.FullName
+		h.audit.record(auditEvent{Kind: "command", Repo: repo, PR: c.Issue.Number, User: c.Sender.Login, Detail: c.parseBody().command})
+		if cmd.gated {
+			if !h.botEnabled(repo) {
+				logInfo("Ignoring command where the bot is disabled", commentFields(c, cmd.name))
+				return
+			}
+			// The repository's file may allow users or disable commands.
+			if h.repoConfigEnabled(repo) && !h.loadRepoConfig(c) {
+				return
+			}
+			if !h.featureEnabled(repo, cmd.name, true) {
+				logInfo("Ignoring command where it is disabled", commentFields(c, cmd.name))
+				c.post(disabledResponse(c, cmd.name), h.username, h.token)
+				return
+			}
+		}
+		if !h.mayUse(cmd.who, repo, c.Sender.Login) {
+			if cmd.who == whoRepoAdmins {
+				c.post(notRepoAdminResponse(c), h.username, h.token)
+			} else {
+				c.post(noAccessResponse(c), h.username, h.token)
+			}
+			h.auditDenied(c, cmd.name)
+			logWarn("Rejecting command from someone not among "+cmd.who, commentFields(c, cmd.name))
+			return
+		}
+		h.audit.record(auditEvent{Kind: "allowed", Repo: repo, PR: c.Issue.Number, User: c.Sender.Login, Detail: cmd.name + ": among " + cmd.who})
+		start := time.Now()
+		f := commentFields(c, cmd.name)
+		logDebug("Running command", f)
+		cmd.run(h, c)
+		f.Duration = time.Since(start)
+		logInfo("Handled command", f)
+	}
+}
+
+// rerun runs the command of the comment again, as when picking up a merge
+// interrupted by a restart, checking again that it may still be used.
+func (h *handler) rerun(c comment) {
+	command := strings.ToLower(c.parseBody().command)
+	for _, cmd := range botCommands() {
+		if strings.HasPrefix(command, cmd.name) {
+			h.command(cmd)(c)
+			return
+		}
+	}
+	log.Printf("Not rerunning unknown command %q on %s", command, c.Repository.FullName)
+}
+
+// mayUse returns whether the user is among who may use a command in the
+// repository.
+func (h *handler) mayUse(who, repo, login string) bool {
+	switch who {
+	case whoAnyone:
+		return true
+	case whoMergers:
+		return h.isAllowed(repo, login)
+	case whoRepoAdmins:
+		return h.isRepoAdmin(repo, login)
+	case whoAdmins:
+		return h.isAdmin(repo, login)
+	}
+	return false
+}
+
+// commandHelp lists the commands available on the repository, as markdown.
+func (h *handler) commandHelp(repo string) string {
+	var lines []string
+	for _, cmd := range botCommands() {
+		if cmd.gated && !h.featureEnabled(repo, cmd.name, true) {
+			continue
+		}
+		usage := "`" + cmd.name + "`"
+		if cmd.args != "" {
+			usage = fmt.Sprintf("`%s %s`", cmd.name, cmd.args)
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s (%s)", usage, cmd.help, cmd.who))
+	}
+	return "- " + strings.Join(lines, "\n- ")
+}
+
+// handleHelp lists the commands.
+func (h *handler) handleHelp(c comment) {
+	c.post(helpResponse(c, h.commandHelp(c.Repository.FullName)), h.username, h.token)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCommandHelp(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, cmd := range botCommands() {
+		if seen[cmd.name] || cmd.run == nil || cmd.help == "" || cmd.who == "" {
+			t.Errorf("Bad command %q", cmd.name)
+		}
+		seen[cmd.name] = true
+	}
+
+	h := newHandler(nil, "bot", "", false)
+	h.settings.repos = map[string]repoSettings{"foo/quiet": {Features: map[string]bool{"lgtm": false}}}
+
+	help := h.commandHelp("foo/bar")
+	for _, want := range []string{"- `merge [when approved]", "- `retarget BRANCH`: Change the base branch of the PR. (those allowed to merge)", "- `lgtm`", "- `help`"} {
+		if !strings.Contains(help, want) {
+			t.Errorf("Help lacks %q:\n%s", want, help)
+		}
+	}
+	if help := h.commandHelp("foo/quiet"); strings.Contains(help, "`lgtm`") {
+		t.Errorf("Help lists a disabled command:\n%s", help)
+	}
+}
+
+func TestCommandRouting(t *testing.T) {
+	var posted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/comments":
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			posted = append(posted, body.Body)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	h := newHandler(nil, "bot", "", false)
+	h.settings.repos = map[string]repoSettings{"foo/bar": {AllowedUsers: []string{"alice"}}}
+	h.settings.setRepoFile("foo/bar", repoSettings{Features: map[string]bool{"lgtm": false, "status": true}})
+	newComment := func(login string) comment {
+		var c comment
+		c.Repository.FullName = "foo/bar"
+		c.Sender.Login = login
+		c.Issue.CommentsURL = srv.URL + "/comments"
+		return c
+	}
+
+	var ran []string
+	run := func(h *handler, c comment) { ran = append(ran, c.Sender.Login) }
+	tests := []struct {
+		cmd     botCommand
+		login   string
+		ran     bool
+		posting string
+	}{
+		{botCommand{name: "status", who: whoAnyone, run: run, gated: true}, "bob", true, ""},
+		{botCommand{name: "retarget", who: whoMergers, run: run, gated: true}, "alice", true, ""},
+		{botCommand{name: "retarget", who: whoMergers, run: run, gated: true}, "bob", false, "can't do that"},
+		{botCommand{name: "onboard", who: whoAdmins, run: run, gated: true}, "alice", false, "can't do that"},
+		{botCommand{name: "lgtm", who: whoMergers, run: run, gated: true}, "alice", false, "not enabled"},
+	}
+	for _, test := range tests {
+		ran, posted = nil, nil
+		h.command(test.cmd)(newComment(test.login))
+		if (len(ran) == 1) != test.ran {
+			t.Errorf("%s by %s: ran %v", test.cmd.name, test.login, ran)
+		}
+		if test.posting == "" && len(posted) != 0 || test.posting != "" && (len(posted) != 1 || !strings.Contains(posted[0], test.posting)) {
+			t.Errorf("%s by %s: posted %q", test.cmd.name, test.login, posted)
+		}
+	}
+}
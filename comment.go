@@ -1,20 +1,20 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"log"
-	"net/http"
+	"fmt"
+	"strings"
 )
 
 type comment struct {
 	Action string
 
 	Comment struct {
+		ID   int64
 		User struct {
 			Login string
 		}
-		Body string
+		Body    string
+		HTMLURL string `json:"html_url"`
 	}
 
 	Issue struct {
@@ -37,6 +37,8 @@ type comment struct {
 		Login string
 		URL   string
 	}
+
+	Forge string `json:"forge,omitempty"` // where the comment was made, if not on GitHub
 }
 
 type user struct {
@@ -49,91 +51,60 @@ func (c *comment) parseBody() body {
 	return parseBody(c.Comment.Body)
 }
 
-func (c *comment) post(body, username, token string) {
-	buf := new(bytes.Buffer)
-	json.NewEncoder(buf).Encode(map[string]string{"body": body})
-	req, err := http.NewRequest("POST", c.Issue.CommentsURL, buf)
-	if err != nil {
-		log.Println("Request:", err)
-		return
-	}
-	req.SetBasicAuth(username, token)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Println("Post:", err)
-		return
-	}
-	resp.Body.Close()
-
-	if resp.StatusCode > 299 {
-		log.Println("Post:", resp.Status)
-		return
+// onBehalfOf returns the user named as in "merge on-behalf-of @user", if
+// any.
+func (c *comment) onBehalfOf() string {
+	fields := strings.Fields(c.parseBody().command)
+	for i := 0; i < len(fields)-1; i++ {
+		if strings.ToLower(fields[i]) == "on-behalf-of" {
+			return strings.TrimPrefix(fields[i+1], "@")
+		}
 	}
+	return ""
 }
 
-func (c *comment) close(username, token string) {
-	buf := new(bytes.Buffer)
-	json.NewEncoder(buf).Encode(map[string]string{"state": "closed"})
-	req, err := http.NewRequest("PATCH", c.Issue.URL, buf)
-	if err != nil {
-		log.Println("Request:", err)
-		return
-	}
-	req.SetBasicAuth(username, token)
+// maxAnchorLength is how much of the command is quoted in responses.
+const maxAnchorLength = 80
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Println("Post:", err)
-		return
+// anchor returns a quote of the command linking to the comment it was
+// given in, which starts responses such that it's clear which command they
+// answer. Comments not from an event, such as for merges resumed after a
+// restart, have no anchor.
+func (c *comment) anchor() string {
+	if c.Comment.HTMLURL == "" {
+		return ""
 	}
-	resp.Body.Close()
-
-	if resp.StatusCode > 299 {
-		log.Println("Post:", resp.Status)
-		return
+	cmd := strings.Join(strings.Fields(c.parseBody().command), " ")
+	if r := []rune(cmd); len(r) > maxAnchorLength {
+		cmd = string(r[:maxAnchorLength-1]) + "…"
 	}
+	return fmt.Sprintf("> [@%s](%s): %s\n\n", c.Comment.User.Login, c.Comment.HTMLURL, cmd)
 }
 
-func (c *comment) user(username, token string) (user, error) {
-	req, err := http.NewRequest("GET", c.Sender.URL, nil)
-	if err != nil {
-		log.Println("Request:", err)
-		return user{}, err
+func (c *comment) post(body, username, token string) {
+	if body == "" {
+		return // left out at this verbosity
 	}
-	req.SetBasicAuth(username, token)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Println("Get:", err)
-		return user{}, err
+	body = c.anchor() + body
+	for _, part := range commentBodies(secrets.redact(body)) {
+		if !c.postOne(part, username, token) {
+			return
+		}
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode > 299 {
-		log.Println("Post:", resp.Status)
-		return user{}, err
-	}
+func (c *comment) postOne(body, username, token string) bool {
+	return c.forge().postComment(c, body, username, token)
+}
 
-	var u user
-	err = json.NewDecoder(resp.Body).Decode(&u)
-	if err != nil {
-		return user{}, err
-	}
+func (c *comment) close(username, token string) {
+	c.forge().closePR(c, username, token)
+}
 
-	return u, nil
+func (c *comment) user(username, token string) (user, error) {
+	return c.forge().getUser(c, username, token)
 }
 
 func (c *comment) getPR() (pr, error) {
-	resp, err := http.Get(c.Issue.PullRequest.URL)
-	if err != nil {
-		return pr{}, err
-	}
-	defer resp.Body.Close()
-
-	var p pr
-	if err = json.NewDecoder(resp.Body).Decode(&p); err != nil {
-		return pr{}, err
-	}
-	return p, nil
+	return c.forge().getPR(c)
 }
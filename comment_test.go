@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestOnBehalfOf(t *testing.T) {
+	cases := []struct {
+		body     string
+		delegate string
+	}{
+		{"@bot merge", ""},
+		{"@bot merge on-behalf-of @alice", "alice"},
+		{"@bot merge On-Behalf-Of bob --wait=1h\n\nSubject", "bob"},
+		{"@bot merge on-behalf-of", ""},
+	}
+	for _, tc := range cases {
+		var c comment
+		c.Comment.Body = tc.body
+		if d := c.onBehalfOf(); d != tc.delegate {
+			t.Errorf("Got %q, expected %q for %q", d, tc.delegate, tc.body)
+		}
+	}
+}
+
+func TestAnchor(t *testing.T) {
+	cases := []struct {
+		body, url string
+		anchor    string
+	}{
+		{"@bot merge", "", ""},
+		{"@bot merge", "https://github.com/foo/bar/pull/1#issuecomment-2", "> [@alice](https://github.com/foo/bar/pull/1#issuecomment-2): merge\n\n"},
+		{"@bot merge  --wait=1h\n\nSubject\n\nDescription", "u", "> [@alice](u): merge --wait=1h\n\n"},
+	}
+	for _, tc := range cases {
+		var c comment
+		c.Comment.Body, c.Comment.HTMLURL = tc.body, tc.url
+		c.Comment.User.Login = "alice"
+		if a := c.anchor(); a != tc.anchor {
+			t.Errorf("Got %q, expected %q for %q", a, tc.anchor, tc.body)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// A configRepo is a central git repository holding the bot configuration,
+// which is cloned and kept up to date so that changes to it take effect
+// without a restart. It may contain:
+//
+//	settings.json         per repository settings, as given by -settings
+//	teams.json            team members, as given by -teams
+//	responses/NAME.tmpl   custom templates for responses, like thanks.tmpl
+//
+// Relative policy paths in the settings refer to files in the repository.
+type configRepo struct {
+	h    *handler
+	url  string
+	dir  string
+	head string
+}
+
+func newConfigRepo(h *handler, url string) *configRepo {
+	return &configRepo{h: h, url: url, dir: filepath.Join(stateDir, "config")}
+}
+
+// sync fetches the latest configuration and loads it if it changed.
+func (r *configRepo) sync() error {
+	s := newScript()
+	if _, err := os.Stat(filepath.Join(r.dir, ".git")); err != nil {
+		s.run("git", "clone", r.url, r.dir)
+	} else {
+		s.run("git", "-C", r.dir, "fetch", "-f", "origin")
+		s.run("git", "-C", r.dir, "reset", "--hard", "origin/HEAD")
+	}
+	head := s.run("git", "-C", r.dir, "rev-parse", "HEAD")
+	if s.Error() != nil {
+		return fmt.Errorf("%s", s.output.String())
+	}
+	if head == r.head {
+		return nil
+	}
+
+	if err := r.load(); err != nil {
+		return fmt.Errorf("configuration at %s: %v", head, err)
+	}
+	log.Println("Loaded configuration", head)
+	r.head = head
+	return nil
+}
+
+// syncLogged is sync for periodic use.
+func (r *configRepo) syncLogged() {
+	if err := r.sync(); err != nil {
+		log.Println("Configuration sync:", err)
+	}
+}
+
+// load reads everything before applying anything, so that a broken
+// configuration leaves the current one in place.
+func (r *configRepo) load() error {
+	repos := make(map[string]repoSettings)
+	bs, err := ioutil.ReadFile(filepath.Join(r.dir, "settings.json"))
+	if err == nil {
+		repos, err = parseSettings("settings.json", bs)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for name, rs := range repos {
+		if rs.Policy != "" && !filepath.IsAbs(rs.Policy) {
+			rs.Policy = filepath.Join(r.dir, rs.Policy)
+			repos[name] = rs
+		}
+	}
+
+	var teams map[string][]string
+	if err := readConfigJSON(filepath.Join(r.dir, "teams.json"), &teams); err != nil {
+		return err
+	}
+
+	files, _ := filepath.Glob(filepath.Join(r.dir, "responses", "*.tmpl"))
+	tmpls := make(map[string]*template.Template)
+	for _, file := range files {
+		bs, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".tmpl")
+		tmpl, err := template.New(name).Parse(string(bs))
+		if err != nil {
+			return err
+		}
+		tmpls[name] = tmpl
+	}
+
+	r.h.settings.replace(repos)
+	if teams != nil {
+		r.h.mut.Lock()
+		r.h.teams = teams
+		r.h.mut.Unlock()
+	}
+	setResponseTemplates(tmpls)
+	return nil
+}
+
+// readConfigJSON decodes the file into v, leaving v untouched if the file
+// doesn't exist.
+func readConfigJSON(path string, v interface{}) error {
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(bs, v); err != nil {
+		return fmt.Errorf("%s: %v", filepath.Base(path), err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigRepoLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "responses"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "settings.json"), []byte(`{"foo/*": {"max_wait": "2h", "policy": "policies/foo.rego"}}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "responses", "noAccess.tmpl"), []byte(`Sorry @{{.Sender}}, ask #help.`), 0644)
+	defer setResponseTemplates(nil)
+
+	h := newHandler(nil, "bot", "token", false)
+	r := &configRepo{h: h, dir: dir}
+	if err := r.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := h.settings.forRepo("foo/bar")
+	if rs.MaxWait.Hours() != 2 || rs.Policy != filepath.Join(dir, "policies/foo.rego") {
+		t.Errorf("Unexpected settings %+v", rs)
+	}
+
+	var c comment
+	c.Sender.Login = "alice"
+	if s := noAccessResponse(c); s != "Sorry @alice, ask #help." {
+		t.Error("Unexpected response", s)
+	}
+	if s := lgtmResponse(c); s != "@alice: Noted! Need another LGTM or explicit merge command." {
+		t.Error("Unexpected default response", s)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "settings.json"), []byte(`{"foo/*": {"max_wait": "nonsense"}}`), 0644)
+	if err := r.load(); err == nil {
+		t.Error("Expected error for broken settings")
+	}
+	if rs := h.settings.forRepo("foo/bar"); rs.MaxWait.Hours() != 2 {
+		t.Error("Expected broken settings to leave the current ones in place")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// conflictsContext is the status we set on PRs that do or don't conflict
+// with their base branch.
+const conflictsContext = "st-review/conflicts"
+
+// A pushEvent tells that a branch moved.
+type pushEvent struct {
+	Ref        string
+	After      string
+	Deleted    bool
+	Repository struct {
+		FullName string `json:"full_name"`
+	}
+}
+
+// branch returns the branch that moved, or "" for tags.
+func (e pushEvent) branch() string {
+	if !strings.HasPrefix(e.Ref, "refs/heads/") {
+		return ""
+	}
+	return strings.TrimPrefix(e.Ref, "refs/heads/")
+}
+
+// conflictCache remembers the conflicts status last set on each PR, so
+// that a push to a busy base branch doesn't set the same status over and
+// over.
+type conflictCache struct {
+	mut  sync.Mutex
+	last map[string]string // "owner/name#number" -> "head:base:state"
+}
+
+// changed records the result for the PR, returning whether it differs from
+// the one before.
+func (cc *conflictCache) changed(key, result string) bool {
+	cc.mut.Lock()
+	defer cc.mut.Unlock()
+	if cc.last == nil {
+		cc.last = make(map[string]string)
+	}
+	if cc.last[key] == result {
+		return false
+	}
+	cc.last[key] = result
+	return true
+}
+
+// handlePush checks the open PRs against a base branch that moved for
+// conflicts, so that they show before anyone asks to merge.
+func (h *handler) handlePush(e pushEvent) {
+	repo, branch := e.Repository.FullName, e.branch()
+	if branch == "" || e.Deleted || !h.botEnabled(repo) || !h.featureEnabled(repo, "conflicts", true) {
+		return
+	}
+	go func() {
+		prs, err := h.openPRs(repo, branch)
+		if err != nil {
+			log.Printf("Open PRs on %s %s: %v", repo, branch, err)
+			return
+		}
+		if len(prs) > 0 {
+			h.checkConflicts(repo, branch, prs)
+		}
+	}()
+}
+
+// openPRs returns the open PRs against the base branch.
+func (h *handler) openPRs(repo, base string) ([]pr, error) {
+	var res []pr
+	for page := 1; ; page++ {
+		var prs []pr
+		u := fmt.Sprintf("%s/repos/%s/pulls?state=open&base=%s&per_page=100&page=%d", githubAPI, repo, url.QueryEscape(base), page)
+		if err := apiRequest("GET", u, nil, &prs, h.username, h.token); err != nil {
+			return nil, err
+		}
+		res = append(res, prs...)
+		if len(prs) < 100 {
+			return res, nil
+		}
+	}
+}
+
+// checkConflicts sets the conflicts status of each PR against the base
+// branch, trying the merges in the clone without touching its worktree.
+func (h *handler) checkConflicts(repo, base string, prs []pr) {
+	defer h.lockRepo(repo)()
+
+	if _, err := os.Stat(filepath.Join(repo, ".git")); err != nil {
+		if err := h.clone(repo); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	h.refreshRemote(repo)
+
+	s := newScript().in(repo)
+	refs := []fetchRef{{remote: base, local: "orig/" + base}}
+	for _, p := range prs {
+		refs = append(refs, prRef(p))
+	}
+	fetchRefs(s, refs...)
+	if s.Error() != nil {
+		log.Printf("Fetching to check conflicts on %s: %s", repo, s.output.String())
+		return
+	}
+	baseSHA := s.run("git", "rev-parse", "orig/"+base)
+
+	for _, p := range prs {
+		files, err := mergeConflicts(repo, "orig/"+base, fmt.Sprintf("pr-%d", p.Number))
+		if err != nil {
+			log.Printf("Checking PR %d on %s for conflicts: %v", p.Number, repo, err)
+			continue
+		}
+		state := stateSuccess
+		if len(files) > 0 {
+			state = stateFailure
+		}
+		key := fmt.Sprintf("%s#%d", repo, p.Number)
+		if h.conflicts.changed(key, p.headSHA()+":"+baseSHA+":"+string(state)) {
+			if len(files) > 0 {
+				metrics.add("conflicts_found", 1)
+			}
+			p.setStatus(state, conflictsContext, conflictsDescription(base, files), h.username, h.token)
+		}
+	}
+}
+
+// conflictsDescription describes the conflicts with the base, short enough
+// for a status.
+func conflictsDescription(base string, files []string) string {
+	if len(files) == 0 {
+		return "No conflicts with " + base + "."
+	}
+	const max = 3
+	desc := strings.Join(files, ", ")
+	if len(files) > max {
+		desc = fmt.Sprintf("%s and %d more", strings.Join(files[:max], ", "), len(files)-max)
+	}
+	desc = fmt.Sprintf("Conflicts with %s in %s.", base, desc)
+	if len(desc) > 140 {
+		desc = fmt.Sprintf("Conflicts with %s in %d files.", base, len(files))
+	}
+	return desc
+}
+
+// mergeConflicts returns the files that conflict when merging the source
+// into the base in the clone in dir, without touching the worktree.
+func mergeConflicts(dir, base, source string) ([]string, error) {
+	s := newScript().in(dir)
+	out := s.run("git", "merge-tree", "--write-tree", "--name-only", "--no-messages", base, source)
+	if s.Error() == nil {
+		return nil, nil
+	}
+	// With conflicts it exits with 1, printing the tree and then the files.
+	lines := strings.Split(out, "\n")
+	if exit, ok := s.Error().(interface{ ExitCode() int }); !ok || exit.ExitCode() != 1 || len(lines) < 2 {
+		return nil, fmt.Errorf("%s", s.output.String())
+	}
+	var files []string
+	seen := make(map[string]bool)
+	for _, f := range lines[1:] {
+		if f != "" && !seen[f] {
+			seen[f] = true
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeConflicts(t *testing.T) {
+	dir := t.TempDir()
+	s := newScript().in(dir)
+	git := func(args ...string) string {
+		return s.run("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	}
+	commit := func(content string, files ...string) {
+		for _, f := range files {
+			os.WriteFile(filepath.Join(dir, f), []byte(content), 0644)
+			git("add", f)
+		}
+		git("commit", "-q", "-m", content)
+	}
+	git("init", "-q", "-b", "main")
+	commit("base\n", "a.txt", "b.txt", "c.txt")
+	git("checkout", "-q", "-b", "clean")
+	commit("clean\n", "c.txt")
+	git("checkout", "-q", "-b", "conflicting", "main")
+	commit("theirs\n", "a.txt", "b.txt")
+	git("checkout", "-q", "main")
+	commit("ours\n", "a.txt", "b.txt")
+	if s.Error() != nil {
+		t.Fatal(s.output.String())
+	}
+
+	if files, err := mergeConflicts(dir, "main", "clean"); err != nil || len(files) != 0 {
+		t.Errorf("Clean merge has conflicts %v, %v", files, err)
+	}
+	files, err := mergeConflicts(dir, "main", "conflicting")
+	if want := []string{"a.txt", "b.txt"}; err != nil || !reflect.DeepEqual(files, want) {
+		t.Errorf("Conflicts %v, %v; want %v", files, err, want)
+	}
+	if _, err := mergeConflicts(dir, "main", "missing"); err == nil {
+		t.Error("Expected an error for a missing branch")
+	}
+	if git("status", "--porcelain") != "" {
+		t.Error("Worktree was touched")
+	}
+}
+
+func TestConflictsDescription(t *testing.T) {
+	tests := []struct {
+		files []string
+		want  string
+	}{
+		{nil, "No conflicts with main."},
+		{[]string{"a.go"}, "Conflicts with main in a.go."},
+		{[]string{"a.go", "b.go", "c.go", "d.go", "e.go"}, "Conflicts with main in a.go, b.go, c.go and 2 more."},
+		{[]string{strings.Repeat("x", 150)}, "Conflicts with main in 1 files."},
+	}
+	for _, test := range tests {
+		if got := conflictsDescription("main", test.files); got != test.want {
+			t.Errorf("conflictsDescription(%v) = %q; want %q", test.files, got, test.want)
+		}
+	}
+
+	var cc conflictCache
+	if !cc.changed("foo/bar#1", "a:b:failure") || cc.changed("foo/bar#1", "a:b:failure") || !cc.changed("foo/bar#1", "a:c:failure") {
+		t.Error("Unexpected cache behaviour")
+	}
+
+	if b := (pushEvent{Ref: "refs/heads/release/1"}).branch(); b != "release/1" {
+		t.Errorf("branch = %q", b)
+	}
+	if b := (pushEvent{Ref: "refs/tags/v1"}).branch(); b != "" {
+		t.Errorf("branch of a tag = %q", b)
+	}
+}
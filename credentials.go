@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// gitCredentialHelper answers git's requests for credentials with the
+// installation token written for the owner of the repository, taken from
+// the path git gives with credential.useHttpPath, or else the bot's token.
+// Neither ends up in clone URLs or the configuration of clones.
+const gitCredentialHelper = `!f() { test "$1" = get || exit 0; o=; while IFS== read -r k v && test -n "$k"; do test "$k" = path && o="${v%%/*}"; done; t="$MERGEBOT_GIT_TOKEN"; test -n "$o" && test -f "$MERGEBOT_GIT_TOKENS/$o" && t="$(cat "$MERGEBOT_GIT_TOKENS/$o")"; echo username=x-access-token; echo "password=$t"; }; f`
+
+// httpsCloneURL is the clone URL template over HTTPS for the GitHub the API
+// URL is of, for use with the credential helper.
+func httpsCloneURL(apiURL string) string {
+	return "https://" + gitHost(apiURL) + "/{repo}.git"
+}
+
+// gitCredentials gives git the token to clone and push over HTTPS with,
+// for deployments without SSH keys.
+type gitCredentials struct {
+	dir string // installation tokens, by owner
+}
+
+// setGitCredentials configures every git command run by scripts to get
+// credentials from the helper, using the token unless an installation token
+// is set for the owner. It needs git 2.31 or later.
+func setGitCredentials(token string) (*gitCredentials, error) {
+	dir, err := ioutil.TempDir("", "mergebot-credentials")
+	if err != nil {
+		return nil, err
+	}
+	gitEnv = append(gitEnv,
+		"GIT_CONFIG_COUNT=2",
+		"GIT_CONFIG_KEY_0=credential.helper",
+		"GIT_CONFIG_VALUE_0="+gitCredentialHelper,
+		"GIT_CONFIG_KEY_1=credential.useHttpPath",
+		"GIT_CONFIG_VALUE_1=true",
+		"GIT_TERMINAL_PROMPT=0",
+		"MERGEBOT_GIT_TOKEN="+token,
+		"MERGEBOT_GIT_TOKENS="+dir,
+	)
+	return &gitCredentials{dir: dir}, nil
+}
+
+// set makes the token the one for the repositories of the owner.
+func (g *gitCredentials) set(owner, token string) error {
+	path := filepath.Join(g.dir, filepath.Base(owner))
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(token), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGitCredentials(t *testing.T) {
+	defer func(env []string) { gitEnv = env }(gitEnv)
+	gitEnv = nil
+	creds, err := setGitCredentials("pat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(creds.dir)
+	if err := creds.set("acme", "installation"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		url      string
+		password string
+	}{
+		{"https://github.com/acme/widget.git", "installation"},
+		{"https://github.com/other/widget.git", "pat"},
+		{"https://github.com/", "pat"},
+	}
+	for _, tc := range cases {
+		s := newScript()
+		out := s.runPipe(strings.NewReader("url="+tc.url+"\n\n"), "git", "credential", "fill")
+		if s.err != nil {
+			t.Fatal(s.err, s.output.String())
+		}
+		lines := strings.Split(out, "\n")
+		if !contains(lines, "username=x-access-token") || !contains(lines, "password="+tc.password) {
+			t.Errorf("Credentials for %s: expected password %s, got\n%s", tc.url, tc.password, out)
+		}
+	}
+
+	if u := httpsCloneURL("https://ghe.example.com/api/v3"); u != "https://ghe.example.com/{repo}.git" {
+		t.Errorf("httpsCloneURL = %s", u)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// A dependencyGate refuses merges of PRs adding dependencies under denied
+// licenses or with known vulnerabilities of at least a severity, as told by
+// the GitHub dependency review API.
+type dependencyGate struct {
+	DeniedLicenses []string `json:"denied_licenses"` // SPDX identifiers, like "GPL-3.0-only"
+	MinSeverity    string   `json:"min_severity"`    // "low", "moderate", "high" or "critical"; unset to ignore vulnerabilities
+}
+
+// A dependencyChange is a dependency added or removed by a PR.
+type dependencyChange struct {
+	ChangeType      string `json:"change_type"` // "added" or "removed"
+	Manifest        string
+	Ecosystem       string
+	Name            string
+	Version         string
+	License         string // SPDX expression, if known
+	Vulnerabilities []struct {
+		Severity        string
+		AdvisoryGHSAID  string `json:"advisory_ghsa_id"`
+		AdvisorySummary string `json:"advisory_summary"`
+	}
+}
+
+var severityRank = map[string]int{"low": 1, "moderate": 2, "high": 3, "critical": 4}
+
+// problems returns what's wrong with the dependencies the changes add.
+func (g *dependencyGate) problems(changes []dependencyChange) []string {
+	var res []string
+	for _, d := range changes {
+		if d.ChangeType != "added" {
+			continue
+		}
+		pkg := fmt.Sprintf("%s %s (%s)", d.Name, d.Version, d.Manifest)
+		if lic := g.deniedLicense(d.License); lic != "" {
+			res = append(res, fmt.Sprintf("%s is licensed under %s, which is not allowed", pkg, lic))
+		}
+		min := severityRank[strings.ToLower(g.MinSeverity)]
+		if min == 0 {
+			continue
+		}
+		for _, v := range d.Vulnerabilities {
+			if severityRank[strings.ToLower(v.Severity)] >= min {
+				res = append(res, fmt.Sprintf("%s has a %s vulnerability, %s: %s", pkg, v.Severity, v.AdvisoryGHSAID, v.AdvisorySummary))
+			}
+		}
+	}
+	return res
+}
+
+// deniedLicense returns the first denied license the SPDX expression
+// mentions. Any mention counts, even as an alternative, so that no one
+// has to work out which side of an OR a dependency is used under.
+func (g *dependencyGate) deniedLicense(expr string) string {
+	for _, id := range strings.FieldsFunc(expr, func(r rune) bool { return r == ' ' || r == '(' || r == ')' }) {
+		for _, denied := range g.DeniedLicenses {
+			if strings.EqualFold(id, denied) {
+				return denied
+			}
+		}
+	}
+	return ""
+}
+
+// dependencyChanges returns the dependency changes between the base branch
+// and the head of the PR.
+func (h *handler) dependencyChanges(repo string, pr pr) ([]dependencyChange, error) {
+	var changes []dependencyChange
+	u := fmt.Sprintf("%s/repos/%s/dependency-graph/compare/%s...%s", githubAPI, repo, url.PathEscape(pr.Base.Ref), pr.headSHA())
+	if err := apiRequest("GET", u, nil, &changes, h.username, h.token); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// checkDependencies returns what's wrong with the dependencies the PR
+// adds, as far as the gate of the repository is concerned.
+func (h *handler) checkDependencies(repo string, pr pr) ([]string, error) {
+	g := h.settings.forRepo(repo).DependencyGate
+	if g == nil {
+		return nil, nil
+	}
+	changes, err := h.dependencyChanges(repo, pr)
+	if err != nil {
+		return nil, err
+	}
+	return g.problems(changes), nil
+}
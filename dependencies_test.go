@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDependencyGate(t *testing.T) {
+	var changes []dependencyChange
+	err := json.Unmarshal([]byte(`[
+		{"change_type": "removed", "manifest": "go.mod", "name": "old", "version": "v1", "license": "GPL-3.0-only"},
+		{"change_type": "added", "manifest": "go.mod", "name": "ok", "version": "v1", "license": "MIT"},
+		{"change_type": "added", "manifest": "go.mod", "name": "dual", "version": "v2", "license": "(MIT OR gpl-3.0-only)"},
+		{"change_type": "added", "manifest": "package-lock.json", "name": "vuln", "version": "1.0.0", "license": "ISC",
+		 "vulnerabilities": [
+			{"severity": "low", "advisory_ghsa_id": "GHSA-1", "advisory_summary": "Minor"},
+			{"severity": "high", "advisory_ghsa_id": "GHSA-2", "advisory_summary": "Major"}
+		 ]}
+	]`), &changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		gate dependencyGate
+		want []string
+	}{
+		{dependencyGate{}, nil},
+		{dependencyGate{DeniedLicenses: []string{"GPL-3.0-only"}}, []string{
+			"dual v2 (go.mod) is licensed under GPL-3.0-only, which is not allowed",
+		}},
+		{dependencyGate{MinSeverity: "moderate"}, []string{
+			"vuln 1.0.0 (package-lock.json) has a high vulnerability, GHSA-2: Major",
+		}},
+		{dependencyGate{MinSeverity: "critical"}, nil},
+		{dependencyGate{MinSeverity: "Low"}, []string{
+			"vuln 1.0.0 (package-lock.json) has a low vulnerability, GHSA-1: Minor",
+			"vuln 1.0.0 (package-lock.json) has a high vulnerability, GHSA-2: Major",
+		}},
+	}
+	for _, test := range tests {
+		if got := test.gate.problems(changes); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%+v: got %q, want %q", test.gate, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+)
+
+// A deploymentGate requires the head of PRs against any of the listed
+// branches to have been deployed to an environment successfully, as told
+// by the GitHub Deployments API, before they're merged. The deployment
+// shows as a status, pending until it succeeds.
+type deploymentGate struct {
+	Branches    []string
+	Environment string // like "staging"
+}
+
+// appliesTo returns true if merges into the branch need a deployment.
+func (g *deploymentGate) appliesTo(branch string) bool {
+	if g == nil {
+		return false
+	}
+	for _, b := range g.Branches {
+		if b == branch {
+			return true
+		}
+	}
+	return false
+}
+
+// context returns the context of the deployment status.
+func (g *deploymentGate) context() string {
+	return "deployment/" + g.Environment
+}
+
+type deployment struct {
+	ID          int
+	StatusesURL string `json:"statuses_url"`
+}
+
+type deploymentStatus struct {
+	State string // error, failure, inactive, in_progress, queued, pending or success
+}
+
+// deploymentState returns the state of the latest deployment of the commit
+// to the environment of the gate, as a status.
+func (h *handler) deploymentState(g *deploymentGate, repo, sha string) status {
+	st := status{Context: g.context(), State: statePending, Description: "Not deployed to " + g.Environment + " yet."}
+
+	var deployments []deployment
+	u := fmt.Sprintf("%s/repos/%s/deployments?sha=%s&environment=%s", githubAPI, repo, sha, url.QueryEscape(g.Environment))
+	if err := apiRequest("GET", u, nil, &deployments, h.username, h.token); err != nil {
+		log.Printf("Deployments of %s on %s: %v", sha, repo, err)
+		st.Description = "Couldn't look up deployments."
+		return st
+	}
+	if len(deployments) == 0 {
+		return st
+	}
+
+	// Both lists are newest first.
+	var statuses []deploymentStatus
+	if err := apiRequest("GET", deployments[0].StatusesURL, nil, &statuses, h.username, h.token); err != nil {
+		log.Printf("Deployment %d on %s: %v", deployments[0].ID, repo, err)
+		st.Description = "Couldn't look up the deployment."
+		return st
+	}
+	if len(statuses) == 0 {
+		st.Description = "Deployment to " + g.Environment + " hasn't started."
+		return st
+	}
+	switch statuses[0].State {
+	case "success":
+		st.State, st.Description = stateSuccess, "Deployed to "+g.Environment+"."
+	case "error", "failure", "inactive":
+		st.State, st.Description = stateFailure, fmt.Sprintf("Deployment to %s: %s.", g.Environment, statuses[0].State)
+	default:
+		st.Description = fmt.Sprintf("Deployment to %s is %s.", g.Environment, statuses[0].State)
+	}
+	return st
+}
+
+// withDeploymentGate adds the deployment status to the statuses of the PR
+// if the repository's gate applies to its base.
+func (h *handler) withDeploymentGate(statuses []status, g *deploymentGate, repo string, pr pr) []status {
+	base := pr.Base.Ref
+	if base == "" {
+		base = pr.PullRequest.Base.Ref
+	}
+	if !g.appliesTo(base) {
+		return statuses
+	}
+	return append(withoutContext(statuses, g.context()), h.deploymentState(g, repo, pr.headSHA()))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeploymentGate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/foo/bar/deployments":
+			if r.URL.Query().Get("environment") != "staging" {
+				w.Write([]byte(`[]`))
+				return
+			}
+			switch sha := r.URL.Query().Get("sha"); sha {
+			case "deployed", "failed", "running":
+				w.Write([]byte(`[{"id": 2, "statuses_url": "http://` + r.Host + `/statuses/` + sha + `"}, {"id": 1}]`))
+			default:
+				w.Write([]byte(`[]`))
+			}
+		case "/statuses/deployed":
+			w.Write([]byte(`[{"state": "success"}, {"state": "in_progress"}]`))
+		case "/statuses/failed":
+			w.Write([]byte(`[{"state": "failure"}, {"state": "in_progress"}]`))
+		case "/statuses/running":
+			w.Write([]byte(`[{"state": "in_progress"}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	h := newHandler(nil, "bot", "", false)
+	g := &deploymentGate{Branches: []string{"production"}, Environment: "staging"}
+	tests := []struct {
+		base, sha string
+		want      prState
+	}{
+		{"production", "deployed", stateSuccess},
+		{"production", "failed", stateFailure},
+		{"production", "running", statePending},
+		{"production", "never", statePending},
+		{"main", "never", ""},
+	}
+	for _, test := range tests {
+		var p pr
+		p.Base.Ref = test.base
+		p.Head.SHA = test.sha
+		statuses := h.withDeploymentGate([]status{{Context: "ci", State: stateSuccess}}, g, "foo/bar", p)
+		var got prState
+		for _, st := range statuses {
+			if st.Context == "deployment/staging" {
+				got = st.State
+			}
+		}
+		if got != test.want {
+			t.Errorf("%s of %s: deployment status %q; want %q", test.base, test.sha, got, test.want)
+		}
+	}
+
+	if (*deploymentGate)(nil).appliesTo("production") {
+		t.Error("Nil gate should not apply")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+)
+
+// baseDrift returns how many commits the base branch has gained since the
+// PR branched off it, which is what its CI results don't account for.
+func baseDrift(repo string, p pr, username, token string) (int, error) {
+	head := p.Head.SHA
+	if head == "" {
+		head = p.PullRequest.Head.SHA
+	}
+	base := p.Base.Ref
+	if base == "" {
+		base = p.PullRequest.Base.Ref
+	}
+	var cmp struct {
+		AheadBy int `json:"ahead_by"`
+	}
+	u := fmt.Sprintf("%s/repos/%s/compare/%s...%s", githubAPI, repo, head, url.PathEscape(base))
+	if err := apiRequest("GET", u, nil, &cmp, username, token); err != nil {
+		return 0, err
+	}
+	return cmp.AheadBy, nil
+}
+
+// driftNotes describes for a waiting response how far the base branch has
+// moved on, and whether that will keep the PR from being merged.
+func (h *handler) driftNotes(repo string, p pr) []string {
+	drift, err := baseDrift(repo, p, h.username, h.token)
+	if err != nil {
+		log.Printf("Base drift of PR %d on %s: %v", p.Number, repo, err)
+		return nil
+	}
+	if drift == 0 {
+		return nil
+	}
+	note := fmt.Sprintf("`%s` has gained %d commit(s) since this PR branched off it.", p.Base.Ref, drift)
+	if max := h.settings.forRepo(repo).MaxBaseDrift; max > 0 {
+		if drift > max {
+			note += fmt.Sprintf(" That's more than the %d allowed without revalidation, so please update the branch and let CI run again.", max)
+		} else {
+			note += fmt.Sprintf(" Up to %d are allowed without revalidation.", max)
+		}
+	}
+	return []string{note}
+}
+
+// checkDrift returns an error if the base branch has drifted too far from
+// the PR to trust its CI results.
+func (h *handler) checkDrift(repo string, p pr) error {
+	max := h.settings.forRepo(repo).MaxBaseDrift
+	if max <= 0 {
+		return nil
+	}
+	drift, err := baseDrift(repo, p, h.username, h.token)
+	if err != nil {
+		log.Printf("Base drift of PR %d on %s: %v", p.Number, repo, err)
+		return nil
+	}
+	if drift > max {
+		return fmt.Errorf("`%s` has gained %d commits since this PR branched off it, more than the %d allowed", p.Base.Ref, drift, max)
+	}
+	return nil
+}
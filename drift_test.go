@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDriftNotes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/foo/bar/compare/abc...main" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"ahead_by": 12}`))
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	h := newHandler(nil, "bot", "", false)
+	var p pr
+	p.Head.SHA, p.Base.Ref = "abc", "main"
+
+	notes := h.driftNotes("foo/bar", p)
+	if len(notes) != 1 || !strings.Contains(notes[0], "12 commit(s)") || strings.Contains(notes[0], "revalidation") {
+		t.Errorf("Unexpected notes %q", notes)
+	}
+	if err := h.checkDrift("foo/bar", p); err != nil {
+		t.Error("Unexpected drift error without a limit:", err)
+	}
+
+	h.settings.repos = map[string]repoSettings{"foo/bar": {MaxBaseDrift: 10}}
+	if notes := h.driftNotes("foo/bar", p); len(notes) != 1 || !strings.Contains(notes[0], "more than the 10 allowed") {
+		t.Errorf("Unexpected notes %q", notes)
+	}
+	if err := h.checkDrift("foo/bar", p); err == nil {
+		t.Error("Expected too much drift")
+	}
+}
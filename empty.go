@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// An emptyMergeError says why merging a PR would change nothing.
+type emptyMergeError struct {
+	reason string
+}
+
+func (e *emptyMergeError) Error() string {
+	return "Nothing to merge: " + e.reason
+}
+
+// gitQuiet runs a git command in the clone whose exit status is the
+// answer, as with diff --quiet.
+func gitQuiet(ctx context.Context, dir string, args ...string) bool {
+	s := newScriptContext(ctx).in(dir)
+	s.run("git", args...)
+	return s.Error() == nil
+}
+
+// explainNoCommits explains a PR without commits that aren't on the base.
+func explainNoCommits(base string) error {
+	return &emptyMergeError{"all commits of the PR are already on " + base + ". It was merged already, or it's meant for another base branch; if so, edit the PR to change the base."}
+}
+
+// explainEmptySquash explains a squash of the PR in the clone that staged no
+// changes, given the merge base of the PR with the base branch.
+func explainEmptySquash(ctx context.Context, dir, base, source, mergeBase string) error {
+	if gitQuiet(ctx, dir, "diff", "--quiet", mergeBase, source) {
+		return &emptyMergeError{"the commits of the PR cancel each other out, so there's no change left."}
+	}
+	if gitQuiet(ctx, dir, "diff", "--quiet", "--ignore-all-space", "--ignore-blank-lines", mergeBase, source) {
+		return &emptyMergeError{"only whitespace changed, and none of it survives the merge. Line endings normalized by .gitattributes are the usual suspect."}
+	}
+	s := newScriptContext(ctx).in(dir)
+	cherry := s.run("git", "cherry", "HEAD", source)
+	if s.Error() == nil && !strings.Contains("\n"+cherry, "\n+") {
+		return &emptyMergeError{"the commits of the PR have already landed on " + base + " as other commits, for example by a cherry-pick."}
+	}
+	return &emptyMergeError{"the changes of the PR are already on " + base + "."}
+}
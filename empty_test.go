@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExplainEmptySquash(t *testing.T) {
+	dir := t.TempDir()
+	s := newScript().in(dir)
+	git := func(args ...string) string {
+		return s.run("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	}
+	write := func(name, content string) {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		git("add", name)
+	}
+	git("init", "-q", "-b", "main")
+	write("a.txt", "one\n")
+	git("commit", "-q", "-m", "Initial")
+	base := git("rev-parse", "HEAD")
+
+	// A branch whose commits revert each other.
+	git("checkout", "-q", "-b", "revert")
+	write("a.txt", "two\n")
+	git("commit", "-q", "-m", "Change")
+	write("a.txt", "one\n")
+	git("commit", "-q", "-m", "Change back")
+
+	// A branch whose change was cherry-picked onto main.
+	git("checkout", "-q", "-b", "picked", base)
+	write("b.txt", "new\n")
+	git("commit", "-q", "-m", "Add b")
+	picked := git("rev-parse", "HEAD")
+	git("checkout", "-q", "main")
+	git("cherry-pick", picked)
+	if s.Error() != nil {
+		t.Fatal(s.output.String())
+	}
+
+	ctx := context.Background()
+	if err := explainEmptySquash(ctx, dir, "main", "revert", base); !strings.Contains(err.Error(), "cancel each other out") {
+		t.Error("Unexpected explanation", err)
+	}
+	if err := explainEmptySquash(ctx, dir, "main", "picked", base); !strings.Contains(err.Error(), "cherry-pick") {
+		t.Error("Unexpected explanation", err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const ciDurationsStateName = "ci-durations.json"
+
+// How many of the latest CI durations of a repository are kept, and how
+// many are needed before estimating from them.
+const (
+	ciDurationSamples    = 20
+	ciDurationMinSamples = 3
+)
+
+// ciDurations keeps how long CI took for recent merges of each repository,
+// to tell those waiting how long it usually takes.
+type ciDurations struct {
+	mut   sync.Mutex
+	Repos map[string][]duration `json:"repos"`
+}
+
+func loadCIDurations() (*ciDurations, error) {
+	d := &ciDurations{Repos: make(map[string][]duration)}
+	if err := loadState(ciDurationsStateName, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// add records that CI took so long on the repository.
+func (d *ciDurations) add(repo string, took time.Duration) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	samples := append(d.Repos[repo], duration{took})
+	if len(samples) > ciDurationSamples {
+		samples = samples[len(samples)-ciDurationSamples:]
+	}
+	d.Repos[repo] = samples
+	if err := saveState(ciDurationsStateName, d); err != nil {
+		log.Println("Saving CI durations:", err)
+	}
+}
+
+// typical returns the median of the recent CI durations of the repository,
+// if there are enough of them.
+func (d *ciDurations) typical(repo string) (time.Duration, bool) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	samples := d.Repos[repo]
+	if len(samples) < ciDurationMinSamples {
+		return 0, false
+	}
+	sorted := make([]time.Duration, len(samples))
+	for i, s := range samples {
+		sorted[i] = s.Duration
+	}
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a] < sorted[b] })
+	return sorted[len(sorted)/2], true
+}
+
+// ciStarted returns when the pending statuses were set, the earliest of
+// them, or the zero time if none say.
+func ciStarted(ss []status) time.Time {
+	var res time.Time
+	for _, s := range ss {
+		if s.State == statePending && !s.UpdatedAt.IsZero() && (res.IsZero() || s.UpdatedAt.Before(res)) {
+			res = s.UpdatedAt
+		}
+	}
+	return res
+}
+
+// ciETA says how long CI usually takes on the repository and, where the
+// statuses tell when it started, about how much of that is left; it's
+// empty without enough history.
+func (h *handler) ciETA(repo string, ss []status, now time.Time) string {
+	if h.ciTimes == nil {
+		return ""
+	}
+	typical, ok := h.ciTimes.typical(repo)
+	if !ok {
+		return ""
+	}
+	eta := "CI usually completes in ~" + roughDuration(typical)
+	if started := ciStarted(ss); !started.IsZero() {
+		if left := typical - now.Sub(started); left >= time.Minute {
+			eta += fmt.Sprintf(", so about %s to go", roughDuration(left))
+		}
+	}
+	return eta
+}
+
+// roughDuration formats the duration in minutes, or hours when long.
+func roughDuration(d time.Duration) string {
+	switch {
+	case d >= 2*time.Hour:
+		return fmt.Sprintf("%d hours", int(d.Hours()+0.5))
+	case d >= 90*time.Second:
+		return fmt.Sprintf("%d minutes", int(d.Minutes()+0.5))
+	default:
+		return "a minute"
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCIETA(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+
+	now := time.Now()
+	h := newHandler(nil, "bot", "", false)
+	pending := []status{
+		{State: stateSuccess, Context: "lint", UpdatedAt: now.Add(-20 * time.Minute)},
+		{State: statePending, Context: "ci", UpdatedAt: now.Add(-7 * time.Minute)},
+		{State: statePending, Context: "e2e", UpdatedAt: now.Add(-5 * time.Minute)},
+		{State: statePending, Context: "required"},
+	}
+	if eta := h.ciETA("foo/bar", pending, now); eta != "" {
+		t.Errorf("Expected no estimate without history, got %q", eta)
+	}
+
+	for _, d := range []time.Duration{20 * time.Minute, 25 * time.Minute} {
+		h.ciTimes.add("foo/bar", d)
+	}
+	if eta := h.ciETA("foo/bar", pending, now); eta != "" {
+		t.Errorf("Expected no estimate from two merges, got %q", eta)
+	}
+	h.ciTimes.add("foo/bar", 2*time.Hour)
+	h.ciTimes.add("other/repo", time.Minute)
+
+	tests := []struct {
+		statuses []status
+		want     string
+	}{
+		{pending, "CI usually completes in ~25 minutes, so about 18 minutes to go"},
+		{nil, "CI usually completes in ~25 minutes"},
+		{[]status{{State: statePending, Context: "ci", UpdatedAt: now.Add(-30 * time.Minute)}}, "CI usually completes in ~25 minutes"},
+	}
+	for _, test := range tests {
+		if eta := h.ciETA("foo/bar", test.statuses, now); eta != test.want {
+			t.Errorf("Got %q, want %q", eta, test.want)
+		}
+	}
+
+	// The history survives a restart, and only the latest merges count.
+	loaded, err := loadCIDurations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < ciDurationSamples; i++ {
+		loaded.add("foo/bar", 3*time.Hour)
+	}
+	if typical, ok := loaded.typical("foo/bar"); !ok || typical != 3*time.Hour {
+		t.Errorf("Expected the older merges to be forgotten, got %v", typical)
+	}
+}
+
+func TestRoughDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{30 * time.Second, "a minute"},
+		{89 * time.Second, "a minute"},
+		{22*time.Minute + 40*time.Second, "23 minutes"},
+		{119 * time.Minute, "119 minutes"},
+		{150 * time.Minute, "3 hours"},
+	}
+	for _, test := range tests {
+		if got := roughDuration(test.d); got != test.want {
+			t.Errorf("roughDuration(%v) = %q, want %q", test.d, got, test.want)
+		}
+	}
+}
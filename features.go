@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const featuresStateName = "features.json"
+
+// featureFlags are runtime overrides enabling or disabling features per
+// repository, set through the admin API and kept across restarts.
+type featureFlags struct {
+	mut       sync.Mutex
+	overrides map[string]map[string]bool // feature -> scope ("owner/name", "owner/*" or "*") -> enabled
+}
+
+func loadFeatureFlags() (*featureFlags, error) {
+	f := &featureFlags{overrides: make(map[string]map[string]bool)}
+	if err := loadState(featuresStateName, &f.overrides); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *featureFlags) lookup(feature, repo string) (bool, bool) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	for _, scope := range repoScopes(repo) {
+		if v, ok := f.overrides[feature][scope]; ok {
+			return v, true
+		}
+	}
+	return false, false
+}
+
+// set sets or, given nil, clears the override for the feature and scope.
+func (f *featureFlags) set(feature, scope string, enabled *bool) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	if enabled == nil {
+		delete(f.overrides[feature], scope)
+		if len(f.overrides[feature]) == 0 {
+			delete(f.overrides, feature)
+		}
+	} else {
+		if f.overrides[feature] == nil {
+			f.overrides[feature] = make(map[string]bool)
+		}
+		f.overrides[feature][scope] = *enabled
+	}
+	return saveState(featuresStateName, f.overrides)
+}
+
+// repoScopes returns the names under which settings for the repository may
+// be given, most specific first.
+func repoScopes(repo string) []string {
+	res := []string{repo}
+	if idx := strings.Index(repo, "/"); idx > 0 {
+		res = append(res, repo[:idx]+"/*")
+	}
+	return append(res, "*")
+}
+
+// featureEnabled returns whether the feature is enabled for the repository,
+// considering first the runtime overrides, then the settings file and last
+// the given default.
+func (h *handler) featureEnabled(repo, feature string, def bool) bool {
+	if v, ok := h.features.lookup(feature, repo); ok {
+		return v
+	}
+	if v, ok := h.settings.feature(repo, feature); ok {
+		return v
+	}
+	return def
+}
+
+// serveFeatures answers GET /admin/features with the current overrides, and
+// takes POST requests like {"feature": "lgtm", "scope": "owner/*",
+// "enabled": false} to change them. A null enabled clears the override.
+func (a *adminAPI) serveFeatures(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		a.h.features.mut.Lock()
+		bs, err := json.Marshal(a.h.features.overrides)
+		a.h.features.mut.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bs)
+
+	case "POST":
+		var req struct {
+			Feature string
+			Scope   string
+			Enabled *bool
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Feature == "" || req.Scope == "" {
+			http.Error(w, "feature and scope are required", http.StatusBadRequest)
+			return
+		}
+		if err := a.h.features.set(req.Feature, req.Scope, req.Enabled); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Feature %s for %s set to %v", req.Feature, req.Scope, fmtEnabled(req.Enabled))
+
+	default:
+		http.Error(w, "GET or POST Expected", http.StatusMethodNotAllowed)
+	}
+}
+
+func fmtEnabled(enabled *bool) string {
+	switch {
+	case enabled == nil:
+		return "default"
+	case *enabled:
+		return "enabled"
+	default:
+		return "disabled"
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestFeatureEnabled(t *testing.T) {
+	h := newHandler(nil, "bot", "token", false)
+	h.settings.repos = map[string]repoSettings{
+		"foo/*":   {Features: map[string]bool{"lgtm": false}},
+		"foo/bar": {Features: map[string]bool{"lgtm": true}},
+	}
+
+	if !h.featureEnabled("other/repo", "lgtm", true) {
+		t.Error("Expected default to apply")
+	}
+	if h.featureEnabled("foo/baz", "lgtm", true) {
+		t.Error("Expected owner setting to apply")
+	}
+	if !h.featureEnabled("foo/bar", "lgtm", false) {
+		t.Error("Expected repository setting to apply")
+	}
+
+	h.features.overrides["lgtm"] = map[string]bool{"foo/bar": false}
+	if h.featureEnabled("foo/bar", "lgtm", true) {
+		t.Error("Expected runtime override to apply")
+	}
+}
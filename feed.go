@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const feedEntries = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Author  struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Summary string `xml:"summary"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+// The feeds server publishes the merges on a repository as an Atom feed at
+// /feeds/owner/name.atom and as JSON lines at /feeds/owner/name.jsonl.
+type feeds struct{}
+
+func (feeds) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/feeds/")
+	var repo, format string
+	if idx := strings.LastIndex(name, "."); idx > 0 {
+		repo, format = name[:idx], name[idx+1:]
+	}
+	if strings.Count(repo, "/") != 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	records, err := readMerges(func(m mergeRecord) bool { return m.Repo == repo })
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch format {
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		for _, rec := range records {
+			enc.Encode(rec)
+		}
+
+	case "atom":
+		w.Header().Set("Content-Type", "application/atom+xml")
+		w.Write([]byte(xml.Header))
+		xml.NewEncoder(w).Encode(atomFeedFor(repo, records))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// atomFeedFor returns a feed of the most recent merge records, newest first.
+func atomFeedFor(repo string, records []mergeRecord) atomFeed {
+	feed := atomFeed{
+		ID:      "urn:mergebot:" + repo,
+		Title:   "Merges on " + repo,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+	}
+	if len(records) > 0 {
+		feed.Updated = records[len(records)-1].Time.UTC().Format(time.RFC3339)
+	}
+
+	for i := len(records) - 1; i >= 0 && len(feed.Entries) < feedEntries; i-- {
+		rec := records[i]
+		e := atomEntry{
+			ID:      fmt.Sprintf("urn:mergebot:%s:%s", rec.Repo, rec.SHA),
+			Title:   fmt.Sprintf("#%d: %s", rec.PR, rec.Title),
+			Updated: rec.Time.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: rec.URL},
+			Summary: fmt.Sprintf("Merged into %s as %s by %s", rec.Base, rec.SHA, rec.Requester),
+		}
+		e.Author.Name = rec.Author
+		feed.Entries = append(feed.Entries, e)
+	}
+	return feed
+}
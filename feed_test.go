@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAtomFeedFor(t *testing.T) {
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var records []mergeRecord
+	for i := 1; i <= feedEntries+5; i++ {
+		records = append(records, mergeRecord{
+			Time:      t0.Add(time.Duration(i) * time.Minute),
+			Repo:      "foo/bar",
+			PR:        i,
+			Title:     fmt.Sprintf("Change %d", i),
+			URL:       fmt.Sprintf("https://github.com/foo/bar/pull/%d", i),
+			Base:      "main",
+			SHA:       fmt.Sprintf("sha%d", i),
+			Author:    "carol",
+			Requester: "alice",
+		})
+	}
+
+	feed := atomFeedFor("foo/bar", records)
+	last := records[len(records)-1]
+	if feed.ID != "urn:mergebot:foo/bar" || feed.Updated != last.Time.Format(time.RFC3339) {
+		t.Errorf("Unexpected feed %s updated %s", feed.ID, feed.Updated)
+	}
+	if len(feed.Entries) != feedEntries {
+		t.Fatalf("Expected %d entries, got %d", feedEntries, len(feed.Entries))
+	}
+	first, oldest := feed.Entries[0], feed.Entries[feedEntries-1]
+	if first.Title != fmt.Sprintf("#%d: Change %d", last.PR, last.PR) || first.ID != "urn:mergebot:foo/bar:"+last.SHA ||
+		first.Link.Href != last.URL || first.Author.Name != "carol" || first.Summary != "Merged into main as "+last.SHA+" by alice" {
+		t.Errorf("Unexpected newest entry %+v", first)
+	}
+	if oldest.Title != "#6: Change 6" {
+		t.Errorf("Expected the oldest entry to be #6, got %s", oldest.Title)
+	}
+
+	if empty := atomFeedFor("foo/bar", nil); len(empty.Entries) != 0 || empty.Updated == "" {
+		t.Errorf("Unexpected empty feed %+v", empty)
+	}
+}
+
+func TestFeedsServeHTTP(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	recordMerge(mergeRecord{Time: t0, Repo: "foo/bar", PR: 1, Title: "First", SHA: "aaa"})
+	recordMerge(mergeRecord{Time: t0.Add(time.Minute), Repo: "foo/baz", PR: 2, Title: "Elsewhere", SHA: "bbb"})
+	recordMerge(mergeRecord{Time: t0.Add(2 * time.Minute), Repo: "foo/bar", PR: 3, Title: "Second", SHA: "ccc"})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		feeds{}.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := get("/feeds/foo/bar.jsonl")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("JSON lines: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var prs []int
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		var rec mergeRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		prs = append(prs, rec.PR)
+	}
+	if fmt.Sprint(prs) != "[1 3]" {
+		t.Errorf("Expected the merges on foo/bar oldest first, got %v", prs)
+	}
+
+	w = get("/feeds/foo/bar.atom")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/atom+xml" || !strings.HasPrefix(w.Body.String(), xml.Header) {
+		t.Fatalf("Atom: %d %s\n%s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	var feed atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Entries) != 2 || feed.Entries[0].Title != "#3: Second" || feed.Entries[1].Title != "#1: First" {
+		t.Errorf("Expected the merges on foo/bar newest first, got %+v", feed.Entries)
+	}
+
+	// Repositories without merges have empty feeds.
+	w = get("/feeds/other/repo.atom")
+	feed = atomFeed{}
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); w.Code != http.StatusOK || err != nil || len(feed.Entries) != 0 {
+		t.Errorf("Unknown repository: %d, %v, %d entries", w.Code, err, len(feed.Entries))
+	}
+	if w = get("/feeds/other/repo.jsonl"); w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("Unknown repository: %d %q", w.Code, w.Body.String())
+	}
+
+	for _, path := range []string{
+		"/feeds/",
+		"/feeds/foo/bar",
+		"/feeds/foo/bar.xml",
+		"/feeds/bar.atom",
+		"/feeds/foo/bar/baz.atom",
+		"/feeds/.atom",
+	} {
+		if w := get(path); w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected not found, got %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// A fetchRef is a ref to fetch from origin into a local branch.
+type fetchRef struct {
+	remote string // such as "master" or "refs/pull/1/head"
+	local  string // the branch to fetch it into
+	sha    string // where the API says the remote ref is, if known
+}
+
+// prRef returns the ref of the head of the PR, fetched into pr-N.
+func prRef(pr pr) fetchRef {
+	return fetchRef{
+		remote: pr.forge().headRef(pr.Number),
+		local:  fmt.Sprintf("pr-%d", pr.Number),
+		sha:    pr.headSHA(),
+	}
+}
+
+// A headMovedError says the PR was pushed to after its merge was asked
+// for, so that what would be merged isn't what was asked for and checked.
+type headMovedError struct {
+	want, got string
+}
+
+func (e *headMovedError) Error() string {
+	return fmt.Sprintf("The head of the PR moved from %s to %s", e.want, e.got)
+}
+
+// checkHead returns a headMovedError if the PR fetched into pr-N isn't at
+// the head its merge was asked for at, where that's known.
+func checkHead(s *script, pr pr) error {
+	want := pr.headSHA()
+	if s.err != nil || want == "" {
+		return nil
+	}
+	got := s.run("git", "rev-parse", fmt.Sprintf("pr-%d", pr.Number))
+	if s.err == nil && got != want {
+		return &headMovedError{want: want, got: got}
+	}
+	return nil
+}
+
+// fetchRefs fetches the refs from origin with a single git fetch, skipping
+// those whose local branch is already at the commit the API reported, and
+// the fetch altogether if that's all of them. The local branches we have
+// are given as negotiation tips, so that on large repositories the server
+// isn't walked through every ref we have to find what it needn't send.
+func fetchRefs(s *script, refs ...fetchRef) {
+	if s.err != nil {
+		return
+	}
+
+	args := []string{"fetch", "-f"}
+	var refspecs []string
+	for _, r := range refs {
+		t := newScriptContext(s.ctx).in(s.dir)
+		local := t.run("git", "rev-parse", "--verify", "-q", "refs/heads/"+r.local+"^{commit}")
+		if t.Error() != nil {
+			local = ""
+		}
+		if r.sha != "" && local == r.sha {
+			continue
+		}
+		if local != "" {
+			args = append(args, "--negotiation-tip=refs/heads/"+r.local)
+		}
+		refspecs = append(refspecs, fmt.Sprintf("%s:%s", r.remote, r.local))
+	}
+	if len(refspecs) == 0 {
+		metrics.add("fetches_skipped", 1)
+		return
+	}
+	args = append(append(args, "origin"), refspecs...)
+	s.run("git", args...)
+}
+
+// branchHead returns the commit the branch is at according to the API.
+func (h *handler) branchHead(repo, branch string) (string, error) {
+	var ref struct {
+		Object struct {
+			SHA string
+		}
+	}
+	u := fmt.Sprintf("%s/repos/%s/git/ref/heads/%s", githubAPI, repo, url.PathEscape(branch))
+	if err := apiRequest("GET", u, nil, &ref, h.username, h.token); err != nil {
+		return "", err
+	}
+	return ref.Object.SHA, nil
+}
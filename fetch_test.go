@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetchRefs(t *testing.T) {
+	origin := t.TempDir()
+	o := newScript().in(origin)
+	git := func(args ...string) string {
+		return o.run("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	}
+	git("init", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "Initial")
+	git("checkout", "-q", "-b", "feature")
+	git("commit", "-q", "--allow-empty", "-m", "Feature")
+	git("update-ref", "refs/pull/1/head", "HEAD")
+	git("checkout", "-q", "main")
+	main, head := git("rev-parse", "main"), git("rev-parse", "feature")
+
+	clone := filepath.Join(t.TempDir(), "clone")
+	newScript().run("git", "clone", "-q", origin, clone)
+	if o.Error() != nil {
+		t.Fatal(o.output.String())
+	}
+
+	refs := []fetchRef{
+		{remote: "main", local: "orig/main", sha: main},
+		prRef(pr{Number: 1, Head: struct{ SHA string }{head}}),
+	}
+	s := newScript().in(clone)
+	fetchRefs(s, refs...)
+	if s.Error() != nil {
+		t.Fatal(s.output.String())
+	}
+	if got := strings.Count(s.output.String(), "$ git fetch"); got != 1 {
+		t.Errorf("Fetched %d times:\n%s", got, s.output)
+	}
+	if got := s.run("git", "rev-parse", "pr-1"); got != head {
+		t.Errorf("pr-1 is at %s, want %s", got, head)
+	}
+
+	// Up to date, so there's nothing to fetch.
+	s = newScript().in(clone)
+	fetchRefs(s, refs...)
+	if strings.Contains(s.output.String(), "$ git fetch") {
+		t.Errorf("Fetched again:\n%s", s.output)
+	}
+
+	// Only what moved is fetched, negotiating from what we have.
+	git("commit", "-q", "--allow-empty", "-m", "More")
+	refs[0].sha = git("rev-parse", "main")
+	s = newScript().in(clone)
+	fetchRefs(s, refs...)
+	out := s.output.String()
+	if !strings.Contains(out, "--negotiation-tip=refs/heads/orig/main origin main:orig/main\n") {
+		t.Errorf("Unexpected fetch:\n%s", out)
+	}
+	if got := s.run("git", "rev-parse", "orig/main"); got != refs[0].sha {
+		t.Errorf("orig/main is at %s, want %s", got, refs[0].sha)
+	}
+}
+
+func TestSquashOtherBase(t *testing.T) {
+	work, origin := t.TempDir(), filepath.Join(t.TempDir(), "origin.git")
+	w := newScript().in(work)
+	git := func(args ...string) string {
+		return w.run("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	}
+	git("init", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "Initial")
+	newScript().run("git", "clone", "-q", "--bare", work, origin)
+	clone := filepath.Join(t.TempDir(), "clone")
+	newScript().run("git", "clone", "-q", origin, clone)
+
+	// The release branch and the PR against it appear after the clone.
+	git("checkout", "-q", "-b", "release-1")
+	git("commit", "-q", "--allow-empty", "-m", "Release fix")
+	git("checkout", "-q", "-b", "fix")
+	os.WriteFile(filepath.Join(work, "fix.txt"), []byte("fixed\n"), 0644)
+	git("add", "fix.txt")
+	git("commit", "-q", "-m", "Fix it")
+	git("push", "-q", origin, "release-1", "fix:refs/pull/7/head")
+	main := git("rev-parse", "main")
+	if w.Error() != nil {
+		t.Fatal(w.output.String())
+	}
+
+	var p pr
+	p.Number = 7
+	p.Base.Ref = "release-1"
+	p.HTMLURL = "https://github.com/foo/bar/pull/7"
+	plan := mergePlan{user: user{Name: "Merger", Email: "merger@example.com"}}
+	newScript().in(clone).run("git", "config", "user.email", "bot@example.com")
+	sha, err := squash(context.Background(), clone, p, plan, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := newScript().in(origin)
+	if got := o.run("git", "rev-parse", "release-1"); got != sha {
+		t.Errorf("release-1 is at %s, want the squashed %s", got, sha)
+	}
+	if got := o.run("git", "rev-parse", "main"); got != main {
+		t.Errorf("main moved to %s", got)
+	}
+	if files := o.run("git", "show", "--format=", "--name-only", sha); files != "fix.txt" {
+		t.Errorf("Squash changed %q", files)
+	}
+}
+
+func TestSquashHeadMoved(t *testing.T) {
+	work, origin := t.TempDir(), filepath.Join(t.TempDir(), "origin.git")
+	w := newScript().in(work)
+	git := func(args ...string) string {
+		return w.run("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	}
+	git("init", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "Initial")
+	git("checkout", "-q", "-b", "fix")
+	os.WriteFile(filepath.Join(work, "fix.txt"), []byte("fixed\n"), 0644)
+	git("add", "fix.txt")
+	git("commit", "-q", "-m", "Fix it")
+	asked := git("rev-parse", "HEAD")
+	// Pushed to after the merge was asked for.
+	os.WriteFile(filepath.Join(work, "fix.txt"), []byte("broken\n"), 0644)
+	git("commit", "-q", "-am", "Break it")
+	pushed := git("rev-parse", "HEAD")
+	newScript().run("git", "clone", "-q", "--bare", work, origin)
+	git("push", "-q", origin, "fix:refs/pull/7/head")
+	main := git("rev-parse", "main")
+	clone := filepath.Join(t.TempDir(), "clone")
+	newScript().run("git", "clone", "-q", origin, clone)
+	if w.Error() != nil {
+		t.Fatal(w.output.String())
+	}
+
+	var p pr
+	p.Number = 7
+	p.Base.Ref = "main"
+	p.Head.SHA = asked
+	plan := mergePlan{user: user{Name: "Merger", Email: "merger@example.com"}}
+	_, err := squash(context.Background(), clone, p, plan, nil, nil)
+	moved, ok := err.(*headMovedError)
+	if !ok || moved.want != asked || moved.got != pushed {
+		t.Fatalf("Expected the head to have moved from %s to %s, got %v", asked, pushed, err)
+	}
+	if got := newScript().in(origin).run("git", "rev-parse", "main"); got != main {
+		t.Errorf("main moved to %s", got)
+	}
+}
+
+func TestSquashThroughAPI(t *testing.T) {
+	work, origin := t.TempDir(), filepath.Join(t.TempDir(), "origin.git")
+	w := newScript().in(work)
+	git := func(args ...string) string {
+		return w.run("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	}
+	git("init", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "Initial")
+	git("checkout", "-q", "-b", "fix")
+	os.WriteFile(filepath.Join(work, "fix.txt"), []byte("fixed\n"), 0644)
+	git("add", "fix.txt")
+	git("commit", "-q", "-m", "Fix it")
+	head := git("rev-parse", "HEAD")
+	newScript().run("git", "clone", "-q", "--bare", work, origin)
+	git("push", "-q", origin, "fix:refs/pull/7/head")
+	main := git("rev-parse", "main")
+	clone := filepath.Join(t.TempDir(), "clone")
+	newScript().run("git", "clone", "-q", origin, clone)
+	if w.Error() != nil {
+		t.Fatal(w.output.String())
+	}
+
+	var merged map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || !strings.HasSuffix(r.URL.Path, "/pulls/7/merge") {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&merged)
+		fmt.Fprint(w, `{"sha": "0123abcd", "merged": true}`)
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	var p pr
+	p.Number = 7
+	p.Base.Ref = "main"
+	p.Head.SHA = head
+	p.HTMLURL = "https://github.com/foo/bar/pull/7"
+	plan := mergePlan{user: user{Name: "Merger", Email: "merger@example.com"}, msg: "Fix it properly\n\nWith a description."}
+	newScript().in(clone).run("git", "config", "user.email", "bot@example.com")
+	h := newHandler(nil, "bot", "token", false)
+	sha, err := h.squashThroughAPI(context.Background(), clone, p, plan, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha != "0123abcd" {
+		t.Errorf("Expected the SHA GitHub merged as, got %s", sha)
+	}
+	if merged["merge_method"] != "squash" || merged["sha"] != head || merged["commit_title"] != "Fix it properly" || !strings.HasPrefix(merged["commit_message"], "With a description.") {
+		t.Errorf("Unexpected merge request %q", merged)
+	}
+	if got := newScript().in(origin).run("git", "rev-parse", "main"); got != main {
+		t.Errorf("main was pushed to, moving to %s", got)
+	}
+}
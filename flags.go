@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// durationsFlag is a flag.Value holding a comma separated list of
+// key=duration pairs.
+type durationsFlag map[string]time.Duration
+
+func (f durationsFlag) String() string {
+	var keys []string
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var res []string
+	for _, k := range keys {
+		res = append(res, fmt.Sprintf("%s=%v", k, f[k]))
+	}
+	return strings.Join(res, ",")
+}
+
+func (f durationsFlag) Set(s string) error {
+	for _, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+		idx := strings.LastIndex(pair, "=")
+		if idx < 0 {
+			return fmt.Errorf("%q is not on the form key=duration", pair)
+		}
+		d, err := time.ParseDuration(pair[idx+1:])
+		if err != nil {
+			return err
+		}
+		f[pair[:idx]] = d
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const flakesStateName = "flakes.json"
+
+// A flakyRule says how to re-trigger a check that is known to fail for
+// reasons unrelated to the change. A failed check is retried when its
+// description matches the pattern, or when its historical flake rate is at
+// least the repository threshold; with neither set, it's always retried.
+type flakyRule struct {
+	Context    string // or "*" for any context
+	Pattern    string // regexp matched against the status description
+	RetryURL   string `json:"retry_url"` // POSTed to, expanding {repo}, {owner}, {name}, {number}, {sha} and {context}
+	Token      string
+	MaxRetries int `json:"max_retries"`
+}
+
+// flakeHistory counts what happened to the failures of a context.
+type flakeHistory struct {
+	Failures int `json:"failures"`
+	Retries  int `json:"retries"`
+	Passed   int `json:"passed"` // retries that then passed
+}
+
+// rate is the share of failures that turned out to be flakes.
+func (f flakeHistory) rate() float64 {
+	if f.Failures == 0 {
+		return 0
+	}
+	return float64(f.Passed) / float64(f.Failures)
+}
+
+// The flakeTracker remembers the retries made for each PR revision and the
+// flake history of each context, the latter across restarts.
+type flakeTracker struct {
+	mut     sync.Mutex
+	retries map[string]int                      // "owner/name#number@sha/context" -> retries
+	history map[string]map[string]*flakeHistory // repo -> context -> history
+}
+
+func loadFlakeTracker() (*flakeTracker, error) {
+	f := &flakeTracker{retries: make(map[string]int), history: make(map[string]map[string]*flakeHistory)}
+	if err := loadState(flakesStateName, &f.history); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *flakeTracker) contextHistory(repo, context string) *flakeHistory {
+	if f.history[repo] == nil {
+		f.history[repo] = make(map[string]*flakeHistory)
+	}
+	fh := f.history[repo][context]
+	if fh == nil {
+		fh = &flakeHistory{}
+		f.history[repo][context] = fh
+	}
+	return fh
+}
+
+func (f *flakeTracker) save() {
+	if err := saveState(flakesStateName, f.history); err != nil {
+		log.Println("Saving flake history:", err)
+	}
+}
+
+func retryKey(repo string, p pr, context string) string {
+	sha := p.Head.SHA
+	if sha == "" {
+		sha = p.PullRequest.Head.SHA
+	}
+	return fmt.Sprintf("%s#%d@%s/%s", repo, p.Number, sha, context)
+}
+
+// findFlakyRule returns the rule for the context, if any.
+func findFlakyRule(rules []flakyRule, context string) *flakyRule {
+	for i := range rules {
+		if rules[i].Context == context || rules[i].Context == "*" {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// shouldRetry decides whether a failed status is worth retrying by the rule.
+func shouldRetry(rule *flakyRule, st status, hist flakeHistory, threshold float64) bool {
+	if rule.Pattern == "" && threshold <= 0 {
+		return true
+	}
+	if rule.Pattern != "" {
+		if re, err := regexp.Compile(rule.Pattern); err == nil && re.MatchString(st.Description) {
+			return true
+		}
+	}
+	return threshold > 0 && hist.Failures > 0 && hist.rate() >= threshold
+}
+
+// retryFlaky re-triggers the failed statuses of the PR that look flaky,
+// returning a note for each retry. Statuses that pass after having been
+// retried are counted as flakes.
+func (h *handler) retryFlaky(repo string, p pr, statuses []status, skip []string) []string {
+	rs := h.settings.forRepo(repo)
+	if len(rs.FlakyChecks) == 0 {
+		return nil
+	}
+	skipped := make(map[string]bool)
+	for _, s := range skip {
+		skipped[s] = true
+	}
+
+	f := h.flakes
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	var notes []string
+	changed := false
+	for _, st := range statuses {
+		rule := findFlakyRule(rs.FlakyChecks, st.Context)
+		if rule == nil || skipped[st.Context] {
+			continue
+		}
+		key := retryKey(repo, p, st.Context)
+		retries := f.retries[key]
+
+		switch st.State {
+		case stateSuccess:
+			if retries > 0 {
+				f.contextHistory(repo, st.Context).Passed++
+				f.retries[key] = -1 // counted
+				changed = true
+			}
+			continue
+		case stateFailure, stateError:
+		default:
+			continue
+		}
+
+		hist := f.contextHistory(repo, st.Context)
+		if retries == 0 {
+			hist.Failures++
+			changed = true
+		}
+		if retries < 0 || retries >= rule.MaxRetries || !shouldRetry(rule, st, *hist, rs.FlakeThreshold) {
+			continue
+		}
+		if err := rule.retry(repo, p, st.Context); err != nil {
+			log.Printf("Retrying %s on %s PR %d: %v", st.Context, repo, p.Number, err)
+			continue
+		}
+		f.retries[key] = retries + 1
+		hist.Retries++
+		changed = true
+		notes = append(notes, fmt.Sprintf("`%s` failed and looks flaky; retried it (attempt %d of %d).", st.Context, retries+1, rule.MaxRetries))
+	}
+	if changed {
+		f.save()
+	}
+	return notes
+}
+
+func (r *flakyRule) retry(repo string, p pr, context string) error {
+	url := strings.Replace(expandPRURL(r.RetryURL, repo, p), "{context}", context, -1)
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return err
+	}
+	if r.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.Token)
+	}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("POST %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// statusWithRetries evaluates the statuses of the PR like evaluateStatuses,
+// but reports a failure that is being retried as pending.
+func (h *handler) statusWithRetries(repo string, p pr, statuses []status, skip []string) (prState, []string) {
+	state, notes := h.evaluateStatuses(statuses, skip)
+	if retried := h.retryFlaky(repo, p, statuses, skip); len(retried) > 0 {
+		notes = append(notes, retried...)
+		if state == stateFailure || state == stateError {
+			state = statePending
+		}
+	}
+	return state, notes
+}
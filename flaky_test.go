@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestShouldRetry(t *testing.T) {
+	timeout := status{State: stateFailure, Description: "Job timed out"}
+	broken := status{State: stateFailure, Description: "3 tests failed"}
+	flaky := flakeHistory{Failures: 10, Retries: 10, Passed: 6}
+
+	cases := []struct {
+		rule      flakyRule
+		st        status
+		hist      flakeHistory
+		threshold float64
+		retry     bool
+	}{
+		{flakyRule{}, broken, flakeHistory{}, 0, true},
+		{flakyRule{Pattern: "timed out"}, timeout, flakeHistory{}, 0, true},
+		{flakyRule{Pattern: "timed out"}, broken, flakeHistory{}, 0, false},
+		{flakyRule{}, broken, flaky, 0.5, true},
+		{flakyRule{}, broken, flaky, 0.8, false},
+		{flakyRule{Pattern: "timed out"}, broken, flaky, 0.5, true},
+	}
+	for i, tc := range cases {
+		if r := shouldRetry(&tc.rule, tc.st, tc.hist, tc.threshold); r != tc.retry {
+			t.Errorf("case %d: shouldRetry = %v, expected %v", i, r, tc.retry)
+		}
+	}
+}
+
+func TestRetryFlaky(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	h := newHandler(nil, "bot", "", false)
+	h.settings.repos = map[string]repoSettings{
+		"foo/bar": {FlakyChecks: []flakyRule{{Context: "ci", RetryURL: srv.URL + "/{number}/{sha}/{context}", MaxRetries: 1}}},
+	}
+	var p pr
+	p.Number, p.Head.SHA = 7, "abc"
+	failed := []status{{State: stateFailure, Context: "ci"}}
+
+	if st, notes := h.statusWithRetries("foo/bar", p, failed, nil); st != statePending || len(notes) != 1 {
+		t.Errorf("Expected a retry, got %v %q", st, notes)
+	}
+	if len(paths) != 1 || paths[0] != "/7/abc/ci" {
+		t.Errorf("Unexpected retry requests %q", paths)
+	}
+	if st, _ := h.statusWithRetries("foo/bar", p, failed, nil); st != stateFailure {
+		t.Error("Expected failure once the retries are used up, got", st)
+	}
+
+	p.Head.SHA = "def"
+	h.statusWithRetries("foo/bar", p, failed, nil)
+	h.statusWithRetries("foo/bar", p, []status{{State: stateSuccess, Context: "ci"}}, nil)
+	if hist := h.flakes.history["foo/bar"]["ci"]; hist.Failures != 2 || hist.Retries != 2 || hist.Passed != 1 {
+		t.Errorf("Unexpected history %+v", hist)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Forges, as named in the Forge setting and in comments and PRs.
+const (
+	forgeGitHub = "github"
+	forgeGitLab = "gitlab"
+	forgeGitea  = "gitea" // and Forgejo
+)
+
+// A forge hosts repositories, doing what the merge flow needs done on
+// comments, PRs and statuses. Everything else is shared, as the clones
+// are plain git.
+type forge interface {
+	postComment(c *comment, body, username, token string) bool
+	closePR(c *comment, username, token string)
+	getUser(c *comment, username, token string) (user, error)
+	getPR(c *comment) (pr, error)
+	setStatus(p *pr, state prState, context, description, target, username, token string)
+	getStatuses(p *pr, repo, username, token string) []status
+	headRef(number int) string // the ref to fetch the head of a PR from
+}
+
+// A memberLister is a forge whose own members may merge, rather than
+// GitHub's collaborators.
+type memberLister interface {
+	members(repo string) ([]string, error)
+}
+
+// A translator is a forge whose webhook events are translated into the
+// GitHub events they amount to.
+type translator interface {
+	translate(eventType string, body []byte) (string, []byte, error)
+}
+
+// forges holds the forges by name; GitLab and Gitea are added when
+// configured.
+var forges = map[string]forge{forgeGitHub: githubForge{}}
+
+// forgeNamed returns the forge by name, GitHub if unnamed or unknown.
+func forgeNamed(name string) forge {
+	if f, ok := forges[name]; ok {
+		return f
+	}
+	if name != "" {
+		log.Printf("Unknown forge %q, using GitHub", name)
+	}
+	return githubForge{}
+}
+
+// onGitHub returns whether the forge name is GitHub's, for the features
+// that only work there.
+func onGitHub(name string) bool {
+	return name == "" || name == forgeGitHub
+}
+
+func (c *comment) forge() forge {
+	return forgeNamed(c.Forge)
+}
+
+func (p *pr) forge() forge {
+	return forgeNamed(p.Forge)
+}
+
+// githubForge works with GitHub through its REST API, with the URLs given
+// in events.
+type githubForge struct{}
+
+func (githubForge) postComment(c *comment, body, username, token string) bool {
+	buf := new(bytes.Buffer)
+	json.NewEncoder(buf).Encode(map[string]string{"body": body})
+	req, err := http.NewRequest("POST", c.Issue.CommentsURL, buf)
+	if err != nil {
+		log.Println("Request:", err)
+		return false
+	}
+	req.SetBasicAuth(username, token)
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		log.Println("Post:", err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Printf("Post: %s: %s", resp.Status, bytes.TrimSpace(msg))
+		return false
+	}
+	return true
+}
+
+func (githubForge) closePR(c *comment, username, token string) {
+	buf := new(bytes.Buffer)
+	json.NewEncoder(buf).Encode(map[string]string{"state": "closed"})
+	req, err := http.NewRequest("PATCH", c.Issue.URL, buf)
+	if err != nil {
+		log.Println("Request:", err)
+		return
+	}
+	req.SetBasicAuth(username, token)
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		log.Println("Post:", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		log.Println("Post:", resp.Status)
+		return
+	}
+}
+
+func (githubForge) getUser(c *comment, username, token string) (user, error) {
+	req, err := http.NewRequest("GET", c.Sender.URL, nil)
+	if err != nil {
+		log.Println("Request:", err)
+		return user{}, err
+	}
+	req.SetBasicAuth(username, token)
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		log.Println("Get:", err)
+		return user{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		log.Println("Post:", resp.Status)
+		return user{}, err
+	}
+
+	var u user
+	err = json.NewDecoder(resp.Body).Decode(&u)
+	if err != nil {
+		return user{}, err
+	}
+
+	return u, nil
+}
+
+func (githubForge) getPR(c *comment) (pr, error) {
+	resp, err := apiClient.Get(c.Issue.PullRequest.URL)
+	if err != nil {
+		return pr{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		log.Println("Get:", c.Issue.PullRequest.URL, resp.Status)
+		return pr{}, &apiError{method: "GET", url: c.Issue.PullRequest.URL, status: resp.Status, code: resp.StatusCode}
+	}
+
+	var p pr
+	if err = json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return pr{}, err
+	}
+	return p, nil
+}
+
+func (githubForge) setStatus(p *pr, state prState, context, description, target, username, token string) {
+	fields := map[string]string{
+		"state":       string(state),
+		"description": description,
+		"context":     context,
+	}
+	if target != "" {
+		fields["target_url"] = target
+	}
+	buf := new(bytes.Buffer)
+	json.NewEncoder(buf).Encode(fields)
+
+	url := p.StatusesURL
+	if url == "" {
+		url = p.Repository.StatusesURL
+	}
+	url = strings.Replace(url, "{sha}", p.headSHA(), 1)
+
+	req, err := http.NewRequest("POST", url, buf)
+	if err != nil {
+		log.Println("Request:", err)
+		return
+	}
+	req.SetBasicAuth(username, token)
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		log.Println("Post:", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		log.Println("Post:", resp.Status)
+		return
+	}
+}
+
+// getStatuses returns the commit statuses along with the check runs.
+func (githubForge) getStatuses(p *pr, repo, username, token string) []status {
+	req, err := http.NewRequest("GET", p.StatusesURL, nil)
+	if err != nil {
+		log.Println("Request:", err)
+		return nil
+	}
+	req.SetBasicAuth(username, token)
+	checks, err := p.getChecks(repo, username, token)
+	if err != nil {
+		checks = []status{checksUnknown(err)}
+	}
+	return withChecks(fetchStatuses(req), checks)
+}
+
+func (githubForge) headRef(number int) string {
+	return fmt.Sprintf("refs/pull/%d/head", number)
+}
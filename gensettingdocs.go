@@ -0,0 +1,69 @@
+//go:build ignore
+
+// gensettingdocs writes settingdocs.go, describing each setting by the
+// comment on its field of repoSettings, so that the schema and the settings
+// documentation can't drift from the code. Run it with go generate.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "settings.go", nil, parser.ParseComments)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by gensettingdocs.go from the comments on repoSettings; DO NOT EDIT.\n\n")
+	buf.WriteString("package main\n\n")
+	buf.WriteString("// settingDocs describes the settings, by key.\n")
+	buf.WriteString("var settingDocs = map[string]string{\n")
+	found := false
+	ast.Inspect(f, func(n ast.Node) bool {
+		spec, ok := n.(*ast.TypeSpec)
+		if !ok || spec.Name.Name != "repoSettings" {
+			return true
+		}
+		found = true
+		for _, field := range spec.Type.(*ast.StructType).Fields.List {
+			doc := strings.TrimSpace(field.Comment.Text())
+			if doc == "" || len(field.Names) == 0 {
+				continue
+			}
+			key := strings.ToLower(field.Names[0].Name)
+			if field.Tag != nil {
+				tag, _ := strconv.Unquote(field.Tag.Value)
+				if name := strings.Split(reflect.StructTag(tag).Get("json"), ",")[0]; name != "" {
+					key = name
+				}
+			}
+			fmt.Fprintf(&buf, "\t%q: %q,\n", key, doc)
+		}
+		return false
+	})
+	if !found {
+		log.Fatal("no repoSettings in settings.go")
+	}
+	buf.WriteString("}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("settingdocs.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// giteaAPI is the base URL of the Gitea API, for repositories whose Forge
+// setting is "gitea". Forgejo serves the same API.
+var giteaAPI = "https://gitea.com/api/v1"
+
+// giteaForge works with Gitea and Forgejo, whose API mostly follows
+// GitHub's. It authenticates with an access token of the bot's user.
+type giteaForge struct {
+	token string
+}
+
+func (f giteaForge) request(method, u string, in, out interface{}) error {
+	return sendAPIRequest(method, u, in, out, func(req *http.Request) { req.Header.Set("Authorization", "token "+f.token) })
+}
+
+// giteaRepo returns the API URL of the repository.
+func giteaRepo(repo string) string {
+	return giteaAPI + "/repos/" + repo
+}
+
+// A giteaPullRequest is a PR as the API and events give it.
+type giteaPullRequest struct {
+	Number  int
+	Title   string
+	State   string // open or closed
+	HTMLURL string `json:"html_url"`
+	User    struct {
+		Login string
+	}
+	Base struct {
+		Ref string
+	}
+	Head struct {
+		SHA string
+	}
+	Mergeable bool
+	Milestone *struct {
+		ID    int
+		Title string
+	}
+}
+
+// pr returns the Gitea PR of the repository as ours.
+func (g giteaPullRequest) pr(repo string) pr {
+	var p pr
+	p.Forge = forgeGitea
+	p.Number = g.Number
+	p.URL = fmt.Sprintf("%s/pulls/%d", giteaRepo(repo), g.Number)
+	p.Title = g.Title
+	p.State = g.State
+	p.User.Login = g.User.Login
+	p.HTMLURL = g.HTMLURL
+	p.Base.Ref = g.Base.Ref
+	p.Head.SHA = g.Head.SHA
+	p.Repository.FullName = repo
+	mergeable := g.Mergeable
+	p.Mergeable = &mergeable
+	if g.Milestone != nil {
+		p.Milestone = &struct {
+			Number int
+			Title  string
+		}{g.Milestone.ID, g.Milestone.Title}
+	}
+	return p
+}
+
+func (f giteaForge) postComment(c *comment, body, username, token string) bool {
+	return f.request("POST", c.Issue.CommentsURL, map[string]string{"body": body}, nil) == nil
+}
+
+func (f giteaForge) closePR(c *comment, username, token string) {
+	f.request("PATCH", c.Issue.URL, map[string]string{"state": "closed"}, nil)
+}
+
+// getUser returns the user who made the comment. Users who keep their
+// email private get a no-reply address.
+func (f giteaForge) getUser(c *comment, username, token string) (user, error) {
+	var u struct {
+		Login    string
+		FullName string `json:"full_name"`
+		Email    string
+	}
+	if err := f.request("GET", c.Sender.URL, nil, &u); err != nil {
+		return user{}, err
+	}
+	return user{Login: u.Login, Name: u.FullName, Email: u.Email}, nil
+}
+
+func (f giteaForge) getPR(c *comment) (pr, error) {
+	var g giteaPullRequest
+	if err := f.request("GET", c.Issue.PullRequest.URL, nil, &g); err != nil {
+		return pr{}, err
+	}
+	return g.pr(c.Repository.FullName), nil
+}
+
+func (f giteaForge) setStatus(p *pr, state prState, context, description, target, username, token string) {
+	fields := map[string]string{
+		"state":       string(state),
+		"context":     context,
+		"description": description,
+	}
+	if target != "" {
+		fields["target_url"] = target
+	}
+	f.request("POST", giteaRepo(p.Repository.FullName)+"/statuses/"+p.headSHA(), fields, nil)
+}
+
+// getStatuses returns the latest status of each context for the head of
+// the PR. Warnings don't hold merges up.
+func (f giteaForge) getStatuses(p *pr, repo, username, token string) []status {
+	var combined struct {
+		Statuses []struct {
+			status
+			Status string `json:"status"` // as Gitea calls the state, which may also be warning
+		}
+	}
+	if err := f.request("GET", giteaRepo(repo)+"/commits/"+p.headSHA()+"/status", nil, &combined); err != nil {
+		return nil
+	}
+	var res []status
+	for _, s := range combined.Statuses {
+		st := s.status
+		st.State = prState(s.Status)
+		if s.Status == "warning" {
+			st.State = stateSuccess
+		}
+		res = append(res, st)
+	}
+	return res
+}
+
+func (giteaForge) headRef(number int) string {
+	return fmt.Sprintf("refs/pull/%d/head", number)
+}
+
+// members returns the collaborators of the repository who may push.
+func (f giteaForge) members(repo string) ([]string, error) {
+	var users []string
+	for page := 1; ; page++ {
+		var collaborators []struct {
+			Login       string
+			Permissions struct {
+				Admin bool
+				Push  bool
+			}
+		}
+		u := fmt.Sprintf("%s/collaborators?limit=50&page=%d", giteaRepo(repo), page)
+		if err := f.request("GET", u, nil, &collaborators); err != nil {
+			return nil, err
+		}
+		for _, c := range collaborators {
+			if c.Permissions.Admin || c.Permissions.Push {
+				users = append(users, c.Login)
+			}
+		}
+		if len(collaborators) < 50 {
+			return users, nil
+		}
+	}
+}
+
+// A giteaEvent is the part of Gitea's issue comment and PR events we use.
+type giteaEvent struct {
+	Action string
+	Issue  struct { // issue comment events
+		Number int
+		User   struct {
+			Login string
+		}
+		PullRequest *struct{} `json:"pull_request"` // set on PRs
+	}
+	Comment struct { // issue comment events
+		ID      int64
+		Body    string
+		HTMLURL string `json:"html_url"`
+		User    struct {
+			Login string
+		}
+	}
+	PullRequest giteaPullRequest `json:"pull_request"` // PR events
+	Repository  struct {
+		FullName string `json:"full_name"`
+	}
+	Sender struct {
+		Login string
+	}
+}
+
+// giteaEventHeader returns the event type of a Gitea or Forgejo delivery,
+// if it's one.
+func giteaEventHeader(header http.Header) string {
+	if event := header.Get("X-Forgejo-Event"); event != "" {
+		return event
+	}
+	return header.Get("X-Gitea-Event")
+}
+
+// verifyGiteaSignature checks the HMAC-SHA256 of the body that Gitea and
+// Forgejo send in hex, without a prefix. Without a secret nothing
+// verifies.
+func verifyGiteaSignature(body []byte, secret string, header http.Header) bool {
+	if secret == "" {
+		return false
+	}
+	sig := header.Get("X-Forgejo-Signature")
+	if sig == "" {
+		sig = header.Get("X-Gitea-Signature")
+	}
+	return sig != "" && checkMAC(body, secret, sig, "", sha256.New)
+}
+
+// giteaActions are the PR event actions for Gitea's PR event actions.
+var giteaActions = map[string]string{
+	"opened":       "opened",
+	"reopened":     "reopened",
+	"closed":       "closed",
+	"synchronized": "synchronize",
+}
+
+// translate turns a Gitea event into the GitHub event it amounts to, with
+// the URLs of the Gitea API, so that it's handled the same from there on.
+// Events with no counterpart give an empty event type.
+func (f giteaForge) translate(eventType string, body []byte) (string, []byte, error) {
+	var ev giteaEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return "", nil, err
+	}
+	repo := ev.Repository.FullName
+	switch eventType {
+	case "issue_comment":
+		if ev.Issue.PullRequest == nil {
+			return "", nil, nil
+		}
+		var c comment
+		c.Forge = forgeGitea
+		c.Action = ev.Action
+		c.Comment.ID = ev.Comment.ID
+		c.Comment.User.Login = ev.Comment.User.Login
+		c.Comment.Body = ev.Comment.Body
+		c.Comment.HTMLURL = ev.Comment.HTMLURL
+		c.Issue.Number = ev.Issue.Number
+		c.Issue.URL = fmt.Sprintf("%s/issues/%d", giteaRepo(repo), ev.Issue.Number)
+		c.Issue.CommentsURL = c.Issue.URL + "/comments"
+		c.Issue.User.Login = ev.Issue.User.Login
+		c.Issue.PullRequest.URL = fmt.Sprintf("%s/pulls/%d", giteaRepo(repo), ev.Issue.Number)
+		c.Repository.FullName = repo
+		c.Sender.Login = ev.Sender.Login
+		c.Sender.URL = giteaAPI + "/users/" + ev.Sender.Login
+		bs, err := json.Marshal(c)
+		return "issue_comment", bs, err
+
+	case "pull_request":
+		action := giteaActions[ev.Action]
+		if action == "" {
+			return "", nil, nil
+		}
+		p := ev.PullRequest.pr(repo)
+		p.Action = action
+		p.PullRequest.URL = p.URL
+		p.PullRequest.User.Login = p.User.Login
+		p.PullRequest.Head.SHA = p.Head.SHA
+		p.PullRequest.Base.Ref = p.Base.Ref
+		bs, err := json.Marshal(p)
+		return "pull_request", bs, err
+	}
+	return "", nil, nil
+}
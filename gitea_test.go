@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGitea(t *testing.T) {
+	var comments []string
+	var posted map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token gtea" {
+			http.Error(w, "no token", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/users/alice":
+			w.Write([]byte(`{"login": "alice", "full_name": "Alice", "email": "alice@example.com"}`))
+		case "/repos/foo/bar/pulls/3":
+			w.Write([]byte(`{"number": 3, "title": "Fix it", "state": "open", "head": {"sha": "abc"}, "base": {"ref": "main"}, "user": {"login": "carol"}, "mergeable": true, "milestone": {"id": 2, "title": "v1"}}`))
+		case "/repos/foo/bar/issues/3/comments":
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			comments = append(comments, body.Body)
+		case "/repos/foo/bar/commits/abc/status":
+			w.Write([]byte(`{"state": "pending", "statuses": [{"status": "warning", "context": "lint"}, {"status": "pending", "context": "ci", "target_url": "https://ci/1"}]}`))
+		case "/repos/foo/bar/statuses/abc":
+			json.NewDecoder(r.Body).Decode(&posted)
+		case "/repos/foo/bar/collaborators":
+			w.Write([]byte(`[{"login": "alice", "permissions": {"push": true}}, {"login": "reader", "permissions": {"pull": true}}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { giteaAPI = u }(giteaAPI)
+	giteaAPI = srv.URL
+	defer delete(forges, forgeGitea)
+	forges[forgeGitea] = giteaForge{token: "gtea"}
+
+	h := newWebhook(":0", "secret", "bot", "")
+	h.forgeFor = func(repo string) string {
+		if repo == "foo/bar" {
+			return forgeGitea
+		}
+		return ""
+	}
+	var got []comment
+	h.handleComment("merge", func(c comment) { got = append(got, c) })
+	deliver := func(header, secret, body string) int {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		req := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(body)))
+		req.Header.Set(header, "issue_comment")
+		req.Header.Set("X-Github-Event", "issue_comment")
+		req.Header.Set("X-Gitea-Signature", hex.EncodeToString(mac.Sum(nil)))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	event := `{"action": "created", "issue": {"number": 3, "user": {"login": "carol"}, "pull_request": {"merged": false}},
+		"comment": {"id": 99, "body": "@bot merge", "html_url": "https://gitea/c/99", "user": {"login": "alice"}},
+		"repository": {"full_name": "foo/bar"}, "sender": {"login": "alice"}}`
+	if code := deliver("X-Gitea-Event", "wrong", event); code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong signature to be refused, got %d", code)
+	}
+	if code := deliver("X-Gitea-Event", "secret", `{"repository": {"full_name": "foo/baz"}}`); code != http.StatusBadRequest {
+		t.Errorf("Expected a repository not on Gitea to be refused, got %d", code)
+	}
+	if code := deliver("X-Forgejo-Event", "secret", event); code != http.StatusOK || len(got) != 1 {
+		t.Fatalf("Expected the comment to be handled, got %d and %+v", code, got)
+	}
+
+	c := got[0]
+	if c.Forge != forgeGitea || c.Sender.Login != "alice" || c.Issue.User.Login != "carol" || c.Issue.Number != 3 || c.Comment.HTMLURL != "https://gitea/c/99" {
+		t.Errorf("Unexpected comment %+v", c)
+	}
+	p, err := c.getPR()
+	if err != nil || p.Forge != forgeGitea || p.headSHA() != "abc" || p.Base.Ref != "main" || p.Mergeable == nil || !*p.Mergeable || p.Milestone == nil || p.Milestone.Title != "v1" {
+		t.Errorf("Unexpected PR %+v, %v", p, err)
+	}
+	statuses := p.getStatuses("foo/bar", "bot", "")
+	if len(statuses) != 2 || statuses[0].State != stateSuccess || statuses[1].State != statePending || statuses[1].TargetURL != "https://ci/1" {
+		t.Errorf("Unexpected statuses %+v", statuses)
+	}
+	p.setStatus(stateFailure, "mergebot", "Conflicts", "bot", "")
+	if posted["state"] != "failure" || posted["context"] != "mergebot" {
+		t.Errorf("Unexpected status posted %v", posted)
+	}
+	if u, err := c.user("bot", ""); err != nil || u.Name != "Alice" || u.Email != "alice@example.com" {
+		t.Errorf("Unexpected user %+v, %v", u, err)
+	}
+	c.post("Done.", "bot", "")
+	if len(comments) != 1 || !bytes.Contains([]byte(comments[0]), []byte("Done.")) {
+		t.Errorf("Unexpected comments %q", comments)
+	}
+	if ref := prRef(p).remote; ref != "refs/pull/3/head" {
+		t.Errorf("Unexpected ref %s", ref)
+	}
+
+	perms := permissions{teamMembers: make(map[string][]string), forgeOf: h.forgeFor}
+	if !perms.isAllowed("foo/bar", "alice") || perms.isAllowed("foo/bar", "reader") {
+		t.Error("Expected only collaborators who may push to be allowed")
+	}
+
+	// A Gitea comment from an admin's GitHub login counts for nothing
+	// unless that login is a collaborator on Gitea as well.
+	perms.alwaysAllowed = []string{"root", "alice"}
+	if perms.isAllowed("foo/bar", "root") || perms.isAdmin("foo/bar", "root") {
+		t.Error("Expected a GitHub admin's login not to count on Gitea")
+	}
+	if !perms.isAllowed("foo/bar", "alice") || perms.isAdmin("foo/bar", "alice") {
+		t.Error("Expected a Gitea collaborator to be allowed, but not as an admin")
+	}
+}
+
+func TestGiteaPullRequestEvents(t *testing.T) {
+	tests := []struct {
+		action, want string
+	}{
+		{"opened", "opened"},
+		{"synchronized", "synchronize"},
+		{"closed", "closed"},
+		{"label_updated", ""},
+	}
+	for _, test := range tests {
+		body := `{"action": "` + test.action + `", "number": 3, "pull_request": {"number": 3, "user": {"login": "carol"}, "head": {"sha": "abc"}, "base": {"ref": "main"}},
+			"repository": {"full_name": "foo/bar"}}`
+		event, bs, err := giteaForge{}.translate("pull_request", []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.want == "" {
+			if event != "" {
+				t.Errorf("%s: expected no event, got %s", test.action, event)
+			}
+			continue
+		}
+		var p pr
+		json.Unmarshal(bs, &p)
+		if event != "pull_request" || p.Action != test.want || p.Forge != forgeGitea || p.PullRequest.Head.SHA != "abc" || p.PullRequest.User.Login != "carol" || p.Number != 3 {
+			t.Errorf("%s: unexpected %s event %+v", test.action, event, p)
+		}
+	}
+}
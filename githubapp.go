@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A githubApp authenticates as a GitHub App installation instead of as a
+// user with a personal access token. Each account the app is installed on
+// has its own installation, with short lived tokens that are refreshed as
+// needed.
+type githubApp struct {
+	id     int64
+	key    *rsa.PrivateKey
+	client *http.Client // without the app's authentication
+
+	mut           sync.Mutex
+	installations map[string]int64            // account -> installation ID
+	tokens        map[int64]installationToken // installation ID -> token
+	lastAccount   string                      // for requests not about a repository
+}
+
+type installationToken struct {
+	Token     string
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// tokenMargin is how long before expiry tokens are refreshed, so that they
+// don't expire during a merge.
+const tokenMargin = 10 * time.Minute
+
+func loadGitHubApp(id int64, keyPath string) (*githubApp, error) {
+	bs, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bs)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", keyPath)
+	}
+	var key *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		var k interface{}
+		k, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err == nil {
+			var ok bool
+			if key, ok = k.(*rsa.PrivateKey); !ok {
+				err = fmt.Errorf("%s: not an RSA key", keyPath)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newGitHubApp(id, key, apiClient), nil
+}
+
+func newGitHubApp(id int64, key *rsa.PrivateKey, client *http.Client) *githubApp {
+	return &githubApp{
+		id:            id,
+		key:           key,
+		client:        client,
+		installations: make(map[string]int64),
+		tokens:        make(map[int64]installationToken),
+	}
+}
+
+// jwt returns a token authenticating as the app itself, valid for a few
+// minutes.
+func (a *githubApp) jwt(now time.Time) (string, error) {
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(), // allow for clock drift
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": a.id,
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
+
+// appRequest performs a request authenticated as the app.
+func (a *githubApp) appRequest(method, url string, out interface{}) error {
+	jwt, err := a.jwt(time.Now())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return &apiError{method: method, url: url, status: resp.Status, code: resp.StatusCode}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// token returns an installation token for the account, which is the owner
+// of a repository. For an empty account, the last one used is assumed.
+func (a *githubApp) token(account string) (string, error) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	if account == "" {
+		account = a.lastAccount
+	}
+	id, ok := a.installations[account]
+	if !ok {
+		var err error
+		if id, err = a.installationID(account); err != nil {
+			return "", err
+		}
+		a.installations[account] = id
+	}
+	a.lastAccount = account
+
+	if tok, ok := a.tokens[id]; ok && time.Until(tok.ExpiresAt) > tokenMargin {
+		return tok.Token, nil
+	}
+	var tok installationToken
+	if err := a.appRequest("POST", fmt.Sprintf("%s/app/installations/%d/access_tokens", githubAPI, id), &tok); err != nil {
+		return "", err
+	}
+	a.tokens[id] = tok
+	secrets.add(tok.Token)
+	return tok.Token, nil
+}
+
+// installationID returns the installation on the account, or any
+// installation if no account is given.
+func (a *githubApp) installationID(account string) (int64, error) {
+	if account == "" {
+		var insts []struct {
+			ID int64
+		}
+		if err := a.appRequest("GET", githubAPI+"/app/installations", &insts); err != nil {
+			return 0, err
+		}
+		if len(insts) == 0 {
+			return 0, fmt.Errorf("the app isn't installed anywhere")
+		}
+		return insts[0].ID, nil
+	}
+	var inst struct {
+		ID int64
+	}
+	if err := a.appRequest("GET", fmt.Sprintf("%s/users/%s/installation", githubAPI, url.PathEscape(account)), &inst); err != nil {
+		return 0, fmt.Errorf("the app isn't installed for %s: %v", account, err)
+	}
+	return inst.ID, nil
+}
+
+// An appTransport authenticates API requests with the installation token
+// for the repository they're about, in place of any other credentials.
+// Requests to other hosts pass through untouched.
+type appTransport struct {
+	app  *githubApp
+	host string
+	base http.RoundTripper
+}
+
+func (t *appTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.base.RoundTrip(req)
+	}
+	tok, err := t.app.token(requestAccount(req.URL.Path))
+	if err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "token "+tok)
+	return t.base.RoundTrip(r)
+}
+
+// requestAccount returns the owner of the repository an API path is about,
+// as in /repos/owner/name/pulls/1.
+func requestAccount(path string) string {
+	idx := strings.Index(path, "/repos/")
+	if idx < 0 {
+		return ""
+	}
+	parts := strings.SplitN(path[idx+len("/repos/"):], "/", 2)
+	return parts[0]
+}
+
+// setGitHubApp makes API requests authenticate as the app installation.
+func setGitHubApp(app *githubApp) error {
+	u, err := url.Parse(githubAPI)
+	if err != nil {
+		return err
+	}
+	base := apiClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	apiClient = &http.Client{Transport: &appTransport{app: app, host: u.Host, base: base}}
+	return nil
+}
+
+// gitHost returns the host git is served from, given the API URL.
+func gitHost(apiURL string) string {
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Host == "api.github.com" {
+		return "github.com"
+	}
+	return u.Host
+}
+
+// appCloneURL is the clone URL template when authenticating as an app;
+// the token expands to a current installation token.
+func appCloneURL(apiURL string) string {
+	return "https://x-access-token:{token}@" + gitHost(apiURL) + "/{repo}.git"
+}
+
+// refreshRemote points the origin of the clone at a fresh installation
+// token, as the one it was cloned with may have expired since.
+func (h *handler) refreshRemote(repo string) {
+	h.refreshCredentials(repo)
+	if h.app == nil || !strings.Contains(h.cloneTemplate(repo), "{token}") {
+		return
+	}
+	s := newScript()
+	s.run("git", "-C", repo, "remote", "set-url", "origin", h.cloneURL(repo))
+	if s.err != nil {
+		log.Println("Refreshing remote:", s.err)
+	}
+}
+
+// refreshCredentials gives the credential helper a fresh installation token
+// for the owner of the repository, when cloning over HTTPS as an app.
+func (h *handler) refreshCredentials(repo string) {
+	if h.app == nil || h.creds == nil {
+		return
+	}
+	owner := strings.SplitN(repo, "/", 2)[0]
+	token, err := h.app.token(owner)
+	if err == nil {
+		err = h.creds.set(owner, token)
+	}
+	if err != nil {
+		log.Println("Refreshing credentials:", err)
+	}
+}
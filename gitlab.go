@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// gitlabAPI is the base URL of the GitLab API, for repositories whose Forge
+// setting is "gitlab".
+var gitlabAPI = "https://gitlab.com/api/v4"
+
+// gitlabForge works with GitLab merge requests, which pass for PRs numbered
+// by their IID, and their notes, which pass for comments. It authenticates
+// with an access token of the bot's user.
+type gitlabForge struct {
+	token string
+}
+
+func (f gitlabForge) request(method, u string, in, out interface{}) error {
+	return sendAPIRequest(method, u, in, out, func(req *http.Request) { req.Header.Set("PRIVATE-TOKEN", f.token) })
+}
+
+// gitlabProject returns the API URL of the project, which is addressed by
+// its escaped path.
+func gitlabProject(repo string) string {
+	return gitlabAPI + "/projects/" + strings.ReplaceAll(url.PathEscape(repo), "/", "%2F")
+}
+
+// gitlabMR returns the API URL of the merge request.
+func gitlabMR(repo string, iid int) string {
+	return fmt.Sprintf("%s/merge_requests/%d", gitlabProject(repo), iid)
+}
+
+// A gitlabMergeRequest is a merge request as the API and events give it.
+type gitlabMergeRequest struct {
+	IID        int
+	Title      string
+	State      string // opened, closed, locked or merged
+	WebURL     string `json:"web_url"`
+	SHA        string // from the API
+	LastCommit struct {
+		ID string
+	} `json:"last_commit"` // from events
+	TargetBranch string `json:"target_branch"`
+	Author       struct {
+		Username string
+	}
+	MergeStatus  string `json:"merge_status"` // unchecked, checking, can_be_merged or cannot_be_merged
+	HasConflicts bool   `json:"has_conflicts"`
+}
+
+// pr returns the merge request of the repository as a PR.
+func (m gitlabMergeRequest) pr(repo string) pr {
+	var p pr
+	p.Forge = forgeGitLab
+	p.Number = m.IID
+	p.URL = gitlabMR(repo, m.IID)
+	p.Title = m.Title
+	p.State = m.State
+	if m.State == "opened" {
+		p.State = "open"
+	}
+	p.User.Login = m.Author.Username
+	p.HTMLURL = m.WebURL
+	p.Base.Ref = m.TargetBranch
+	p.Head.SHA = m.SHA
+	if p.Head.SHA == "" {
+		p.Head.SHA = m.LastCommit.ID
+	}
+	p.Repository.FullName = repo
+	switch m.MergeStatus {
+	case "can_be_merged", "cannot_be_merged":
+		mergeable := !m.HasConflicts
+		p.Mergeable = &mergeable
+	}
+	return p
+}
+
+func (f gitlabForge) postComment(c *comment, body, username, token string) bool {
+	return f.request("POST", c.Issue.CommentsURL, map[string]string{"body": body}, nil) == nil
+}
+
+func (f gitlabForge) closePR(c *comment, username, token string) {
+	f.request("PUT", c.Issue.URL, map[string]string{"state_event": "close"}, nil)
+}
+
+// getUser returns the user who made the comment. Only public emails are
+// given, so those without one can't be committers.
+func (f gitlabForge) getUser(c *comment, username, token string) (user, error) {
+	var u struct {
+		Username    string
+		Name        string
+		PublicEmail string `json:"public_email"`
+	}
+	if err := f.request("GET", c.Sender.URL, nil, &u); err != nil {
+		return user{}, err
+	}
+	return user{Login: u.Username, Name: u.Name, Email: u.PublicEmail}, nil
+}
+
+func (f gitlabForge) getPR(c *comment) (pr, error) {
+	var m gitlabMergeRequest
+	if err := f.request("GET", c.Issue.PullRequest.URL, nil, &m); err != nil {
+		return pr{}, err
+	}
+	return m.pr(c.Repository.FullName), nil
+}
+
+// gitlabStates are the commit status states for ours.
+var gitlabStates = map[prState]string{
+	statePending: "pending",
+	stateSuccess: "success",
+	stateFailure: "failed",
+	stateError:   "failed",
+}
+
+func (f gitlabForge) setStatus(p *pr, state prState, context, description, target, username, token string) {
+	fields := map[string]string{
+		"state":       gitlabStates[state],
+		"name":        context,
+		"description": description,
+	}
+	if target != "" {
+		fields["target_url"] = target
+	}
+	f.request("POST", gitlabProject(p.Repository.FullName)+"/statuses/"+p.headSHA(), fields, nil)
+}
+
+// gitlabPipelineContext is the context of the status for the pipeline of
+// a merge request, as may be required with RequiredStatuses.
+const gitlabPipelineContext = "pipeline"
+
+// getStatuses returns the status of the latest pipeline for the head of
+// the merge request, which sums up its jobs and any external statuses.
+func (f gitlabForge) getStatuses(p *pr, repo, username, token string) []status {
+	var pipelines []struct {
+		ID        int
+		SHA       string
+		Status    string
+		WebURL    string    `json:"web_url"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+	if err := f.request("GET", gitlabMR(repo, p.Number)+"/pipelines", nil, &pipelines); err != nil {
+		return nil
+	}
+	for _, pl := range pipelines { // newest first
+		if pl.SHA != p.headSHA() {
+			continue
+		}
+		st := status{
+			Context:     gitlabPipelineContext,
+			State:       pipelineState(pl.Status),
+			Description: fmt.Sprintf("Pipeline #%d %s", pl.ID, pl.Status),
+			TargetURL:   pl.WebURL,
+			UpdatedAt:   pl.UpdatedAt,
+		}
+		return []status{st}
+	}
+	return nil
+}
+
+// pipelineState returns the status state for the state of a pipeline.
+func pipelineState(s string) prState {
+	switch s {
+	case "success":
+		return stateSuccess
+	case "failed":
+		return stateFailure
+	case "canceled", "skipped":
+		return stateError
+	}
+	return statePending // created, waiting_for_resource, preparing, pending, running, manual or scheduled
+}
+
+func (gitlabForge) headRef(number int) string {
+	return fmt.Sprintf("refs/merge-requests/%d/head", number)
+}
+
+// gitlabDeveloper is the access level that may merge.
+const gitlabDeveloper = 30
+
+// members returns those who may merge in the project, being developers or
+// above, directly or through groups.
+func (f gitlabForge) members(repo string) ([]string, error) {
+	var users []string
+	for page := 1; ; page++ {
+		var members []struct {
+			Username    string
+			AccessLevel int `json:"access_level"`
+		}
+		u := fmt.Sprintf("%s/members/all?per_page=100&page=%d", gitlabProject(repo), page)
+		if err := f.request("GET", u, nil, &members); err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			if m.AccessLevel >= gitlabDeveloper {
+				users = append(users, m.Username)
+			}
+		}
+		if len(members) < 100 {
+			return users, nil
+		}
+	}
+}
+
+// A gitlabEvent is the part of GitLab's note and merge request events we
+// use.
+type gitlabEvent struct {
+	User struct {
+		ID       int
+		Username string
+	}
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	}
+	ObjectAttributes struct {
+		gitlabMergeRequest        // merge request events
+		ID                 int64  // note events
+		Note               string // note events
+		NoteableType       string `json:"noteable_type"`
+		URL                string
+		Action             string // merge request events
+		OldRev             string `json:"oldrev"` // merge request events with new commits
+	} `json:"object_attributes"`
+	MergeRequest struct { // note events on merge requests
+		IID      int
+		AuthorID int `json:"author_id"`
+	} `json:"merge_request"`
+}
+
+// gitlabDeliveryRepo returns the project a GitLab event is about.
+func gitlabDeliveryRepo(body []byte) string {
+	var ev gitlabEvent
+	json.Unmarshal(body, &ev)
+	return ev.Project.PathWithNamespace
+}
+
+// verifyGitLabToken checks the secret GitLab sends as is in X-Gitlab-Token.
+// Without a secret nothing verifies.
+func verifyGitLabToken(secret string, header http.Header) bool {
+	token := header.Get("X-Gitlab-Token")
+	return secret != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+}
+
+// gitlabActions are the PR event actions for merge request event actions.
+var gitlabActions = map[string]string{
+	"open":   "opened",
+	"reopen": "reopened",
+	"close":  "closed",
+	"merge":  "closed",
+}
+
+// translate turns a GitLab event into the GitHub event it amounts to, so
+// that it's handled the same from there on. Events with no GitHub
+// counterpart give an empty event type.
+func (f gitlabForge) translate(eventType string, body []byte) (string, []byte, error) {
+	var ev gitlabEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return "", nil, err
+	}
+	repo := ev.Project.PathWithNamespace
+	attrs := ev.ObjectAttributes
+	switch eventType {
+	case "Note Hook":
+		if attrs.NoteableType != "MergeRequest" {
+			return "", nil, nil
+		}
+		var c comment
+		c.Forge = forgeGitLab
+		c.Action = "created"
+		c.Comment.ID = attrs.ID
+		c.Comment.User.Login = ev.User.Username
+		c.Comment.Body = attrs.Note
+		c.Comment.HTMLURL = attrs.URL
+		c.Issue.Number = ev.MergeRequest.IID
+		c.Issue.URL = gitlabMR(repo, ev.MergeRequest.IID)
+		c.Issue.CommentsURL = c.Issue.URL + "/notes"
+		c.Issue.PullRequest.URL = c.Issue.URL
+		c.Repository.FullName = repo
+		c.Sender.Login = ev.User.Username
+		c.Sender.URL = fmt.Sprintf("%s/users/%d", gitlabAPI, ev.User.ID)
+		// Merges thank the author, whom the event only gives the ID of.
+		var author struct{ Username string }
+		if err := f.request("GET", fmt.Sprintf("%s/users/%d", gitlabAPI, ev.MergeRequest.AuthorID), nil, &author); err == nil {
+			c.Issue.User.Login = author.Username
+		}
+		bs, err := json.Marshal(c)
+		return "issue_comment", bs, err
+
+	case "Merge Request Hook":
+		action := gitlabActions[attrs.Action]
+		if attrs.Action == "update" && attrs.OldRev != "" {
+			action = "synchronize"
+		}
+		if action == "" {
+			return "", nil, nil
+		}
+		p := attrs.gitlabMergeRequest.pr(repo)
+		p.Action = action
+		p.PullRequest.URL = p.URL
+		p.PullRequest.User.Login = ev.User.Username
+		p.PullRequest.Head.SHA = p.Head.SHA
+		p.PullRequest.Base.Ref = p.Base.Ref
+		p.HTMLURL = attrs.URL
+		bs, err := json.Marshal(p)
+		return "pull_request", bs, err
+	}
+	return "", nil, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestGitLab(t *testing.T) {
+	var notes []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "glpat" {
+			http.Error(w, "no token", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.EscapedPath() {
+		case "/users/7":
+			w.Write([]byte(`{"username": "carol"}`))
+		case "/users/8":
+			w.Write([]byte(`{"username": "alice", "name": "Alice", "public_email": "alice@example.com"}`))
+		case "/projects/foo%2Fbar/merge_requests/3":
+			w.Write([]byte(`{"iid": 3, "title": "Fix it", "state": "opened", "sha": "abc", "target_branch": "main", "author": {"username": "carol"}, "merge_status": "can_be_merged"}`))
+		case "/projects/foo%2Fbar/merge_requests/3/notes":
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			notes = append(notes, body.Body)
+		case "/projects/foo%2Fbar/merge_requests/3/pipelines":
+			w.Write([]byte(`[{"id": 12, "sha": "def", "status": "success"}, {"id": 11, "sha": "abc", "status": "running", "web_url": "https://gitlab/p/11"}]`))
+		case "/projects/foo%2Fbar/members/all":
+			w.Write([]byte(`[{"username": "alice", "access_level": 40}, {"username": "guest", "access_level": 10}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { gitlabAPI = u }(gitlabAPI)
+	gitlabAPI = srv.URL
+	defer delete(forges, forgeGitLab)
+	forges[forgeGitLab] = gitlabForge{token: "glpat"}
+
+	h := newWebhook(":0", "secret", "bot", "")
+	h.forgeFor = func(repo string) string {
+		if repo == "foo/bar" {
+			return forgeGitLab
+		}
+		return ""
+	}
+	var got []comment
+	h.handleComment("merge", func(c comment) { got = append(got, c) })
+	deliver := func(event, token, body string) int {
+		req := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(body)))
+		req.Header.Set("X-Gitlab-Event", event)
+		req.Header.Set("X-Gitlab-Token", token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	note := `{"object_kind": "note", "user": {"id": 8, "username": "alice"}, "project": {"path_with_namespace": "foo/bar"},
+		"object_attributes": {"id": 99, "note": "@bot merge", "noteable_type": "MergeRequest", "url": "https://gitlab/n/99"},
+		"merge_request": {"iid": 3, "author_id": 7}}`
+	if code := deliver("Note Hook", "wrong", note); code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong token to be refused, got %d", code)
+	}
+	if code := deliver("Note Hook", "secret", `{"project": {"path_with_namespace": "foo/baz"}}`); code != http.StatusBadRequest {
+		t.Errorf("Expected a repository not on GitLab to be refused, got %d", code)
+	}
+	if code := deliver("Note Hook", "secret", note); code != http.StatusOK || len(got) != 1 {
+		t.Fatalf("Expected the note to be handled, got %d and %+v", code, got)
+	}
+
+	c := got[0]
+	if c.Forge != forgeGitLab || c.Sender.Login != "alice" || c.Issue.User.Login != "carol" || c.Issue.Number != 3 || c.Comment.HTMLURL != "https://gitlab/n/99" {
+		t.Errorf("Unexpected comment %+v", c)
+	}
+	p, err := c.getPR()
+	if err != nil || p.Forge != forgeGitLab || p.State != "open" || p.headSHA() != "abc" || p.Base.Ref != "main" || p.User.Login != "carol" || p.Mergeable == nil || !*p.Mergeable {
+		t.Errorf("Unexpected PR %+v, %v", p, err)
+	}
+	want := []status{{Context: gitlabPipelineContext, State: statePending, Description: "Pipeline #11 running", TargetURL: "https://gitlab/p/11"}}
+	if statuses := p.getStatuses("foo/bar", "bot", ""); !reflect.DeepEqual(statuses, want) {
+		t.Errorf("Got statuses %+v, want %+v", statuses, want)
+	}
+	if u, err := c.user("bot", ""); err != nil || u.Email != "alice@example.com" {
+		t.Errorf("Unexpected user %+v, %v", u, err)
+	}
+	c.post("Done.", "bot", "")
+	if len(notes) != 1 || !bytes.Contains([]byte(notes[0]), []byte("Done.")) {
+		t.Errorf("Unexpected notes %q", notes)
+	}
+	if ref := prRef(p).remote; ref != "refs/merge-requests/3/head" {
+		t.Errorf("Unexpected ref %s", ref)
+	}
+
+	perms := permissions{teamMembers: make(map[string][]string), forgeOf: h.forgeFor}
+	if !perms.isAllowed("foo/bar", "alice") || perms.isAllowed("foo/bar", "guest") {
+		t.Error("Expected developers and above to be allowed")
+	}
+
+	// The admins are GitHub logins, which anyone may register on GitLab.
+	perms.alwaysAllowed = []string{"root"}
+	if perms.isAllowed("foo/bar", "root") || perms.isAdmin("foo/bar", "root") {
+		t.Error("Expected a GitHub admin's login not to count on GitLab")
+	}
+
+	// Nor is the configuration file read from the namesake GitHub repository.
+	hd := newHandler([]string{"root"}, "bot", "", false)
+	hd.settings.defaults.Forge = forgeGitLab
+	if hd.loadRepoConfig(c) || len(notes) != 2 {
+		t.Errorf("Expected the repository configuration to be refused, got notes %q", notes)
+	}
+}
+
+func TestGitLabMergeRequestEvents(t *testing.T) {
+	tests := []struct {
+		action, oldrev string
+		want           string
+	}{
+		{"open", "", "opened"},
+		{"update", "", ""},
+		{"update", "abc", "synchronize"},
+		{"merge", "", "closed"},
+		{"approved", "", ""},
+	}
+	for _, test := range tests {
+		body := `{"object_kind": "merge_request", "user": {"username": "carol"}, "project": {"path_with_namespace": "foo/bar"},
+			"object_attributes": {"iid": 3, "action": "` + test.action + `", "oldrev": "` + test.oldrev + `", "target_branch": "main", "last_commit": {"id": "abc"}}}`
+		event, bs, err := gitlabForge{}.translate("Merge Request Hook", []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.want == "" {
+			if event != "" {
+				t.Errorf("%s: expected no event, got %s", test.action, event)
+			}
+			continue
+		}
+		var p pr
+		json.Unmarshal(bs, &p)
+		if event != "pull_request" || p.Action != test.want || p.Forge != forgeGitLab || p.headSHA() != "abc" || p.PullRequest.Base.Ref != "main" || p.Repository.FullName != "foo/bar" {
+			t.Errorf("%s: unexpected %s event %+v", test.action, event, p)
+		}
+	}
+}
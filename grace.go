@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// graceFor returns how long a failed status of the context may take to
+// recover, as some CI systems fail briefly before retrying on their own.
+func graceFor(grace map[string]duration, context string) time.Duration {
+	if d, ok := grace[context]; ok {
+		return d.Duration
+	}
+	return grace["*"].Duration
+}
+
+// inGrace returns the statuses with failures still within the grace period
+// of their context turned pending, and those contexts. The grace period
+// runs from when the status failed, so statuses that don't say get none.
+func inGrace(grace map[string]duration, ss []status, now time.Time) ([]status, []string) {
+	var res []status
+	var recovering []string
+	for _, st := range ss {
+		limit := graceFor(grace, st.Context)
+		failed := st.State == stateFailure || st.State == stateError
+		if failed && limit > 0 && !st.UpdatedAt.IsZero() && now.Sub(st.UpdatedAt) < limit {
+			st.State = statePending
+			recovering = append(recovering, st.Context)
+		}
+		res = append(res, st)
+	}
+	return res, recovering
+}
+
+// graceNotes notes how the contexts that were waited on to recover ended
+// up, given their latest statuses.
+func graceNotes(grace map[string]duration, waited map[string]bool, ss []status) []string {
+	var notes []string
+	for _, st := range ss {
+		if !waited[st.Context] {
+			continue
+		}
+		switch st.State {
+		case stateSuccess:
+			notes = append(notes, fmt.Sprintf("`%s` failed, then recovered within its grace period of %v.", st.Context, graceFor(grace, st.Context)))
+		case stateFailure, stateError:
+			notes = append(notes, fmt.Sprintf("`%s` failed and didn't recover within its grace period of %v.", st.Context, graceFor(grace, st.Context)))
+		}
+	}
+	sort.Strings(notes)
+	return notes
+}
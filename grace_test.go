@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInGrace(t *testing.T) {
+	now := time.Now()
+	grace := map[string]duration{"*": {5 * time.Minute}, "slow": {time.Hour}, "strict": {0}}
+	ss := []status{
+		{State: stateFailure, Context: "ci", UpdatedAt: now.Add(-time.Minute)},
+		{State: stateError, Context: "lint", UpdatedAt: now.Add(-10 * time.Minute)},
+		{State: stateFailure, Context: "slow", UpdatedAt: now.Add(-10 * time.Minute)},
+		{State: stateFailure, Context: "strict", UpdatedAt: now},
+		{State: stateFailure, Context: "undated"},
+		{State: stateSuccess, Context: "done", UpdatedAt: now},
+	}
+	res, recovering := inGrace(grace, ss, now)
+	var states []prState
+	for _, st := range res {
+		states = append(states, st.State)
+	}
+	if want := []prState{statePending, stateError, statePending, stateFailure, stateFailure, stateSuccess}; !reflect.DeepEqual(states, want) {
+		t.Errorf("Got states %v, want %v", states, want)
+	}
+	if !reflect.DeepEqual(recovering, []string{"ci", "slow"}) {
+		t.Errorf("Unexpected recovering contexts %q", recovering)
+	}
+	if ss[0].State != stateFailure {
+		t.Error("Expected the statuses given not to change")
+	}
+
+	notes := graceNotes(grace, map[string]bool{"ci": true, "lint": true, "slow": true}, []status{
+		{State: stateSuccess, Context: "ci"},
+		{State: stateError, Context: "lint"},
+		{State: statePending, Context: "slow"},
+	})
+	want := []string{"`ci` failed, then recovered within its grace period of 5m0s.", "`lint` failed and didn't recover within its grace period of 5m0s."}
+	if !reflect.DeepEqual(notes, want) {
+		t.Errorf("Got notes %q, want %q", notes, want)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+const greetedStateName = "greeted.json"
+
+const defaultGreeting = `Welcome, @{{.Author}}, and thanks for your first pull request to {{.Repo}}! :wave:
+
+Once it has been reviewed, a maintainer will merge it by commenting ` + "`@{{.Bot}} merge`" + `. The change is squashed into a single commit using the first commit message or a message given in the merge comment.
+{{- if .Checks}}
+
+The following checks need to pass before merging: {{.Checks}}.
+{{- end}}`
+
+// greetedStore keeps who has been greeted on each repository.
+type greetedStore struct {
+	mut    sync.Mutex
+	logins map[string]stringset // repo -> logins
+}
+
+func loadGreetedStore() (*greetedStore, error) {
+	s := &greetedStore{logins: make(map[string]stringset)}
+	if err := loadState(greetedStateName, &s.logins); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// claim records the author as greeted on the repository, returning false
+// if they already were.
+func (s *greetedStore) claim(repo, login string) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for _, l := range s.logins[repo] {
+		if l == login {
+			return false
+		}
+	}
+	s.logins[repo] = s.logins[repo].add(login)
+	if err := saveState(greetedStateName, s.logins); err != nil {
+		log.Println("Saving greeted users:", err)
+	}
+	return true
+}
+
+// greetNewcomer welcomes authors opening their first PR on the repository,
+// remembering who has been greeted so that nobody is greeted twice.
+func (h *handler) greetNewcomer(p pr) {
+	rs := h.settings.forRepo(p.Repository.FullName)
+	if rs.Greet == nil || !*rs.Greet {
+		return
+	}
+	switch p.PullRequest.AuthorAssociation {
+	case "FIRST_TIME_CONTRIBUTOR", "FIRST_TIMER", "NONE":
+	default:
+		return
+	}
+
+	author := p.PullRequest.User.Login
+	text, err := greetingText(rs.Greeting, author, p.Repository.FullName, h.username, rs.RequiredChecks)
+	if err != nil {
+		log.Printf("Greeting for %s: %v", p.Repository.FullName, err)
+		return
+	}
+	if !h.greeted.claim(p.Repository.FullName, author) {
+		return
+	}
+
+	var c comment
+	c.Repository.FullName = p.Repository.FullName
+	c.Issue.Number = p.Number
+	c.Issue.User.Login = author
+	c.Issue.CommentsURL = fmt.Sprintf("%s/repos/%s/issues/%d/comments", githubAPI, p.Repository.FullName, p.Number)
+	c.post(greetingResponse(c, text), h.username, h.token)
+}
+
+// greetingText renders the greeting template, or the default one if none
+// is set.
+func greetingText(text, author, repo, bot string, checks []string) (string, error) {
+	if text == "" {
+		text = defaultGreeting
+	}
+	tmpl, err := template.New("greeting").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, map[string]string{
+		"Author": author,
+		"Repo":   repo,
+		"Bot":    bot,
+		"Checks": strings.Join(quoted(checks), ", "),
+	})
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// quoted returns the strings as markdown code.
+func quoted(ss []string) []string {
+	var res []string
+	for _, s := range ss {
+		res = append(res, "`"+s+"`")
+	}
+	return res
+}
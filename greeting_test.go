@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestGreetingText(t *testing.T) {
+	cases := []struct {
+		text   string
+		checks []string
+		want   string
+	}{
+		{"Hi @{{.Author}}, welcome to {{.Repo}}. Say `@{{.Bot}} merge` when reviewed.", nil, "Hi @carol, welcome to foo/bar. Say `@bot merge` when reviewed."},
+		{"{{if .Checks}}Needs {{.Checks}}.{{else}}No checks.{{end}}", []string{"ci", "lint"}, "Needs `ci`, `lint`."},
+		{"{{if .Checks}}Needs {{.Checks}}.{{else}}No checks.{{end}}", nil, "No checks."},
+	}
+	for _, tc := range cases {
+		if got, err := greetingText(tc.text, "carol", "foo/bar", "bot", tc.checks); err != nil || got != tc.want {
+			t.Errorf("greetingText(%q) = %q, %v, expected %q", tc.text, got, err, tc.want)
+		}
+	}
+
+	got, err := greetingText("", "carol", "foo/bar", "bot", []string{"ci"})
+	if want := "Welcome, @carol, and thanks for your first pull request to foo/bar! :wave:\n\nOnce it has been reviewed, a maintainer will merge it by commenting `@bot merge`. The change is squashed into a single commit using the first commit message or a message given in the merge comment.\n\nThe following checks need to pass before merging: `ci`."; err != nil || got != want {
+		t.Errorf("Default greeting %q, %v, expected %q", got, err, want)
+	}
+	if _, err := greetingText("{{.Author", "carol", "foo/bar", "bot", nil); err == nil {
+		t.Error("Expected an error for a broken template")
+	}
+}
+
+func TestGreetNewcomer(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+
+	var mut sync.Mutex
+	greetings := make(map[string]int) // comments URL -> greetings
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		greetings[r.URL.Path]++
+		mut.Unlock()
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	greet := true
+	h := newHandler(nil, "bot", "token", false)
+	h.settings.defaults.Greet = &greet
+	open := func(repo string, number int, author, association string) pr {
+		var p pr
+		p.Repository.FullName = repo
+		p.Number = number
+		p.PullRequest.User.Login = author
+		p.PullRequest.AuthorAssociation = association
+		return p
+	}
+
+	// Opened at once in different repositories, and twice in one.
+	var wg sync.WaitGroup
+	for _, p := range []pr{
+		open("foo/bar", 1, "carol", "FIRST_TIME_CONTRIBUTOR"),
+		open("foo/bar", 2, "carol", "FIRST_TIME_CONTRIBUTOR"),
+		open("foo/baz", 1, "carol", "FIRST_TIME_CONTRIBUTOR"),
+		open("foo/qux", 1, "dave", "NONE"),
+		open("foo/qux", 2, "erin", "MEMBER"),
+	} {
+		wg.Add(1)
+		go func(p pr) {
+			defer wg.Done()
+			h.greetNewcomer(p)
+		}(p)
+	}
+	wg.Wait()
+
+	bar := greetings["/repos/foo/bar/issues/1/comments"] + greetings["/repos/foo/bar/issues/2/comments"]
+	if bar != 1 || greetings["/repos/foo/baz/issues/1/comments"] != 1 || greetings["/repos/foo/qux/issues/1/comments"] != 1 || greetings["/repos/foo/qux/issues/2/comments"] != 0 {
+		t.Errorf("Expected one greeting per newcomer and repository, got %v", greetings)
+	}
+
+	// Nor again after a restart.
+	loaded, err := loadGreetedStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.greeted = loaded
+	h.greetNewcomer(open("foo/baz", 3, "carol", "FIRST_TIME_CONTRIBUTOR"))
+	if n := greetings["/repos/foo/baz/issues/3/comments"]; n != 0 {
+		t.Errorf("Greeted again after restarting")
+	}
+
+	// Nothing is posted in silent mode.
+	defer func(f func(string) string) { verbosityOf = f }(verbosityOf)
+	verbosityOf = func(string) string { return verbositySilent }
+	h.greetNewcomer(open("foo/bar", 4, "frank", "NONE"))
+	if n := greetings["/repos/foo/bar/issues/4/comments"]; n != 0 {
+		t.Errorf("Greeted in silent mode")
+	}
+}
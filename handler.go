@@ -2,9 +2,11 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -12,6 +14,7 @@ import (
 	"time"
 )
 
+// The default wait and poll limits, unless configured otherwise.
 const (
 	maxWaitTime = 30 * time.Minute
 	maxPollTime = 64 * time.Second
@@ -19,79 +22,127 @@ const (
 
 // The handler receives commands from the webhook
 type handler struct {
-	token       string
-	username    string
-	allowed     []string
-	teamAllowed []string
-	stop        chan struct{}
-	pending     map[int]struct{}
-	lgtm        map[int]stringset
-	mut         sync.Mutex
-	branches    bool
+	token        string
+	username     string
+	allowed      []string
+	teamAllowed  []string
+	stop         chan struct{}
+	pending      map[string]struct{}  // "owner/name#number" of PRs waiting to merge
+	lgtm         map[string]stringset // "owner/name#number" -> who said LGTM
+	mut          sync.Mutex           // guards the maps, but isn't held for long
+	repos        repoLocks            // held while working on a repository
+	groups       repoLocks            // held while landing a merge in a concurrency group
+	branches     bool
+	staleness    staleness
+	settings     *settings
+	teams        map[string][]string // team -> members, for reporting
+	hookURL      string              // public URL of the webhook, for onboarding
+	attestor     *attestor           // signs merge provenance, if set
+	audit        *auditor            // exports security relevant events, if set
+	features     *featureFlags
+	trains       map[string]*train // "owner/name:branch" -> train
+	trainStats   *trainStats
+	flakes       *flakeTracker
+	app          *githubApp      // authenticates as a GitHub App installation, if set
+	creds        *gitCredentials // gives git the token over HTTPS, if set
+	ci           ciWatchers      // waiting merges to wake up on CI events
+	maintenance  *maintenance
+	pendingStore *pendingStore  // pending merges, kept across restarts
+	diskQuota    byteSize       // for all clones together; unlimited if zero
+	conflicts    conflictCache  // conflicts statuses last set
+	prefs        *prefStore     // of users, set with config commands
+	greeted      *greetedStore  // first time contributors welcomed
+	links        *linkStore     // codes for linking chat handles to logins
+	tracked      *trackingStore // issues tracking failed merges
+	chat         *chatNotifier  // sends direct messages, if set
+	ciTimes      *ciDurations   // how long CI takes, for estimates
 	permissions
 }
 
 func newHandler(allowed []string, username, token string, branches bool) *handler {
-	return &handler{
-		username: username,
-		token:    token,
-		allowed:  allowed,
-		stop:     make(chan struct{}),
-		pending:  make(map[int]struct{}),
-		lgtm:     make(map[int]stringset),
-		branches: branches,
+	h := &handler{
+		username:     username,
+		token:        token,
+		allowed:      allowed,
+		stop:         make(chan struct{}),
+		pending:      make(map[string]struct{}),
+		pendingStore: &pendingStore{merges: make(map[string]pendingMerge)},
+		lgtm:         make(map[string]stringset),
+		trains:       make(map[string]*train),
+		trainStats:   &trainStats{repos: make(map[string]*ejectionStats)},
+		flakes:       &flakeTracker{retries: make(map[string]int), history: make(map[string]map[string]*flakeHistory)},
+		branches:     branches,
+		features:     &featureFlags{overrides: make(map[string]map[string]bool)},
+		prefs:        &prefStore{Users: make(map[string]userPrefs), Digests: make(map[string][]string)},
+		greeted:      &greetedStore{logins: make(map[string]stringset)},
+		links:        &linkStore{Pending: make(map[string]pendingLink)},
+		tracked:      &trackingStore{Issues: make(map[string]int)},
+		ciTimes:      &ciDurations{Repos: make(map[string][]duration)},
+		settings: &settings{
+			defaults: repoSettings{MaxWait: duration{maxWaitTime}, MaxPoll: duration{maxPollTime}},
+		},
 		permissions: permissions{
 			token:         token,
 			alwaysAllowed: allowed,
 			teamMembers:   make(map[string][]string),
 		},
 	}
+	h.permissions.forgeOf = func(repo string) string { return h.settings.forRepo(repo).Forge }
+	return h
 }
 
 func (h *handler) handlePullReq(p pr) {
-	h.mut.Lock()
-	defer h.mut.Unlock()
+	if !h.botEnabled(p.Repository.FullName) {
+		return
+	}
+
+	defer h.lockRepo(p.Repository.FullName)()
 
 	if _, err := os.Stat(filepath.Join(p.Repository.FullName, ".git")); err != nil {
-		if err := clone(p.Repository.FullName); err != nil {
+		if err := h.clone(p.Repository.FullName); err != nil {
 			log.Println(err)
 			return
 		}
 	}
-
-	cur, err := os.Getwd()
-	if err != nil {
-		log.Println("No working dir?")
-		return
-	}
-
-	os.Chdir(p.Repository.FullName)
+	h.refreshRemote(p.Repository.FullName)
 
 	switch p.Action {
 	case "synchronize", "opened", "reopened":
-		if h.branches {
-			updatePRBranch(p.Number)
-		}
 		p.setStatus(stateSuccess, "st-review", "At your service.", h.username, h.token)
+		if !onGitHub(p.Forge) {
+			break // the rest works with GitHub only
+		}
+		repo := p.Repository.FullName
+		if h.branches && !h.observing(repo) {
+			updatePRBranch(repo, p.Number)
+		}
+		if h.featureEnabled(repo, "labels", true) {
+			h.labelPR(p)
+		}
+		if p.Action == "opened" {
+			if h.featureEnabled(repo, "reviewers", true) {
+				h.suggestReviewers(p)
+			}
+			if h.featureEnabled(repo, "greeting", true) {
+				h.greetNewcomer(p)
+			}
+		}
+		if h.featureEnabled(repo, "conflicts", true) {
+			// Once we're done with the clone.
+			go h.checkConflicts(repo, p.PullRequest.Base.Ref, []pr{p})
+		}
 	case "closed":
-		if h.branches {
-			deletePRBranch(p.Number)
+		if h.branches && onGitHub(p.Forge) && !h.observing(p.Repository.FullName) {
+			deletePRBranch(p.Repository.FullName, p.Number)
 		}
 		p.setStatus(stateSuccess, "st-review", "Closed.", h.username, h.token)
+		h.leaveTrain(p.Repository.FullName, p.Number)
+		h.closeTracking(p.Repository.FullName, p.Number, fmt.Sprintf("#%d was closed.", p.Number))
 	}
-
-	os.Chdir(cur)
 }
 
 func (h *handler) handleStop(c comment) {
-	h.mut.Lock()
-	defer h.mut.Unlock()
-
-	if !h.isAllowed(c.Repository.FullName, c.Sender.Login) {
-		c.post(noAccessResponse(c), h.username, h.token)
-		log.Println("Rejecting request by unknown user", c.Sender.Login)
-		return
-	}
+	defer h.lockRepo(c.Repository.FullName)()
 
 	pr, err := c.getPR()
 	if err != nil {
@@ -100,61 +151,111 @@ func (h *handler) handleStop(c comment) {
 	}
 
 	pr.setStatus(stateFailure, "st-review", "Not to be merged as is.", h.username, h.token)
+	h.leaveTrain(c.Repository.FullName, c.Issue.Number)
 	c.post(notMergingResponse(c), h.username, h.token)
 }
 
 func (h *handler) handleMerge(c comment) {
-	h.mut.Lock()
-	defer h.mut.Unlock()
+	defer h.lockRepo(c.Repository.FullName)()
 
-	if !h.isAllowed(c.Repository.FullName, c.Sender.Login) {
-		c.post(noAccessResponse(c), h.username, h.token)
-		log.Println("Rejecting request by unknown user", c.Sender.Login)
+	if delegate := c.onBehalfOf(); delegate != "" && !h.isAllowed(c.Repository.FullName, delegate) {
+		c.post(delegateNoAccessResponse(c, delegate), h.username, h.token)
+		h.auditDenied(c, "merge on behalf of "+delegate)
+		log.Println("Rejecting request on behalf of unknown user", delegate)
 		return
 	}
 
-	if _, ok := h.pending[c.Issue.Number]; ok {
+	if h.isPending(c) {
 		c.post(alreadyPendingResponse(c), h.username, h.token)
 		log.Println("Rejecting request for already pending PR")
 		return
 	}
 
+	if wait, clamped, err := h.waitTime(c); err != nil {
+		c.post(badOptionResponse(c, err.Error()), h.username, h.token)
+		return
+	} else if clamped {
+		c.post(waitClampedResponse(c, wait), h.username, h.token)
+	}
+	if _, err := h.pollTime(c); err != nil {
+		c.post(badOptionResponse(c, err.Error()), h.username, h.token)
+		return
+	}
+	if _, err := h.messageMode(c); err != nil {
+		c.post(badOptionResponse(c, err.Error()), h.username, h.token)
+		return
+	}
+
 	pr, err := c.getPR()
 	if err != nil {
 		log.Println("No pull request:", err)
 		return
 	}
 
+	if !h.baseAllowed(c.Repository.FullName, pr.Base.Ref) {
+		c.post(baseNotAllowedResponse(c, pr.Base.Ref), h.username, h.token)
+		return
+	}
+
+	gate := h.settings.forRepo(c.Repository.FullName).ChangeGate
+	if gate.appliesTo(pr.Base.Ref) && c.changeTicket() == "" {
+		c.post(changeRequiredResponse(c, pr.Base.Ref), h.username, h.token)
+		return
+	}
+
+	// Trains squash, so other strategies go on their own, except past a
+	// queue, which every merge waits its turn in.
+	if h.trainEnabled(c.Repository.FullName) {
+		switch strategy := h.mergeStrategy(c); {
+		case strategy == strategySquash:
+			h.enqueueTrain(c, pr)
+			return
+		case h.queueEnabled(c.Repository.FullName):
+			c.post(queueStrategyResponse(c, strategy), h.username, h.token)
+			return
+		}
+	}
+
 	skip := fieldValues(c.Comment.Body, "Skip-Check")
-	status := overallStatus(pr.getStatuses(h.username, h.token), skip)
+	status, notes := h.checkStatus(c.Repository.FullName, pr, skip)
+
+	if status == stateSuccess {
+		if missing, approvalNotes := h.missingApprovals(c, pr); missing > 0 {
+			c.post(waitingApprovalResponse(c, missing, approvalNotes), h.username, h.token)
+			h.mergeStatus(c, pr, statePending, "Waiting for approvals to merge.")
+			h.waitAndMerge(c, pr)
+			return
+		}
+	}
 
 	switch status {
 	case stateSuccess:
-		h.performMerge(c, pr)
+		h.mergeNow(c, pr, notes)
 
 	case statePending:
-		c.post(waitingResponse(c), h.username, h.token)
-		h.pending[c.Issue.Number] = struct{}{}
-		go h.delayedMerge(c, pr)
+		eta := h.ciETA(c.Repository.FullName, h.getStatuses(c.Repository.FullName, pr), time.Now())
+		c.post(waitingResponse(c, eta, h.driftNotes(c.Repository.FullName, pr)), h.username, h.token)
+		h.mergeStatus(c, pr, statePending, "Waiting for checks to merge.")
+		h.waitAndMerge(c, pr)
 
 	default:
-		c.post(badBuildResponse(c, status), h.username, h.token)
+		c.post(badBuildResponse(c, status, notes), h.username, h.token)
 	}
 }
 
 func (h *handler) handleLGTM(c comment) {
-	h.mut.Lock()
-	defer h.mut.Unlock()
-
-	if !h.isAllowed(c.Repository.FullName, c.Sender.Login) {
-		c.post(noAccessResponse(c), h.username, h.token)
-		log.Println("Rejecting request by unknown user", c.Sender.Login)
-		return
-	}
+	defer h.lockRepo(c.Repository.FullName)()
 
-	h.lgtm[c.Issue.Number] = h.lgtm[c.Issue.Number].add(c.Sender.Login)
-	if len(h.lgtm[c.Issue.Number]) >= 2 {
-		defer func() { delete(h.lgtm, c.Issue.Number) }()
+	h.mut.Lock()
+	h.lgtm[pendingKey(c)] = h.lgtm[pendingKey(c)].add(c.Sender.Login)
+	approvals := len(h.lgtm[pendingKey(c)])
+	h.mut.Unlock()
+	if approvals >= 2 {
+		defer func() {
+			h.mut.Lock()
+			delete(h.lgtm, pendingKey(c))
+			h.mut.Unlock()
+		}()
 
 		pr, err := c.getPR()
 		if err != nil {
@@ -163,181 +264,901 @@ func (h *handler) handleLGTM(c comment) {
 		}
 
 		skip := fieldValues(c.Comment.Body, "Skip-Check")
-		status := overallStatus(pr.getStatuses(h.username, h.token), skip)
+		status, notes := h.checkStatus(c.Repository.FullName, pr, skip)
 
 		switch status {
 		case stateSuccess:
-			h.performMerge(c, pr)
+			h.mergeNow(c, pr, notes)
 
 		case statePending:
-			c.post(waitingResponse(c), h.username, h.token)
-			h.pending[c.Issue.Number] = struct{}{}
-			go h.delayedMerge(c, pr)
+			eta := h.ciETA(c.Repository.FullName, h.getStatuses(c.Repository.FullName, pr), time.Now())
+			c.post(waitingResponse(c, eta, h.driftNotes(c.Repository.FullName, pr)), h.username, h.token)
+			h.mergeStatus(c, pr, statePending, "Waiting for checks to merge.")
+			h.waitAndMerge(c, pr)
 
 		default:
-			c.post(badBuildResponse(c, status), h.username, h.token)
+			c.post(badBuildResponse(c, status, notes), h.username, h.token)
 		}
 	} else {
 		c.post(lgtmResponse(c), h.username, h.token)
 	}
 }
 
-func (h *handler) delayedMerge(c comment, pr pr) {
-	defer func() {
-		h.mut.Lock()
-		delete(h.pending, c.Issue.Number)
-		h.mut.Unlock()
-	}()
+// mergeNow merges the PR whose checks have passed, with the repository
+// locked. Merges in a concurrency group may have to queue, which isn't done
+// holding up the events for the repository, so they go the way of merges
+// waiting for their checks instead.
+func (h *handler) mergeNow(c comment, pr pr, notes []string) {
+	if h.settings.forRepo(c.Repository.FullName).ConcurrencyGroup != "" {
+		h.waitAndMerge(c, pr)
+		return
+	}
+	h.performMerge(c, pr, notes)
+}
+
+// delayedMerge waits for the PR to become mergeable, since t0, and merges
+// it.
+func (h *handler) delayedMerge(c comment, pr pr, t0 time.Time) {
+	defer h.unmarkPending(c)
 
-	t0 := time.Now()
 	wait := time.Second
+	maxWait, _, _ := h.waitTime(c)
+	maxPoll, _ := h.pollTime(c)
+	rs := h.settings.forRepo(c.Repository.FullName)
+
+	// While the statuses keep changing CI is making progress, and the
+	// deadline is pushed forward up to the hard cap.
+	hardCap := rs.MaxWaitCap.Duration
+	if hardCap < maxWait {
+		hardCap = maxWait
+	}
+	deadline := t0.Add(maxWait)
+	lastSeen := ""
+	var approvalNotes []string
+	missingApprovals := 0                   // with the checks green, last time they were
+	gracedContexts := make(map[string]bool) // contexts given time to recover from failing
+	finished := make(map[string]bool)       // contexts seen done, to tell restarts
+	var ciStart time.Time                   // when CI started on the head, for estimates
+	ciTimed := false
 
 	skip := fieldValues(c.Comment.Body, "Skip-Check")
 
-	for time.Since(t0) < maxWaitTime {
-		status := overallStatus(pr.getStatuses(h.username, h.token), skip)
+	events, stop := h.ci.watch(c.Repository.FullName, pr.Head.SHA)
+	defer stop()
+
+	for time.Now().Before(deadline) {
+		if !h.botEnabled(c.Repository.FullName) {
+			logInfo("Abandoning merge as the bot was disabled", commentFields(c, "merge"))
+			return
+		}
+		if h.maintenance.active() {
+			// Merges wait out maintenance like the queued commands.
+			time.Sleep(wait)
+			continue
+		}
+
+		statuses := h.getStatuses(c.Repository.FullName, pr)
+		restarted := false
+		for _, s := range statuses {
+			restarted = restarted || s.State == statePending && finished[s.Context]
+			finished[s.Context] = s.State != statePending
+		}
+		if restarted || ciStart.IsZero() {
+			ciStart = ciStarted(statuses)
+		}
+		if restarted {
+			ciTimed = false
+			if eta := h.ciETA(c.Repository.FullName, statuses, time.Now()); eta != "" {
+				c.post(checksRestartedResponse(c, eta), h.username, h.token)
+			}
+		}
+		graced, recovering := inGrace(rs.FailureGrace, statuses, time.Now())
+		for _, context := range recovering {
+			gracedContexts[context] = true
+		}
+		status, notes := h.statusWithRetries(c.Repository.FullName, pr, graced, skip)
+		if status != statePending {
+			notes = append(notes, graceNotes(rs.FailureGrace, gracedContexts, statuses)...)
+		}
+
+		if status == stateSuccess && !ciTimed && !ciStart.IsZero() && h.ciTimes != nil {
+			h.ciTimes.add(c.Repository.FullName, time.Since(ciStart))
+			ciTimed = true
+		}
+		missingApprovals = 0
+		if status == stateSuccess {
+			if missingApprovals, approvalNotes = h.missingApprovals(c, pr); missingApprovals > 0 {
+				status = statePending
+			}
+		}
 
 		switch status {
 		case stateSuccess:
-			h.performMerge(c, pr)
+			h.landMerge(c, pr, notes)
 			return
 		case stateError, stateFailure:
-			c.post(badBuildResponse(c, status), h.username, h.token)
+			c.post(badBuildResponse(c, status, notes), h.username, h.token)
+			h.mergeStatus(c, pr, stateFailure, "Not merged; checks failed.")
 			return
 		}
 
-		time.Sleep(wait)
-		if wait < maxPollTime {
+		if seen := statusFingerprint(statuses); seen != lastSeen {
+			if lastSeen != "" {
+				deadline = extendDeadline(deadline, time.Now().Add(maxWait), t0.Add(hardCap))
+				h.pendingStore.extend(c, deadline)
+			}
+			lastSeen = seen
+		}
+
+		if waitForCI(events, wait) {
+			// Events are coming through, so polling is just the
+			// fallback for any that get lost.
+			wait = maxPoll
+		} else if wait < maxPoll {
 			wait *= 2
 		}
 	}
 
-	c.post(timeoutResponse(c, maxWaitTime), h.username, h.token)
+	waited := time.Since(t0).Truncate(time.Second)
+	if missingApprovals > 0 {
+		c.post(withNotes(approvalTimeoutResponse(c, waited, missingApprovals), approvalNotes), h.username, h.token)
+	} else {
+		c.post(withNotes(timeoutResponse(c, waited), approvalNotes), h.username, h.token)
+	}
+	h.mergeStatus(c, pr, stateFailure, "Not merged; gave up waiting.")
+}
+
+// landMerge merges the PR whose checks passed while waiting, once any merge
+// in its concurrency group is done, re-checking what may have changed
+// meanwhile right before merging.
+func (h *handler) landMerge(c comment, pr pr, notes []string) {
+	defer h.lockGroup(c.Repository.FullName, func(group string) {
+		logInfo("Queued behind another merge in concurrency group "+group, commentFields(c, "merge"))
+		c.post(concurrencyQueuedResponse(c, group), h.username, h.token)
+	})()
+
+	// What was checked when the merge was asked for may have changed while
+	// waiting.
+	cur, err := c.getPR()
+	if err != nil {
+		c.post(recheckFailedResponse(c), h.username, h.token)
+		h.mergeStatus(c, pr, stateFailure, "Not merged; couldn't check the PR again.")
+		logWarn("Abandoning merge as the PR couldn't be fetched: "+err.Error(), commentFields(c, "merge"))
+		return
+	}
+	if why := h.recheckMerge(c, pr, cur); why != "" {
+		c.post(mergeRecheckResponse(c, why), h.username, h.token)
+		h.auditDenied(c, "delayed merge: "+why)
+		h.mergeStatus(c, pr, stateFailure, "Not merged; "+why+".")
+		logWarn("Abandoning merge as "+why, commentFields(c, "merge"))
+		return
+	}
+	// What's merged is what was asked for and checked, not what was pushed
+	// since.
+	if cur.headSHA() != "" && pr.headSHA() != "" && cur.headSHA() != pr.headSHA() {
+		c.post(headMovedResponse(c, pr.headSHA(), cur.headSHA()), h.username, h.token)
+		h.mergeStatus(c, pr, stateFailure, "Not merged; the head moved.")
+		return
+	}
+	defer h.lockRepo(c.Repository.FullName)()
+	h.performMerge(c, pr, notes)
+}
+
+// recheckMerge returns why the delayed merge of the PR, now as cur,
+// shouldn't go ahead after all, or nothing if it should. Whoever asked,
+// and whoever they asked for, must still be allowed to merge, and the PR
+// must still be open and against the same base branch.
+func (h *handler) recheckMerge(c comment, pr, cur pr) string {
+	repo := c.Repository.FullName
+	switch delegate := c.onBehalfOf(); {
+	case !h.isAllowed(repo, c.Sender.Login):
+		return fmt.Sprintf("%s is no longer allowed to merge", c.Sender.Login)
+	case delegate != "" && !h.isAllowed(repo, delegate):
+		return fmt.Sprintf("%s is no longer allowed to merge", delegate)
+	case cur.State != "" && cur.State != "open":
+		return "the PR was closed"
+	case cur.Base.Ref != "" && pr.Base.Ref != "" && cur.Base.Ref != pr.Base.Ref:
+		return fmt.Sprintf("the PR was retargeted from %s to %s", pr.Base.Ref, cur.Base.Ref)
+	}
+	return ""
+}
+
+// extendDeadline returns the later of the current and wanted deadlines,
+// though never later than the hard cap.
+func extendDeadline(current, wanted, hardCap time.Time) time.Time {
+	if wanted.After(hardCap) {
+		wanted = hardCap
+	}
+	if wanted.After(current) {
+		return wanted
+	}
+	return current
+}
+
+// mergeStatusContext is the context merge requests are reported under in
+// silent mode. It's our own, so it never holds up merges.
+const mergeStatusContext = "st-review/merge"
+
+// mergeStatus reports how a merge request is doing as a status on the PR,
+// for repositories with silent responses where nothing else would say so.
+// As a GitHub App, it's a check run quoting the command instead; either
+// links to the comment with the command.
+func (h *handler) mergeStatus(c comment, p pr, state prState, description string) {
+	if state != statePending {
+		h.notifyOutcome(c, p, description)
+	}
+	if h.settings.forRepo(c.Repository.FullName).Verbosity != verbositySilent {
+		return
+	}
+	if h.app != nil {
+		summary := strings.TrimSpace(c.anchor()) + "\n\n" + description
+		err := p.createCheckRun(c.Repository.FullName, mergeStatusContext, state, description, summary, c.Comment.HTMLURL, h.username, h.token)
+		if err == nil {
+			return
+		}
+		log.Println("Check run:", err)
+	}
+	p.setStatusLink(state, mergeStatusContext, description, c.Comment.HTMLURL, h.username, h.token)
+}
+
+// isAllowed returns whether the user may merge in the repository, being
+// among the configured allowed users or a collaborator.
+func (h *handler) isAllowed(repo, login string) bool {
+	for _, user := range h.settings.forRepo(repo).AllowedUsers {
+		if login == user {
+			return true
+		}
+	}
+	return h.permissions.isAllowed(repo, login)
+}
+
+// repoConfigEnabled returns whether the repository's configuration file
+// is to be read.
+func (h *handler) repoConfigEnabled(repo string) bool {
+	rc := h.settings.forRepo(repo).RepoConfig
+	return rc != nil && *rc
+}
+
+// baseAllowed returns whether PRs against the base branch may be merged in
+// the repository; any branch may unless the repository has a list of
+// branch patterns.
+func (h *handler) baseAllowed(repo, base string) bool {
+	patterns := h.settings.forRepo(repo).BaseBranches
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Commit message modes, for messages given in merge comments.
+const (
+	messageReflow   = "reflow"   // the description is reflowed to the wrap width
+	messageVerbatim = "verbatim" // the message is taken as written
+)
+
+// messageMode returns how to treat the commit message given in the comment;
+// either what was requested using --message= or the configured mode.
+func (h *handler) messageMode(c comment) (string, error) {
+	mode := h.settings.forRepo(c.Repository.FullName).MessageMode
+	if v, ok := c.parseBody().option("message"); ok {
+		mode = strings.ToLower(v)
+	}
+	switch mode {
+	case "", messageReflow:
+		return messageReflow, nil
+	case messageVerbatim:
+		return messageVerbatim, nil
+	}
+	return messageReflow, fmt.Errorf("%q is not a message mode; use reflow or verbatim", mode)
+}
+
+// waitTime returns how long to wait for pending statuses on behalf of the
+// comment; either what was requested using --wait= or the configured limit.
+// Requests are cut down to the hard cap, or the limit if that's longer, and
+// whether one was is returned as well. The configured limit is returned
+// along with the error for an invalid requested time.
+func (h *handler) waitTime(c comment) (time.Duration, bool, error) {
+	rs := h.settings.forRepo(c.Repository.FullName)
+	limit := rs.MaxWait.Duration
+	if v, ok := c.parseBody().option("wait"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return limit, false, fmt.Errorf("%q is not a valid wait time", v)
+		}
+		most := rs.MaxWaitCap.Duration
+		if most < limit {
+			most = limit
+		}
+		if d > most {
+			return most, true, nil
+		}
+		return d, false, nil
+	}
+	return limit, false, nil
+}
+
+// pollTime returns the longest interval between polls of pending statuses
+// on behalf of the comment, either requested using --poll= or configured,
+// as waitTime does.
+func (h *handler) pollTime(c comment) (time.Duration, error) {
+	limit := h.settings.forRepo(c.Repository.FullName).MaxPoll.Duration
+	if v, ok := c.parseBody().option("poll"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			return limit, fmt.Errorf("%q is not a valid poll interval; it must be at least 1s", v)
+		}
+		return d, nil
+	}
+	return limit, nil
+}
+
+// checkStatus returns the overall status of the PR, disregarding the skipped
+// contexts, along with notes on any decisions made about stale statuses.
+// Failures within the grace period of their context count as pending.
+func (h *handler) checkStatus(repo string, pr pr, skip []string) (prState, []string) {
+	statuses, _ := inGrace(h.settings.forRepo(repo).FailureGrace, h.getStatuses(repo, pr), time.Now())
+	return h.statusWithRetries(repo, pr, statuses, skip)
+}
+
+// getStatuses returns the statuses of the PR from the status source
+// configured for the repository, which is GitHub (commit statuses along
+// with check runs) unless set otherwise, merged with the results from any configured CI systems and adjusted for
+// the contexts required by the changed paths and the deployment gate.
+func (h *handler) getStatuses(repo string, pr pr) []status {
+	rs := h.settings.forRepo(repo)
+	statuses := h.reportedStatuses(repo, pr)
+	statuses = withRequiredStatuses(statuses, rs.RequiredStatuses)
+	statuses = h.withDeploymentGate(statuses, rs.DeploymentGate, repo, pr)
+	return h.withPathRules(statuses, rs.PathContexts, pr)
+}
+
+// reportedStatuses returns the statuses the status source and CI systems
+// report for the PR, other than our own.
+func (h *handler) reportedStatuses(repo string, pr pr) []status {
+	rs := h.settings.forRepo(repo)
+	var statuses []status
+	if rs.StatusURL != "" {
+		statuses = pr.getStatusesFrom(rs.StatusURL, repo)
+	} else {
+		statuses = pr.getStatuses(repo, h.username, h.token)
+	}
+	statuses = withoutContext(statuses, mergeStatusContext)
+	return withCIStatuses(statuses, rs.CISources, repo, pr)
+}
+
+func (h *handler) evaluateStatuses(statuses []status, skip []string) (prState, []string) {
+	statuses, notes := h.staleness.apply(statuses, time.Now())
+	return overallStatus(statuses, skip), notes
 }
 
-func (h *handler) performMerge(c comment, pr pr) {
-	log.Printf("Attemping merge of PR %d on %s for %s", c.Issue.Number, c.Repository.FullName, c.Sender.Login)
+func (h *handler) performMerge(c comment, pr pr, notes []string) {
+	logInfo("Attempting merge", commentFields(c, "merge"))
+
+	prog := h.startProgress(c)
+	defer prog.stop()
+
+	// The merge as a whole gets a deadline, so that a hung git can't hold
+	// the lock forever.
+	ctx, cancel := context.Background(), func() {}
+	timeout := h.settings.forRepo(c.Repository.FullName).MergeTimeout.Duration
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
 
 	if _, err := os.Stat(filepath.Join(c.Repository.FullName, ".git")); err != nil {
-		if err := clone(c.Repository.FullName); err != nil {
+		prog.set("cloning")
+		if err := h.cloneContext(ctx, c.Repository.FullName); err != nil {
+			if ctx.Err() != nil {
+				h.mergeTimedOut(c, timeout, "cloning")
+				return
+			}
 			log.Println(err)
 			c.post(cloneFailedResponse(c, err.Error()), h.username, h.token)
 			return
 		}
 	}
+	h.refreshRemote(c.Repository.FullName)
 
-	cur, err := os.Getwd()
-	if err != nil {
-		log.Println("No working dir?")
+	prog.set("checking merge gates")
+	plan, ok := h.planMerge(c, pr)
+	if !ok {
+		return
+	}
+	if h.observing(c.Repository.FullName) {
+		h.reportObserved(c, pr, plan, notes)
 		return
 	}
 
-	overrideDescr := ""
-	body := c.parseBody()
+	if onGitHub(pr.Forge) {
+		if sha, err := h.branchHead(c.Repository.FullName, pr.Base.Ref); err != nil {
+			log.Println("Branch head:", err)
+		} else {
+			plan.baseSHA = sha
+		}
+	}
+	plan.sparse = h.sparseFor(c.Repository.FullName, pr)
 
-	if body.subject != "" {
-		overrideDescr = strings.TrimSpace(body.subject + "\n\n" + reflow(body.description, 76))
+	journal := beginJournal(c, pr, plan)
+	defer journal.step(stepDone, "")
+
+	merge := squash
+	switch plan.strategy {
+	case strategyRebase:
+		merge = rebase
+	case strategyMerge:
+		merge = mergeCommit
+	default:
+		if rs := h.settings.forRepo(c.Repository.FullName); rs.MergeAPI != nil && *rs.MergeAPI && onGitHub(pr.Forge) {
+			merge = h.squashThroughAPI
+		}
+	}
+	sha1, err := merge(ctx, c.Repository.FullName, pr, plan, prog, journal)
+	if err != nil && ctx.Err() != nil {
+		resetWorktree(c.Repository.FullName)
+		h.mergeTimedOut(c, timeout, prog.current())
+		return
+	}
+	if err == nil {
+		prog.set("updating the version")
+		note, verr := h.versionAfterMerge(c.Repository.FullName, pr.Base.Ref, plan.bump)
+		if verr != nil {
+			note = "Versioning failed: " + verr.Error()
+			log.Printf("Versioning after merge of PR %d on %s: %v", c.Issue.Number, c.Repository.FullName, verr)
+		}
+		if note != "" {
+			notes = append(notes, note)
+		}
 	}
 
-	user, err := c.user(h.username, h.token)
-	if err != nil || user.Email == "" {
-		c.post(noUserResponse(c), h.username, h.token)
-		log.Printf("Failed merge of PR %d on %s for %s: no user info (%v)", c.Issue.Number, c.Repository.FullName, c.Sender.Login, err)
+	if moved, ok := err.(*headMovedError); ok {
+		c.post(headMovedResponse(c, moved.want, moved.got), h.username, h.token)
+		h.mergeStatus(c, pr, stateFailure, "Not merged; the head moved.")
+		logWarn("Abandoning merge as the head moved", commentFields(c, "merge"))
+		return
+	}
+	if empty, ok := err.(*emptyMergeError); ok {
+		c.post(nothingToMergeResponse(c, empty.reason), h.username, h.token)
+		logInfo("Nothing to merge: "+empty.reason, commentFields(c, "merge"))
+		return
+	}
+	if err != nil {
+		c.post(errorResponse(c, err.Error()), h.username, h.token)
+		h.mergeStatus(c, pr, stateError, "Merge failed.")
+		f := commentFields(c, "merge")
+		f.Err = err
+		logError("Failed merge", f)
+		h.trackFailure(c, pr, "git couldn't merge it", quoteOutput(err.Error()))
+		h.audit.record(auditEvent{Kind: "failed", Repo: c.Repository.FullName, PR: c.Issue.Number, User: c.Sender.Login, Detail: err.Error()})
 		return
 	}
 
-	os.Chdir(c.Repository.FullName)
-	sha1, err := squash(pr, user, overrideDescr, h.lgtm[c.Issue.Number])
-	os.Chdir(cur)
+	h.completeMerge(c, pr, sha1, plan, notes)
+}
+
+// A mergePlan is what goes into the squashed commit besides the changes.
+type mergePlan struct {
+	user     user // the committer
+	msg      string
+	lgtm     []string
+	trailers []string
+	bump     string   // version bump level, if any
+	delegate string   // who the merge is on behalf of, if anyone
+	verbatim bool     // msg is to be committed as written
+	subject  string   // replaces the subject of the first commit, if set
+	baseSHA  string   // head of the base branch according to the API, if known
+	sparse   []string // sparse checkout patterns, if not checking out everything
+	strategy string   // how the PR is merged; the message is ignored when rebasing
+}
+
+// planMerge checks the gates that apply at merge time and works out the
+// commit message. When the merge may not proceed the requester has been
+// told why and false is returned.
+func (h *handler) planMerge(c comment, pr pr) (mergePlan, bool) {
+	h.mut.Lock()
+	plan := mergePlan{lgtm: h.lgtm[pendingKey(c)]}
+	h.mut.Unlock()
+	if !h.baseAllowed(c.Repository.FullName, pr.Base.Ref) {
+		c.post(baseNotAllowedResponse(c, pr.Base.Ref), h.username, h.token)
+		return plan, false
+	}
+	if h.settings.forRepo(c.Repository.FullName).Versioning != "" {
+		if plan.bump = h.prBump(c.Repository.FullName, c.Issue.Number); plan.bump != "" {
+			plan.trailers = append(plan.trailers, "Version-Bump: "+plan.bump)
+		}
+	}
+	plan.strategy = h.mergeStrategy(c)
+	plan.delegate = c.onBehalfOf()
+	if plan.delegate != "" {
+		plan.trailers = append(plan.trailers, "On-Behalf-Of: "+plan.delegate, "Merged-By: "+c.Sender.Login)
+	}
+	if err := h.checkDrift(c.Repository.FullName, pr); err != nil {
+		c.post(staleBaseResponse(c, err.Error()), h.username, h.token)
+		return plan, false
+	}
+	if gate := h.settings.forRepo(c.Repository.FullName).ChangeGate; gate.appliesTo(pr.Base.Ref) {
+		ticket := c.changeTicket()
+		if ticket == "" {
+			c.post(changeRequiredResponse(c, pr.Base.Ref), h.username, h.token)
+			return plan, false
+		}
+		if err := gate.check(ticket, c.Repository.FullName, pr.Base.Ref); err != nil {
+			c.post(changeNotApprovedResponse(c, err.Error()), h.username, h.token)
+			log.Printf("Refused merge of PR %d on %s for %s: %v", c.Issue.Number, c.Repository.FullName, c.Sender.Login, err)
+			return plan, false
+		}
+		plan.trailers = append(plan.trailers, "Change-Ticket: "+ticket)
+	}
+
+	problems, err := h.checkReviews(c, pr)
+	if err != nil {
+		// Merging on a guess would defeat the gate.
+		c.post(errorResponse(c, err.Error()), h.username, h.token)
+		f := commentFields(c, "merge")
+		f.Err = err
+		logError("Failed merge: reviews", f)
+		return plan, false
+	}
+	if len(problems) > 0 {
+		c.post(reviewsRequiredResponse(c, problems), h.username, h.token)
+		h.audit.record(auditEvent{Kind: "denied", Repo: c.Repository.FullName, PR: c.Issue.Number, User: c.Sender.Login, Detail: "reviews: " + strings.Join(problems, "; ")})
+		return plan, false
+	}
+
+	problems, err = h.checkDependencies(c.Repository.FullName, pr)
+	if err != nil {
+		// Merging on a guess would defeat the gate.
+		c.post(dependencyReviewFailedResponse(c, err.Error()), h.username, h.token)
+		f := commentFields(c, "merge")
+		f.Err = err
+		logError("Failed merge: dependency review", f)
+		return plan, false
+	}
+	if len(problems) > 0 {
+		c.post(dependenciesDeniedResponse(c, problems), h.username, h.token)
+		h.audit.record(auditEvent{Kind: "denied", Repo: c.Repository.FullName, PR: c.Issue.Number, User: c.Sender.Login, Detail: "dependencies: " + strings.Join(problems, "; ")})
+		h.trackFailure(c, pr, "it adds dependencies that aren't allowed", withNotes("Problems:", problems))
+		return plan, false
+	}
 
+	deny, err := h.checkPolicy(c, pr)
 	if err != nil {
 		c.post(errorResponse(c, err.Error()), h.username, h.token)
-		log.Printf("Failed merge of PR %d on %s for %s:\n%s", c.Issue.Number, c.Repository.FullName, c.Sender.Login, err.Error())
+		f := commentFields(c, "merge")
+		f.Err = err
+		logError("Failed merge: policy", f)
+		return plan, false
+	}
+	if len(deny) > 0 {
+		c.post(policyDeniedResponse(c, deny), h.username, h.token)
+		h.audit.record(auditEvent{Kind: "denied", Repo: c.Repository.FullName, PR: c.Issue.Number, User: c.Sender.Login, Detail: "policy: " + strings.Join(deny, "; ")})
+		h.trackFailure(c, pr, "the merge policy doesn't allow it", withNotes("Reasons:", deny))
+		return plan, false
+	}
 
-		return
+	body := c.parseBody()
+	if mode, _ := h.messageMode(c); mode == messageVerbatim {
+		plan.msg = verbatimMessage(c.Comment.Body)
+		plan.verbatim = true
+	} else if body.subject != "" {
+		width := h.settings.forRepo(c.Repository.FullName).WrapWidth
+		if width <= 0 {
+			width = defaultWrapWidth
+		}
+		plan.msg = strings.TrimSpace(body.subject + "\n\n" + reflow(body.description, width))
+	} else if rs := h.settings.forRepo(c.Repository.FullName); rs.AreaSubjects != nil && *rs.AreaSubjects && pr.Title != "" {
+		files, err := pr.getFiles(h.username, h.token)
+		if err != nil {
+			log.Printf("Files of PR %d on %s: %v", c.Issue.Number, c.Repository.FullName, err)
+		}
+		plan.subject = areaSubject(pr.Title, files)
 	}
 
-	c.post(thanksResponse(c, sha1), h.username, h.token)
+	plan.user, err = c.user(h.username, h.token)
+	if err != nil || plan.user.Email == "" {
+		c.post(noUserResponse(c), h.username, h.token)
+		f := commentFields(c, "merge")
+		f.Err = err
+		logWarn("Failed merge: no user info", f)
+		return plan, false
+	}
+	return plan, true
+}
+
+// completeMerge records and reports a merge that has been pushed.
+func (h *handler) completeMerge(c comment, pr pr, sha1 string, plan mergePlan, notes []string) {
+	rec := mergeRecord{
+		Time:       time.Now(),
+		Repo:       c.Repository.FullName,
+		PR:         c.Issue.Number,
+		Title:      pr.Title,
+		URL:        pr.HTMLURL,
+		Base:       pr.Base.Ref,
+		SHA:        sha1,
+		Strategy:   strategySquash,
+		Author:     c.Issue.User.Login,
+		Requester:  c.Sender.Login,
+		OnBehalfOf: plan.delegate,
+	}
+	if plan.strategy != "" {
+		rec.Strategy = plan.strategy
+	}
+	if pr.Milestone != nil {
+		rec.Milestone = pr.Milestone.Number
+	}
+	skip := fieldValues(c.Comment.Body, "Skip-Check")
+	if len(skip) > 0 {
+		rec.Overrides = append(rec.Overrides, "Skipped checks "+strings.Join(skip, ", "))
+	}
+	rec.Overrides = append(rec.Overrides, notes...)
+	recordMerge(rec)
+	h.attestMerge(pr, rec)
+	detail := "Merged into " + rec.Base + " as " + sha1
+	if plan.delegate != "" {
+		detail += " on behalf of " + plan.delegate
+	}
+	h.audit.record(auditEvent{Kind: "merge", Repo: rec.Repo, PR: rec.PR, User: rec.Requester, SHA: sha1, Detail: detail})
+	for _, o := range rec.Overrides {
+		h.audit.record(auditEvent{Kind: "override", Repo: rec.Repo, PR: rec.PR, User: rec.Requester, Detail: o})
+	}
+
+	h.closeTracking(rec.Repo, rec.PR, fmt.Sprintf("#%d was merged as %s.", rec.PR, sha1))
+	c.post(withNotes(thanksResponse(c, sha1), notes), h.username, h.token)
+	h.mergeStatus(c, pr, stateSuccess, "Merged as "+sha1+".")
 	c.close(h.username, h.token)
-	log.Printf("Completed merge of PR %d on %s for %s", c.Issue.Number, c.Repository.FullName, c.Sender.Login)
+	go h.handOffArtifacts(c, pr, sha1)
+	go h.watchBuild(c, pr, plan.baseSHA, sha1)
+	logInfo("Completed merge as "+sha1, commentFields(c, "merge"))
 }
 
 var allowedCommitSubjectRe = regexp.MustCompile(`^[a-zA-Z0-9_./-]+:\s`)
 
-func squash(pr pr, user user, msg string, lgtm []string) (string, error) {
-	sourceBranch := fmt.Sprintf("pr-%d", pr.Number)
+// squash squashes the PR onto its base branch in the clone in dir and
+// pushes the result.
+func squash(ctx context.Context, dir string, pr pr, plan mergePlan, prog *progress, journal *mergeJournal) (string, error) {
+	s, sha1, err := squashLocally(ctx, dir, pr, plan, prog, journal)
+	if err != nil {
+		return "", err
+	}
+	if s.Error() == nil {
+		journal.step(stepPushing, sha1)
+	}
+	prog.set("pushing to " + pr.Base.Ref)
+	s.run("git", "push", "origin", pr.Base.Ref)
+
+	if s.Error() != nil {
+		// Overwrite the error with whatever actual output we had, as a markdown verbatim.
+		return "", fmt.Errorf("%s", s.output.String())
+	}
+	journal.step(stepPushed, sha1)
+	return sha1, nil
+}
+
+// squashThroughAPI squashes the PR like squash, but has GitHub merge it
+// with the message of the squashed commit rather than pushing, for bases
+// that are protected against pushes. The clone in dir is of the repository
+// of the same name. What's merged is the head that was checked.
+func (h *handler) squashThroughAPI(ctx context.Context, dir string, pr pr, plan mergePlan, prog *progress, journal *mergeJournal) (string, error) {
+	s, sha1, err := squashLocally(ctx, dir, pr, plan, prog, journal)
+	if err != nil {
+		return "", err
+	}
+	msg := s.run("git", "log", "-1", "--format=%B", sha1)
+	if s.Error() != nil {
+		return "", fmt.Errorf("%s", s.output.String())
+	}
+	title, description := msg, ""
+	if i := strings.Index(msg, "\n"); i >= 0 {
+		title, description = msg[:i], strings.TrimSpace(msg[i+1:])
+	}
+
+	prog.set("merging into " + pr.Base.Ref + " through the API")
+	req := map[string]string{
+		"merge_method":   "squash",
+		"commit_title":   title,
+		"commit_message": description,
+		"sha":            pr.headSHA(),
+	}
+	var res struct {
+		SHA string
+	}
+	u := fmt.Sprintf("%s/repos/%s/pulls/%d/merge", githubAPI, dir, pr.Number)
+	if err := apiRequest("PUT", u, req, &res, h.username, h.token); err != nil {
+		return "", fmt.Errorf("GitHub refused to merge: %v", err)
+	}
+	journal.step(stepPushed, res.SHA)
+	return res.SHA, nil
+}
+
+// squashLocally commits the PR squashed onto its base branch in the clone
+// in dir, returning the script with any error from the way there.
+func squashLocally(ctx context.Context, dir string, pr pr, plan mergePlan, prog *progress, journal *mergeJournal) (*script, string, error) {
 	dstBranch := pr.Base.Ref
 
-	s := newScript()
-	s.run("git", "fetch", "-f", "origin", fmt.Sprintf("refs/pull/%d/head:pr-%d", pr.Number, pr.Number))
-	s.run("git", "fetch", "-f", "origin", fmt.Sprintf("%s:orig/%s", dstBranch, dstBranch))
+	prog.set("fetching " + dstBranch)
+	s := newScriptContext(ctx).in(dir)
+	fetchRefs(s, fetchRef{remote: dstBranch, local: "orig/" + dstBranch, sha: plan.baseSHA}, prRef(pr))
+	if err := checkHead(s, pr); err != nil {
+		return nil, "", err
+	}
+	if s.Error() == nil {
+		journal.step(stepFetched, s.run("git", "rev-parse", "orig/"+dstBranch))
+	}
 
 	s.run("git", "reset", "--hard")
-	s.run("git", "checkout", dstBranch)
-	s.run("git", "reset", "--hard", "orig/"+dstBranch)
+	setSparse(s, plan.sparse)
+	// The base may be a release or feature branch the clone hasn't seen,
+	// so it's checked out from what was just fetched.
+	s.run("git", "checkout", "-B", dstBranch, "orig/"+dstBranch)
 	s.run("git", "clean", "-fxd")
 
+	prog.set("squashing")
+	sha1, err := squashCommit(s, pr, plan)
+	if err != nil {
+		return nil, "", err
+	}
+	if s.Error() == nil {
+		journal.step(stepCommitted, sha1)
+	}
+	return s, sha1, nil
+}
+
+// squashCommit commits the changes of the PR squashed on top of the current
+// HEAD of the clone the script runs in, returning the new commit.
+func squashCommit(s *script, pr pr, plan mergePlan) (string, error) {
+	sourceBranch := fmt.Sprintf("pr-%d", pr.Number)
+	fetchRefs(s, prRef(pr)) // usually fetched along with the base already
+
 	// Find first commit and extract info from it
-	t := newScript()
-	mergeBase := t.run("git", "merge-base", sourceBranch, dstBranch)
+	t := newScriptContext(s.ctx).in(s.dir)
+	mergeBase := t.run("git", "merge-base", sourceBranch, "HEAD")
 	revs := strings.Fields(t.run("git", "rev-list", mergeBase+".."+sourceBranch))
 	if len(revs) == 0 {
-		return "", fmt.Errorf("Nothing to merge, as far as I can tell.")
+		if t.Error() != nil {
+			return "", fmt.Errorf("%s", t.output.String())
+		}
+		return "", explainNoCommits(pr.Base.Ref)
 	}
 	firstCommit := revs[len(revs)-1]
 	authorName := t.run("git", "log", "-n1", "--pretty=format:%an", firstCommit)
 	authorEmail := t.run("git", "log", "-n1", "--pretty=format:%ae", firstCommit)
-	os.Setenv("GIT_COMMITTER_NAME", user.Name)
-	os.Setenv("GIT_COMMITTER_EMAIL", user.Email)
-	os.Setenv("GIT_AUTHOR_NAME", authorName)
-	os.Setenv("GIT_AUTHOR_EMAIL", authorEmail)
+	env := []string{
+		"GIT_COMMITTER_NAME=" + plan.user.Name,
+		"GIT_COMMITTER_EMAIL=" + plan.user.Email,
+		"GIT_AUTHOR_NAME=" + authorName,
+		"GIT_AUTHOR_EMAIL=" + authorEmail,
+	}
 
 	var body string
-	if msg != "" {
+	if plan.msg != "" {
 		// Overridden commit message from parameters
-		body = msg
+		body = plan.msg
 	} else {
 		// Commit message from first commit
 		body = t.run("git", "log", "-n1", "--pretty=format:%B", firstCommit)
+		if plan.subject != "" {
+			body = plan.subject + "\n\n" + strings.TrimSpace(t.run("git", "log", "-n1", "--pretty=format:%b", firstCommit))
+		}
 	}
 
-	body = fmt.Sprintf("%s\n\nGitHub-Pull-Request: %s\n", strings.TrimSpace(body), pr.HTMLURL)
-	if len(lgtm) > 0 {
-		body = fmt.Sprintf("%sLGTM: %s\n", body, strings.Join(lgtm, ", "))
-	}
+	body = withTrailers(body, pr, plan)
 
 	s.run("git", "merge", "--squash", "--no-commit", sourceBranch)
-	s.runPipe(bytes.NewBufferString(body), "git", "commit", "-F", "-")
-	sha1 := s.run("git", "rev-parse", "HEAD")
-	s.run("git", "push", "origin", dstBranch)
+	if s.Error() == nil && gitQuiet(s.ctx, s.dir, "diff", "--cached", "--quiet") {
+		err := explainEmptySquash(s.ctx, s.dir, pr.Base.Ref, sourceBranch, mergeBase)
+		s.run("git", "reset", "--hard")
+		return "", err
+	}
+	if plan.verbatim {
+		// Keep lines starting with # and runs of blank lines.
+		s.runPipeEnv(env, bytes.NewBufferString(body), "git", "commit", "--cleanup=verbatim", "-F", "-")
+	} else {
+		s.runPipeEnv(env, bytes.NewBufferString(body), "git", "commit", "-F", "-")
+	}
+	return s.run("git", "rev-parse", "HEAD"), nil
+}
 
-	if s.Error() != nil {
-		// Overwrite the error with whatever actual output we had, as a markdown verbatim.
-		return "", fmt.Errorf("%s", s.output.String())
+// withTrailers adds the trailers of the merge to the commit message.
+func withTrailers(body string, pr pr, plan mergePlan) string {
+	body = fmt.Sprintf("%s\n\nGitHub-Pull-Request: %s\n", strings.TrimSpace(body), pr.HTMLURL)
+	if len(plan.lgtm) > 0 {
+		body = fmt.Sprintf("%sLGTM: %s\n", body, strings.Join(plan.lgtm, ", "))
 	}
-	return sha1, nil
+	for _, t := range plan.trailers {
+		body += t + "\n"
+	}
+	return body
 }
 
-func updatePRBranch(pr int) {
-	s := newScript()
+func updatePRBranch(dir string, pr int) {
+	s := newScript().in(dir)
 	s.run("git", "fetch", "-f", "origin", fmt.Sprintf("refs/pull/%d/head:pr-%d", pr, pr))
 	s.run("git", "push", "-f", "origin", fmt.Sprintf("pr-%d", pr))
 }
 
-func deletePRBranch(pr int) {
-	s := newScript()
+func deletePRBranch(dir string, pr int) {
+	s := newScript().in(dir)
 	s.run("git", "push", "origin", fmt.Sprintf(":pr-%d", pr))
 }
 
-func clone(repo string) error {
-	s := newScript()
-	s.run("git", "clone", fmt.Sprintf("git@github.com:%s.git", repo), repo)
+// defaultCloneURL is the clone URL template used unless configured
+// otherwise.
+const defaultCloneURL = "git@github.com:{repo}.git"
+
+// sshCloneURL is the clone URL template over SSH for the GitHub the API
+// URL is of, defaultCloneURL for github.com itself.
+func sshCloneURL(apiURL string) string {
+	return "git@" + gitHost(apiURL) + ":{repo}.git"
+}
+
+// cloneTemplate returns the configured clone URL template for the
+// repository.
+func (h *handler) cloneTemplate(repo string) string {
+	if tmpl := h.settings.forRepo(repo).CloneURL; tmpl != "" {
+		return tmpl
+	}
+	return defaultCloneURL
+}
+
+// cloneURL returns the URL to clone the repository from, expanding {repo},
+// {owner} and {name} in the configured template, and {token} to an
+// installation token when authenticating as a GitHub App.
+func (h *handler) cloneURL(repo string) string {
+	tmpl := h.cloneTemplate(repo)
+	owner, name := repo, ""
+	if idx := strings.Index(repo, "/"); idx >= 0 {
+		owner, name = repo[:idx], repo[idx+1:]
+	}
+	token := ""
+	if h.app != nil && strings.Contains(tmpl, "{token}") {
+		var err error
+		if token, err = h.app.token(owner); err != nil {
+			log.Println("Installation token:", err)
+		}
+	}
+	return strings.NewReplacer("{repo}", repo, "{owner}", owner, "{name}", name, "{token}", token).Replace(tmpl)
+}
+
+func clone(repo, url string) error {
+	return cloneContext(context.Background(), repo, url)
+}
+
+// cloneContext clones like clone, with any extra arguments to git clone,
+// but gives up when the context is done, removing the partial clone.
+func cloneContext(ctx context.Context, repo, url string, args ...string) error {
+	s := newScriptContext(ctx)
+	s.run("git", append(append([]string{"clone"}, args...), url, repo)...)
 	if s.Error() != nil {
+		if ctx.Err() != nil {
+			os.RemoveAll(repo)
+		}
 		return fmt.Errorf("%s", s.output.String())
 	}
 	return nil
 }
 
+// resetWorktree cleans up after an interrupted merge in the clone,
+// including the lock files a killed git leaves behind.
+func resetWorktree(dir string) {
+	os.Remove(filepath.Join(dir, ".git", "index.lock"))
+	os.RemoveAll(filepath.Join(dir, ".git", "rebase-merge"))
+	s := newScript().in(dir)
+	s.run("git", "reset", "--hard")
+	s.run("git", "clean", "-fxd")
+}
+
+// mergeTimedOut reports a merge that was abandoned at its deadline.
+func (h *handler) mergeTimedOut(c comment, timeout time.Duration, phase string) {
+	metrics.add("merge_timeouts", 1)
+	log.Printf("Merge of PR %d on %s for %s timed out after %v while %s", c.Issue.Number, c.Repository.FullName, c.Sender.Login, timeout, phase)
+	c.post(mergeTimeoutResponse(c, timeout, phase), h.username, h.token)
+}
+
 func fieldValues(message, field string) []string {
 	var res []string
 	field = strings.ToLower(field)
@@ -1,8 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestFieldValues(t *testing.T) {
@@ -24,3 +33,363 @@ func TestFieldValues(t *testing.T) {
 		}
 	}
 }
+
+func TestExtendDeadline(t *testing.T) {
+	t0 := time.Now()
+	current := t0.Add(30 * time.Minute)
+	hardCap := t0.Add(time.Hour)
+
+	if d := extendDeadline(current, t0.Add(45*time.Minute), hardCap); !d.Equal(t0.Add(45 * time.Minute)) {
+		t.Error("Expected deadline to be extended, got", d.Sub(t0))
+	}
+	if d := extendDeadline(current, t0.Add(20*time.Minute), hardCap); !d.Equal(current) {
+		t.Error("Expected deadline not to move back, got", d.Sub(t0))
+	}
+	if d := extendDeadline(current, t0.Add(2*time.Hour), hardCap); !d.Equal(hardCap) {
+		t.Error("Expected deadline to stop at the cap, got", d.Sub(t0))
+	}
+}
+
+func TestCloneURL(t *testing.T) {
+	h := newHandler(nil, "bot", "token", false)
+	h.settings.repos = map[string]repoSettings{
+		"corp/*": {CloneURL: "ssh://git@mirror.corp:2222/{owner}/{name}.git"},
+	}
+
+	if u := h.cloneURL("foo/bar"); u != "git@github.com:foo/bar.git" {
+		t.Error("Unexpected default clone URL", u)
+	}
+	if u := h.cloneURL("corp/bar"); u != "ssh://git@mirror.corp:2222/corp/bar.git" {
+		t.Error("Unexpected templated clone URL", u)
+	}
+}
+
+func TestEnterpriseURLs(t *testing.T) {
+	defer func(u string) { githubAPI = u }(githubAPI)
+	tests := []struct {
+		api, uploads, clone, email string
+	}{
+		{"https://api.github.com", "https://uploads.github.com", defaultCloneURL, "bot@users.noreply.github.com"},
+		{"https://ghe.corp/api/v3", "https://ghe.corp/api/uploads", "git@ghe.corp:{repo}.git", "bot@users.noreply.ghe.corp"},
+	}
+	for _, test := range tests {
+		githubAPI = test.api
+		if u := uploadsURL(test.api); u != test.uploads {
+			t.Errorf("%s: uploads at %s, want %s", test.api, u, test.uploads)
+		}
+		if u := sshCloneURL(test.api); u != test.clone {
+			t.Errorf("%s: clone URL %s, want %s", test.api, u, test.clone)
+		}
+		if e := noreplyEmail("bot"); e != test.email {
+			t.Errorf("%s: email %s, want %s", test.api, e, test.email)
+		}
+	}
+
+	githubAPI = "https://ghe.corp/api/v3"
+	h := newWebhook(":0", "secret", "bot", "")
+	body := []byte(`{"action": "created", "repository": {"full_name": "foo/bar"}, "comment": {"body": "@bot merge"}}`)
+	mac := hmac.New(sha1.New, []byte("secret"))
+	mac.Write(body)
+	for host, want := range map[string]int{"": http.StatusOK, "GHE.corp": http.StatusOK, "other.corp": http.StatusBadRequest} {
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		req.Header.Set("X-Github-Event", "issue_comment")
+		req.Header.Set("X-Hub-Signature", fmt.Sprintf("sha1=%x", mac.Sum(nil)))
+		if host != "" {
+			req.Header.Set("X-Github-Enterprise-Host", host)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("Delivery from %q got %d, want %d", host, rec.Code, want)
+		}
+	}
+}
+
+func TestServeRestart(t *testing.T) {
+	h := newWebhook("256.0.0.0:1", "secret", "bot", "")
+	h.handleHTTP("/healthz", http.NotFoundHandler())
+	// The supervisor restarts Serve when it returns, as it does when it
+	// can't listen.
+	h.Serve()
+	h.Serve()
+}
+
+func TestMessageMode(t *testing.T) {
+	h := newHandler(nil, "bot", "token", false)
+	h.settings.repos = map[string]repoSettings{
+		"corp/*": {MessageMode: "verbatim"},
+	}
+
+	cases := []struct {
+		repo, command, mode string
+		err                 bool
+	}{
+		{"foo/bar", "@bot merge", messageReflow, false},
+		{"corp/bar", "@bot merge", messageVerbatim, false},
+		{"corp/bar", "@bot merge --message=reflow", messageReflow, false},
+		{"foo/bar", "@bot merge --message=Verbatim", messageVerbatim, false},
+		{"foo/bar", "@bot merge --message=pretty", messageReflow, true},
+	}
+	for _, tc := range cases {
+		var c comment
+		c.Repository.FullName = tc.repo
+		c.Comment.Body = tc.command
+		mode, err := h.messageMode(c)
+		if mode != tc.mode || (err != nil) != tc.err {
+			t.Errorf("messageMode(%s, %q) = %q, %v", tc.repo, tc.command, mode, err)
+		}
+	}
+}
+
+func TestWaitAndPollTime(t *testing.T) {
+	h := newHandler(nil, "bot", "token", false)
+	h.settings.defaults.MaxWaitCap = duration{2 * time.Hour}
+	h.settings.repos = map[string]repoSettings{
+		"slow/*": {MaxWait: duration{3 * time.Hour}, MaxPoll: duration{5 * time.Minute}},
+	}
+
+	cases := []struct {
+		repo, command string
+		wait, poll    time.Duration
+		clamped, err  bool
+	}{
+		{"foo/bar", "@bot merge", maxWaitTime, maxPollTime, false, false},
+		{"slow/bar", "@bot merge", 3 * time.Hour, 5 * time.Minute, false, false},
+		{"slow/bar", "@bot merge --wait=150m --poll=10m", 150 * time.Minute, 10 * time.Minute, false, false},
+		{"foo/bar", "@bot merge wait=2h poll=2m", 2 * time.Hour, 2 * time.Minute, false, false},
+		{"foo/bar", "@bot merge wait=8760h", 2 * time.Hour, maxPollTime, true, false},
+		{"slow/bar", "@bot merge wait=4h", 3 * time.Hour, 5 * time.Minute, true, false},
+		{"foo/bar", "@bot merge --wait=forever", maxWaitTime, maxPollTime, false, true},
+		{"foo/bar", "@bot merge poll=10ms", maxWaitTime, maxPollTime, false, true},
+	}
+	for _, tc := range cases {
+		var c comment
+		c.Repository.FullName = tc.repo
+		c.Comment.Body = tc.command
+		wait, clamped, werr := h.waitTime(c)
+		poll, perr := h.pollTime(c)
+		if wait != tc.wait || clamped != tc.clamped || poll != tc.poll || (werr != nil || perr != nil) != tc.err {
+			t.Errorf("%s %q: wait %v, %v, %v and poll %v, %v", tc.repo, tc.command, wait, clamped, werr, poll, perr)
+		}
+	}
+}
+
+func TestBaseAllowed(t *testing.T) {
+	h := newHandler(nil, "bot", "token", false)
+	h.settings.repos = map[string]repoSettings{
+		"corp/*": {BaseBranches: []string{"main", "release-*"}},
+	}
+
+	cases := []struct {
+		repo, base string
+		ok         bool
+	}{
+		{"foo/bar", "master", true},
+		{"corp/bar", "main", true},
+		{"corp/bar", "release-1.2", true},
+		{"corp/bar", "feature/x", false},
+	}
+	for _, tc := range cases {
+		if ok := h.baseAllowed(tc.repo, tc.base); ok != tc.ok {
+			t.Errorf("baseAllowed(%s, %s) = %v, expected %v", tc.repo, tc.base, ok, tc.ok)
+		}
+	}
+}
+
+func TestRecheckMerge(t *testing.T) {
+	h := newHandler(nil, "bot", "token", false)
+	h.settings.defaults.AllowedUsers = []string{"alice", "bob"}
+
+	asked := func(state, base string) pr {
+		var p pr
+		p.State = state
+		p.Base.Ref = base
+		return p
+	}
+	cases := []struct {
+		sender, body string
+		cur          pr
+		why          string
+	}{
+		{"alice", "@bot merge", asked("open", "main"), ""},
+		{"alice", "@bot merge on-behalf-of @bob", asked("open", "main"), ""},
+		{"carol", "@bot merge", asked("open", "main"), "carol is no longer allowed to merge"},
+		{"alice", "@bot merge on-behalf-of @carol", asked("open", "main"), "carol is no longer allowed to merge"},
+		{"alice", "@bot merge", asked("closed", "main"), "the PR was closed"},
+		{"alice", "@bot merge", asked("open", "release-1"), "the PR was retargeted from main to release-1"},
+		{"alice", "@bot merge", asked("", ""), ""},
+	}
+	for _, tc := range cases {
+		var c comment
+		c.Repository.FullName = "foo/bar"
+		c.Sender.Login = tc.sender
+		c.Comment.Body = tc.body
+		if why := h.recheckMerge(c, asked("open", "main"), tc.cur); why != tc.why {
+			t.Errorf("recheckMerge(%s, %q) = %q, expected %q", tc.sender, tc.body, why, tc.why)
+		}
+	}
+}
+
+func TestMergeQueueStrategies(t *testing.T) {
+	var comments []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/foo/bar/pulls/1":
+			w.Write([]byte(`{"number": 1, "state": "open", "base": {"ref": "main"}, "head": {"sha": "abc"}}`))
+		case "/repos/foo/bar/issues/1/comments":
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			comments = append(comments, body.Body)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	queue := true
+	h := newHandler([]string{"alice"}, "bot", "token", false)
+	h.settings.repos = map[string]repoSettings{"foo/*": {MergeQueue: &queue, TrainLength: 5}}
+	if !h.trainEnabled("foo/bar") || !h.queueEnabled("foo/bar") || h.queueEnabled("other/bar") {
+		t.Error("Expected a queue on foo/bar only")
+	}
+
+	// Merges that don't squash can't skip the queue.
+	for _, body := range []string{"@bot rebase", "@bot merge --no-squash"} {
+		comments = nil
+		var c comment
+		c.Repository.FullName = "foo/bar"
+		c.Sender.Login = "alice"
+		c.Comment.Body = body
+		c.Issue.Number = 1
+		c.Issue.PullRequest.URL = srv.URL + "/repos/foo/bar/pulls/1"
+		c.Issue.CommentsURL = srv.URL + "/repos/foo/bar/issues/1/comments"
+		h.handleMerge(c)
+		if len(comments) != 1 || !strings.Contains(comments[0], "queue") || h.isPending(c) {
+			t.Errorf("%q: expected the merge to be refused, got %q", body, comments)
+		}
+	}
+}
+
+func TestApprovalTimeout(t *testing.T) {
+	var comments []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/statuses":
+			w.Write([]byte(`[{"state": "success", "context": "ci"}]`))
+		case strings.HasSuffix(r.URL.Path, "/reviews"):
+			w.Write([]byte(`[]`))
+		case strings.HasSuffix(r.URL.Path, "/comments"):
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			comments = append(comments, body.Body)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	h := newHandler([]string{"alice"}, "bot", "token", false)
+	var c comment
+	c.Repository.FullName = "foo/bar"
+	c.Sender.Login = "alice"
+	c.Comment.Body = "@bot merge when approved --wait=100ms"
+	c.Issue.Number = 1
+	c.Issue.CommentsURL = srv.URL + "/repos/foo/bar/issues/1/comments"
+	var p pr
+	p.Number = 1
+	p.URL = srv.URL + "/repos/foo/bar/pulls/1"
+	p.StatusesURL = srv.URL + "/statuses"
+	p.Head.SHA = "abc"
+
+	// The checks are green, so it's approvals that were waited for.
+	h.delayedMerge(c, p, time.Now())
+	if len(comments) != 1 || !strings.Contains(comments[0], "for 1 more approval(s)") {
+		t.Errorf("Expected a timeout waiting for approvals, got %q", comments)
+	}
+}
+
+func TestLandMergeUnavailablePR(t *testing.T) {
+	var comments []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/foo/bar/pulls/1":
+			http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+		case strings.HasSuffix(r.URL.Path, "/comments"):
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			comments = append(comments, body.Body)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	h := newHandler([]string{"alice"}, "bot", "token", false)
+	var c comment
+	c.Repository.FullName = "foo/bar"
+	c.Sender.Login = "alice"
+	c.Issue.Number = 1
+	c.Issue.PullRequest.URL = srv.URL + "/repos/foo/bar/pulls/1"
+	c.Issue.CommentsURL = srv.URL + "/repos/foo/bar/issues/1/comments"
+	if _, err := c.getPR(); err == nil {
+		t.Error("Expected an error getting a PR that isn't found")
+	}
+
+	var p pr
+	p.Number = 1
+	p.State = "open"
+	p.Base.Ref = "main"
+	p.Head.SHA = "abc"
+	h.landMerge(c, p, nil)
+	if len(comments) != 1 || !strings.Contains(comments[0], "couldn't get the PR") {
+		t.Errorf("Expected the merge to be refused, got %q", comments)
+	}
+}
+
+func TestApprovalByNonCollaborator(t *testing.T) {
+	var comments []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/statuses":
+			w.Write([]byte(`[{"state": "success", "context": "ci"}]`))
+		case strings.HasSuffix(r.URL.Path, "/reviews"):
+			w.Write([]byte(`[{"user": {"login": "mallory"}, "state": "APPROVED", "commit_id": "abc"}]`))
+		case strings.HasSuffix(r.URL.Path, "/merge"):
+			t.Error("Merged on the approval of a non-collaborator")
+		case strings.HasSuffix(r.URL.Path, "/comments"):
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			comments = append(comments, body.Body)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	h := newHandler(nil, "bot", "token", false)
+	h.permissions.directory = map[string][]string{"foo/*": {"alice"}}
+	var c comment
+	c.Repository.FullName = "foo/bar"
+	c.Sender.Login = "alice"
+	c.Comment.Body = "@bot merge when approved --wait=100ms"
+	c.Issue.Number = 1
+	c.Issue.CommentsURL = srv.URL + "/repos/foo/bar/issues/1/comments"
+	var p pr
+	p.Number = 1
+	p.URL = srv.URL + "/repos/foo/bar/pulls/1"
+	p.StatusesURL = srv.URL + "/statuses"
+	p.Head.SHA = "abc"
+
+	h.delayedMerge(c, p, time.Now())
+	if len(comments) != 1 || !strings.Contains(comments[0], "for 1 more approval(s)") {
+		t.Errorf("Expected a timeout waiting for approvals, got %q", comments)
+	}
+}
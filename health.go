@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenCheckInterval is how long a check of the GitHub token is trusted
+// for, so that probes don't eat into the rate limit.
+const tokenCheckInterval = time.Minute
+
+// healthChecker serves /healthz, which checks what the bot needs locally,
+// and /readyz, which also checks that GitHub takes its token, for probes
+// and load balancers.
+type healthChecker struct {
+	h *handler
+
+	mut       sync.Mutex
+	checked   time.Time
+	tokenErr  error
+	checkUser func() error // asks GitHub who we are
+}
+
+func newHealthChecker(h *handler) *healthChecker {
+	hc := &healthChecker{h: h}
+	hc.checkUser = func() error {
+		if h.app != nil {
+			// Installation tokens have no user, but do have repositories.
+			return apiRequest("GET", githubAPI+"/installation/repositories?per_page=1", nil, nil, h.username, h.token)
+		}
+		return apiRequest("GET", githubAPI+"/user", nil, nil, h.username, h.token)
+	}
+	return hc
+}
+
+// localChecks returns the failures of the checks that don't need GitHub,
+// by name.
+func (hc *healthChecker) localChecks() map[string]error {
+	return map[string]error{
+		"git":      gitAvailable(),
+		"workdir":  writable("."),
+		"statedir": writable(stateDir),
+	}
+}
+
+// tokenValid returns why GitHub doesn't take our token, if it doesn't.
+func (hc *healthChecker) tokenValid() error {
+	hc.mut.Lock()
+	defer hc.mut.Unlock()
+	if time.Since(hc.checked) > tokenCheckInterval {
+		hc.tokenErr = hc.checkUser()
+		hc.checked = time.Now()
+	}
+	return hc.tokenErr
+}
+
+func (hc *healthChecker) serveHealth(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, hc.localChecks())
+}
+
+func (hc *healthChecker) serveReady(w http.ResponseWriter, r *http.Request) {
+	checks := hc.localChecks()
+	checks["github"] = hc.tokenValid()
+	writeHealth(w, checks)
+}
+
+// writeHealth reports the checks as JSON, with 503 Service Unavailable if
+// any failed.
+func writeHealth(w http.ResponseWriter, checks map[string]error) {
+	res := struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}{Status: "ok", Checks: make(map[string]string)}
+	code := http.StatusOK
+	for name, err := range checks {
+		res.Checks[name] = "ok"
+		if err != nil {
+			res.Checks[name] = err.Error()
+			res.Status = "failing"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(res)
+}
+
+// gitAvailable returns why git can't be run, if it can't.
+func gitAvailable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, gitBinary, "--version").CombinedOutput(); err != nil {
+		return fmt.Errorf("git --version: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// writable returns why files can't be written in the directory, if they
+// can't.
+func writable(dir string) error {
+	fd, err := ioutil.TempFile(dir, ".healthz")
+	if err != nil {
+		return err
+	}
+	fd.Close()
+	return os.Remove(fd.Name())
+}
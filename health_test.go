@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+
+	hc := newHealthChecker(newHandler(nil, "bot", "", false))
+	calls := 0
+	var tokenErr error
+	hc.checkUser = func() error {
+		calls++
+		return tokenErr
+	}
+	probe := func(serve http.HandlerFunc) (int, map[string]string) {
+		rec := httptest.NewRecorder()
+		serve(rec, httptest.NewRequest("GET", "/", nil))
+		var res struct{ Checks map[string]string }
+		json.NewDecoder(rec.Body).Decode(&res)
+		return rec.Code, res.Checks
+	}
+
+	if code, checks := probe(hc.serveHealth); code != http.StatusOK || checks["git"] != "ok" || checks["statedir"] != "ok" || checks["github"] != "" {
+		t.Errorf("Expected to be healthy, got %d %v", code, checks)
+	}
+	tokenErr = errors.New("GET: 401 Unauthorized")
+	if code, checks := probe(hc.serveReady); code != http.StatusServiceUnavailable || checks["github"] != "GET: 401 Unauthorized" {
+		t.Errorf("Expected not to be ready with a bad token, got %d %v", code, checks)
+	}
+	tokenErr = nil
+	probe(hc.serveReady)
+	if calls != 1 {
+		t.Errorf("Expected the token check to be cached, got %d calls", calls)
+	}
+
+	stateDir = filepath.Join(stateDir, "missing")
+	if code, checks := probe(hc.serveHealth); code != http.StatusServiceUnavailable || checks["statedir"] == "ok" {
+		t.Errorf("Expected an unwritable state directory to fail, got %d %v", code, checks)
+	}
+	if _, err := os.Stat(stateDir); !os.IsNotExist(err) {
+		t.Error("Expected nothing to be created")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// maxHistory is how many audit events the history command lists, the most
+// recent ones.
+const maxHistory = 50
+
+// handleHistory lists the audit events of the PR: who asked for what and
+// when, whether they were allowed to, and how each attempt went. They come
+// from the audit log, so deleted or minimized comments don't lose them.
+func (h *handler) handleHistory(c comment) {
+	if h.audit == nil || h.audit.logName == "" {
+		c.post(badOptionResponse(c, "there's no audit log to tell the history from"), h.username, h.token)
+		return
+	}
+	repo, number := c.Repository.FullName, c.Issue.Number
+	events, err := h.audit.readAudit(func(ev auditEvent) bool {
+		return ev.PR == number && strings.EqualFold(ev.Repo, repo)
+	})
+	if err != nil {
+		log.Println("Audit log:", err)
+		c.post(badOptionResponse(c, "couldn't read the audit log"), h.username, h.token)
+		return
+	}
+	var lines []string
+	if len(events) > maxHistory {
+		lines = append(lines, fmt.Sprintf("%d earlier events not shown.", len(events)-maxHistory))
+		events = events[len(events)-maxHistory:]
+	}
+	for _, ev := range events {
+		lines = append(lines, historyLine(ev))
+	}
+	c.post(historyResponse(c, lines), h.username, h.token)
+}
+
+// historyLine describes the audit event, without mentioning anyone.
+func historyLine(ev auditEvent) string {
+	name := auditNames[ev.Kind]
+	if name == "" {
+		name = ev.Kind
+	}
+	detail := ev.Detail
+	if ev.Kind == "command" {
+		detail = codeSpan(detail)
+	}
+	line := fmt.Sprintf("%s %s, by %s", ev.Time.UTC().Format("2006-01-02 15:04 MST"), name, ev.User)
+	if detail != "" {
+		line += ": " + detail
+	}
+	if ev.SHA != "" && !strings.Contains(detail, ev.SHA) {
+		line += " (" + ev.SHA + ")"
+	}
+	return line
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+
+	var posted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Body string }
+		json.NewDecoder(r.Body).Decode(&body)
+		posted = append(posted, body.Body)
+	}))
+	defer srv.Close()
+
+	h := newHandler(nil, "bot", "", false)
+	var c comment
+	c.Repository.FullName = "foo/bar"
+	c.Sender.Login = "carol"
+	c.Issue.Number = 5
+	c.Issue.CommentsURL = srv.URL
+
+	h.handleHistory(c)
+	if len(posted) != 1 || !strings.Contains(posted[0], "no audit log") {
+		t.Fatalf("Expected no history without an audit log, got %q", posted)
+	}
+
+	var err error
+	if h.audit, err = newAuditor("cef", "", "", auditLogName); err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2021, 3, 4, 5, 6, 0, 0, time.UTC)
+	h.audit.record(auditEvent{Time: t0, Kind: "command", Repo: "foo/bar", PR: 5, User: "mallory", Detail: "merge"})
+	h.audit.record(auditEvent{Time: t0, Kind: "denied", Repo: "foo/bar", PR: 5, User: "mallory", Detail: "merge"})
+	h.audit.record(auditEvent{Time: t0, Kind: "command", Repo: "foo/bar", PR: 6, User: "alice", Detail: "merge"})
+	h.audit.record(auditEvent{Time: t0.Add(time.Hour), Kind: "merge", Repo: "Foo/Bar", PR: 5, User: "alice", SHA: "abc123", Detail: "Merged into main as abc123"})
+
+	h.handleHistory(c)
+	want := "@carol: Here's what I have on record for this PR:\n\n" +
+		"- 2021-03-04 05:06 UTC Command received, by mallory: `merge`\n" +
+		"- 2021-03-04 05:06 UTC Authorization failure, by mallory: merge\n" +
+		"- 2021-03-04 06:06 UTC Merge, by alice: Merged into main as abc123"
+	if len(posted) != 2 || posted[1] != want {
+		t.Errorf("Unexpected history\n%s\nwant\n%s", posted[len(posted)-1], want)
+	}
+
+	c.Issue.Number = 7
+	h.handleHistory(c)
+	if len(posted) != 3 || !strings.Contains(posted[2], "nothing on record") {
+		t.Errorf("Expected an empty history, got %q", posted[2:])
+	}
+}
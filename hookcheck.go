@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The hookChecker verifies that the webhook on each served repository is
+// present and healthy, repairing it when asked to.
+type hookChecker struct {
+	h      *handler
+	repair bool
+}
+
+func (c *hookChecker) checkAll() {
+	for _, repo := range c.h.servedRepos() {
+		c.check(repo)
+	}
+}
+
+func (c *hookChecker) check(repo string) {
+	hooks, err := c.h.listHooks(repo)
+	if err != nil {
+		log.Printf("Webhook check on %s failed: %v", repo, err)
+		return
+	}
+
+	existing := findHook(hooks, c.h.hookURL)
+	problems := hookProblems(existing)
+	if len(problems) == 0 {
+		return
+	}
+
+	log.Printf("Webhook on %s needs attention: %s", repo, strings.Join(problems, "; "))
+	if !c.repair {
+		return
+	}
+
+	if existing == nil {
+		_, err = c.h.createHook(repo)
+	} else {
+		// The secret is never returned by the API so we can't tell if it
+		// drifted; the update sets it again regardless.
+		err = c.h.updateHook(repo, existing.ID)
+	}
+	if err != nil {
+		log.Printf("Repairing webhook on %s failed: %v", repo, err)
+		return
+	}
+	log.Printf("Repaired webhook on %s", repo)
+}
+
+// hookProblems returns a description of each way the given hook differs
+// from what we need. A nil hook is missing entirely.
+func hookProblems(hk *hook) []string {
+	if hk == nil {
+		return []string{"webhook is missing"}
+	}
+
+	var res []string
+	if !hk.Active {
+		res = append(res, "webhook is inactive")
+	}
+	if hk.Config.ContentType != "json" {
+		res = append(res, "content type is "+hk.Config.ContentType)
+	}
+	events := make(map[string]bool)
+	for _, ev := range hk.Events {
+		events[ev] = true
+	}
+	for _, ev := range hookEvents {
+		if !events[ev] && !events["*"] {
+			res = append(res, "not subscribed to "+ev)
+		}
+	}
+	if lr := hk.LastResponse; lr != nil && lr.Code > 299 {
+		res = append(res, "last delivery failed: "+lr.Status+" "+lr.Message)
+	}
+	return res
+}
+
+// servedRepos returns the names of the repositories we have a clone of.
+func (h *handler) servedRepos() []string {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	dirs, _ := filepath.Glob(filepath.Join("*", "*", ".git"))
+	var res []string
+	for _, dir := range dirs {
+		res = append(res, filepath.ToSlash(filepath.Dir(dir)))
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestHookProblems(t *testing.T) {
+	good := hook{Active: true, Events: []string{"pull_request", "issue_comment", "milestone", "push", "status", "check_run", "check_suite"}}
+	good.Config.ContentType = "json"
+	if p := hookProblems(&good); len(p) != 0 {
+		t.Error("Unexpected problems with good hook:", p)
+	}
+
+	if p := hookProblems(nil); len(p) != 1 {
+		t.Error("Expected missing hook to be a problem, got", p)
+	}
+
+	bad := good
+	bad.Active = false
+	bad.Events = []string{"pull_request", "milestone", "push", "status", "check_run", "check_suite"}
+	if p := hookProblems(&bad); len(p) != 2 {
+		t.Error("Expected two problems with inactive hook missing events, got", p)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const linksStateName = "links.json"
+
+// linkCodeTTL is how long a code linking a chat handle to a login may be
+// redeemed for.
+const linkCodeTTL = 15 * time.Minute
+
+// A pendingLink is a chat handle waiting for its code to be posted on
+// GitHub by the login it's to be linked to.
+type pendingLink struct {
+	Chat    string    `json:"chat"`
+	Login   string    `json:"login,omitempty"` // who must post the code, if they asked for it on GitHub
+	Expires time.Time `json:"expires"`
+}
+
+// linkStore keeps the codes given out to link chat handles to logins.
+// Whoever receives a code in chat and posts it in a comment proves they
+// are both, so chat notifications and commands can trust the link.
+type linkStore struct {
+	mut     sync.Mutex
+	Pending map[string]pendingLink `json:"pending"` // code -> link
+}
+
+func loadLinkStore() (*linkStore, error) {
+	s := &linkStore{Pending: make(map[string]pendingLink)}
+	if err := loadState(linksStateName, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// start returns a new code for linking the chat handle, to the login if
+// given or else to whoever posts it.
+func (s *linkStore) start(chat, login string, now time.Time) (string, error) {
+	bs := make([]byte, 6)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	code := hex.EncodeToString(bs)
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for c, l := range s.Pending {
+		if now.After(l.Expires) || l.Chat == chat {
+			delete(s.Pending, c)
+		}
+	}
+	s.Pending[code] = pendingLink{Chat: chat, Login: strings.ToLower(login), Expires: now.Add(linkCodeTTL)}
+	return code, saveState(linksStateName, s)
+}
+
+// redeem returns the chat handle the code links the login to, using the
+// code up.
+func (s *linkStore) redeem(code, login string, now time.Time) (string, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	l, ok := s.Pending[code]
+	if !ok || now.After(l.Expires) || l.Login != "" && l.Login != strings.ToLower(login) {
+		return "", false
+	}
+	delete(s.Pending, code)
+	if err := saveState(linksStateName, s); err != nil {
+		log.Println("Saving links:", err)
+	}
+	return l.Chat, true
+}
+
+// loginFor returns the login the chat handle is linked to, if any.
+func (s *prefStore) loginFor(chat string) (string, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for login, p := range s.Users {
+		if p.Chat != "" && strings.EqualFold(p.Chat, chat) {
+			return login, true
+		}
+	}
+	return "", false
+}
+
+// link links the chat handle to the login, unlinking it from anyone else.
+func (s *prefStore) link(login, chat string) error {
+	if other, ok := s.loginFor(chat); ok && other != strings.ToLower(login) {
+		if _, err := s.update(other, func(p *userPrefs) error { return p.unlink() }); err != nil {
+			return err
+		}
+	}
+	_, err := s.update(login, func(p *userPrefs) error { return p.set("chat", chat) })
+	return err
+}
+
+// unlink forgets the chat handle, along with notifications sent there.
+func (p *userPrefs) unlink() error {
+	p.Chat = ""
+	if p.Notify == notifyChat || p.Notify == notifyDigest {
+		p.Notify = ""
+	}
+	return nil
+}
+
+// startLink sends a code to the chat handle for the sender to post, as in
+// "config set chat alice.s".
+func (h *handler) startLink(c comment, chat string) {
+	if h.chat == nil {
+		c.post(badOptionResponse(c, "there's no chat integration to link a handle for"), h.username, h.token)
+		return
+	}
+	code, err := h.links.start(chat, c.Sender.Login, time.Now())
+	if err != nil {
+		log.Println("Starting link:", err)
+		c.post(badOptionResponse(c, "couldn't make a code to link with"), h.username, h.token)
+		return
+	}
+	text := fmt.Sprintf("To link this chat account to %s on GitHub, comment `@%s link %s` on a PR within %s. If that wasn't you, ignore this.", c.Sender.Login, h.username, code, linkCodeTTL)
+	go h.chat.send(chat, text)
+	c.post(linkCodeSentResponse(c, chat, linkCodeTTL), h.username, h.token)
+}
+
+// handleLink links the chat handle a code was sent to, or given out for,
+// to the sender of the comment, as in "link 0123456789ab".
+func (h *handler) handleLink(c comment) {
+	fields := strings.Fields(c.parseBody().command)
+	if len(fields) != 2 {
+		c.post(badOptionResponse(c, "say `link CODE` with the code you got in chat"), h.username, h.token)
+		return
+	}
+	chat, ok := h.links.redeem(strings.ToLower(fields[1]), c.Sender.Login, time.Now())
+	if !ok {
+		c.post(badOptionResponse(c, "that code is unknown, expired or for someone else"), h.username, h.token)
+		return
+	}
+	if err := h.prefs.link(c.Sender.Login, chat); err != nil {
+		log.Println("Saving preferences:", err)
+	}
+	h.audit.record(auditEvent{Kind: "link", Repo: c.Repository.FullName, PR: c.Issue.Number, User: c.Sender.Login, Detail: "Linked chat handle " + chat})
+	c.post(linkedResponse(c, chat), h.username, h.token)
+}
+
+// serveLinks serves links to chat integrations: GET with ?chat=HANDLE
+// returns the login linked, and POST with {"chat": HANDLE} returns a code
+// for its user to post on GitHub.
+func (a *adminAPI) serveLinks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		chat := r.URL.Query().Get("chat")
+		login, ok := a.h.prefs.loginFor(chat)
+		if !ok {
+			http.Error(w, "Not linked", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"chat": chat, "login": login})
+
+	case "POST":
+		var req struct {
+			Chat string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Chat == "" {
+			http.Error(w, "chat is required", http.StatusBadRequest)
+			return
+		}
+		code, err := a.h.links.start(req.Chat, "", time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"chat": req.Chat, "code": code, "command": fmt.Sprintf("@%s link %s", a.h.username, code)})
+
+	default:
+		http.Error(w, "GET or POST Expected", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const journalName = "merge-journal.jsonl"
+
+// The merge journal records the steps of each merge as they're taken, so
+// that after a crash we can tell whether the push happened. A merge that
+// was pushed is completed, one that can't have been is retried.
+const (
+	stepIntent    = "intent"    // about to merge, for Comment
+	stepFetched   = "fetched"   // the base branch is at BaseSHA
+	stepCommitted = "committed" // the squashed commit SHA was created locally
+	stepPushing   = "pushing"   // about to push SHA
+	stepPushed    = "pushed"    // SHA was pushed
+	stepDone      = "done"      // the merge was completed or failed
+)
+
+type journalEntry struct {
+	Time     time.Time
+	Repo     string
+	PR       int
+	Step     string
+	Comment  *comment `json:",omitempty"`
+	Delegate string   `json:",omitempty"`
+	Base     string   `json:",omitempty"`
+	BaseSHA  string   `json:",omitempty"`
+	SHA      string   `json:",omitempty"`
+}
+
+var journalMut sync.Mutex
+
+// A mergeJournal writes the journal entries of one merge. Its methods are
+// nil safe, so that merges may go unjournaled.
+type mergeJournal struct {
+	repo string
+	pr   int
+}
+
+// beginJournal records the intent to merge the PR on behalf of the comment.
+func beginJournal(c comment, pr pr, plan mergePlan) *mergeJournal {
+	j := &mergeJournal{repo: c.Repository.FullName, pr: c.Issue.Number}
+	j.write(journalEntry{Step: stepIntent, Comment: &c, Delegate: plan.delegate, Base: pr.Base.Ref})
+	return j
+}
+
+func (j *mergeJournal) step(step, sha string) {
+	if j == nil {
+		return
+	}
+	e := journalEntry{Step: step}
+	if step == stepFetched {
+		e.BaseSHA = sha
+	} else {
+		e.SHA = sha
+	}
+	j.write(e)
+}
+
+// write appends the entry to the journal and syncs it to disk; the entry
+// must be durable before the step it describes is taken.
+func (j *mergeJournal) write(e journalEntry) {
+	e.Time = time.Now()
+	e.Repo = j.repo
+	e.PR = j.pr
+
+	journalMut.Lock()
+	defer journalMut.Unlock()
+	if err := appendSynced(journalName, e); err != nil {
+		log.Println("Merge journal:", err)
+	}
+}
+
+// An interruptedMerge is what the journal knows about a merge that didn't
+// finish.
+type interruptedMerge struct {
+	comment  comment
+	delegate string
+	base     string
+	step     string // the last step taken
+	sha      string // the squashed commit, once created
+}
+
+// readJournal returns the merges the journal has no end for, in the order
+// they were started.
+func readJournal() ([]*interruptedMerge, error) {
+	merges := make(map[string]*interruptedMerge)
+	var order []string
+	err := readStateLines(journalName, func(line []byte) {
+		var e journalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return // torn write at the crash
+		}
+		key := fmt.Sprintf("%s#%d", e.Repo, e.PR)
+		if e.Step == stepIntent && e.Comment != nil {
+			if merges[key] == nil {
+				order = append(order, key)
+			}
+			merges[key] = &interruptedMerge{comment: *e.Comment, delegate: e.Delegate, base: e.Base, step: stepIntent}
+			return
+		}
+		m := merges[key]
+		if m == nil {
+			return
+		}
+		m.step = e.Step
+		if e.SHA != "" {
+			m.sha = e.SHA
+		}
+	})
+	var res []*interruptedMerge
+	for _, key := range order {
+		if m := merges[key]; m.step != stepDone {
+			res = append(res, m)
+		}
+	}
+	return res, err
+}
+
+// recoverMerges deals with the merges that were interrupted by a crash,
+// completing those that were pushed and retrying those that weren't. The
+// journal starts over afterwards.
+func (h *handler) recoverMerges() error {
+	journalMut.Lock()
+	merges, err := readJournal()
+	if err == nil {
+		err = removeState(journalName)
+	}
+	journalMut.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range merges {
+		c := m.comment
+		pushed, err := m.pushed()
+		if err != nil {
+			log.Printf("Recovering merge of PR %d on %s: %v", c.Issue.Number, c.Repository.FullName, err)
+			c.post(mergeInterruptedResponse(c, m.sha), h.username, h.token)
+			continue
+		}
+		if pushed {
+			log.Printf("Completing interrupted merge of PR %d on %s as %s", c.Issue.Number, c.Repository.FullName, m.sha)
+			pr, err := c.getPR()
+			if err != nil {
+				log.Println("No pull request:", err)
+				continue
+			}
+			unlock := h.lockRepo(c.Repository.FullName)
+			h.completeMerge(c, pr, m.sha, mergePlan{delegate: m.delegate}, []string{"The merge was completed after a restart."})
+			unlock()
+			continue
+		}
+		pr, err := c.getPR()
+		if err != nil {
+			log.Println("No pull request:", err)
+			continue
+		}
+		if pr.State != "open" {
+			continue
+		}
+		log.Printf("Retrying interrupted merge of PR %d on %s", c.Issue.Number, c.Repository.FullName)
+		go h.rerun(c)
+	}
+	return nil
+}
+
+// pushed returns whether the squashed commit made it to the base branch.
+func (m *interruptedMerge) pushed() (bool, error) {
+	switch m.step {
+	case stepPushed:
+		return true, nil
+	case stepPushing:
+	default:
+		return false, nil // never attempted
+	}
+
+	repo := m.comment.Repository.FullName
+	s := newScript()
+	s.run("git", "-C", repo, "fetch", "-f", "origin", fmt.Sprintf("%s:orig/%s", m.base, m.base))
+	if s.Error() != nil {
+		return false, fmt.Errorf("%s", s.output.String())
+	}
+	t := newScript()
+	t.run("git", "-C", repo, "merge-base", "--is-ancestor", m.sha, "orig/"+m.base)
+	return t.Error() == nil, nil
+}
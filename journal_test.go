@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestReadJournal(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+
+	var c comment
+	c.Repository.FullName = "foo/bar"
+	var p pr
+	p.Base.Ref = "master"
+
+	c.Issue.Number = 1
+	j := beginJournal(c, p, mergePlan{})
+	j.step(stepFetched, "base")
+	j.step(stepPushing, "abc")
+	j.step(stepPushed, "abc")
+	j.step(stepDone, "")
+
+	c.Issue.Number = 2
+	j = beginJournal(c, p, mergePlan{delegate: "someone"})
+	j.step(stepCommitted, "def")
+	j.step(stepPushing, "def")
+
+	c.Issue.Number = 3
+	j = beginJournal(c, p, mergePlan{})
+	j.step(stepFetched, "base")
+
+	merges, err := readJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merges) != 2 {
+		t.Fatalf("Expected two interrupted merges, got %d", len(merges))
+	}
+	if m := merges[0]; m.comment.Issue.Number != 2 || m.step != stepPushing || m.sha != "def" || m.delegate != "someone" || m.base != "master" {
+		t.Errorf("Unexpected merge %+v", m)
+	}
+	if m := merges[1]; m.comment.Issue.Number != 3 || m.step != stepFetched {
+		t.Errorf("Unexpected merge %+v", m)
+	}
+	if pushed, err := merges[1].pushed(); pushed || err != nil {
+		t.Error("A merge that never pushed can't have been pushed")
+	}
+}
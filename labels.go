@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// A sizeLabel is applied to PRs changing at most Max lines. The size labels
+// are considered in order and the first match is used; a zero Max matches any
+// size.
+type sizeLabel struct {
+	Label string
+	Max   int
+}
+
+type prFile struct {
+	Filename  string
+	Additions int
+	Deletions int
+}
+
+func (p *pr) getFiles(username, token string) ([]prFile, error) {
+	prURL := p.PullRequest.URL
+	if prURL == "" {
+		prURL = p.URL
+	}
+	var res []prFile
+	for page := 1; ; page++ {
+		var files []prFile
+		url := fmt.Sprintf("%s/files?per_page=100&page=%d", prURL, page)
+		if err := apiRequest("GET", url, nil, &files, username, token); err != nil {
+			return nil, err
+		}
+		res = append(res, files...)
+		if len(files) < 100 {
+			return res, nil
+		}
+	}
+}
+
+// prLabels returns the size and area labels that should be set, given the
+// changed files.
+func prLabels(files []prFile, sizes []sizeLabel, areas map[string][]string) []string {
+	changed := 0
+	for _, f := range files {
+		changed += f.Additions + f.Deletions
+	}
+
+	var res []string
+	for _, s := range sizes {
+		if s.Max == 0 || changed <= s.Max {
+			res = append(res, s.Label)
+			break
+		}
+	}
+
+	for label, prefixes := range areas {
+	files:
+		for _, f := range files {
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(f.Filename, prefix) {
+					res = append(res, label)
+					break files
+				}
+			}
+		}
+	}
+
+	sort.Strings(res)
+	return res
+}
+
+// labelPR sets the size and area labels on the PR according to the
+// repository settings, removing size and area labels that no longer apply.
+func (h *handler) labelPR(p pr) {
+	rs := h.settings.forRepo(p.Repository.FullName)
+	if len(rs.SizeLabels) == 0 && len(rs.AreaLabels) == 0 {
+		return
+	}
+
+	files, err := p.getFiles(h.username, h.token)
+	if err != nil {
+		log.Println("Getting PR files:", err)
+		return
+	}
+	want := prLabels(files, rs.SizeLabels, rs.AreaLabels)
+
+	managed := make(map[string]bool)
+	for _, s := range rs.SizeLabels {
+		managed[s.Label] = true
+	}
+	for label := range rs.AreaLabels {
+		managed[label] = true
+	}
+
+	labelsURL := fmt.Sprintf("%s/repos/%s/issues/%d/labels", githubAPI, p.Repository.FullName, p.Number)
+	var current []struct {
+		Name string
+	}
+	if err := apiRequest("GET", labelsURL, nil, &current, h.username, h.token); err != nil {
+		return
+	}
+
+	wanted := make(map[string]bool)
+	for _, label := range want {
+		wanted[label] = true
+	}
+	for _, label := range current {
+		if managed[label.Name] && !wanted[label.Name] {
+			apiRequest("DELETE", labelsURL+"/"+url.PathEscape(label.Name), nil, nil, h.username, h.token)
+		}
+	}
+
+	if len(want) > 0 {
+		apiRequest("POST", labelsURL, map[string][]string{"labels": want}, nil, h.username, h.token)
+	}
+}
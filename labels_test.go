@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPRLabels(t *testing.T) {
+	sizes := []sizeLabel{{"size/S", 10}, {"size/M", 100}, {"size/L", 0}}
+	areas := map[string][]string{
+		"area/frontend": {"web/", "ui/"},
+		"area/docs":     {"docs/"},
+	}
+
+	cases := []struct {
+		files  []prFile
+		labels []string
+	}{
+		{nil, []string{"size/S"}},
+		{[]prFile{{"web/index.html", 5, 5}}, []string{"area/frontend", "size/S"}},
+		{[]prFile{{"ui/a.js", 50, 0}, {"docs/a.md", 50, 0}}, []string{"area/docs", "area/frontend", "size/M"}},
+		{[]prFile{{"main.go", 500, 0}}, []string{"size/L"}},
+	}
+
+	for _, tc := range cases {
+		if labels := prLabels(tc.files, sizes, areas); !reflect.DeepEqual(labels, tc.labels) {
+			t.Errorf("Got labels %v for %v, expected %v", labels, tc.files, tc.labels)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import "sync"
+
+// repoLocks serializes the work on each repository, and with it the use of
+// its clone, so that unrelated repositories are handled concurrently.
+type repoLocks struct {
+	mut   sync.Mutex
+	locks map[string]chan struct{} // "owner/name" -> lock, held while full
+}
+
+func (l *repoLocks) get(repo string) chan struct{} {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if l.locks == nil {
+		l.locks = make(map[string]chan struct{})
+	}
+	ch := l.locks[repo]
+	if ch == nil {
+		ch = make(chan struct{}, 1)
+		l.locks[repo] = ch
+	}
+	return ch
+}
+
+// lock locks the repository, returning the function to unlock it.
+func (l *repoLocks) lock(repo string) func() {
+	ch := l.get(repo)
+	ch <- struct{}{}
+	return func() { <-ch }
+}
+
+// tryLock locks the repository if it isn't locked already, returning the
+// function to unlock it, or nil.
+func (l *repoLocks) tryLock(repo string) func() {
+	ch := l.get(repo)
+	select {
+	case ch <- struct{}{}:
+		return func() { <-ch }
+	default:
+		return nil
+	}
+}
+
+// lockRepo locks the repository for the handler, as in
+//
+//	defer h.lockRepo(repo)()
+//
+// Locks on several repositories must not be waited for at once. The
+// handler mutex may be taken while holding a repository lock, but not the
+// other way around.
+func (h *handler) lockRepo(repo string) func() {
+	return h.repos.lock(repo)
+}
+
+// lockGroup locks the concurrency group of the repository, if it's in one,
+// so that merges across the group land one at a time, returning the
+// function to unlock it. If another merge holds it, queued is called before
+// waiting for it, which may take a while: it's taken before the lock of the
+// repository, never while holding it, and not while handling an event.
+func (h *handler) lockGroup(repo string, queued func(group string)) func() {
+	group := h.settings.forRepo(repo).ConcurrencyGroup
+	if group == "" {
+		return func() {}
+	}
+	if unlock := h.groups.tryLock(group); unlock != nil {
+		return unlock
+	}
+	if queued != nil {
+		queued(group)
+	}
+	return h.groups.lock(group)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRepoLocks(t *testing.T) {
+	var l repoLocks
+	unlock := l.lock("foo/bar")
+
+	// Other repositories aren't held up.
+	done := make(chan struct{})
+	go func() {
+		l.lock("foo/baz")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Lock on another repository blocked")
+	}
+
+	// The same repository is.
+	locked := make(chan struct{})
+	go func() {
+		l.lock("foo/bar")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("Lock on a locked repository didn't block")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Lock not released")
+	}
+}
+
+func TestRepoTryLock(t *testing.T) {
+	var l repoLocks
+	unlock := l.lock("foo/bar")
+	if l.tryLock("foo/bar") != nil {
+		t.Error("Expected a locked repository to fail")
+	}
+	other := l.tryLock("foo/baz")
+	if other == nil {
+		t.Fatal("Expected another repository to lock")
+	}
+	other()
+	unlock()
+	if again := l.tryLock("foo/bar"); again == nil {
+		t.Error("Expected an unlocked repository to lock")
+	} else {
+		again()
+	}
+}
+
+func TestLockGroup(t *testing.T) {
+	h := newHandler(nil, "bot", "token", false)
+	h.settings.repos = map[string]repoSettings{
+		"corp/*": {ConcurrencyGroup: "deploy"},
+	}
+	unlock := h.lockGroup("corp/api", func(string) { t.Error("Expected a free group not to queue") })
+
+	// Repositories outside the group aren't held up.
+	h.lockGroup("foo/bar", func(string) { t.Error("Expected no group for foo/bar") })()
+
+	// Others in the group queue until the merge is done.
+	queued := make(chan string, 1)
+	locked := make(chan struct{})
+	go func() {
+		h.lockGroup("corp/web", func(group string) { queued <- group })()
+		close(locked)
+	}()
+	select {
+	case group := <-queued:
+		if group != "deploy" {
+			t.Errorf("Queued in group %q", group)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected corp/web to queue")
+	}
+	select {
+	case <-locked:
+		t.Fatal("Lock on a busy group didn't block")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Group lock not released")
+	}
+}
+
+func TestLandMergeInGroup(t *testing.T) {
+	var mut sync.Mutex
+	state := "open"
+	var comments []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		defer mut.Unlock()
+		switch r.URL.Path {
+		case "/repos/corp/web/pulls/1":
+			fmt.Fprintf(w, `{"number": 1, "state": %q, "base": {"ref": "main"}, "head": {"sha": "abc"}}`, state)
+		case "/repos/corp/web/issues/1/comments":
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			comments = append(comments, body.Body)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	h := newHandler([]string{"alice"}, "bot", "token", false)
+	h.settings.repos = map[string]repoSettings{
+		"corp/*": {ConcurrencyGroup: "deploy"},
+	}
+	var c comment
+	c.Repository.FullName = "corp/web"
+	c.Sender.Login = "alice"
+	c.Comment.Body = "@bot merge"
+	c.Issue.Number = 1
+	c.Issue.PullRequest.URL = srv.URL + "/repos/corp/web/pulls/1"
+	c.Issue.CommentsURL = srv.URL + "/repos/corp/web/issues/1/comments"
+	var p pr
+	p.Number = 1
+	p.State = "open"
+	p.Base.Ref = "main"
+	p.Head.SHA = "abc"
+
+	// Another merge in the group is landing, and the PR is closed while
+	// this one waits for it.
+	unlockGroup := h.lockGroup("corp/api", nil)
+	done := make(chan struct{})
+	go func() {
+		h.landMerge(c, p, nil)
+		close(done)
+	}()
+	for queued := false; !queued; time.Sleep(10 * time.Millisecond) {
+		mut.Lock()
+		queued = len(comments) == 1 && strings.Contains(comments[0], "`deploy` concurrency group")
+		state = "closed"
+		mut.Unlock()
+	}
+
+	// The events of the repository aren't held up meanwhile.
+	h.lockRepo("corp/web")()
+
+	unlockGroup()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Merge still queued")
+	}
+	mut.Lock()
+	defer mut.Unlock()
+	if len(comments) != 2 || !strings.Contains(comments[1], "the PR was closed") {
+		t.Errorf("Expected the merge to be rechecked once it's its turn, got %q", comments)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel orders log entries by how much they matter.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+func parseLogLevel(s string) (logLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return logLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("log level %q is not one of %s", s, strings.Join(logLevelNames, ", "))
+}
+
+// logFields are what a log entry is about, where known. Zero valued fields
+// are left out.
+type logFields struct {
+	Repo     string
+	PR       int
+	Actor    string // who the entry is on behalf of
+	Command  string
+	Duration time.Duration
+	Err      error
+}
+
+// commentFields returns the fields for the comment with the command.
+func commentFields(c comment, command string) logFields {
+	return logFields{Repo: c.Repository.FullName, PR: c.Issue.Number, Actor: c.Sender.Login, Command: command}
+}
+
+// A logger writes log entries at or above its level as text lines, or as
+// JSON lines for log aggregation systems. It also takes the lines of the
+// standard logger, at info level unless they're alerts.
+type logger struct {
+	mut   sync.Mutex
+	out   io.Writer
+	json  bool
+	level logLevel
+	now   func() time.Time
+}
+
+var defaultLogger = &logger{out: os.Stderr, level: levelInfo, now: time.Now}
+
+func (l *logger) log(level logLevel, msg string, f logFields) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if level < l.level {
+		return
+	}
+	if l.json {
+		l.out.Write(append(l.jsonEntry(level, msg, f), '\n'))
+		return
+	}
+	l.out.Write([]byte(l.textEntry(level, msg, f) + "\n"))
+}
+
+func (l *logger) jsonEntry(level logLevel, msg string, f logFields) []byte {
+	entry := struct {
+		Time     time.Time `json:"time"`
+		Level    string    `json:"level"`
+		Msg      string    `json:"msg"`
+		Repo     string    `json:"repo,omitempty"`
+		PR       int       `json:"pr,omitempty"`
+		Actor    string    `json:"actor,omitempty"`
+		Command  string    `json:"command,omitempty"`
+		Duration float64   `json:"duration_seconds,omitempty"`
+		Err      string    `json:"error,omitempty"`
+	}{Time: l.now().UTC(), Level: level.String(), Msg: msg, Repo: f.Repo, PR: f.PR, Actor: f.Actor, Command: f.Command, Duration: f.Duration.Seconds()}
+	if f.Err != nil {
+		entry.Err = f.Err.Error()
+	}
+	bs, _ := json.Marshal(entry)
+	return bs
+}
+
+func (l *logger) textEntry(level logLevel, msg string, f logFields) string {
+	parts := []string{l.now().Format("2006/01/02 15:04:05"), strings.ToUpper(level.String()), msg}
+	if f.Repo != "" {
+		parts = append(parts, "repo="+f.Repo)
+	}
+	if f.PR != 0 {
+		parts = append(parts, fmt.Sprintf("pr=%d", f.PR))
+	}
+	if f.Actor != "" {
+		parts = append(parts, "actor="+f.Actor)
+	}
+	if f.Command != "" {
+		parts = append(parts, "command="+f.Command)
+	}
+	if f.Duration != 0 {
+		parts = append(parts, "duration="+f.Duration.String())
+	}
+	if f.Err != nil {
+		parts = append(parts, fmt.Sprintf("error=%q", f.Err.Error()))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Write takes a line from the standard logger, which is set to add no
+// prefix of its own.
+func (l *logger) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	level := levelInfo
+	if strings.HasPrefix(msg, "ALERT: ") {
+		level, msg = levelError, strings.TrimPrefix(msg, "ALERT: ")
+	}
+	l.log(level, msg, logFields{})
+	return len(p), nil
+}
+
+func logDebug(msg string, f logFields) { defaultLogger.log(levelDebug, msg, f) }
+func logInfo(msg string, f logFields)  { defaultLogger.log(levelInfo, msg, f) }
+func logWarn(msg string, f logFields)  { defaultLogger.log(levelWarn, msg, f) }
+func logError(msg string, f logFields) { defaultLogger.log(levelError, msg, f) }
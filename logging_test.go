@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestLogger(t *testing.T) {
+	now := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	merge := logFields{Repo: "o/r", PR: 3, Actor: "alice", Command: "merge"}
+	failed := merge
+	failed.Err = errors.New("conflict in a.go")
+	handled := merge
+	handled.Duration = 1500 * time.Millisecond
+
+	cases := []struct {
+		name  string
+		json  bool
+		level logLevel
+		log   func(l *logger)
+		want  string
+	}{
+		{"text", false, levelInfo, func(l *logger) { l.log(levelInfo, "Attempting merge", merge) },
+			"2021/03/04 05:06:07 INFO Attempting merge repo=o/r pr=3 actor=alice command=merge\n"},
+		{"text error", false, levelInfo, func(l *logger) { l.log(levelError, "Failed merge", failed) },
+			"2021/03/04 05:06:07 ERROR Failed merge repo=o/r pr=3 actor=alice command=merge error=\"conflict in a.go\"\n"},
+		{"text duration", false, levelInfo, func(l *logger) { l.log(levelInfo, "Handled command", handled) },
+			"2021/03/04 05:06:07 INFO Handled command repo=o/r pr=3 actor=alice command=merge duration=1.5s\n"},
+		{"json", true, levelInfo, func(l *logger) { l.log(levelError, "Failed merge", failed) },
+			`{"time":"2021-03-04T05:06:07Z","level":"error","msg":"Failed merge","repo":"o/r","pr":3,"actor":"alice","command":"merge","error":"conflict in a.go"}` + "\n"},
+		{"json duration", true, levelInfo, func(l *logger) { l.log(levelInfo, "Handled command", handled) },
+			`{"time":"2021-03-04T05:06:07Z","level":"info","msg":"Handled command","repo":"o/r","pr":3,"actor":"alice","command":"merge","duration_seconds":1.5}` + "\n"},
+		{"below level", false, levelWarn, func(l *logger) { l.log(levelInfo, "Attempting merge", merge) }, ""},
+		{"debug", false, levelDebug, func(l *logger) { l.log(levelDebug, "Running command", logFields{}) },
+			"2021/03/04 05:06:07 DEBUG Running command\n"},
+		{"standard logger", true, levelInfo, func(l *logger) { log.New(l, "", 0).Println("Saving links:", "disk full") },
+			`{"time":"2021-03-04T05:06:07Z","level":"info","msg":"Saving links: disk full"}` + "\n"},
+		{"standard logger alert", false, levelInfo, func(l *logger) { log.New(l, "", 0).Printf("ALERT: unusual merging by %s", "mallory") },
+			"2021/03/04 05:06:07 ERROR unusual merging by mallory\n"},
+		{"standard logger below level", false, levelError, func(l *logger) { log.New(l, "", 0).Println("Merge log: gone") }, ""},
+	}
+	for _, tc := range cases {
+		var buf bytes.Buffer
+		l := &logger{out: &buf, json: tc.json, level: tc.level, now: func() time.Time { return now }}
+		tc.log(l)
+		if got := buf.String(); got != tc.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	cases := []struct {
+		in    string
+		want  logLevel
+		valid bool
+	}{
+		{"debug", levelDebug, true},
+		{"INFO", levelInfo, true},
+		{"warn", levelWarn, true},
+		{"error", levelError, true},
+		{"verbose", 0, false},
+	}
+	for _, tc := range cases {
+		got, err := parseLogLevel(tc.in)
+		if (err == nil) != tc.valid || got != tc.want {
+			t.Errorf("parseLogLevel(%q) = %v, %v", tc.in, got, err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// GitHub refuses comments longer than 65536 characters; we stay below that
+// with room for the continuation notes.
+var maxCommentLength = 65000
+
+var (
+	logsURL       string // public URL the full text of truncated comments is served under, if any
+	splitComments int    // how many comments a long body may be split across; zero to truncate instead
+)
+
+// commentBodies prepares a body for posting as one or more comments. A body
+// that is too long is split across comments when configured to, and
+// otherwise truncated with a link to the full text when that can be served.
+func commentBodies(body string) []string {
+	if utf8.RuneCountInString(body) <= maxCommentLength {
+		return []string{body}
+	}
+
+	parts := splitBody(body, maxCommentLength)
+	if splitComments > 0 && len(parts) <= splitComments {
+		for i := range parts {
+			parts[i] += fmt.Sprintf("\n\n(part %d of %d)", i+1, len(parts))
+		}
+		return parts
+	}
+
+	note := "\n\n:scissors: The rest was cut, as it's too long for a comment."
+	if logsURL != "" {
+		if url, err := saveLog(body); err != nil {
+			log.Println("Saving full comment:", err)
+		} else {
+			note = fmt.Sprintf("\n\n:scissors: The rest was cut, as it's too long for a comment. The full text is at %s.", url)
+		}
+	}
+	return []string{parts[0] + note}
+}
+
+var fenceRe = regexp.MustCompile("^\\s*(```+)(.*)$")
+
+// splitBody splits the body into parts of at most max characters, at line
+// boundaries where possible. A code block that spans parts is closed at the
+// end of one and reopened at the start of the next.
+func splitBody(body string, max int) []string {
+	var parts []string
+	var cur strings.Builder
+	curLen := 0
+	fence := ""   // the line opening the current code block, if in one
+	closing := "" // the backticks closing it
+
+	flush := func() {
+		s := cur.String()
+		if fence != "" {
+			s += closing + "\n"
+		}
+		parts = append(parts, s)
+		cur.Reset()
+		curLen = 0
+		if fence != "" {
+			cur.WriteString(fence)
+			curLen = utf8.RuneCountInString(fence)
+		}
+	}
+
+	for _, line := range strings.SplitAfter(body, "\n") {
+		if line == "" {
+			continue
+		}
+		for {
+			room := max - curLen - len(closing) - 4 // for a closing fence
+			n := utf8.RuneCountInString(line)
+			if n <= room {
+				break
+			}
+			if curLen > len(fence) {
+				flush()
+				continue
+			}
+			// A single line that's too long on its own is broken.
+			cur.WriteString(string([]rune(line)[:room-1]) + "\n")
+			curLen += room
+			line = string([]rune(line)[room-1:])
+			flush()
+		}
+		cur.WriteString(line)
+		curLen += utf8.RuneCountInString(line)
+		if m := fenceRe.FindStringSubmatch(strings.TrimRight(line, "\n")); m != nil {
+			switch {
+			case fence == "":
+				fence, closing = strings.TrimSpace(line)+"\n", m[1]
+			case len(m[1]) >= len(closing) && strings.TrimSpace(m[2]) == "":
+				fence, closing = "", ""
+			}
+		}
+	}
+	if curLen > 0 {
+		parts = append(parts, cur.String())
+	}
+	return parts
+}
+
+const logsDir = "logs"
+
+var logIDRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// saveLog stores the text for serving and returns its public URL.
+func saveLog(text string) (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	name := hex.EncodeToString(id[:])
+	dir := filepath.Join(stateDir, logsDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".txt"), []byte(text), 0600); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(logsURL, "/") + "/" + name, nil
+}
+
+// The logs server serves the full text of truncated comments at
+// /logs/<id>. The ids are random, so this is not authenticated.
+type logs struct{}
+
+func (logs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/logs/")
+	if !logIDRe.MatchString(id) {
+		http.NotFound(w, r)
+		return
+	}
+	bs, err := ioutil.ReadFile(filepath.Join(stateDir, logsDir, id+".txt"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(bs)
+}
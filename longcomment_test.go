@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitBody(t *testing.T) {
+	body := "Merge failed:\n\n```\n" + strings.Repeat("error: something\n", 10) + "```\n"
+	parts := splitBody(body, 60)
+	if len(parts) < 2 {
+		t.Fatalf("Expected the body to be split, got %q", parts)
+	}
+	for i, p := range parts {
+		if len(p) > 60 {
+			t.Errorf("Part %d is too long: %q", i, p)
+		}
+		if strings.Count(p, "```")%2 != 0 {
+			t.Errorf("Part %d has an unbalanced code block: %q", i, p)
+		}
+	}
+	if joined := strings.Join(parts, ""); !strings.Contains(joined, strings.Repeat("error: something\n", 2)) {
+		t.Errorf("Lost lines in %q", joined)
+	}
+
+	parts = splitBody(strings.Repeat("x", 25), 10)
+	if joined := strings.Replace(strings.Join(parts, ""), "\n", "", -1); len(parts) < 3 || joined != strings.Repeat("x", 25) {
+		t.Errorf("Expected a long line to be broken, got %q", parts)
+	}
+}
+
+func TestCommentBodies(t *testing.T) {
+	defer func(max, split int, url, dir string) {
+		maxCommentLength, splitComments, logsURL, stateDir = max, split, url, dir
+	}(maxCommentLength, splitComments, logsURL, stateDir)
+	maxCommentLength = 100
+	stateDir = t.TempDir()
+	body := strings.Repeat("line of output\n", 20)
+
+	if res := commentBodies("short"); len(res) != 1 || res[0] != "short" {
+		t.Errorf("Unexpected bodies %q", res)
+	}
+
+	logsURL = "https://bot.example.com/logs/"
+	res := commentBodies(body)
+	if len(res) != 1 || !strings.Contains(res[0], "https://bot.example.com/logs/") {
+		t.Errorf("Expected a truncated body with a link, got %q", res)
+	}
+
+	splitComments = 5
+	if res := commentBodies(body); len(res) != 4 || !strings.HasSuffix(res[3], "(part 4 of 4)") {
+		t.Errorf("Expected the body split in four, got %q", res)
+	}
+}
+
+func TestSplitBodyLongFence(t *testing.T) {
+	body := "````console\n" + strings.Repeat("```\n", 20) + "````\n"
+	for i, p := range splitBody(body, 40) {
+		if !strings.HasPrefix(p, "````console\n") || !strings.HasSuffix(p, "````\n") {
+			t.Errorf("Part %d doesn't keep the code block: %q", i, p)
+		}
+	}
+}
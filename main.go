@@ -1,10 +1,16 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/thejerf/suture"
 )
@@ -12,30 +18,390 @@ import (
 func main() {
 	listenAddr := flag.String("listen", ":8080", "Listen address")
 	secret := flag.String("secret", "", "Github webhook secret")
-	token := flag.String("token", "", "Github access token")
+	token := flag.String("token", "", "Github access token (not needed with -app-id)")
+	appID := flag.Int64("app-id", 0, "GitHub App ID, for authenticating as an app installation instead of with -token")
+	appKey := flag.String("app-key", "", "Private key (PEM) of the GitHub App")
 	username := flag.String("username", "", "Github user name")
 	allow := flag.String("allow", "", "Comma separeted list of allowed maintainers")
 	branches := flag.Bool("branches", false, "Keep and update branches for PRs")
+	apiURL := flag.String("api-url", githubAPI, "Base URL of the GitHub API (https://host/api/v3 for GitHub Enterprise)")
+	uploadURL := flag.String("upload-url", "", "Base URL for GitHub uploads (derived from -api-url if empty)")
+	flag.StringVar(&gitlabAPI, "gitlab-url", gitlabAPI, "Base URL of the GitLab API, for repositories set up as on GitLab")
+	gitlabToken := flag.String("gitlab-token", "", "GitLab access token (GitLab repositories are not served if empty)")
+	flag.StringVar(&giteaAPI, "gitea-url", giteaAPI, "Base URL of the Gitea or Forgejo API, for repositories set up as on Gitea")
+	giteaToken := flag.String("gitea-token", "", "Gitea or Forgejo access token (Gitea repositories are not served if empty)")
+	cloneURL := flag.String("clone-url", defaultCloneURL, "Default clone URL template, expanding {repo}, {owner} and {name}")
+	useHTTPS := flag.Bool("https", false, "Clone and push over HTTPS with the token, or installation tokens as an app, given to git by a credential helper (needs git 2.31)")
+	usersFile := flag.String("users", "", "JSON file mapping repositories to allowed users, instead of asking GitHub for collaborators")
+	hookURL := flag.String("hook-url", "", "Public URL of the webhook receiver, for onboarding repositories")
+	hookCheck := flag.Duration("hook-check", 0, "Interval between webhook health checks (disabled if zero)")
+	hookRepair := flag.Bool("hook-repair", false, "Repair webhooks that fail the health check")
+	protection := flag.String("protection", "", "Branch protection template file to check served repositories against")
+	protectionCheck := flag.Duration("protection-check", time.Hour, "Interval between branch protection checks")
+	protectionFix := flag.Bool("protection-fix", false, "Correct branch protection that differs from the template")
+	stale := make(durationsFlag)
+	flag.Var(stale, "stale", "Comma separated list of context=duration after which a pending status is stale (use * for any context)")
+	staleIgnore := flag.Bool("stale-ignore", false, "Ignore stale statuses instead of considering them failed")
+	maxWait := flag.Duration("max-wait", maxWaitTime, "How long to wait for pending statuses before giving up")
+	maxPoll := flag.Duration("max-poll", maxPollTime, "The longest interval between polls of pending statuses")
+	maxWaitCap := flag.Duration("max-wait-cap", 2*time.Hour, "How long to keep waiting at most while pending statuses are making progress")
+	mergeTimeout := flag.Duration("merge-timeout", 15*time.Minute, "How long a merge may take before it's abandoned (no limit if zero)")
+	greet := flag.Bool("greet", false, "Welcome first time contributors")
+	chatURL := flag.String("chat-url", "", "URL to POST direct messages to as {\"user\": ..., \"text\": ...}, for users who prefer chat notifications")
+	digestInterval := flag.Duration("digest-interval", 24*time.Hour, "Interval between digests for users who prefer them")
+	anomalyCheck := flag.Duration("anomaly-check", time.Hour, "Interval between looking for unusual merging to alert admins of (disabled if zero)")
+	oddHoursFlag := flag.String("odd-hours", "", "Hours of the day when merges are unusual, as FROM-TO in local time such as 22-6")
+	flag.StringVar(&stateDir, "state", stateDir, "Directory for persistent state")
+	stateDriver := flag.String("state-store", storeFile, "Where to keep pending merges, preferences and logs: file (in the state directory), memory (lost on restart) or sql")
+	stateSQLDriver := flag.String("state-sql-driver", "", "database/sql driver for the sql state store, built in with its build tag (such as postgres)")
+	stateSQLDSN := flag.String("state-sql-dsn", "", "Data source name of the database for the sql state store")
+	teamsFile := flag.String("teams", "", "JSON file mapping team names to members, for reporting")
+	settingsFile := flag.String("settings", "", "JSON, YAML or TOML file with per repository settings, by extension; TOML without inline tables, arrays of tables or multi-line values (the defaults may also be set in the environment, as "+settingsEnvPrefix+"MAX_WAIT=2h)")
+	settingsSchema := flag.String("settings-schema", "", "Print the schema of the settings, as json (a JSON Schema) or markdown, and exit")
+	serveFeeds := flag.Bool("feeds", false, "Publish merge feeds under /feeds/ (without authentication)")
+	apiProxy := flag.String("api-proxy", "", "HTTP(S) or SOCKS5 proxy URL for GitHub API requests")
+	gitProxy := flag.String("git-proxy", "", "HTTP(S) or SOCKS5 proxy URL for git operations")
+	gitPath := flag.String("git", "git", "Git binary to run, by name in the PATH or a path")
+	gitEnvFlag := flag.String("git-env", "", "Comma separated NAME=VALUE pairs to add to the environment of git")
+	gitCleanEnv := flag.Bool("git-clean-env", false, "Run git with just PATH, HOME, TMPDIR and SSH_AUTH_SOCK from the environment, and -git-env")
+	noProxy := flag.String("no-proxy", "", "Comma separated list of hosts, domains and networks not to proxy")
+	attestKey := flag.String("attest-key", "", "Ed25519 private key (PEM) for signing merge attestations (disabled if empty)")
+	attestUpload := flag.String("attest-upload", "", "URL to POST signed merge attestations to")
+	auditFormat := flag.String("audit-format", "cef", "Format of exported audit events, cef or json")
+	auditSyslog := flag.String("audit-syslog", "", "Syslog to export audit events to, as udp://host:port, tcp://host:port or local")
+	auditWebhook := flag.String("audit-webhook", "", "URL to POST audit events to")
+	auditLog := flag.Bool("audit-log", true, "Append audit events to "+auditLogName+" in the state directory, for querying at /admin/audit")
+	flag.StringVar(&opaBinary, "opa", opaBinary, "Open Policy Agent binary, for evaluating merge policies")
+	configRepoURL := flag.String("config-repo", "", "Git repository with settings, teams and response templates, overriding -settings and -teams")
+	configCheck := flag.Duration("config-check", 5*time.Minute, "Interval between updates from the configuration repository")
+	flag.StringVar(&logsURL, "logs-url", "", "Public URL of /logs/ on this server, for linking the full text of truncated comments (not served if empty)")
+	flag.IntVar(&splitComments, "split-comments", 0, "Split long comments across up to this many comments instead of truncating")
+	adminToken := flag.String("admin-token", "", "Bearer token for the admin API (disabled if empty)")
+	exportPath := flag.String("export", "", "Write the state to this archive and exit, for moving to another host (stop the bot or enter maintenance first)")
+	importPath := flag.String("import", "", "Unpack the state from this archive into the empty state directory and exit")
+	var diskQuota byteSize
+	flag.Var(&diskQuota, "disk-quota", "Disk space for all clones together, like 20G, evicting the least recently merged to make room (unlimited if zero)")
+	logLevelFlag := flag.String("log-level", "info", "Least level of log entries to write: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "Format of log entries: text, or json for log aggregation systems")
+	redactFiles := flag.String("redact-files", "", "Comma separated list of key files (such as the SSH key) whose contents are masked in logs and comments")
 	flag.Parse()
 
-	if *secret == "" || *token == "" || *username == "" {
-		fmt.Println("Must set Github webhook secret, Github access token, and Github user name")
+	level, err := parseLogLevel(*logLevelFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *logFormat != "text" && *logFormat != "json" {
+		fmt.Println("Log format must be text or json")
+		os.Exit(1)
+	}
+	defaultLogger.out = redactingWriter{os.Stderr}
+	defaultLogger.level = level
+	defaultLogger.json = *logFormat == "json"
+	log.SetFlags(0)
+	log.SetOutput(defaultLogger)
+	secrets.addBasicAuth(*username, *token)
+	secrets.add(*secret, *adminToken, *gitlabToken, *giteaToken, *stateSQLDSN)
+	for _, path := range strings.Split(*redactFiles, ",") {
+		if path == "" {
+			continue
+		}
+		if err := secrets.addFile(path); err != nil {
+			fmt.Println("Loading file to redact:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *exportPath != "" || *importPath != "" {
+		if err := runStateArchive(*stateDriver, *exportPath, *importPath); err != nil {
+			fmt.Println("State archive:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	defaults := repoSettings{CloneURL: *cloneURL, MaxWait: duration{*maxWait}, MaxPoll: duration{*maxPoll}, MaxWaitCap: duration{*maxWaitCap}, MergeTimeout: duration{*mergeTimeout}, Greet: greet, WebhookSecret: *secret}
+	if err := settingsFromEnv(os.Environ(), &defaults); err != nil {
+		fmt.Println("Settings in the environment:", err)
+		os.Exit(1)
+	}
+	secrets.add(defaults.WebhookSecret)
+	if *settingsSchema != "" {
+		if err := writeSettingsSchema(os.Stdout, *settingsSchema, defaults); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if *secret == "" || (*token == "" && *appID == 0) || *username == "" {
+		fmt.Println("Must set Github webhook secret, Github access token or app ID, and Github user name")
+		os.Exit(1)
+	}
+	if *appID != 0 && *appKey == "" {
+		fmt.Println("Must set the GitHub App private key with -app-key")
 		os.Exit(1)
 	}
 
 	allowedUsers := strings.Split(*allow, ",")
 
+	// Commands run in the clones, so state must be somewhere absolute for
+	// them to find it.
+	if stateDir, err = filepath.Abs(stateDir); err != nil {
+		fmt.Println("State directory:", err)
+		os.Exit(1)
+	}
+	if store, err = openStore(*stateDriver, *stateSQLDriver, *stateSQLDSN); err != nil {
+		fmt.Println("State store:", err)
+		os.Exit(1)
+	}
+
+	githubAPI = strings.TrimRight(*apiURL, "/")
+	githubUploads = uploadsURL(githubAPI)
+	if *uploadURL != "" {
+		githubUploads = strings.TrimRight(*uploadURL, "/")
+	}
+	gitlabAPI = strings.TrimRight(gitlabAPI, "/")
+	if *gitlabToken != "" {
+		forges[forgeGitLab] = gitlabForge{token: *gitlabToken}
+	}
+	giteaAPI = strings.TrimRight(giteaAPI, "/")
+	if *giteaToken != "" {
+		forges[forgeGitea] = giteaForge{token: *giteaToken}
+	}
+
+	if err := setGitCommand(*gitPath, *gitEnvFlag, *gitCleanEnv); err != nil {
+		fmt.Println("Git:", err)
+		os.Exit(1)
+	}
+
+	noProxyList := strings.Split(*noProxy, ",")
+	if *apiProxy != "" {
+		if err := setAPIProxy(*apiProxy, noProxyList); err != nil {
+			fmt.Println("API proxy:", err)
+			os.Exit(1)
+		}
+	}
+	if *gitProxy != "" {
+		if err := setGitProxy(*gitProxy, noProxyList); err != nil {
+			fmt.Println("Git proxy:", err)
+			os.Exit(1)
+		}
+	}
+
+	var creds *gitCredentials
+	if *useHTTPS {
+		if creds, err = setGitCredentials(*token); err != nil {
+			fmt.Println("Git credentials:", err)
+			os.Exit(1)
+		}
+		if defaults.CloneURL == defaultCloneURL {
+			defaults.CloneURL = httpsCloneURL(githubAPI)
+		}
+	}
+
+	var app *githubApp
+	if *appID != 0 {
+		if app, err = loadGitHubApp(*appID, *appKey); err != nil {
+			fmt.Println("Loading GitHub App key:", err)
+			os.Exit(1)
+		}
+		if err := setGitHubApp(app); err != nil {
+			fmt.Println("GitHub App:", err)
+			os.Exit(1)
+		}
+		if defaults.CloneURL == defaultCloneURL {
+			defaults.CloneURL = appCloneURL(githubAPI)
+		}
+	} else if defaults.CloneURL == defaultCloneURL {
+		defaults.CloneURL = sshCloneURL(githubAPI)
+	}
+
 	s := newHandler(allowedUsers, *username, *token, *branches)
+	s.app = app
+	s.creds = creds
+	s.hookURL = *hookURL
+	s.diskQuota = diskQuota
+	s.staleness = staleness{thresholds: stale, ignore: *staleIgnore}
+	if s.settings, err = loadSettings(*settingsFile, defaults); err != nil {
+		fmt.Println("Loading settings:", err)
+		os.Exit(1)
+	}
+	verbosityOf = func(repo string) string { return s.settings.forRepo(repo).Verbosity }
+	if *teamsFile != "" {
+		bs, err := ioutil.ReadFile(*teamsFile)
+		if err == nil {
+			err = json.Unmarshal(bs, &s.teams)
+		}
+		if err != nil {
+			fmt.Println("Loading teams:", err)
+			os.Exit(1)
+		}
+	}
+	if *usersFile != "" {
+		bs, err := ioutil.ReadFile(*usersFile)
+		if err == nil {
+			err = json.Unmarshal(bs, &s.directory)
+		}
+		if err != nil {
+			fmt.Println("Loading users:", err)
+			os.Exit(1)
+		}
+	}
+	if *attestKey != "" {
+		if s.attestor, err = loadAttestor(*attestKey, *attestUpload); err != nil {
+			fmt.Println("Loading attestation key:", err)
+			os.Exit(1)
+		}
+		log.Println("Signing merge attestations with key", s.attestor.keyID)
+	}
+	if *auditSyslog != "" || *auditWebhook != "" || *auditLog {
+		logName := ""
+		if *auditLog {
+			logName = auditLogName
+		}
+		if s.audit, err = newAuditor(*auditFormat, *auditSyslog, *auditWebhook, logName); err != nil {
+			fmt.Println("Audit export:", err)
+			os.Exit(1)
+		}
+	}
+	if s.features, err = loadFeatureFlags(); err != nil {
+		fmt.Println("Loading feature flags:", err)
+		os.Exit(1)
+	}
+	if s.trainStats, err = loadTrainStats(); err != nil {
+		fmt.Println("Loading train statistics:", err)
+		os.Exit(1)
+	}
+	if s.flakes, err = loadFlakeTracker(); err != nil {
+		fmt.Println("Loading flake history:", err)
+		os.Exit(1)
+	}
+	if s.pendingStore, err = loadPendingStore(); err != nil {
+		fmt.Println("Loading pending merges:", err)
+		os.Exit(1)
+	}
+	if s.prefs, err = loadPrefStore(); err != nil {
+		fmt.Println("Loading preferences:", err)
+		os.Exit(1)
+	}
+	prefsOf = s.prefs.get
+	if s.greeted, err = loadGreetedStore(); err != nil {
+		fmt.Println("Loading greeted users:", err)
+		os.Exit(1)
+	}
+	if s.links, err = loadLinkStore(); err != nil {
+		fmt.Println("Loading chat links:", err)
+		os.Exit(1)
+	}
+	if s.ciTimes, err = loadCIDurations(); err != nil {
+		fmt.Println("Loading CI durations:", err)
+		os.Exit(1)
+	}
+	if s.tracked, err = loadTrackingStore(); err != nil {
+		fmt.Println("Loading tracking issues:", err)
+		os.Exit(1)
+	}
+	if *chatURL != "" {
+		s.chat = &chatNotifier{url: *chatURL}
+	}
+	if s.maintenance, err = loadMaintenance(); err != nil {
+		fmt.Println("Loading maintenance state:", err)
+		os.Exit(1)
+	}
+	var anomalies *anomalyDetector
+	if *anomalyCheck > 0 {
+		odd, err := parseOddHours(*oddHoursFlag)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if anomalies, err = loadAnomalyDetector(s, odd); err != nil {
+			fmt.Println("Loading anomalies:", err)
+			os.Exit(1)
+		}
+	}
+	var config *configRepo
+	if *configRepoURL != "" {
+		config = newConfigRepo(s, *configRepoURL)
+		if err := config.sync(); err != nil {
+			fmt.Println("Configuration repository:", err)
+			os.Exit(1)
+		}
+	}
+	if flag.Arg(0) == "selftest" {
+		// The sandbox repository gets the same settings as any other.
+		if flag.NArg() != 2 {
+			fmt.Println("Usage: mergebot [flags] selftest OWNER/NAME")
+			os.Exit(1)
+		}
+		if !s.runSelfTest(flag.Arg(1), os.Stdout) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 	h := newWebhook(*listenAddr, *secret, *username, *token)
-	h.handleComment("merge", s.handleMerge)
-	h.handleComment("squash", s.handleMerge)
-	h.handleComment("stop", s.handleStop)
-	h.handleComment("don't", s.handleStop)
-	h.handleComment("prevent", s.handleStop)
-	h.handleComment("lgtm", s.handleLGTM)
+	h.secretFor = func(repo string) string { return s.settings.forRepo(repo).WebhookSecret }
+	h.forgeFor = func(repo string) string { return s.settings.forRepo(repo).Forge }
+	s.registerCommands(h)
 	h.handlePR(s.handlePullReq)
+	h.handleMilestone(s.handleMilestone)
+	h.handleCI(s.handleCIEvent)
+	h.handlePush(s.handlePush)
+	h.maintenance = s.maintenance
+	s.maintenance.replay = h.replay
+	s.maintenance.watchSignal()
+	health := newHealthChecker(s)
+	h.handleHTTP("/healthz", http.HandlerFunc(health.serveHealth))
+	h.handleHTTP("/readyz", http.HandlerFunc(health.serveReady))
+	if *serveFeeds {
+		h.handleHTTP("/feeds/", feeds{})
+	}
+	if logsURL != "" {
+		h.handleHTTP("/logs/", logs{})
+	}
+	if *adminToken != "" {
+		h.handleHTTP("/admin/", &adminAPI{h: s, token: *adminToken})
+	}
+
+	var syncer *protectionSyncer
+	if *protection != "" {
+		t, err := loadProtectionTemplate(*protection)
+		if err != nil {
+			fmt.Println("Loading branch protection template:", err)
+			os.Exit(1)
+		}
+		syncer = &protectionSyncer{h: s, template: t, correct: *protectionFix}
+	}
+
+	if err := s.recoverMerges(); err != nil {
+		fmt.Println("Recovering interrupted merges:", err)
+		os.Exit(1)
+	}
+	s.resumePending()
+	// Only once interrupted and pending merges are picked up again, so that
+	// replayed commands don't race them on the same PRs.
+	if err := s.maintenance.drain(); err != nil {
+		log.Println("Replaying queued events:", err)
+	}
 
 	main := suture.NewSimple("main")
 	main.Add(h)
+	if *hookCheck > 0 && *hookURL != "" {
+		c := &hookChecker{h: s, repair: *hookRepair}
+		main.Add(newPeriodic(*hookCheck, c.checkAll))
+	}
+	if syncer != nil {
+		main.Add(newPeriodic(*protectionCheck, syncer.syncAll))
+	}
+	if config != nil {
+		main.Add(newPeriodic(*configCheck, config.syncLogged))
+	}
+	if s.chat != nil {
+		main.Add(newPeriodic(*digestInterval, s.sendDigests))
+	}
+	if anomalies != nil {
+		main.Add(newPeriodic(*anomalyCheck, anomalies.check))
+	}
 	main.Serve()
 }
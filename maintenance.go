@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	maintenanceStateName = "maintenance.json"
+	intakeQueueName      = "intake-queue.jsonl"
+)
+
+// In maintenance mode webhook events are validated and queued on disk, but
+// not acted upon until maintenance ends. Commands get a banner response
+// saying they'll be handled later.
+type maintenance struct {
+	mut     sync.Mutex
+	On      bool      `json:"on"`
+	Since   time.Time `json:"since,omitempty"`
+	Message string    `json:"message,omitempty"` // shown in the banner
+	replay  func(eventType string, body []byte)
+}
+
+// A queuedEvent is a webhook event received during maintenance.
+type queuedEvent struct {
+	Type     string          `json:"type"`
+	Body     json.RawMessage `json:"body"`
+	Received time.Time       `json:"received"`
+}
+
+func loadMaintenance() (*maintenance, error) {
+	m := &maintenance{}
+	if err := loadState(maintenanceStateName, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// active returns whether events are to be queued instead of handled.
+func (m *maintenance) active() bool {
+	if m == nil {
+		return false
+	}
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.On
+}
+
+// banner returns the message for the banner response.
+func (m *maintenance) banner() string {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.Message
+}
+
+// queue durably records the event for when maintenance ends. It returns
+// false if maintenance ended in the meantime, in which case the event is
+// to be handled right away.
+func (m *maintenance) queue(eventType string, body []byte) (bool, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if !m.On {
+		return false, nil
+	}
+	ev := queuedEvent{Type: eventType, Body: json.RawMessage(body), Received: time.Now()}
+	if err := appendSynced(intakeQueueName, ev); err != nil {
+		return false, err
+	}
+	metrics.add("events_queued", 1)
+	return true, nil
+}
+
+// set enters or leaves maintenance mode. Leaving it replays the queued
+// events in the order they were received.
+func (m *maintenance) set(on bool, message string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if on && !m.On {
+		m.Since = time.Now()
+	}
+	if !on {
+		m.Since, message = time.Time{}, ""
+	}
+	m.On, m.Message = on, message
+	if err := saveState(maintenanceStateName, m); err != nil {
+		return err
+	}
+	log.Printf("Maintenance mode %s", fmtEnabled(&on))
+	if !on {
+		return m.drainLocked()
+	}
+	return nil
+}
+
+// drain replays any events left queued, such as when maintenance ended
+// while we were down.
+func (m *maintenance) drain() error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if m.On {
+		return nil
+	}
+	return m.drainLocked()
+}
+
+func (m *maintenance) drainLocked() error {
+	events, err := queuedEvents()
+	if err != nil || len(events) == 0 {
+		return err
+	}
+	// Events are handled at most once; a crash while replaying loses the
+	// rest rather than repeating merges.
+	if err := removeState(intakeQueueName); err != nil {
+		return err
+	}
+	log.Printf("Replaying %d events queued during maintenance", len(events))
+	go func() {
+		for _, ev := range events {
+			m.replay(ev.Type, ev.Body)
+		}
+	}()
+	return nil
+}
+
+// queuedEvents returns the events waiting for maintenance to end.
+func queuedEvents() ([]queuedEvent, error) {
+	var events []queuedEvent
+	err := readStateLines(intakeQueueName, func(line []byte) {
+		var ev queuedEvent
+		if err := json.Unmarshal(line, &ev); err != nil {
+			log.Println("Queued event:", err)
+			return
+		}
+		events = append(events, ev)
+	})
+	return events, err
+}
+
+// toggle flips maintenance mode, as on a signal.
+func (m *maintenance) toggle() {
+	if err := m.set(!m.active(), ""); err != nil {
+		log.Println("Maintenance mode:", err)
+	}
+}
+
+// serveMaintenance answers GET /admin/maintenance with the state and the
+// number of queued events, and takes POST requests like {"enabled": true,
+// "message": "Back at 14:00 UTC"} to enter or leave maintenance.
+func (a *adminAPI) serveMaintenance(w http.ResponseWriter, r *http.Request) {
+	m := a.h.maintenance
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		events, err := queuedEvents()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		m.mut.Lock()
+		state := map[string]interface{}{"enabled": m.On, "message": m.Message, "queued": len(events)}
+		if m.On {
+			state["since"] = m.Since
+		}
+		m.mut.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+
+	case "POST":
+		var req struct {
+			Enabled bool
+			Message string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := m.set(req.Enabled, req.Message); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+	default:
+		http.Error(w, "GET or POST Expected", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceQueue(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+
+	var banners int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		banners++
+	}))
+	defer srv.Close()
+
+	h := newWebhook(":0", "secret", "bot", "")
+	handled := make(chan int, 10)
+	h.handleComment("merge", func(c comment) { handled <- c.Issue.Number })
+	h.maintenance = &maintenance{replay: h.replay}
+
+	deliver := func(number int) int {
+		body := []byte(fmt.Sprintf(`{"comment": {"body": "@bot merge"}, "issue": {"number": %d, "comments_url": %q}}`, number, srv.URL))
+		mac := hmac.New(sha1.New, []byte("secret"))
+		mac.Write(body)
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		req.Header.Set("X-Github-Event", "issue_comment")
+		req.Header.Set("X-Hub-Signature", fmt.Sprintf("sha1=%x", mac.Sum(nil)))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if err := h.maintenance.set(true, "Upgrading disks."); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		if code := deliver(i); code != http.StatusAccepted {
+			t.Errorf("Expected the event to be accepted, got %d", code)
+		}
+	}
+	if len(handled) != 0 || banners != 2 {
+		t.Errorf("Expected queued events with banners, got %d handled and %d banners", len(handled), banners)
+	}
+
+	// The queue survives a restart.
+	loaded, err := loadMaintenance()
+	if err != nil || !loaded.On || loaded.Message != "Upgrading disks." {
+		t.Fatalf("Expected maintenance to be persisted, got %+v, %v", loaded, err)
+	}
+	loaded.replay = h.replay
+	if err := loaded.set(false, ""); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		select {
+		case n := <-handled:
+			if n != i {
+				t.Errorf("Expected event %d to be replayed, got %d", i, n)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Queued event not replayed")
+		}
+	}
+	if events, err := queuedEvents(); err != nil || len(events) != 0 {
+		t.Errorf("Expected an empty queue, got %v, %v", events, err)
+	}
+
+	h.maintenance = loaded
+	if code := deliver(3); code != http.StatusOK || <-handled != 3 {
+		t.Error("Expected events to be handled after maintenance")
+	}
+}
@@ -0,0 +1,5 @@
+package main
+
+// watchSignal does nothing, as there is no SIGUSR1 on Windows; use the
+// admin API instead.
+func (m *maintenance) watchSignal() {}
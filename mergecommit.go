@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// mergeCommitMessage returns the message of the merge commit for the PR:
+// a subject with its number and title, followed by the message given in
+// the merge command, if any.
+func mergeCommitMessage(pr pr, plan mergePlan) string {
+	if plan.verbatim {
+		return plan.msg
+	}
+	title := pr.Title
+	if plan.subject != "" {
+		title = plan.subject
+	}
+	body := fmt.Sprintf("Merge #%d: %s", pr.Number, title)
+	if plan.msg != "" {
+		body += "\n\n" + plan.msg
+	}
+	return withTrailers(body, pr, plan)
+}
+
+// mergeCommit merges the PR into its base branch in the clone in dir with
+// a merge commit, keeping the commits of the PR as they are, and pushes
+// the result.
+func mergeCommit(ctx context.Context, dir string, pr pr, plan mergePlan, prog *progress, journal *mergeJournal) (string, error) {
+	dstBranch := pr.Base.Ref
+	sourceBranch := fmt.Sprintf("pr-%d", pr.Number)
+
+	prog.set("fetching " + dstBranch)
+	s := newScriptContext(ctx).in(dir)
+	fetchRefs(s, fetchRef{remote: dstBranch, local: "orig/" + dstBranch, sha: plan.baseSHA}, prRef(pr))
+	if err := checkHead(s, pr); err != nil {
+		return "", err
+	}
+	if s.Error() == nil {
+		journal.step(stepFetched, s.run("git", "rev-parse", "orig/"+dstBranch))
+	}
+
+	s.run("git", "reset", "--hard")
+	setSparse(s, plan.sparse)
+	s.run("git", "checkout", "-B", dstBranch, "orig/"+dstBranch)
+	s.run("git", "clean", "-fxd")
+	if s.Error() != nil {
+		return "", fmt.Errorf("%s", s.output.String())
+	}
+
+	t := newScriptContext(ctx).in(dir)
+	if revs := t.run("git", "rev-list", "HEAD.."+sourceBranch); revs == "" {
+		if t.Error() != nil {
+			return "", fmt.Errorf("%s", t.output.String())
+		}
+		return "", explainNoCommits(dstBranch)
+	}
+
+	prog.set("merging")
+	env := []string{
+		"GIT_COMMITTER_NAME=" + plan.user.Name,
+		"GIT_COMMITTER_EMAIL=" + plan.user.Email,
+		"GIT_AUTHOR_NAME=" + plan.user.Name,
+		"GIT_AUTHOR_EMAIL=" + plan.user.Email,
+	}
+	args := []string{"merge", "--no-ff"}
+	if plan.verbatim {
+		args = append(args, "--cleanup=verbatim")
+	}
+	s.runPipeEnv(env, nil, "git", append(args, "-m", mergeCommitMessage(pr, plan), sourceBranch)...)
+	if s.Error() != nil {
+		newScriptContext(ctx).in(dir).run("git", "merge", "--abort")
+		return "", fmt.Errorf("%s", s.output.String())
+	}
+	sha1 := s.run("git", "rev-parse", "HEAD")
+	journal.step(stepCommitted, sha1)
+	journal.step(stepPushing, sha1)
+
+	prog.set("pushing to " + dstBranch)
+	s.run("git", "push", "origin", dstBranch)
+	if s.Error() != nil {
+		return "", fmt.Errorf("%s", s.output.String())
+	}
+	journal.step(stepPushed, sha1)
+	return sha1, nil
+}
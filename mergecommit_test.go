@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeCommit(t *testing.T) {
+	work, origin := t.TempDir(), filepath.Join(t.TempDir(), "origin.git")
+	w := newScript().in(work)
+	git := func(args ...string) string {
+		return w.run("git", append([]string{"-c", "user.name=Author", "-c", "user.email=author@example.com"}, args...)...)
+	}
+	commit := func(file, msg string) {
+		os.WriteFile(filepath.Join(work, file), []byte(msg+"\n"), 0644)
+		git("add", file)
+		git("commit", "-q", "-m", msg)
+	}
+	git("init", "-q", "-b", "main")
+	commit("a.txt", "Initial")
+	git("checkout", "-q", "-b", "feature")
+	commit("b.txt", "First")
+	commit("c.txt", "Second")
+	git("checkout", "-q", "main")
+	commit("d.txt", "Meanwhile")
+	newScript().run("git", "clone", "-q", "--bare", work, origin)
+	git("push", "-q", origin, "feature:refs/pull/5/head")
+	clone := filepath.Join(t.TempDir(), "clone")
+	newScript().run("git", "clone", "-q", origin, clone)
+	if w.Error() != nil {
+		t.Fatal(w.output.String())
+	}
+
+	var p pr
+	p.Number = 5
+	p.Title = "Add b and c"
+	p.Base.Ref = "main"
+	p.HTMLURL = "https://github.com/foo/bar/pull/5"
+	plan := mergePlan{user: user{Name: "Merger", Email: "merger@example.com"}, strategy: strategyMerge, lgtm: []string{"carol"}}
+	sha, err := mergeCommit(context.Background(), clone, p, plan, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := newScript().in(origin)
+	if got := o.run("git", "rev-parse", "main"); got != sha {
+		t.Errorf("main is at %s, want %s", got, sha)
+	}
+	if parents := o.run("git", "log", "-n1", "--format=%p", sha); len(parents) != 2*7+1 && len(parents) != 2*40+1 {
+		t.Errorf("Not a merge commit, parents %q", parents)
+	}
+	want := "Merge #5: Add b and c\n\nGitHub-Pull-Request: https://github.com/foo/bar/pull/5\nLGTM: carol"
+	if msg := o.run("git", "log", "-n1", "--format=%B", sha); msg != want {
+		t.Errorf("Unexpected message %q", msg)
+	}
+	if log := o.run("git", "log", "--format=%s", "main^2"); log != "Second\nFirst\nInitial" {
+		t.Errorf("Commits of the PR not kept: %q", log)
+	}
+	if who := o.run("git", "log", "-n1", "--format=%an %cn", sha); who != "Merger Merger" {
+		t.Errorf("Merged by %q", who)
+	}
+}
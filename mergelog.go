@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// A mergeRecord describes a completed merge.
+type mergeRecord struct {
+	Time       time.Time `json:"time"`
+	Repo       string    `json:"repo"`
+	PR         int       `json:"pr"`
+	Title      string    `json:"title"`
+	URL        string    `json:"url"`
+	Base       string    `json:"base"`
+	SHA        string    `json:"sha"`
+	Strategy   string    `json:"strategy"`
+	Author     string    `json:"author"`                 // who opened the PR
+	Requester  string    `json:"requester"`              // who asked for the merge
+	OnBehalfOf string    `json:"on_behalf_of,omitempty"` // who the requester merged for
+	Milestone  int       `json:"milestone,omitempty"`    // milestone number
+	Overrides  []string  `json:"overrides,omitempty"`    // checks skipped and other overrides
+}
+
+const mergeLogName = "merges.jsonl"
+
+var mergeLogMut sync.Mutex
+
+// recordMerge appends the record to the merge log.
+func recordMerge(r mergeRecord) {
+	mergeLogMut.Lock()
+	defer mergeLogMut.Unlock()
+
+	if err := appendState(mergeLogName, r); err != nil {
+		log.Println("Merge log:", err)
+	}
+}
+
+// readMerges returns the merge records for which keep returns true, oldest
+// first.
+func readMerges(keep func(mergeRecord) bool) ([]mergeRecord, error) {
+	mergeLogMut.Lock()
+	defer mergeLogMut.Unlock()
+
+	var res []mergeRecord
+	err := readStateLines(mergeLogName, func(line []byte) {
+		var r mergeRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return
+		}
+		if keep == nil || keep(r) {
+			res = append(res, r)
+		}
+	})
+	return res, err
+}
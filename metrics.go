@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// counters are simple named event counters, served by the admin API in the
+// Prometheus text format.
+type counters struct {
+	mut    sync.Mutex
+	values map[string]int64
+}
+
+var metrics = &counters{values: make(map[string]int64)}
+
+func (c *counters) add(name string, n int64) {
+	c.mut.Lock()
+	c.values[name] += n
+	c.mut.Unlock()
+}
+
+// set sets a value that isn't a count, such as the disk usage.
+func (c *counters) set(name string, v int64) {
+	c.mut.Lock()
+	c.values[name] = v
+	c.mut.Unlock()
+}
+
+func (c *counters) get(name string) int64 {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.values[name]
+}
+
+func (c *counters) serve(w io.Writer) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	var names []string
+	for name := range c.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "mergebot_%s %d\n", name, c.values[name])
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+type milestoneEvent struct {
+	Action    string
+	Milestone struct {
+		Number  int
+		Title   string
+		HTMLURL string `json:"html_url"`
+	}
+	Repository struct {
+		FullName string `json:"full_name"`
+	}
+}
+
+// handleMilestone opens an issue summarizing the merges for a milestone
+// when it is closed, if the repository wants that.
+func (h *handler) handleMilestone(m milestoneEvent) {
+	repo := m.Repository.FullName
+	if m.Action != "closed" || !h.botEnabled(repo) {
+		return
+	}
+	if rs := h.settings.forRepo(repo); rs.MilestoneSummary == nil || !*rs.MilestoneSummary {
+		return
+	}
+
+	records, err := readMerges(func(r mergeRecord) bool {
+		return r.Repo == repo && r.Milestone == m.Milestone.Number
+	})
+	if err != nil {
+		log.Println("Milestone summary:", err)
+		return
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	issue := map[string]interface{}{
+		"title":     fmt.Sprintf("Merged in %s", m.Milestone.Title),
+		"body":      milestoneSummary(m.Milestone.Title, records),
+		"milestone": m.Milestone.Number,
+	}
+	url := fmt.Sprintf("%s/repos/%s/issues", githubAPI, repo)
+	if err := apiRequest("POST", url, issue, nil, h.username, h.token); err != nil {
+		log.Println("Milestone summary:", err)
+		return
+	}
+	log.Printf("Posted summary of %d merges for milestone %s on %s", len(records), m.Milestone.Title, repo)
+}
+
+// milestoneSummary lists the merged PRs and the contributors, in markdown.
+func milestoneSummary(title string, records []mergeRecord) string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("The following %d pull requests were merged for %s:", len(records), title), "")
+	authors := make(map[string]bool)
+	for _, r := range records {
+		lines = append(lines, fmt.Sprintf("- #%d %s (%s, @%s)", r.PR, r.Title, r.SHA, r.Author))
+		authors[r.Author] = true
+	}
+
+	var names []string
+	for a := range authors {
+		names = append(names, "@"+a)
+	}
+	sort.Strings(names)
+	lines = append(lines, "", "Thanks to the contributors: "+strings.Join(names, ", ")+".")
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMilestoneSummary(t *testing.T) {
+	records := []mergeRecord{
+		{PR: 1, Title: "First", SHA: "aaa", Author: "bob"},
+		{PR: 2, Title: "Second", SHA: "bbb", Author: "alice"},
+		{PR: 3, Title: "Third", SHA: "ccc", Author: "bob"},
+	}
+	s := milestoneSummary("v1.0", records)
+	if !strings.Contains(s, "- #2 Second (bbb, @alice)") {
+		t.Error("Missing PR line in summary:\n" + s)
+	}
+	if !strings.HasSuffix(s, "Thanks to the contributors: @alice, @bob.") {
+		t.Error("Unexpected contributors in summary:\n" + s)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "log"
+
+// observing returns whether the bot only observes the repository: it
+// handles events and evaluates the gates, saying what it would do, but
+// never pushes. Teams can trial its policy before granting it write access.
+func (h *handler) observing(repo string) bool {
+	rs := h.settings.forRepo(repo)
+	return rs.Observe != nil && *rs.Observe
+}
+
+// reportObserved says what the merge that passed its gates would have
+// been, in place of making it.
+func (h *handler) reportObserved(c comment, pr pr, plan mergePlan, notes []string) {
+	log.Printf("Observed merge of PR %d on %s for %s", c.Issue.Number, c.Repository.FullName, c.Sender.Login)
+	strategy := plan.strategy
+	if strategy == "" {
+		strategy = strategySquash
+	}
+	c.post(wouldMergeResponse(c, pr.Base.Ref, strategy, notes), h.username, h.token)
+	h.mergeStatus(c, pr, stateSuccess, "Would merge, but only observing.")
+}
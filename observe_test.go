@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestObserve(t *testing.T) {
+	var posted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Body string }
+		json.NewDecoder(r.Body).Decode(&body)
+		posted = append(posted, body.Body)
+	}))
+	defer srv.Close()
+
+	yes := true
+	h := newHandler(nil, "bot", "", false)
+	h.settings.repos = map[string]repoSettings{
+		"foo/*":   {MergeTrain: &yes},
+		"foo/obs": {Observe: &yes},
+	}
+	if !h.trainEnabled("foo/bar") || h.trainEnabled("foo/obs") {
+		t.Error("Expected trains only where not observing")
+	}
+	if _, err := h.releaseNotesPR("foo/obs"); err == nil || !strings.Contains(err.Error(), "only observing") {
+		t.Errorf("Expected release notes to be refused, got %v", err)
+	}
+
+	var c comment
+	c.Repository.FullName = "foo/obs"
+	c.Sender.Login = "alice"
+	c.Issue.CommentsURL = srv.URL
+	var p pr
+	p.Base.Ref = "main"
+	h.reportObserved(c, p, mergePlan{strategy: strategyRebase}, []string{"Ignored a stale status."})
+	if len(posted) != 1 || !strings.Contains(posted[0], "would merge this into `main` now, by rebase") || !strings.Contains(posted[0], "Ignored a stale status.") {
+		t.Errorf("Unexpected responses %q", posted)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// The events the bot needs to receive from every repository it serves.
+var hookEvents = []string{"check_run", "check_suite", "issue_comment", "milestone", "pull_request", "push", "status"}
+
+type hook struct {
+	ID     int      `json:"id,omitempty"`
+	Name   string   `json:"name,omitempty"`
+	Active bool     `json:"active"`
+	Events []string `json:"events"`
+	Config struct {
+		URL         string `json:"url"`
+		ContentType string `json:"content_type"`
+		Secret      string `json:"secret,omitempty"`
+	} `json:"config"`
+	LastResponse *struct {
+		Code    int
+		Status  string
+		Message string
+	} `json:"last_response,omitempty"`
+}
+
+// desiredHook returns the webhook configuration we want on the repository.
+func (h *handler) desiredHook(repo string) hook {
+	want := hook{Name: "web", Active: true, Events: hookEvents}
+	want.Config.URL = h.hookURL
+	want.Config.ContentType = "json"
+	want.Config.Secret = h.settings.forRepo(repo).WebhookSecret
+	return want
+}
+
+func (h *handler) createHook(repo string) (int, error) {
+	var res hook
+	url := fmt.Sprintf("%s/repos/%s/hooks", githubAPI, repo)
+	if err := apiRequest("POST", url, h.desiredHook(repo), &res, h.username, h.token); err != nil {
+		return 0, err
+	}
+	return res.ID, nil
+}
+
+func (h *handler) updateHook(repo string, id int) error {
+	url := fmt.Sprintf("%s/repos/%s/hooks/%d", githubAPI, repo, id)
+	return apiRequest("PATCH", url, h.desiredHook(repo), nil, h.username, h.token)
+}
+
+func (h *handler) listHooks(repo string) ([]hook, error) {
+	var hooks []hook
+	url := fmt.Sprintf("%s/repos/%s/hooks", githubAPI, repo)
+	if err := apiRequest("GET", url, nil, &hooks, h.username, h.token); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+// findHook returns the hook pointing at url, or nil.
+func findHook(hooks []hook, url string) *hook {
+	for i := range hooks {
+		if hooks[i].Config.URL == url {
+			return &hooks[i]
+		}
+	}
+	return nil
+}
+
+// repoNamePart matches owners and names of repositories as GitHub allows
+// them.
+var repoNamePart = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// validRepoName returns whether repo is a repository name on the form
+// owner/name, which is then also safe as the path of its clone.
+func validRepoName(repo string) bool {
+	parts := strings.Split(repo, "/")
+	if len(parts) != 2 {
+		return false
+	}
+	for _, part := range parts {
+		if !repoNamePart.MatchString(part) || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+type repoInfo struct {
+	FullName      string `json:"full_name"`
+	DefaultBranch string `json:"default_branch"`
+	Permissions   struct {
+		Admin bool
+		Push  bool
+	}
+}
+
+// onboard prepares a new repository to be served by the bot: it verifies
+// that we have push access, sets up the webhook and clones the repository.
+// The returned string is a human readable summary of what was done.
+func (h *handler) onboard(repo string) (string, error) {
+	if !validRepoName(repo) {
+		return "", fmt.Errorf("%q is not a repository name on the form owner/name", repo)
+	}
+	if h.hookURL == "" {
+		return "", fmt.Errorf("no webhook URL configured")
+	}
+
+	var info repoInfo
+	if err := apiRequest("GET", fmt.Sprintf("%s/repos/%s", githubAPI, repo), nil, &info, h.username, h.token); err != nil {
+		return "", err
+	}
+	if !info.Permissions.Push {
+		return "", fmt.Errorf("@%s does not have push access to %s", h.username, repo)
+	}
+
+	hooks, err := h.listHooks(repo)
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	if existing := findHook(hooks, h.hookURL); existing != nil {
+		if err := h.updateHook(repo, existing.ID); err != nil {
+			return "", err
+		}
+		lines = append(lines, fmt.Sprintf("Updated existing webhook %d.", existing.ID))
+	} else {
+		id, err := h.createHook(repo)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, fmt.Sprintf("Created webhook %d for %s.", id, strings.Join(hookEvents, ", ")))
+	}
+
+	defer h.lockRepo(repo)()
+	if _, err := os.Stat(filepath.Join(repo, ".git")); err != nil {
+		if err := h.clone(repo); err != nil {
+			return "", err
+		}
+		lines = append(lines, "Cloned the repository.")
+	} else {
+		lines = append(lines, "Repository was already cloned.")
+	}
+
+	log.Printf("Onboarded %s: %s", repo, strings.Join(lines, " "))
+	return strings.Join(lines, " "), nil
+}
+
+func (h *handler) handleOnboard(c comment) {
+	fields := strings.Fields(c.parseBody().command)
+	if len(fields) < 2 {
+		c.post(onboardFailedResponse(c, "Which repository? Use `onboard owner/name`."), h.username, h.token)
+		return
+	}
+
+	repo := fields[1]
+	res, err := h.onboard(repo)
+	if err != nil {
+		c.post(onboardFailedResponse(c, err.Error()), h.username, h.token)
+		return
+	}
+	c.post(onboardedResponse(c, repo, res), h.username, h.token)
+}
+
+// The admin API serves administrative requests over HTTP, authenticated by
+// a bearer token.
+type adminAPI struct {
+	h     *handler
+	token string
+}
+
+func (a *adminAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+a.token)) != 1 {
+		a.h.audit.record(auditEvent{Kind: "denied", User: r.RemoteAddr, Detail: "admin API " + r.URL.Path})
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/admin/onboard":
+		if r.Method != "POST" {
+			http.Error(w, "POST Expected", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Repo string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res, err := a.h.onboard(req.Repo)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"repo": req.Repo, "result": res})
+
+	case "/admin/report":
+		a.serveReport(w, r)
+
+	case "/admin/simulate":
+		a.serveSimulate(w, r)
+
+	case "/admin/release-notes":
+		if r.Method != "POST" {
+			http.Error(w, "POST Expected", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Repo string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		url, err := a.h.releaseNotesPR(req.Repo)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"repo": req.Repo, "pr": url})
+
+	case "/admin/train-stats":
+		a.serveTrainStats(w, r)
+
+	case "/admin/audit":
+		a.serveAudit(w, r)
+
+	case "/admin/links":
+		a.serveLinks(w, r)
+
+	case "/admin/features":
+		a.serveFeatures(w, r)
+
+	case "/admin/maintenance":
+		a.serveMaintenance(w, r)
+
+	case "/admin/metrics":
+		metrics.serve(w)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
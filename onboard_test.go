@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidRepoName(t *testing.T) {
+	cases := []struct {
+		repo  string
+		valid bool
+	}{
+		{"foo/bar", true},
+		{"foo-corp/bar.go", true},
+		{"foo_1/.github", true},
+		{"foo", false},
+		{"foo/bar/baz", false},
+		{"../x", false},
+		{"a/..", false},
+		{"./bar", false},
+		{"foo/", false},
+		{"/bar", false},
+		{"foo/bar baz", false},
+		{`foo\bar/baz`, false},
+	}
+	for _, tc := range cases {
+		if valid := validRepoName(tc.repo); valid != tc.valid {
+			t.Errorf("validRepoName(%q) = %v, expected %v", tc.repo, valid, tc.valid)
+		}
+	}
+}
+
+func TestOnboard(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(t.TempDir())
+	// Already cloned, so nothing is cloned from anywhere.
+	os.MkdirAll(filepath.Join("foo", "bar", ".git"), 0755)
+
+	var existing string
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.Method + " " + r.URL.Path {
+		case "GET /repos/foo/bar":
+			w.Write([]byte(`{"full_name": "foo/bar", "permissions": {"push": true}}`))
+		case "GET /repos/foo/bar/hooks":
+			w.Write([]byte(`[` + existing + `]`))
+		case "POST /repos/foo/bar/hooks":
+			var h hook
+			json.NewDecoder(r.Body).Decode(&h)
+			if h.Config.URL != "https://bot.example.com/" || !h.Active || len(h.Events) != len(hookEvents) {
+				t.Errorf("Unexpected hook %+v", h)
+			}
+			w.Write([]byte(`{"id": 12}`))
+		case "PATCH /repos/foo/bar/hooks/7":
+			w.Write([]byte(`{"id": 7}`))
+		case "GET /repos/foo/private":
+			w.Write([]byte(`{"full_name": "foo/private", "permissions": {"push": false}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	h := newHandler(nil, "bot", "token", false)
+	h.hookURL = "https://bot.example.com/"
+
+	res, err := h.onboard("foo/bar")
+	if err != nil || !strings.Contains(res, "Created webhook 12") || requests[len(requests)-1] != "POST /repos/foo/bar/hooks" {
+		t.Errorf("Expected the webhook to be created, got %q, %v after %v", res, err, requests)
+	}
+
+	existing = `{"id": 7, "config": {"url": "https://bot.example.com/"}}`
+	requests = nil
+	res, err = h.onboard("foo/bar")
+	if err != nil || !strings.Contains(res, "Updated existing webhook 7") || requests[len(requests)-1] != "PATCH /repos/foo/bar/hooks/7" {
+		t.Errorf("Expected the webhook to be updated, got %q, %v after %v", res, err, requests)
+	}
+
+	if _, err := h.onboard("foo/private"); err == nil || !strings.Contains(err.Error(), "push access") {
+		t.Errorf("Expected onboarding without push access to fail, got %v", err)
+	}
+
+	requests = nil
+	for _, repo := range []string{"../x", "a/..", "foo"} {
+		if _, err := h.onboard(repo); err == nil {
+			t.Errorf("Expected onboarding %q to fail", repo)
+		}
+	}
+	if len(requests) != 0 {
+		t.Errorf("Expected invalid names to be refused without requests, got %v", requests)
+	}
+}
+
+func TestAdminAPIToken(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+	h := newHandler(nil, "bot", "token", false)
+	a := &adminAPI{h: h, token: "secret"}
+
+	cases := []struct {
+		auth string
+		code int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer secre", http.StatusUnauthorized},
+		{"Bearer secret2", http.StatusUnauthorized},
+		{"secret", http.StatusUnauthorized},
+		{"Basic secret", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("POST", "/admin/onboard", strings.NewReader(`{"repo": "../x"`))
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, req)
+		if w.Code != tc.code {
+			t.Errorf("Authorization %q: expected %d, got %d", tc.auth, tc.code, w.Code)
+		}
+	}
+
+	// With the token, bad names are refused.
+	req := httptest.NewRequest("POST", "/admin/onboard", strings.NewReader(`{"repo": "../x"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, req)
+	if w.Code == http.StatusOK || !strings.Contains(w.Body.String(), "not a repository name") {
+		t.Errorf("Expected ../x to be refused, got %d %s", w.Code, w.Body.String())
+	}
+}
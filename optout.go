@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+)
+
+// botFeature is the feature under which the bot as a whole is switched off
+// for a repository, by its admins with the disable command.
+const botFeature = "bot"
+
+// botEnabled returns whether the bot acts on the repository at all.
+func (h *handler) botEnabled(repo string) bool {
+	return h.featureEnabled(repo, botFeature, true)
+}
+
+// isRepoAdmin returns whether the user administers the repository, or is
+// one of our own admins.
+func (h *handler) isRepoAdmin(repo, login string) bool {
+	if h.isAdmin(repo, login) {
+		return true
+	}
+	if !h.permissions.onGitHub(repo) {
+		return false // GitHub would be asked about a namesake repository
+	}
+	var perm struct {
+		Permission string
+	}
+	u := fmt.Sprintf("%s/repos/%s/collaborators/%s/permission", githubAPI, repo, url.PathEscape(login))
+	if err := apiRequest("GET", u, nil, &perm, h.username, h.token); err != nil {
+		log.Println("Permission:", err)
+		return false
+	}
+	return perm.Permission == "admin"
+}
+
+func (h *handler) handleDisable(c comment) {
+	h.toggleBot(c, false)
+}
+
+func (h *handler) handleEnable(c comment) {
+	h.toggleBot(c, true)
+}
+
+// toggleBot switches the bot off or back on for the repository of the
+// comment. Enabling clears the override, such that the central settings
+// apply again, unless they disable the bot too.
+func (h *handler) toggleBot(c comment, enabled bool) {
+	repo := c.Repository.FullName
+	var err error
+	if enabled {
+		if err = h.features.set(botFeature, repo, nil); err == nil && !h.botEnabled(repo) {
+			err = h.features.set(botFeature, repo, &enabled)
+		}
+	} else {
+		err = h.features.set(botFeature, repo, &enabled)
+	}
+	if err != nil {
+		log.Println("Toggling bot:", err)
+		c.post(toggleFailedResponse(c, err.Error()), h.username, h.token)
+		return
+	}
+
+	log.Printf("Bot %s for %s by %s", fmtEnabled(&enabled), repo, c.Sender.Login)
+	h.audit.record(auditEvent{Kind: "toggle", Repo: repo, PR: c.Issue.Number, User: c.Sender.Login, Detail: "Bot " + fmtEnabled(&enabled)})
+	c.post(botToggledResponse(c, enabled), h.username, h.token)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestToggleBot(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+
+	var posted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/foo/bar/collaborators/alice/permission":
+			w.Write([]byte(`{"permission": "admin"}`))
+		case "/repos/foo/bar/collaborators/bob/permission":
+			w.Write([]byte(`{"permission": "write"}`))
+		case "/comments":
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			posted = append(posted, body.Body)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	h := newHandler(nil, "bot", "", false)
+	comment := func(login string) comment {
+		var c comment
+		c.Repository.FullName = "foo/bar"
+		c.Sender.Login = login
+		c.Issue.CommentsURL = srv.URL + "/comments"
+		return c
+	}
+	// Through the webhook, which checks who may use the commands.
+	w := newWebhook(":0", "secret", "bot", "")
+	h.registerCommands(w)
+	disable, enable := w.commentHandlers["disable"], w.commentHandlers["enable"]
+
+	disable(comment("bob"))
+	if !h.botEnabled("foo/bar") || len(posted) != 1 || !strings.Contains(posted[0], "Only admins") {
+		t.Errorf("Expected non-admin to be refused, posted %q", posted)
+	}
+
+	disable(comment("alice"))
+	if h.botEnabled("foo/bar") || !h.botEnabled("foo/baz") {
+		t.Error("Expected the bot to be disabled for the repository only")
+	}
+	loaded, err := loadFeatureFlags()
+	if err != nil || loaded.overrides[botFeature]["foo/bar"] {
+		t.Errorf("Expected the override to be persisted, got %v, %v", loaded.overrides, err)
+	}
+
+	enable(comment("alice"))
+	if !h.botEnabled("foo/bar") || len(h.features.overrides) != 0 {
+		t.Errorf("Expected the override to be cleared, got %v", h.features.overrides)
+	}
+
+	// Where the central settings disable the bot, enabling overrides them.
+	h.settings.repos = map[string]repoSettings{"foo/*": {Features: map[string]bool{botFeature: false}}}
+	enable(comment("alice"))
+	if !h.botEnabled("foo/bar") {
+		t.Error("Expected the bot to be enabled over the settings")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// A pathRule says that changes to any of the path prefixes require the
+// contexts, for monorepos where CI only runs what is relevant to the change.
+type pathRule struct {
+	Paths    []string
+	Contexts []string
+}
+
+// applyPathRules returns the statuses adjusted for the changed files:
+// contexts covered by the rules but not required for any changed file are
+// disregarded, and required contexts that haven't reported yet are pending.
+func applyPathRules(statuses []status, rules []pathRule, files []prFile) []status {
+	required := make(map[string]bool)
+	covered := make(map[string]bool)
+	for _, rule := range rules {
+		match := false
+	files:
+		for _, f := range files {
+			for _, prefix := range rule.Paths {
+				if strings.HasPrefix(f.Filename, prefix) {
+					match = true
+					break files
+				}
+			}
+		}
+		for _, ctx := range rule.Contexts {
+			covered[ctx] = true
+			if match {
+				required[ctx] = true
+			}
+		}
+	}
+
+	var res []status
+	seen := make(map[string]bool)
+	for _, st := range statuses {
+		if covered[st.Context] && !required[st.Context] {
+			continue
+		}
+		res = append(res, st)
+		seen[st.Context] = true
+	}
+	for _, rule := range rules {
+		for _, ctx := range rule.Contexts {
+			if required[ctx] && !seen[ctx] {
+				res = append(res, status{State: statePending, Context: ctx, Description: "Required for the changed paths, not reported yet"})
+				seen[ctx] = true
+			}
+		}
+	}
+	return res
+}
+
+// withPathRules applies the path rules of the repository, if any.
+func (h *handler) withPathRules(statuses []status, rules []pathRule, pr pr) []status {
+	if len(rules) == 0 {
+		return statuses
+	}
+	files, err := pr.getFiles(h.username, h.token)
+	if err != nil {
+		// Better to wait for everything than to merge on a guess.
+		log.Println("Getting PR files:", err)
+		return statuses
+	}
+	return applyPathRules(statuses, rules, files)
+}
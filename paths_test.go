@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestApplyPathRules(t *testing.T) {
+	rules := []pathRule{
+		{Paths: []string{"web/"}, Contexts: []string{"web-tests"}},
+		{Paths: []string{"server/", "proto/"}, Contexts: []string{"server-tests"}},
+	}
+	statuses := []status{
+		{State: stateSuccess, Context: "lint"},
+		{State: statePending, Context: "web-tests"},
+	}
+	files := []prFile{{Filename: "proto/api.proto"}}
+
+	res := applyPathRules(statuses, rules, files)
+	if len(res) != 2 {
+		t.Fatalf("Unexpected statuses %+v", res)
+	}
+	if res[0].Context != "lint" || res[1].Context != "server-tests" || res[1].State != statePending {
+		t.Errorf("Unexpected statuses %+v", res)
+	}
+
+	statuses = append(statuses, status{State: stateSuccess, Context: "server-tests"})
+	if s := overallStatus(applyPathRules(statuses, rules, files), nil); s != stateSuccess {
+		t.Error("Expected success once the required context reported, got", s)
+	}
+}
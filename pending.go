@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const pendingStateName = "pending.json"
+
+// A pendingMerge is a merge request waiting for statuses, approvals or its
+// turn in a merge train. They're kept on disk so that they are resumed
+// after a restart rather than silently dropped.
+type pendingMerge struct {
+	Comment  comment   `json:"comment"`
+	Started  time.Time `json:"started"`
+	Deadline time.Time `json:"deadline"`
+	Train    bool      `json:"train,omitempty"`
+	Head     string    `json:"head,omitempty"` // of the PR when the merge was asked for
+}
+
+// pendingStore persists the pending merges, by "owner/name#number".
+type pendingStore struct {
+	mut    sync.Mutex
+	merges map[string]pendingMerge
+}
+
+func loadPendingStore() (*pendingStore, error) {
+	s := &pendingStore{merges: make(map[string]pendingMerge)}
+	if err := loadState(pendingStateName, &s.merges); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func pendingKey(c comment) string {
+	return fmt.Sprintf("%s#%d", c.Repository.FullName, c.Issue.Number)
+}
+
+func (s *pendingStore) add(m pendingMerge) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.merges[pendingKey(m.Comment)] = m
+	if err := saveState(pendingStateName, s.merges); err != nil {
+		log.Println("Saving pending merges:", err)
+	}
+}
+
+func (s *pendingStore) remove(c comment) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if _, ok := s.merges[pendingKey(c)]; !ok {
+		return
+	}
+	delete(s.merges, pendingKey(c))
+	if err := saveState(pendingStateName, s.merges); err != nil {
+		log.Println("Saving pending merges:", err)
+	}
+}
+
+// extend moves the deadline of the pending merge of the PR.
+func (s *pendingStore) extend(c comment, deadline time.Time) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	m, ok := s.merges[pendingKey(c)]
+	if !ok || m.Deadline.Equal(deadline) {
+		return
+	}
+	m.Deadline = deadline
+	s.merges[pendingKey(c)] = m
+	if err := saveState(pendingStateName, s.merges); err != nil {
+		log.Println("Saving pending merges:", err)
+	}
+}
+
+// get returns the pending merge of the PR, if any.
+func (s *pendingStore) get(c comment) (pendingMerge, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	m, ok := s.merges[pendingKey(c)]
+	return m, ok
+}
+
+// all returns the pending merges.
+func (s *pendingStore) all() []pendingMerge {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	var res []pendingMerge
+	for _, m := range s.merges {
+		res = append(res, m)
+	}
+	return res
+}
+
+// isPending returns whether a merge of the PR is already pending.
+func (h *handler) isPending(c comment) bool {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	_, ok := h.pending[pendingKey(c)]
+	return ok
+}
+
+// markPending records the merge of the PR as pending, in memory and, as
+// given, on disk.
+func (h *handler) markPending(m pendingMerge, persist bool) {
+	h.mut.Lock()
+	h.pending[pendingKey(m.Comment)] = struct{}{}
+	h.mut.Unlock()
+	if persist {
+		h.pendingStore.add(m)
+	}
+}
+
+// unmarkPending records that the merge of the PR is no longer pending.
+func (h *handler) unmarkPending(c comment) {
+	h.mut.Lock()
+	delete(h.pending, pendingKey(c))
+	h.mut.Unlock()
+	h.pendingStore.remove(c)
+}
+
+// waitAndMerge marks the PR as pending and waits for it to become
+// mergeable in the background.
+func (h *handler) waitAndMerge(c comment, pr pr) {
+	maxWait, _, _ := h.waitTime(c)
+	now := time.Now()
+	h.markPending(pendingMerge{Comment: c, Started: now, Deadline: now.Add(maxWait), Head: pr.headSHA()}, true)
+	go h.delayedMerge(c, pr, now)
+}
+
+// resumeRetryPoll is how often pending merges whose PR couldn't be fetched
+// are tried again after a restart.
+var resumeRetryPoll = time.Minute
+
+// resumePending picks up the merges that were pending when we stopped.
+// Waiting resumes with the original deadline, so merges whose time ran
+// out while we were down get the usual response saying so. Merges whose PR
+// can't be fetched, as when GitHub is down too, are kept and tried again
+// until it can.
+func (h *handler) resumePending() {
+	var retry []pendingMerge
+	for _, m := range h.pendingStore.all() {
+		if !h.resumeMerge(m) {
+			// Meanwhile, it's still pending to anyone asking again.
+			h.markPending(m, false)
+			retry = append(retry, m)
+		}
+	}
+	if len(retry) > 0 {
+		go h.retryResume(retry)
+	}
+}
+
+// retryResume tries resuming the merges until every PR could be fetched.
+func (h *handler) retryResume(retry []pendingMerge) {
+	for len(retry) > 0 {
+		time.Sleep(resumeRetryPoll)
+		var again []pendingMerge
+		for _, m := range retry {
+			if !h.resumeMerge(m) {
+				again = append(again, m)
+			}
+		}
+		retry = again
+	}
+}
+
+// resumeMerge resumes the pending merge, or drops it if the PR is gone,
+// closed or was pushed to meanwhile, telling the requester. It returns
+// false if the PR couldn't be fetched to tell.
+func (h *handler) resumeMerge(m pendingMerge) bool {
+	c := m.Comment
+	pr, err := c.getPR()
+	switch {
+	case isNotFound(err):
+		h.dropPending(c, "the PR could no longer be found")
+		return true
+	case err != nil:
+		log.Printf("Resuming pending merge of PR %d on %s: %v", c.Issue.Number, c.Repository.FullName, err)
+		return false
+	case pr.State == "closed" || pr.State == "merged":
+		h.dropPending(c, "the PR was closed")
+		return true
+	case pr.State != "open":
+		log.Printf("Resuming pending merge of PR %d on %s: unexpected state %q", c.Issue.Number, c.Repository.FullName, pr.State)
+		return false
+	case m.Head != "" && pr.headSHA() != m.Head:
+		h.unmarkPending(c)
+		c.post(headMovedResponse(c, m.Head, pr.headSHA()), h.username, h.token)
+		return true
+	}
+	log.Printf("Resuming pending merge of PR %d on %s", c.Issue.Number, c.Repository.FullName)
+	if m.Train {
+		h.unmarkPending(c)
+		go h.rerun(c)
+		return true
+	}
+	h.markPending(m, false)
+	go h.delayedMerge(c, pr, m.Started)
+	return true
+}
+
+// dropPending forgets the pending merge, telling the requester why.
+func (h *handler) dropPending(c comment, why string) {
+	c.post(pendingDroppedResponse(c, why), h.username, h.token)
+	h.unmarkPending(c)
+	log.Printf("Dropped pending merge of PR %d on %s as %s", c.Issue.Number, c.Repository.FullName, why)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPendingStore(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+
+	s, err := loadPendingStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c1, c2 comment
+	c1.Repository.FullName, c1.Issue.Number = "foo/bar", 1
+	c2.Repository.FullName, c2.Issue.Number = "foo/baz", 1
+	c1.Comment.Body = "@bot merge --wait=2h"
+	started := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.add(pendingMerge{Comment: c1, Started: started, Deadline: started.Add(2 * time.Hour)})
+	s.add(pendingMerge{Comment: c2, Started: started, Train: true})
+	s.remove(c2)
+
+	loaded, err := loadPendingStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := loaded.all()
+	if len(all) != 1 || all[0].Comment.Comment.Body != c1.Comment.Body || !all[0].Started.Equal(started) {
+		t.Errorf("Unexpected pending merges %+v", all)
+	}
+}
+
+func TestResumePendingClosed(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+	defer func(d time.Duration) { resumeRetryPoll = d }(resumeRetryPoll)
+	resumeRetryPoll = 10 * time.Millisecond
+
+	var mut sync.Mutex
+	down := true
+	var comments []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		defer mut.Unlock()
+		switch {
+		case r.URL.Path == "/comments":
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			comments = append(comments, body.Body)
+		case down:
+			// Nothing that could be taken for a closed PR.
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write([]byte(`{"state": "closed"}`))
+		}
+	}))
+	defer srv.Close()
+
+	h := newHandler(nil, "bot", "", false)
+	var c comment
+	c.Repository.FullName, c.Issue.Number = "foo/bar", 1
+	c.Issue.PullRequest.URL = srv.URL + "/pull"
+	c.Issue.CommentsURL = srv.URL + "/comments"
+	h.pendingStore.add(pendingMerge{Comment: c, Started: time.Now()})
+
+	h.resumePending()
+	if len(h.pendingStore.all()) != 1 || !h.isPending(c) {
+		t.Error("Expected the merge to be kept while the PR can't be fetched")
+	}
+
+	mut.Lock()
+	down = false
+	mut.Unlock()
+	for i := 0; i < 100 && h.isPending(c); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	mut.Lock()
+	defer mut.Unlock()
+	if len(h.pendingStore.all()) != 0 || h.isPending(c) {
+		t.Error("Expected the merge of a closed PR to be dropped")
+	}
+	if len(comments) != 1 || !strings.Contains(comments[0], "as the PR was closed") {
+		t.Errorf("Expected the requester to be told, got %q", comments)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import "time"
+
+// A periodic service calls fn once every interval until stopped.
+type periodic struct {
+	interval time.Duration
+	fn       func()
+	stop     chan struct{}
+}
+
+func newPeriodic(interval time.Duration, fn func()) *periodic {
+	return &periodic{
+		interval: interval,
+		fn:       fn,
+		stop:     make(chan struct{}),
+	}
+}
+
+func (p *periodic) Serve() {
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			p.fn()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *periodic) Stop() {
+	p.stop <- struct{}{}
+}
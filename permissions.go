@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/url"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/google/go-github/github"
 	"golang.org/x/oauth2"
@@ -12,19 +15,23 @@ import (
 type permissions struct {
 	token         string
 	alwaysAllowed []string
-	teamMembers   map[string][]string // repo -> list of members
+	mut           sync.Mutex               // guards teamMembers
+	teamMembers   map[string][]string      // repo -> list of members
+	directory     map[string][]string      // "owner/name", "owner/*" or "*" -> members, instead of asking GitHub
+	forgeOf       func(repo string) string // where the repository is hosted, GitHub if not set
 }
 
 func (p *permissions) isAllowed(repo, login string) bool {
-	// Check the list of always allowed users
-	for _, user := range p.alwaysAllowed {
-		if login == user {
-			return true
-		}
+	// Check the list of always allowed users, who are GitHub logins
+	if p.isAdmin(repo, login) {
+		return true
 	}
 
 	// Check the cached list of team members for the given repo
-	for _, user := range p.teamMembers[repo] {
+	p.mut.Lock()
+	cached := p.teamMembers[repo]
+	p.mut.Unlock()
+	for _, user := range cached {
 		if login == user {
 			return true
 		}
@@ -37,8 +44,10 @@ func (p *permissions) isAllowed(repo, login string) bool {
 		return false
 	}
 	log.Println(" ... got", users)
+	p.mut.Lock()
 	p.teamMembers[repo] = users
-	for _, user := range p.teamMembers[repo] {
+	p.mut.Unlock()
+	for _, user := range users {
 		if login == user {
 			return true
 		}
@@ -50,13 +59,51 @@ func (p *permissions) isAllowed(repo, login string) bool {
 	return false
 }
 
+// isAdmin returns true if login is one of the always allowed users, who also
+// get to perform administrative actions. They're GitHub logins, so on other
+// forges, where anyone may have registered the same name, nobody is.
+func (p *permissions) isAdmin(repo, login string) bool {
+	if !p.onGitHub(repo) {
+		return false
+	}
+	for _, user := range p.alwaysAllowed {
+		if login == user {
+			return true
+		}
+	}
+	return false
+}
+
+// onGitHub returns whether the repository is hosted on GitHub.
+func (p *permissions) onGitHub(repo string) bool {
+	return p.forgeOf == nil || onGitHub(p.forgeOf(repo))
+}
+
 func (p *permissions) collaborators(repo string) ([]string, error) {
+	if p.directory != nil {
+		return p.directoryMembers(repo), nil
+	}
+	if p.forgeOf != nil {
+		if m, ok := forgeNamed(p.forgeOf(repo)).(memberLister); ok {
+			return m.members(repo)
+		}
+	}
+
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: p.token},
 	)
-	tc := oauth2.NewClient(oauth2.NoContext, ts)
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, apiClient)
+	tc := oauth2.NewClient(ctx, ts)
 
 	client := github.NewClient(tc)
+	base, err := url.Parse(githubAPI + "/")
+	if err != nil {
+		return nil, err
+	}
+	client.BaseURL = base
+	if client.UploadURL, err = url.Parse(githubUploads + "/"); err != nil {
+		return nil, err
+	}
 
 	opt := &github.ListOptions{PerPage: 50}
 	var allCollabs []*github.User
@@ -86,3 +133,14 @@ func (p *permissions) collaborators(repo string) ([]string, error) {
 	sort.Strings(users)
 	return users, nil
 }
+
+// directoryMembers returns the members listed for the repository in the
+// user directory, using the most specific entry.
+func (p *permissions) directoryMembers(repo string) []string {
+	for _, scope := range repoScopes(repo) {
+		if users, ok := p.directory[scope]; ok {
+			return users
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDirectoryMembers(t *testing.T) {
+	p := permissions{directory: map[string][]string{
+		"corp/secret": {"alice"},
+		"corp/*":      {"alice", "bob"},
+		"*":           {"carol"},
+	}}
+
+	cases := []struct {
+		repo  string
+		users []string
+	}{
+		{"corp/secret", []string{"alice"}},
+		{"corp/other", []string{"alice", "bob"}},
+		{"elsewhere/repo", []string{"carol"}},
+	}
+	for _, tc := range cases {
+		if users, _ := p.collaborators(tc.repo); !reflect.DeepEqual(users, tc.users) {
+			t.Errorf("Unexpected members %v for %s", users, tc.repo)
+		}
+	}
+}
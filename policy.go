@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// opaBinary is the Open Policy Agent executable used to evaluate policies.
+var opaBinary = "opa"
+
+const policyLogName = "policy-decisions.jsonl"
+
+var policyLogMut sync.Mutex
+
+// A policyInput is the document describing a merge request that policies
+// are evaluated against, available as input in Rego.
+type policyInput struct {
+	Repo      string        `json:"repo"`
+	PR        int           `json:"pr"`
+	Title     string        `json:"title"`
+	Base      string        `json:"base"`
+	Author    string        `json:"author"`
+	Requester string        `json:"requester"`
+	Admin     bool          `json:"admin"` // the requester is an always allowed user
+	Command   string        `json:"command"`
+	Time      time.Time     `json:"time"`
+	Weekday   string        `json:"weekday"`
+	Hour      int           `json:"hour"`
+	Files     []string      `json:"files"`
+	Checks    []checkResult `json:"checks"`
+	Approvers []string      `json:"approvers"`
+}
+
+// A policyDecision is logged for every evaluation, so that past requests
+// can be replayed against other policies.
+type policyDecision struct {
+	Time   time.Time   `json:"time"`
+	Policy string      `json:"policy"`
+	Input  policyInput `json:"input"`
+	Deny   []string    `json:"deny"`
+}
+
+// evaluatePolicy evaluates data.mergebot.deny in the Rego policy file
+// against the input, returning the reasons to deny the merge, if any.
+func evaluatePolicy(policy string, input policyInput) ([]string, error) {
+	bs, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newScript()
+	out := s.runPipe(bytes.NewReader(bs), opaBinary, "eval", "--format", "json", "--stdin-input", "--data", policy, "data.mergebot.deny")
+	if s.Error() != nil {
+		return nil, fmt.Errorf("%s", s.output.String())
+	}
+	return parseOPAResult([]byte(out))
+}
+
+// parseOPAResult returns the deny reasons from the output of opa eval. An
+// undefined deny rule allows the merge.
+func parseOPAResult(bs []byte) ([]string, error) {
+	var res struct {
+		Result []struct {
+			Expressions []struct {
+				Value interface{}
+			}
+		}
+	}
+	if err := json.Unmarshal(bs, &res); err != nil {
+		return nil, err
+	}
+
+	var deny []string
+	for _, r := range res.Result {
+		for _, e := range r.Expressions {
+			switch v := e.Value.(type) {
+			case []interface{}:
+				for _, msg := range v {
+					deny = append(deny, fmt.Sprint(msg))
+				}
+			case map[string]interface{}:
+				// deny[msg] = true style rules
+				for msg := range v {
+					deny = append(deny, msg)
+				}
+			case string:
+				deny = append(deny, v)
+			case bool:
+				if v {
+					deny = append(deny, "denied by policy")
+				}
+			}
+		}
+	}
+	sort.Strings(deny)
+	return deny, nil
+}
+
+// policyInputFor describes the merge request in the comment. Without the
+// files or reviews, rules about them couldn't deny anything, so not getting
+// them is an error.
+func (h *handler) policyInputFor(c comment, pr pr) (policyInput, error) {
+	now := time.Now()
+	in := policyInput{
+		Repo:      c.Repository.FullName,
+		PR:        c.Issue.Number,
+		Title:     pr.Title,
+		Base:      pr.Base.Ref,
+		Author:    c.Issue.User.Login,
+		Requester: c.Sender.Login,
+		Admin:     h.isAdmin(c.Repository.FullName, c.Sender.Login),
+		Command:   c.parseBody().command,
+		Time:      now.UTC(),
+		Weekday:   now.Weekday().String(),
+		Hour:      now.Hour(),
+	}
+	files, err := pr.getFiles(h.username, h.token)
+	if err != nil {
+		return in, fmt.Errorf("getting the changed files for the policy: %v", err)
+	}
+	for _, f := range files {
+		in.Files = append(in.Files, f.Filename)
+	}
+	for _, st := range h.getStatuses(c.Repository.FullName, pr) {
+		in.Checks = append(in.Checks, checkResult{Context: st.Context, State: st.State})
+	}
+	rs, err := pr.getReviews(h.username, h.token)
+	if err != nil {
+		return in, fmt.Errorf("getting the reviews for the policy: %v", err)
+	}
+	in.Approvers = approvers(rs)
+	return in, nil
+}
+
+// checkPolicy evaluates the repository policy, if any, for the merge
+// request and logs the decision. It returns the reasons to deny the merge.
+func (h *handler) checkPolicy(c comment, pr pr) ([]string, error) {
+	policy := h.settings.forRepo(c.Repository.FullName).Policy
+	if policy == "" {
+		return nil, nil
+	}
+
+	in, err := h.policyInputFor(c, pr)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := evaluatePolicy(policy, in)
+	if err != nil {
+		return nil, err
+	}
+
+	policyLogMut.Lock()
+	err = appendState(policyLogName, policyDecision{Time: in.Time, Policy: policy, Input: in, Deny: deny})
+	policyLogMut.Unlock()
+	if err != nil {
+		log.Println("Policy decision log:", err)
+	}
+
+	if len(deny) > 0 {
+		log.Printf("Policy %s denies merge of PR %d on %s: %s", policy, in.PR, in.Repo, strings.Join(deny, "; "))
+	}
+	return deny, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOPAResult(t *testing.T) {
+	cases := []struct {
+		out  string
+		deny []string
+	}{
+		{`{}`, nil},
+		{`{"result": [{"expressions": [{"value": [], "text": "data.mergebot.deny"}]}]}`, nil},
+		{`{"result": [{"expressions": [{"value": ["no merges on Friday", "needs two approvals"]}]}]}`, []string{"needs two approvals", "no merges on Friday"}},
+		{`{"result": [{"expressions": [{"value": {"frozen": true}}]}]}`, []string{"frozen"}},
+	}
+	for _, tc := range cases {
+		deny, err := parseOPAResult([]byte(tc.out))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !reflect.DeepEqual(deny, tc.deny) {
+			t.Errorf("Got %q, expected %q for %s", deny, tc.deny, tc.out)
+		}
+	}
+}
+
+func TestPolicyInputFailsClosed(t *testing.T) {
+	failing := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == failing:
+			http.Error(w, "oops", http.StatusInternalServerError)
+		case strings.HasSuffix(r.URL.Path, "/files"), strings.HasSuffix(r.URL.Path, "/reviews"):
+			w.Write([]byte(`[]`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	h := newHandler(nil, "bot", "token", false)
+	h.settings.defaults.Policy = "policy.rego"
+	var c comment
+	c.Repository.FullName = "foo/bar"
+	c.Issue.Number = 1
+	var p pr
+	p.URL = srv.URL + "/repos/foo/bar/pulls/1"
+
+	for _, path := range []string{"/repos/foo/bar/pulls/1/files", "/repos/foo/bar/pulls/1/reviews"} {
+		failing = path
+		if deny, err := h.checkPolicy(c, p); err == nil {
+			t.Errorf("Expected an error with %s failing, got %q", path, deny)
+		}
+	}
+}
@@ -1,30 +1,55 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
+	"time"
 )
 
 type pr struct {
 	Action      string
 	Number      int
 	PullRequest struct {
+		URL               string
+		AuthorAssociation string `json:"author_association"`
+		User              struct {
+			Login string
+		}
 		Head struct {
 			SHA string
 		}
-	} `json:"pull_request"`
+		Base struct {
+			Ref string
+		}
+	} `json:"pull_request"` // set in events
 	Repository struct {
 		FullName    string `json:"full_name"`
 		StatusesURL string `json:"statuses_url"` // set in events, contains {sha} placeholder
 	}
+	URL   string   `json:"url"` // set when getting manually
+	Title string   // set when getting manually
+	State string   // set when getting manually
+	User  struct { // set when getting manually
+		Login string
+	}
 	StatusesURL string   `json:"statuses_url"` // set when getting manually
 	HTMLURL     string   `json:"html_url"`     // set when getting manually
 	Base        struct { // set when getting manually
 		Ref string
 	}
+	Head struct { // set when getting manually
+		SHA string
+	}
+	Mergeable *bool     `json:"mergeable"` // set when getting manually, once GitHub has worked it out
+	Milestone *struct { // set when getting manually
+		Number int
+		Title  string
+	}
+	Forge string `json:"forge,omitempty"` // where the PR is, if not on GitHub
 }
 
 type prState string
@@ -37,56 +62,54 @@ const (
 )
 
 type status struct {
-	State   prState
-	Context string
-	Creator struct {
+	State       prState
+	Context     string
+	Description string
+	TargetURL   string    `json:"target_url"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Creator     struct {
 		Login string
 	}
 }
 
 func (p *pr) setStatus(state prState, context, description, username, token string) {
-	buf := new(bytes.Buffer)
-	json.NewEncoder(buf).Encode(map[string]string{
-		"state":       string(state),
-		"description": description,
-		"context":     context,
-	})
-
-	url := p.StatusesURL
-	if url == "" {
-		url = p.Repository.StatusesURL
-	}
-	url = strings.Replace(url, "{sha}", p.PullRequest.Head.SHA, 1)
+	p.setStatusLink(state, context, description, "", username, token)
+}
 
-	req, err := http.NewRequest("POST", url, buf)
-	if err != nil {
-		log.Println("Request:", err)
-		return
+// headSHA returns the head commit of the PR, as set in events or when
+// getting it manually.
+func (p *pr) headSHA() string {
+	if p.PullRequest.Head.SHA != "" {
+		return p.PullRequest.Head.SHA
 	}
-	req.SetBasicAuth(username, token)
+	return p.Head.SHA
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Println("Post:", err)
-		return
-	}
-	resp.Body.Close()
+// setStatusLink sets a status linking to the target URL.
+func (p *pr) setStatusLink(state prState, context, description, target, username, token string) {
+	p.forge().setStatus(p, state, context, description, target, username, token)
+}
 
-	if resp.StatusCode > 299 {
-		log.Println("Post:", resp.Status)
-		return
-	}
+// getStatuses returns the statuses of the PR on its forge.
+func (p *pr) getStatuses(repo, username, token string) []status {
+	return p.forge().getStatuses(p, repo, username, token)
 }
 
-func (p *pr) getStatuses(username, token string) []status {
-	req, err := http.NewRequest("GET", p.StatusesURL, nil)
+// getStatusesFrom returns the statuses from an internal status source
+// instead of GitHub. The source is given as a URL template expanding {repo},
+// {number} and {sha}, and must answer with a list of statuses in the same
+// format as GitHub, newest first. No credentials are sent.
+func (p *pr) getStatusesFrom(tmpl, repo string) []status {
+	req, err := http.NewRequest("GET", expandPRURL(tmpl, repo, *p), nil)
 	if err != nil {
 		log.Println("Request:", err)
 		return nil
 	}
-	req.SetBasicAuth(username, token)
+	return fetchStatuses(req)
+}
 
-	resp, err := http.DefaultClient.Do(req)
+func fetchStatuses(req *http.Request) []status {
+	resp, err := apiClient.Do(req)
 	if err != nil {
 		log.Println("Get:", err)
 		return nil
@@ -140,3 +163,14 @@ func overallStatus(ss []status, skip []string) prState {
 	}
 	return total
 }
+
+// statusFingerprint returns a string that changes whenever any of the
+// statuses change state or are updated.
+func statusFingerprint(ss []status) string {
+	var parts []string
+	for _, s := range ss {
+		parts = append(parts, fmt.Sprintf("%s=%s@%d", s.Context, s.State, s.UpdatedAt.Unix()))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
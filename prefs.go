@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const prefsStateName = "preferences.json"
+
+// How users may be notified of the outcome of merges they ask for or
+// author.
+const (
+	notifyMention = "mention" // @-mentioned in the responses, the default
+	notifyQuiet   = "quiet"   // named in the responses without an @-mention
+	notifyChat    = "chat"    // sent a direct message instead of a mention
+	notifyDigest  = "digest"  // sent a message summing up the outcomes now and then
+)
+
+// userPrefs are what a user has set with config commands. Zero valued
+// fields are unset.
+type userPrefs struct {
+	Notify    string `json:"notify,omitempty"`
+	Verbosity string `json:"verbosity,omitempty"` // of responses to their commands, instead of the repository's
+	Chat      string `json:"chat,omitempty"`      // the chat handle linked, by posting a code sent there
+	Away      string `json:"away,omitempty"`      // the day they're back, as YYYY-MM-DD
+	Backup    string `json:"backup,omitempty"`    // who looks after their merges while they're away
+}
+
+// prefKeys are the preferences that can be set, with their values if
+// they're limited to some.
+var prefKeys = map[string][]string{
+	"notify":    {notifyMention, notifyQuiet, notifyChat, notifyDigest},
+	"verbosity": {verbosityFull, verbosityTerse, verbositySilent},
+	"chat":      nil,
+}
+
+// set sets the preference, or clears it given an empty value.
+func (p *userPrefs) set(key, value string) error {
+	values, ok := prefKeys[key]
+	if !ok {
+		return fmt.Errorf("there's no %q preference; use notify, verbosity or chat", key)
+	}
+	if value != "" && values != nil {
+		valid := false
+		for _, v := range values {
+			valid = valid || v == value
+		}
+		if !valid {
+			return fmt.Errorf("%q is not a value for %s; use %s", value, key, strings.Join(values, ", "))
+		}
+	}
+	switch key {
+	case "notify":
+		p.Notify = value
+	case "verbosity":
+		p.Verbosity = value
+	case "chat":
+		p.Chat = value
+	}
+	return nil
+}
+
+// lines lists the preferences that are set, as markdown.
+func (p userPrefs) lines() []string {
+	var res []string
+	for _, kv := range [][2]string{{"notify", p.Notify}, {"verbosity", p.Verbosity}, {"chat", p.Chat}, {"away until", p.Away}, {"backup", p.Backup}} {
+		if kv[1] != "" {
+			res = append(res, fmt.Sprintf("%s: %s", kv[0], codeSpan(kv[1])))
+		}
+	}
+	return res
+}
+
+// prefStore keeps the preferences of users, along with the digests
+// waiting to be sent to them.
+type prefStore struct {
+	mut     sync.Mutex
+	Users   map[string]userPrefs `json:"users"`   // login -> preferences
+	Digests map[string][]string  `json:"digests"` // login -> outcomes since the last digest
+}
+
+func loadPrefStore() (*prefStore, error) {
+	s := &prefStore{Users: make(map[string]userPrefs), Digests: make(map[string][]string)}
+	if err := loadState(prefsStateName, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *prefStore) get(login string) userPrefs {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.Users[strings.ToLower(login)]
+}
+
+// update changes the preferences of the user with fn and saves them, unless
+// fn fails.
+func (s *prefStore) update(login string, fn func(*userPrefs) error) (userPrefs, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	login = strings.ToLower(login)
+	p := s.Users[login]
+	if err := fn(&p); err != nil {
+		return p, err
+	}
+	if p == (userPrefs{}) {
+		delete(s.Users, login)
+	} else {
+		s.Users[login] = p
+	}
+	return p, saveState(prefsStateName, s)
+}
+
+// addDigest adds the outcome to the next digest of the user.
+func (s *prefStore) addDigest(login, line string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	login = strings.ToLower(login)
+	s.Digests[login] = append(s.Digests[login], line)
+	if err := saveState(prefsStateName, s); err != nil {
+		log.Println("Saving preferences:", err)
+	}
+}
+
+// takeDigests returns the digests waiting, clearing them.
+func (s *prefStore) takeDigests() map[string][]string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	res := s.Digests
+	s.Digests = make(map[string][]string)
+	if err := saveState(prefsStateName, s); err != nil {
+		log.Println("Saving preferences:", err)
+	}
+	return res
+}
+
+// prefsOf returns the preferences of the user, for responses.
+var prefsOf = func(login string) userPrefs { return userPrefs{} }
+
+// unmention returns the text with the @-mentions of the user turned into
+// plain names.
+func unmention(text, login string) string {
+	if login == "" {
+		return text
+	}
+	re := regexp.MustCompile(`(?i)@` + regexp.QuoteMeta(login) + `([^\w-]|$)`)
+	return re.ReplaceAllString(text, login+"$1")
+}
+
+// mentionsAsPreferred returns the response without mentioning the sender
+// or author of the comment if they'd rather not be.
+func mentionsAsPreferred(text string, c comment) string {
+	for _, login := range []string{c.Sender.Login, c.Issue.User.Login} {
+		if n := prefsOf(login).Notify; n != "" && n != notifyMention {
+			text = unmention(text, login)
+		}
+	}
+	return text
+}
+
+// notifyOutcome tells the requester and author of the merge how it went,
+// where they prefer chat or digests to mentions.
+func (h *handler) notifyOutcome(c comment, p pr, description string) {
+	if h.prefs == nil || h.chat == nil {
+		return
+	}
+	line := strings.TrimSpace(fmt.Sprintf("%s#%d %s: %s %s", c.Repository.FullName, c.Issue.Number, p.Title, description, p.HTMLURL))
+	for _, login := range dedupe([]string{c.Sender.Login, c.Issue.User.Login}) {
+		prefs := h.prefs.get(login)
+		if prefs.Chat == "" {
+			continue
+		}
+		switch prefs.Notify {
+		case notifyChat:
+			go h.chat.send(prefs.Chat, line)
+		case notifyDigest:
+			h.prefs.addDigest(login, line)
+		}
+	}
+}
+
+// sendDigests sends the users who prefer digests what happened since the
+// last one.
+func (h *handler) sendDigests() {
+	digests := h.prefs.takeDigests()
+	logins := make([]string, 0, len(digests))
+	for login := range digests {
+		logins = append(logins, login)
+	}
+	sort.Strings(logins)
+	for _, login := range logins {
+		if chat := h.prefs.get(login).Chat; chat != "" {
+			text := "Merges since the last digest:\n- " + strings.Join(digests[login], "\n- ")
+			h.chat.send(chat, text)
+		}
+	}
+}
+
+// dedupe returns the non-empty strings, each once, in order.
+func dedupe(ss []string) []string {
+	var res []string
+	seen := make(map[string]bool)
+	for _, s := range ss {
+		if s != "" && !seen[strings.ToLower(s)] {
+			res = append(res, s)
+			seen[strings.ToLower(s)] = true
+		}
+	}
+	return res
+}
+
+// A chatNotifier sends direct messages through a chat integration, which
+// takes POST requests like {"user": "alice", "text": "..."}.
+type chatNotifier struct {
+	url string
+}
+
+func (n *chatNotifier) send(user, text string) {
+	err := sendAPIRequest("POST", n.url, map[string]string{"user": user, "text": text}, nil, func(*http.Request) {})
+	if err != nil {
+		log.Printf("Chat message to %s: %v", user, err)
+	}
+}
+
+// handleConfig sets or shows the preferences of the sender, as in
+// "config set notify quiet", "config unset notify" or "config".
+func (h *handler) handleConfig(c comment) {
+	fields := strings.Fields(c.parseBody().command)
+	var (
+		prefs userPrefs
+		err   error
+	)
+	switch {
+	case len(fields) == 1 || len(fields) == 2 && strings.ToLower(fields[1]) == "show":
+		prefs = h.prefs.get(c.Sender.Login)
+	case len(fields) == 4 && strings.ToLower(fields[1]) == "set":
+		key, value := strings.ToLower(fields[2]), strings.ToLower(fields[3])
+		if key == "chat" {
+			h.startLink(c, fields[3])
+			return
+		}
+		if key == "notify" && (value == notifyChat || value == notifyDigest) {
+			if h.chat == nil {
+				err = fmt.Errorf("there's no chat integration to send %s notifications with", value)
+				break
+			}
+			if h.prefs.get(c.Sender.Login).Chat == "" {
+				err = fmt.Errorf("link your chat handle first, with `config set chat HANDLE`")
+				break
+			}
+		}
+		prefs, err = h.prefs.update(c.Sender.Login, func(p *userPrefs) error { return p.set(key, value) })
+	case len(fields) == 3 && strings.ToLower(fields[2]) == "chat" && strings.ToLower(fields[1]) == "unset":
+		prefs, err = h.prefs.update(c.Sender.Login, func(p *userPrefs) error { return p.unlink() })
+	case len(fields) == 3 && strings.ToLower(fields[1]) == "unset":
+		prefs, err = h.prefs.update(c.Sender.Login, func(p *userPrefs) error { return p.set(strings.ToLower(fields[2]), "") })
+	default:
+		err = fmt.Errorf("say `config set KEY VALUE`, `config unset KEY` or `config`")
+	}
+	if err != nil {
+		c.post(badOptionResponse(c, err.Error()), h.username, h.token)
+		return
+	}
+	c.post(prefsResponse(c, prefs.lines()), h.username, h.token)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnmention(t *testing.T) {
+	tests := []struct {
+		text, login, want string
+	}{
+		{"Thanks, @alice!", "alice", "Thanks, alice!"},
+		{"@Alice: Merged.", "alice", "alice: Merged."},
+		{"cc @alice-bob and @alice", "alice", "cc @alice-bob and alice"},
+		{"Thanks, @bob!", "alice", "Thanks, @bob!"},
+		{"Thanks, @bob!", "", "Thanks, @bob!"},
+	}
+	for _, test := range tests {
+		if got := unmention(test.text, test.login); got != test.want {
+			t.Errorf("unmention(%q, %q) = %q, want %q", test.text, test.login, got, test.want)
+		}
+	}
+}
+
+func TestPrefs(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+
+	var posted []string
+	messages := make(chan map[string]string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/comments":
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			posted = append(posted, body.Body)
+		case "/chat":
+			var msg map[string]string
+			json.NewDecoder(r.Body).Decode(&msg)
+			messages <- msg
+		}
+	}))
+	defer srv.Close()
+
+	h := newHandler(nil, "bot", "", false)
+	defer func(fn func(string) userPrefs) { prefsOf = fn }(prefsOf)
+	prefsOf = h.prefs.get
+	comment := func(login, body string) comment {
+		var c comment
+		c.Repository.FullName = "foo/bar"
+		c.Sender.Login = login
+		c.Issue.User.Login = "carol"
+		c.Issue.Number = 4
+		c.Comment.Body = body
+		c.Issue.CommentsURL = srv.URL + "/comments"
+		return c
+	}
+
+	h.handleConfig(comment("alice", "@bot config set notify digest"))
+	if len(posted) != 1 || !strings.Contains(posted[0], "no chat integration") {
+		t.Fatalf("Expected digests to need chat, posted %q", posted)
+	}
+	h.chat = &chatNotifier{url: srv.URL + "/chat"}
+	posted = nil
+	h.handleConfig(comment("alice", "@bot config set notify digest"))
+	h.handleConfig(comment("alice", "@bot config set chat Alice.S"))
+	var code string
+	select {
+	case msg := <-messages:
+		fields := strings.Fields(msg["text"][strings.Index(msg["text"], "`@bot link"):])
+		if msg["user"] != "Alice.S" || len(fields) < 3 {
+			t.Fatalf("Unexpected chat message %v", msg)
+		}
+		code = strings.Trim(fields[2], "`")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a code in chat")
+	}
+	h.handleLink(comment("mallory", "@bot link "+code))
+	h.handleLink(comment("alice", "@bot link "+code))
+	for _, body := range []string{"@bot config set notify digest", "@bot config set verbosity loud", "@bot config set verbosity terse", "@bot config unset verbosity", "@bot config"} {
+		h.handleConfig(comment("alice", body))
+	}
+	if len(posted) != 9 || !strings.Contains(posted[0], "link your chat handle first") || !strings.Contains(posted[1], "sent `Alice.S` a code") || !strings.Contains(posted[2], "for someone else") {
+		t.Fatalf("Unexpected responses %q", posted)
+	}
+	if !strings.Contains(posted[3], "Linked chat handle `Alice.S`") || !strings.Contains(posted[5], `"loud" is not a value for verbosity`) || !strings.Contains(posted[8], "notify: `digest`") || !strings.Contains(posted[8], "chat: `Alice.S`") || strings.Contains(posted[8], "verbosity") {
+		t.Fatalf("Unexpected responses %q", posted)
+	}
+	if !strings.HasPrefix(posted[8], "alice: ") {
+		t.Errorf("Expected alice not to be mentioned, got %q", posted[8])
+	}
+	loaded, err := loadPrefStore()
+	if err != nil || loaded.Users["alice"].Notify != notifyDigest {
+		t.Errorf("Expected the preferences to be persisted, got %+v, %v", loaded.Users, err)
+	}
+	if login, ok := h.prefs.loginFor("alice.s"); !ok || login != "alice" {
+		t.Errorf("Expected Alice.S to be linked to alice, got %q", login)
+	}
+
+	h.prefs.link("carol", "carol")
+	h.prefs.update("carol", func(p *userPrefs) error { return p.set("notify", notifyChat) })
+	var p pr
+	p.Title = "Fix it"
+	h.mergeStatus(comment("alice", "@bot merge"), p, statePending, "Waiting for checks to merge.")
+	h.mergeStatus(comment("alice", "@bot merge"), p, stateSuccess, "Merged as abc.")
+	h.sendDigests()
+	h.sendDigests()
+	for i := 0; i < 2; i++ {
+		var msg map[string]string
+		select {
+		case msg = <-messages:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected a chat message")
+		}
+		switch msg["user"] {
+		case "carol":
+			if msg["text"] != "foo/bar#4 Fix it: Merged as abc." {
+				t.Errorf("Unexpected chat message %q", msg["text"])
+			}
+		case "Alice.S":
+			if !strings.Contains(msg["text"], "since the last digest:\n- foo/bar#4 Fix it: Merged as abc.") {
+				t.Errorf("Unexpected digest %q", msg["text"])
+			}
+		default:
+			t.Errorf("Unexpected message %v", msg)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// A progress keeps the requester informed during a merge that takes a
+// while. Nothing is posted until the merge has gone on for the configured
+// interval; from then on a single comment is updated with the current phase
+// every interval, and deleted when the merge is done.
+type progress struct {
+	c        comment
+	username string
+	token    string
+	interval time.Duration
+	start    time.Time
+	stopped  chan struct{}
+
+	mut   sync.Mutex
+	phase string
+	url   string // of the progress comment, once posted
+}
+
+// startProgress starts reporting the progress of a merge, if the repository
+// wants it. The returned progress may be nil; its methods are nil safe.
+func (h *handler) startProgress(c comment) *progress {
+	interval := h.settings.forRepo(c.Repository.FullName).ProgressInterval.Duration
+	if interval <= 0 {
+		return nil
+	}
+	p := &progress{
+		c:        c,
+		username: h.username,
+		token:    h.token,
+		interval: interval,
+		start:    time.Now(),
+		stopped:  make(chan struct{}),
+		phase:    "starting",
+	}
+	go p.run()
+	return p
+}
+
+// set records the phase the merge has reached, as in "fetching".
+func (p *progress) set(phase string) {
+	if p == nil {
+		return
+	}
+	p.mut.Lock()
+	p.phase = phase
+	p.mut.Unlock()
+}
+
+// current returns the phase the merge has reached.
+func (p *progress) current() string {
+	if p == nil {
+		return "merging"
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.phase
+}
+
+// stop ends the reporting and removes the progress comment.
+func (p *progress) stop() {
+	if p == nil {
+		return
+	}
+	close(p.stopped)
+
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.url != "" {
+		apiRequest("DELETE", p.url, nil, nil, p.username, p.token)
+		p.url = ""
+	}
+}
+
+func (p *progress) run() {
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.stopped:
+			return
+		case now := <-t.C:
+			p.update(now)
+		}
+	}
+}
+
+func (p *progress) update(now time.Time) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	select {
+	case <-p.stopped:
+		return // lost the race against stop; don't post after the fact
+	default:
+	}
+
+	text := progressResponse(p.c, p.phase, now.Sub(p.start).Round(time.Second))
+	if text == "" {
+		return // not reported at this verbosity
+	}
+	body := map[string]string{"body": secrets.redact(text)}
+	if p.url != "" {
+		if err := apiRequest("PATCH", p.url, body, nil, p.username, p.token); err != nil {
+			log.Println("Updating progress:", err)
+		}
+		return
+	}
+	var posted struct {
+		URL string `json:"url"`
+	}
+	if err := apiRequest("POST", p.c.Issue.CommentsURL, body, &posted, p.username, p.token); err != nil {
+		log.Println("Posting progress:", err)
+		return
+	}
+	p.url = posted.URL
+}
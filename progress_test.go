@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	var mut sync.Mutex
+	var methods []string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		methods = append(methods, r.Method)
+		mut.Unlock()
+		if r.Method == "POST" {
+			w.Write([]byte(`{"url": "` + srv.URL + `/comments/1"}`))
+		}
+	}))
+	defer srv.Close()
+
+	h := newHandler(nil, "bot", "", false)
+	h.settings.defaults.ProgressInterval = duration{10 * time.Millisecond}
+	var c comment
+	c.Issue.CommentsURL = srv.URL + "/issues/1/comments"
+
+	p := h.startProgress(c)
+	p.set("fetching")
+	time.Sleep(35 * time.Millisecond)
+	p.stop()
+
+	mut.Lock()
+	defer mut.Unlock()
+	if len(methods) < 3 || methods[0] != "POST" || methods[1] != "PATCH" || methods[len(methods)-1] != "DELETE" {
+		t.Errorf("Unexpected requests %q", methods)
+	}
+
+	h.settings.defaults.ProgressInterval = duration{}
+	if p := h.startProgress(c); p != nil {
+		t.Error("Expected no progress reporting when unset")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// A protectionTemplate declares the branch protection settings the bot
+// depends on. Direct pushes by the bot must still be possible, so admins are
+// not included in the enforcement unless the template says so.
+type protectionTemplate struct {
+	Branches        []string // defaults to the repository's default branch
+	RequiredChecks  []string `json:"required_checks"`
+	Strict          bool     // require branches to be up to date
+	RequiredReviews int      `json:"required_reviews"`
+	DismissStale    bool     `json:"dismiss_stale_reviews"`
+	LinearHistory   bool     `json:"linear_history"`
+	EnforceAdmins   bool     `json:"enforce_admins"`
+}
+
+func loadProtectionTemplate(path string) (protectionTemplate, error) {
+	var t protectionTemplate
+	fd, err := os.Open(path)
+	if err != nil {
+		return t, err
+	}
+	defer fd.Close()
+	err = json.NewDecoder(fd).Decode(&t)
+	return t, err
+}
+
+// branchProtection is the protection as reported by the API.
+type branchProtection struct {
+	RequiredStatusChecks *struct {
+		Strict   bool
+		Contexts []string
+	} `json:"required_status_checks"`
+	RequiredPullRequestReviews *struct {
+		RequiredApprovingReviewCount int  `json:"required_approving_review_count"`
+		DismissStaleReviews          bool `json:"dismiss_stale_reviews"`
+	} `json:"required_pull_request_reviews"`
+	RequiredLinearHistory struct {
+		Enabled bool
+	} `json:"required_linear_history"`
+	EnforceAdmins struct {
+		Enabled bool
+	} `json:"enforce_admins"`
+}
+
+// drift returns a description of each way the actual protection differs from
+// the template.
+func (t protectionTemplate) drift(p branchProtection) []string {
+	var res []string
+
+	var contexts []string
+	strict := false
+	if p.RequiredStatusChecks != nil {
+		contexts = append(contexts, p.RequiredStatusChecks.Contexts...)
+		strict = p.RequiredStatusChecks.Strict
+	}
+	want := append([]string(nil), t.RequiredChecks...)
+	sort.Strings(contexts)
+	sort.Strings(want)
+	if len(want) != len(contexts) || (len(want) > 0 && !reflect.DeepEqual(want, contexts)) {
+		res = append(res, fmt.Sprintf("required checks are [%s], not [%s]", strings.Join(contexts, ", "), strings.Join(want, ", ")))
+	}
+	if len(want) > 0 && strict != t.Strict {
+		res = append(res, fmt.Sprintf("strict status checks is %v, not %v", strict, t.Strict))
+	}
+
+	reviews, dismiss := 0, false
+	if p.RequiredPullRequestReviews != nil {
+		reviews = p.RequiredPullRequestReviews.RequiredApprovingReviewCount
+		dismiss = p.RequiredPullRequestReviews.DismissStaleReviews
+	}
+	if reviews != t.RequiredReviews {
+		res = append(res, fmt.Sprintf("required reviews is %d, not %d", reviews, t.RequiredReviews))
+	}
+	if t.RequiredReviews > 0 && dismiss != t.DismissStale {
+		res = append(res, fmt.Sprintf("dismiss stale reviews is %v, not %v", dismiss, t.DismissStale))
+	}
+
+	if p.RequiredLinearHistory.Enabled != t.LinearHistory {
+		res = append(res, fmt.Sprintf("linear history is %v, not %v", p.RequiredLinearHistory.Enabled, t.LinearHistory))
+	}
+	if p.EnforceAdmins.Enabled != t.EnforceAdmins {
+		res = append(res, fmt.Sprintf("enforce admins is %v, not %v", p.EnforceAdmins.Enabled, t.EnforceAdmins))
+	}
+
+	return res
+}
+
+// request returns the body of a protection update request implementing the
+// template.
+func (t protectionTemplate) request() interface{} {
+	req := map[string]interface{}{
+		"enforce_admins":          t.EnforceAdmins,
+		"required_linear_history": t.LinearHistory,
+		"restrictions":            nil,
+	}
+	if len(t.RequiredChecks) > 0 {
+		req["required_status_checks"] = map[string]interface{}{
+			"strict":   t.Strict,
+			"contexts": t.RequiredChecks,
+		}
+	} else {
+		req["required_status_checks"] = nil
+	}
+	if t.RequiredReviews > 0 {
+		req["required_pull_request_reviews"] = map[string]interface{}{
+			"required_approving_review_count": t.RequiredReviews,
+			"dismiss_stale_reviews":           t.DismissStale,
+		}
+	} else {
+		req["required_pull_request_reviews"] = nil
+	}
+	return req
+}
+
+// The protectionSyncer compares branch protection on each served repository
+// with the template, reporting and optionally correcting any drift.
+type protectionSyncer struct {
+	h        *handler
+	template protectionTemplate
+	correct  bool
+}
+
+func (s *protectionSyncer) syncAll() {
+	for _, repo := range s.h.servedRepos() {
+		if err := s.sync(repo); err != nil {
+			log.Printf("Branch protection sync on %s failed: %v", repo, err)
+		}
+	}
+}
+
+func (s *protectionSyncer) sync(repo string) error {
+	branches := s.template.Branches
+	if len(branches) == 0 {
+		var info repoInfo
+		if err := apiRequest("GET", fmt.Sprintf("%s/repos/%s", githubAPI, repo), nil, &info, s.h.username, s.h.token); err != nil {
+			return err
+		}
+		branches = []string{info.DefaultBranch}
+	}
+
+	for _, branch := range branches {
+		url := fmt.Sprintf("%s/repos/%s/branches/%s/protection", githubAPI, repo, branch)
+
+		var cur branchProtection
+		if err := apiRequest("GET", url, nil, &cur, s.h.username, s.h.token); err != nil && !isNotFound(err) {
+			return err
+		}
+
+		drift := s.template.drift(cur)
+		if len(drift) == 0 {
+			continue
+		}
+		log.Printf("Branch protection on %s %s has drifted: %s", repo, branch, strings.Join(drift, "; "))
+		if !s.correct {
+			continue
+		}
+
+		if err := apiRequest("PUT", url, s.template.request(), nil, s.h.username, s.h.token); err != nil {
+			return err
+		}
+		log.Printf("Corrected branch protection on %s %s", repo, branch)
+	}
+	return nil
+}
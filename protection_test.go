@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestProtectionDrift(t *testing.T) {
+	tmpl := protectionTemplate{
+		RequiredChecks:  []string{"ci/b", "ci/a"},
+		RequiredReviews: 1,
+		LinearHistory:   true,
+	}
+
+	var matching branchProtection
+	json.Unmarshal([]byte(`{
+		"required_status_checks": {"strict": false, "contexts": ["ci/a", "ci/b"]},
+		"required_pull_request_reviews": {"required_approving_review_count": 1},
+		"required_linear_history": {"enabled": true}
+	}`), &matching)
+	if d := tmpl.drift(matching); len(d) != 0 {
+		t.Error("Unexpected drift:", d)
+	}
+
+	if d := tmpl.drift(branchProtection{}); len(d) != 3 {
+		t.Error("Expected three differences from unprotected branch, got", d)
+	}
+}
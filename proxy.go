@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// apiClient is the HTTP client used for all API requests.
+var apiClient = http.DefaultClient
+
+// gitEnv is added to the environment of every command run by scripts.
+var gitEnv []string
+
+// proxyFunc returns a proxy selection function for http.Transport that uses
+// the proxy for all hosts except those matched by the no proxy list.
+func proxyFunc(proxy *url.URL, noProxy []string) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if noProxyMatch(req.URL.Hostname(), noProxy) {
+			return nil, nil
+		}
+		return proxy, nil
+	}
+}
+
+// noProxyMatch returns true if the host matches any of the entries, which
+// are host names, domain suffixes (with or without a leading dot), IP
+// addresses, CIDR networks or "*".
+func noProxyMatch(host string, noProxy []string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		case ip != nil:
+			if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(ip) {
+				return true
+			}
+			if entry == host {
+				return true
+			}
+		default:
+			entry = strings.TrimPrefix(entry, ".")
+			if host == entry || strings.HasSuffix(host, "."+entry) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setAPIProxy makes API requests go through the given HTTP(S) or SOCKS5
+// proxy, except to the hosts in the no proxy list.
+func setAPIProxy(proxy string, noProxy []string) error {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return err
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = proxyFunc(u, noProxy)
+	apiClient = &http.Client{Transport: tr}
+	return nil
+}
+
+// setGitProxy makes git operations go through the given proxy, for both
+// HTTP(S) and SSH remotes, except to the hosts in the no proxy list.
+func setGitProxy(proxy string, noProxy []string) error {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return err
+	}
+
+	var ncProto string
+	switch u.Scheme {
+	case "http", "https":
+		ncProto = "connect"
+	case "socks5", "socks5h":
+		ncProto = "5"
+	default:
+		return fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+
+	np := strings.Join(noProxy, ",")
+	gitEnv = append(gitEnv,
+		"http_proxy="+proxy,
+		"https_proxy="+proxy,
+		"all_proxy="+proxy,
+		"no_proxy="+np,
+		"NO_PROXY="+np,
+	)
+
+	// SSH doesn't know about proxies on its own. The no proxy list can't be
+	// honored here without a wrapper script.
+	gitEnv = append(gitEnv, fmt.Sprintf("GIT_SSH_COMMAND=ssh -o ProxyCommand='nc -X %s -x %s %%h %%p'", ncProto, u.Host))
+	return nil
+}
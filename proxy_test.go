@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestNoProxyMatch(t *testing.T) {
+	list := []string{"internal.corp", ".example.com", "10.0.0.0/8", "192.168.1.1", "localhost:8080"}
+	cases := []struct {
+		host  string
+		match bool
+	}{
+		{"internal.corp", true},
+		{"git.internal.corp", true},
+		{"example.com", true},
+		{"api.example.com", true},
+		{"notexample.com", false},
+		{"github.com", false},
+		{"10.1.2.3", true},
+		{"192.168.1.1", true},
+		{"192.168.1.2", false},
+		{"localhost", true},
+	}
+	for _, tc := range cases {
+		if m := noProxyMatch(tc.host, list); m != tc.match {
+			t.Errorf("noProxyMatch(%q) = %v, expected %v", tc.host, m, tc.match)
+		}
+	}
+
+	if !noProxyMatch("anything", []string{"*"}) {
+		t.Error("Expected * to match anything")
+	}
+}
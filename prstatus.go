@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// handleStatus tells how the PR stands as far as we're concerned: what
+// its checks say, where it is in the queue, and whether the one asking may
+// merge it.
+func (h *handler) handleStatus(c comment) {
+	pr, err := c.getPR()
+	if err != nil {
+		log.Println("No pull request:", err)
+		return
+	}
+	c.post(prStatusResponse(c, h.prStatusLines(c, pr, time.Now())), h.username, h.token)
+}
+
+func (h *handler) prStatusLines(c comment, pr pr, now time.Time) []string {
+	repo := c.Repository.FullName
+	state, notes := h.checkStatus(repo, pr, nil)
+	lines := []string{fmt.Sprintf("Checks are %s.", state)}
+	lines = append(lines, notes...)
+
+	position := h.trainPosition(c, pr)
+	switch {
+	case position > 0:
+		lines = append(lines, fmt.Sprintf("Queued in the merge train for %s at position %d.", pr.Base.Ref, position))
+	case h.isPending(c):
+		line := "Waiting to merge."
+		if m, ok := h.pendingStore.get(c); ok && !m.Deadline.IsZero() {
+			left := m.Deadline.Sub(now).Round(time.Second)
+			if left < 0 {
+				left = 0
+			}
+			line = fmt.Sprintf("Waiting to merge, giving up in %v unless CI keeps making progress.", left)
+		}
+		lines = append(lines, line)
+	default:
+		lines = append(lines, "Not waiting to merge.")
+	}
+
+	if h.isAllowed(repo, c.Sender.Login) {
+		lines = append(lines, fmt.Sprintf("@%s may merge it.", c.Sender.Login))
+	} else {
+		lines = append(lines, fmt.Sprintf("@%s may not merge it.", c.Sender.Login))
+	}
+	return lines
+}
+
+// trainPosition returns where the PR is in its merge train, counting from
+// one, or zero if it isn't in one.
+func (h *handler) trainPosition(c comment, pr pr) int {
+	defer h.lockRepo(c.Repository.FullName)()
+
+	h.mut.Lock()
+	t := h.trains[c.Repository.FullName+":"+pr.Base.Ref]
+	h.mut.Unlock()
+	if t == nil {
+		return 0
+	}
+	for i, car := range t.queue {
+		if car.c.Issue.Number == c.Issue.Number {
+			return i + 1
+		}
+	}
+	return 0
+}
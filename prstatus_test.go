@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPRStatusLines(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/statuses":
+			w.Write([]byte(`[{"state": "pending", "context": "ci"}]`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	h := newHandler(nil, "bot", "", false)
+	h.settings.repos = map[string]repoSettings{"foo/bar": {AllowedUsers: []string{"alice"}}}
+	var p pr
+	p.Base.Ref = "main"
+	p.StatusesURL = srv.URL + "/statuses"
+	comment := func(number int, login string) comment {
+		var c comment
+		c.Repository.FullName = "foo/bar"
+		c.Issue.Number = number
+		c.Sender.Login = login
+		return c
+	}
+	now := time.Now()
+
+	got := h.prStatusLines(comment(1, "bob"), p, now)
+	want := []string{"Checks are pending.", "Not waiting to merge.", "@bob may not merge it."}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %q; want %q", got, want)
+	}
+
+	h.markPending(pendingMerge{Comment: comment(1, "alice"), Started: now, Deadline: now.Add(90 * time.Second)}, true)
+	got = h.prStatusLines(comment(1, "alice"), p, now)
+	want = []string{"Checks are pending.", "Waiting to merge, giving up in 1m30s unless CI keeps making progress.", "@alice may merge it."}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %q; want %q", got, want)
+	}
+
+	h.trains["foo/bar:main"] = &train{repo: "foo/bar", base: "main", queue: []trainCar{{c: comment(3, "alice")}, {c: comment(2, "alice")}}}
+	got = h.prStatusLines(comment(2, "alice"), p, now)
+	if got[1] != "Queued in the merge train for main at position 2." {
+		t.Errorf("Unexpected queue line %q", got[1])
+	}
+}
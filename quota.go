@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A byteSize is a number of bytes, given like "500M" or "20G".
+type byteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   byteSize
+}{{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}}
+
+func parseByteSize(s string) (byteSize, error) {
+	s = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	mult := byteSize(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSuffix(s, u.suffix), u.size
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("%q is not a size", s)
+	}
+	return byteSize(v * float64(mult)), nil
+}
+
+func (b byteSize) String() string {
+	for _, u := range byteUnits {
+		if b >= u.size {
+			return strconv.FormatFloat(float64(b)/float64(u.size), 'f', -1, 64) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(b), 10)
+}
+
+// Set implements flag.Value.
+func (b *byteSize) Set(s string) error {
+	v, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+func (b byteSize) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.String())
+}
+
+func (b *byteSize) UnmarshalJSON(bs []byte) error {
+	var s string
+	if err := json.Unmarshal(bs, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(bs, &n); err != nil {
+			return err
+		}
+		*b = byteSize(n)
+		return nil
+	}
+	return b.Set(s)
+}
+
+// A quotaError refuses a clone that doesn't fit.
+type quotaError struct {
+	repo   string
+	reason string
+}
+
+func (e *quotaError) Error() string {
+	return fmt.Sprintf("not cloning %s: %s", e.repo, e.reason)
+}
+
+// dirSize returns the total size of the files under dir.
+func dirSize(dir string) (byteSize, error) {
+	var size byteSize
+	err := filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // removed while walking, as git does with lock files
+			}
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			size += byteSize(fi.Size())
+		}
+		return nil
+	})
+	return size, err
+}
+
+// diskUsage returns the size of each clone, and their total.
+func (h *handler) diskUsage() (map[string]byteSize, byteSize) {
+	sizes := make(map[string]byteSize)
+	var total byteSize
+	for _, repo := range h.servedRepos() {
+		size, err := dirSize(repo)
+		if err != nil {
+			log.Println("Disk usage:", err)
+			continue
+		}
+		sizes[repo] = size
+		total += size
+	}
+	metrics.set("disk_usage_bytes", int64(total))
+	return sizes, total
+}
+
+// repoSize returns the size GitHub reports for the repository, which is
+// roughly that of a fresh clone.
+func (h *handler) repoSize(repo string) (byteSize, error) {
+	var info struct {
+		Size int64 // in kilobytes
+	}
+	if err := apiRequest("GET", fmt.Sprintf("%s/repos/%s", githubAPI, repo), nil, &info, h.username, h.token); err != nil {
+		return 0, err
+	}
+	return byteSize(info.Size) << 10, nil
+}
+
+// makeRoom checks that a clone of the repository fits the quotas, evicting
+// other clones if need be. It's called with the repository locked.
+func (h *handler) makeRoom(repo string) error {
+	perRepo := h.settings.forRepo(repo).DiskQuota
+	if perRepo == 0 && h.diskQuota == 0 {
+		return nil
+	}
+	size, err := h.repoSize(repo)
+	if err != nil {
+		log.Printf("Size of %s unknown, cloning anyway: %v", repo, err)
+		return nil
+	}
+	if perRepo > 0 && size > perRepo {
+		return h.refuseClone(repo, fmt.Sprintf("at %v it's over its disk quota of %v", size, perRepo))
+	}
+	if h.diskQuota == 0 {
+		return nil
+	}
+	sizes, total := h.diskUsage()
+	if total+size <= h.diskQuota {
+		return nil
+	}
+
+	for _, victim := range h.evictionOrder(repo) {
+		unlock := h.repos.tryLock(victim)
+		if unlock == nil {
+			continue // in use
+		}
+		err := os.RemoveAll(victim)
+		unlock()
+		if err != nil {
+			log.Printf("Evicting %s: %v", victim, err)
+			continue
+		}
+		total -= sizes[victim]
+		metrics.add("clones_evicted", 1)
+		metrics.set("disk_usage_bytes", int64(total))
+		log.Printf("Evicted the clone of %s to make room for %s", victim, repo)
+		if total+size <= h.diskQuota {
+			return nil
+		}
+	}
+	return h.refuseClone(repo, fmt.Sprintf("at %v it doesn't fit the disk quota of %v, with %v in use by clones that can't be evicted", size, h.diskQuota, total))
+}
+
+// refuseClone alerts the operator to a clone refused for lack of space.
+func (h *handler) refuseClone(repo, reason string) error {
+	metrics.add("clones_refused", 1)
+	log.Printf("ALERT: refusing to clone %s: %s", repo, reason)
+	return &quotaError{repo: repo, reason: reason}
+}
+
+// evictionOrder returns the other cloned repositories, least recently
+// merged first.
+func (h *handler) evictionOrder(repo string) []string {
+	records, err := readMerges(nil)
+	if err != nil {
+		log.Println("Merge log:", err)
+	}
+	last := make(map[string]time.Time)
+	for _, r := range records {
+		if r.Time.After(last[r.Repo]) {
+			last[r.Repo] = r.Time
+		}
+	}
+	var res []string
+	for _, r := range h.servedRepos() {
+		if r != repo {
+			res = append(res, r)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return last[res[i]].Before(last[res[j]])
+	})
+	return res
+}
+
+// clone clones the repository, if it fits the disk quotas. It's called
+// with the repository locked.
+func (h *handler) clone(repo string) error {
+	return h.cloneContext(context.Background(), repo)
+}
+
+// cloneContext clones like clone, but gives up when the context is done.
+func (h *handler) cloneContext(ctx context.Context, repo string) error {
+	if err := h.makeRoom(repo); err != nil {
+		return err
+	}
+	var args []string
+	if rs := h.settings.forRepo(repo); rs.SparseCheckout != nil && *rs.SparseCheckout {
+		args = append(args, "--sparse") // just the top level until there's work to do
+	}
+	h.refreshCredentials(repo)
+	return cloneContext(ctx, repo, h.cloneURL(repo), args...)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want byteSize
+		str  string
+	}{
+		{"0", 0, "0"},
+		{"512", 512, "512"},
+		{"1k", 1 << 10, "1K"},
+		{"500M", 500 << 20, "500M"},
+		{"1.5G", 3 << 29, "1.5G"},
+		{"20GB", 20 << 30, "20G"},
+		{"2T", 2 << 40, "2T"},
+	}
+	for _, test := range tests {
+		got, err := parseByteSize(test.in)
+		if err != nil || got != test.want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", test.in, got, err, test.want)
+		}
+		if got.String() != test.str {
+			t.Errorf("%d.String() = %q; want %q", got, got.String(), test.str)
+		}
+	}
+	for _, in := range []string{"", "G", "-1M", "lots"} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q) succeeded", in)
+		}
+	}
+}
+
+func TestMakeRoom(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(t.TempDir())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/foo/new":
+			w.Write([]byte(`{"size": 2}`))
+		case "/repos/foo/huge":
+			w.Write([]byte(`{"size": 100}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	// Three clones of 1K each, the middle one merged least recently and
+	// the last one never.
+	for _, repo := range []string{"foo/a", "foo/b", "foo/c"} {
+		os.MkdirAll(filepath.Join(repo, ".git"), 0755)
+		os.WriteFile(filepath.Join(repo, ".git", "pack"), make([]byte, 1<<10), 0644)
+	}
+	now := time.Now()
+	recordMerge(mergeRecord{Repo: "foo/a", Time: now})
+	recordMerge(mergeRecord{Repo: "foo/b", Time: now.Add(-time.Hour)})
+
+	h := newHandler(nil, "bot", "", false)
+	h.settings = &settings{defaults: repoSettings{DiskQuota: 50 << 10}}
+	h.diskQuota = 4 << 10
+
+	if got, want := h.evictionOrder("foo/new"), []string{"foo/c", "foo/b", "foo/a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("evictionOrder = %v; want %v", got, want)
+	}
+
+	// The clone in use is skipped.
+	unlock := h.repos.lock("foo/c")
+	if err := h.makeRoom("foo/new"); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if got, want := h.servedRepos(), []string{"foo/a", "foo/c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after eviction, cloned %v; want %v", got, want)
+	}
+
+	if _, ok := h.makeRoom("foo/huge").(*quotaError); !ok {
+		t.Error("cloned a repository over its quota")
+	}
+	h.settings.defaults.DiskQuota = 0
+	if _, ok := h.makeRoom("foo/huge").(*quotaError); !ok {
+		t.Error("cloned a repository over the overall quota")
+	}
+	if got, want := h.servedRepos(), []string(nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("after eviction, cloned %v; want %v", got, want)
+	}
+}
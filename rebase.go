@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Merge strategies.
+const (
+	strategySquash = "squash" // one commit with the changes of the PR
+	strategyRebase = "rebase" // the commits of the PR on top of the base
+	strategyMerge  = "merge"  // a merge commit, with the commits of the PR
+)
+
+// mergeStrategy returns how the comment has the PR merged: as its command
+// says, as in "rebase" or "merge --no-squash", or as configured for the
+// repository.
+func (h *handler) mergeStrategy(c comment) string {
+	body := c.parseBody()
+	if f := strings.Fields(strings.ToLower(body.command)); len(f) > 0 && (f[0] == strategySquash || f[0] == strategyRebase) {
+		return f[0]
+	}
+	if body.flag("no-squash") {
+		return strategyMerge
+	}
+	switch s := h.settings.forRepo(c.Repository.FullName).MergeStrategy; s {
+	case strategyRebase, strategyMerge:
+		return s
+	}
+	return strategySquash
+}
+
+// rebase rebases the commits of the PR onto its base branch in the clone in
+// dir and pushes them, each kept as it is apart from the committer, who is
+// the one merging as with a squash.
+func rebase(ctx context.Context, dir string, pr pr, plan mergePlan, prog *progress, journal *mergeJournal) (string, error) {
+	dstBranch := pr.Base.Ref
+	sourceBranch := fmt.Sprintf("pr-%d", pr.Number)
+
+	prog.set("fetching " + dstBranch)
+	s := newScriptContext(ctx).in(dir)
+	fetchRefs(s, fetchRef{remote: dstBranch, local: "orig/" + dstBranch, sha: plan.baseSHA}, prRef(pr))
+	if err := checkHead(s, pr); err != nil {
+		return "", err
+	}
+	if s.Error() == nil {
+		journal.step(stepFetched, s.run("git", "rev-parse", "orig/"+dstBranch))
+	}
+
+	s.run("git", "reset", "--hard")
+	setSparse(s, plan.sparse)
+	s.run("git", "checkout", "-B", dstBranch, sourceBranch)
+	s.run("git", "clean", "-fxd")
+	if s.Error() != nil {
+		return "", fmt.Errorf("%s", s.output.String())
+	}
+
+	t := newScriptContext(ctx).in(dir)
+	mergeBase := t.run("git", "merge-base", sourceBranch, "orig/"+dstBranch)
+	if revs := t.run("git", "rev-list", "orig/"+dstBranch+".."+sourceBranch); revs == "" {
+		if t.Error() != nil {
+			return "", fmt.Errorf("%s", t.output.String())
+		}
+		return "", explainNoCommits(dstBranch)
+	}
+
+	prog.set("rebasing")
+	env := []string{
+		"GIT_COMMITTER_NAME=" + plan.user.Name,
+		"GIT_COMMITTER_EMAIL=" + plan.user.Email,
+	}
+	s.runPipeEnv(env, nil, "git", "rebase", "--no-autosquash", "orig/"+dstBranch)
+	if s.Error() != nil {
+		newScriptContext(ctx).in(dir).run("git", "rebase", "--abort")
+		return "", fmt.Errorf("%s", s.output.String())
+	}
+	sha1 := s.run("git", "rev-parse", "HEAD")
+	if sha1 == t.run("git", "rev-parse", "orig/"+dstBranch) {
+		// Every commit was dropped as already upstream.
+		return "", explainEmptySquash(ctx, dir, dstBranch, sourceBranch, mergeBase)
+	}
+	journal.step(stepCommitted, sha1)
+	journal.step(stepPushing, sha1)
+
+	prog.set("pushing to " + dstBranch)
+	s.run("git", "push", "origin", dstBranch)
+	if s.Error() != nil {
+		return "", fmt.Errorf("%s", s.output.String())
+	}
+	journal.step(stepPushed, sha1)
+	return sha1, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeStrategy(t *testing.T) {
+	h := newHandler(nil, "bot", "", false)
+	h.settings.repos = map[string]repoSettings{
+		"foo/rebased": {MergeStrategy: strategyRebase},
+		"foo/merged":  {MergeStrategy: strategyMerge},
+	}
+
+	tests := []struct {
+		repo, body, want string
+	}{
+		{"foo/bar", "@bot merge", strategySquash},
+		{"foo/bar", "@bot squash", strategySquash},
+		{"foo/bar", "@bot rebase", strategyRebase},
+		{"foo/bar", "@bot Rebase --wait=1h", strategyRebase},
+		{"foo/rebased", "@bot merge", strategyRebase},
+		{"foo/rebased", "@bot squash", strategySquash},
+		{"foo/bar", "@bot merge --no-squash", strategyMerge},
+		{"foo/merged", "@bot merge", strategyMerge},
+	}
+	for _, test := range tests {
+		var c comment
+		c.Repository.FullName = test.repo
+		c.Comment.Body = test.body
+		if got := h.mergeStrategy(c); got != test.want {
+			t.Errorf("mergeStrategy(%q on %s) = %q; want %q", test.body, test.repo, got, test.want)
+		}
+	}
+}
+
+func TestRebase(t *testing.T) {
+	work, origin := t.TempDir(), filepath.Join(t.TempDir(), "origin.git")
+	w := newScript().in(work)
+	git := func(args ...string) string {
+		return w.run("git", append([]string{"-c", "user.name=Author", "-c", "user.email=author@example.com"}, args...)...)
+	}
+	commit := func(file, msg string) {
+		os.WriteFile(filepath.Join(work, file), []byte(msg+"\n"), 0644)
+		git("add", file)
+		git("commit", "-q", "-m", msg)
+	}
+	git("init", "-q", "-b", "main")
+	commit("a.txt", "Initial")
+	git("checkout", "-q", "-b", "feature")
+	commit("b.txt", "First")
+	commit("c.txt", "Second")
+	git("checkout", "-q", "main")
+	commit("d.txt", "Meanwhile")
+	newScript().run("git", "clone", "-q", "--bare", work, origin)
+	git("push", "-q", origin, "feature:refs/pull/3/head")
+	clone := filepath.Join(t.TempDir(), "clone")
+	newScript().run("git", "clone", "-q", origin, clone)
+	if w.Error() != nil {
+		t.Fatal(w.output.String())
+	}
+
+	var p pr
+	p.Number = 3
+	p.Base.Ref = "main"
+	plan := mergePlan{user: user{Name: "Merger", Email: "merger@example.com"}, strategy: strategyRebase}
+	sha, err := rebase(context.Background(), clone, p, plan, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := newScript().in(origin)
+	log := o.run("git", "log", "--format=%s %an %cn", "main")
+	want := "Second Author Merger\nFirst Author Merger\nMeanwhile Author Author\nInitial Author Author"
+	if log != want || o.run("git", "rev-parse", "main") != sha {
+		t.Errorf("Unexpected history at %s:\n%s", sha, log)
+	}
+
+	// Nothing left to rebase the second time around.
+	if _, err := rebase(context.Background(), clone, p, plan, nil, nil); err == nil || !strings.Contains(err.Error(), "Nothing to merge") {
+		t.Errorf("Unexpected error rebasing again: %v", err)
+	}
+}
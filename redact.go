@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// The redactor masks our own secrets wherever text leaves the process:
+// log lines, script transcripts and comments. Git and remotes are prone to
+// echoing authenticated URLs and headers back at us.
+type redactor struct {
+	mut    sync.RWMutex
+	values []string
+}
+
+var secrets = &redactor{}
+
+// minSecretLength keeps short values, which would mask too much, out of
+// the redactor.
+const minSecretLength = 6
+
+// add registers secret values to be masked.
+func (r *redactor) add(values ...string) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+outer:
+	for _, v := range values {
+		if len(v) < minSecretLength {
+			continue
+		}
+		for _, have := range r.values {
+			if have == v {
+				continue outer // settings are registered again on reload
+			}
+		}
+		r.values = append(r.values, v)
+	}
+}
+
+// addBasicAuth registers the credentials along with their encoding in a
+// basic authorization header.
+func (r *redactor) addBasicAuth(username, password string) {
+	if password == "" {
+		return
+	}
+	r.add(password, base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+}
+
+// addFile registers the lines of a key file, such that the key doesn't
+// get out even when printed partially.
+func (r *redactor) addFile(path string) error {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(bs), "\n") {
+		if line = strings.TrimSpace(line); !strings.HasPrefix(line, "-----") {
+			r.add(line)
+		}
+	}
+	return nil
+}
+
+var privateKeyRe = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----(?s:.*?)-----END [A-Z0-9 ]*PRIVATE KEY-----`)
+
+func (r *redactor) redact(s string) string {
+	r.mut.RLock()
+	for _, v := range r.values {
+		s = strings.Replace(s, v, "***", -1)
+	}
+	r.mut.RUnlock()
+	s = privateKeyRe.ReplaceAllString(s, "[private key redacted]")
+	return redactCredentials(s)
+}
+
+// redactingWriter redacts what's written through it, for the log.
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, secrets.redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
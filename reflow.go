@@ -5,16 +5,32 @@ import (
 	"bytes"
 	"io"
 	"strings"
+	"unicode"
 )
 
+// defaultWrapWidth is the width commit bodies are reflowed to unless
+// configured otherwise.
+const defaultWrapWidth = 76
+
 func reflow(in string, width int) string {
 	out := new(bytes.Buffer)  // Reflowed text
 	para := new(bytes.Buffer) // Current paragraph
+	fence := ""               // Closing fence of the code block we're in, if any
 	scanner := bufio.NewScanner(strings.NewReader(in))
+	scanner.Buffer(nil, 1<<20)
 	for scanner.Scan() {
 		line := scanner.Text()
-		if len(line) == 0 || strings.IndexAny(line, " \t") == 0 {
-			// Line is empty or starts with space. The previous paragraph has thus ended.
+		f := codeFence(line)
+		inCode := fence != ""
+		switch {
+		case fence == "" && f != "":
+			fence, inCode = f, true
+		case fence != "" && f != "" && f[0] == fence[0] && len(f) >= len(fence) && strings.TrimSpace(line) == f:
+			fence = "" // the closing line is still part of the block
+		}
+		if inCode || len(line) == 0 || strings.IndexAny(line, " \t") == 0 {
+			// Line is empty, starts with space or is in a code block. The
+			// previous paragraph has thus ended.
 			if para.Len() > 0 {
 				reflowParagraph(out, para, width)
 				para.Reset()
@@ -37,22 +53,107 @@ func reflow(in string, width int) string {
 	return strings.TrimRight(out.String(), "\n") + "\n"
 }
 
+// codeFence returns the fence if the line opens or closes a fenced code
+// block.
+func codeFence(line string) string {
+	line = strings.TrimSpace(line)
+	for _, c := range []string{"`", "~"} {
+		n := len(line) - len(strings.TrimLeft(line, c))
+		if n >= 3 {
+			return line[:n]
+		}
+	}
+	return ""
+}
+
 func reflowParagraph(out io.Writer, in io.Reader, width int) {
 	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1<<20)
 	scanner.Split(bufio.ScanWords)
 	curWidth := 0
 	for scanner.Scan() {
-		word := scanner.Text()
-		if curWidth+len(word) > width {
-			out.Write([]byte("\n"))
-			curWidth = 0
-		}
-		if curWidth > 0 {
-			out.Write([]byte(" "))
-			curWidth++
+		// Text in scripts without spaces between words, such as CJK, may
+		// be broken between any two wide characters.
+		for i, seg := range wideSegments(scanner.Text()) {
+			space := i == 0 && curWidth > 0
+			w := textWidth(seg)
+			if space {
+				w++
+			}
+			if curWidth > 0 && curWidth+w > width {
+				out.Write([]byte("\n"))
+				curWidth = 0
+				space = false
+				w = textWidth(seg)
+			}
+			if space {
+				out.Write([]byte(" "))
+			}
+			out.Write([]byte(seg))
+			curWidth += w
 		}
-		out.Write([]byte(word))
-		curWidth += len(word)
 	}
 	out.Write([]byte("\n\n"))
 }
+
+// wideSegments splits a word before and after each wide character; other
+// runs of characters, like URLs, are kept whole. Punctuation closing a wide
+// character stays with it, as do the marks and modifiers extending it and
+// the characters joined to it, like the emoji in a ZWJ sequence (UAX #14
+// LB8a and LB9).
+func wideSegments(word string) []string {
+	var segs []string
+	start := 0
+	var prev, base rune // the last character, and the last not extending another
+	for i, r := range word {
+		if i > start && (isWide(r) || isWide(base)) && !unicode.IsPunct(r) && !isExtending(r) && prev != zeroWidthJoiner {
+			segs = append(segs, word[start:i])
+			start = i
+		}
+		prev = r
+		if !isExtending(r) {
+			base = r
+		}
+	}
+	return append(segs, word[start:])
+}
+
+// zeroWidthJoiner joins characters, as in emoji ZWJ sequences.
+const zeroWidthJoiner = '\u200d'
+
+// isExtending returns whether the rune extends the character before it
+// rather than standing on its own: combining marks, format characters like
+// joiners, and emoji skin tone modifiers.
+func isExtending(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) || r >= 0x1f3fb && r <= 0x1f3ff
+}
+
+// textWidth returns the number of columns the text takes in a terminal.
+func textWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		switch {
+		case isExtending(r):
+			// Combining marks, joiners and modifiers take no room of their own.
+		case isWide(r):
+			n += 2
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+// isWide returns whether the rune is displayed in two columns, as East
+// Asian wide and fullwidth characters and most emoji are.
+func isWide(r rune) bool {
+	switch {
+	case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+		return true
+	case r >= 0x3000 && r <= 0x303f, // CJK punctuation
+		r >= 0xff00 && r <= 0xff60, r >= 0xffe0 && r <= 0xffe6, // fullwidth forms
+		r >= 0x1f300 && r <= 0x1faff: // emoji and pictographs
+		return true
+	}
+	return false
+}
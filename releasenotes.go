@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A releaseNoteSection collects the PRs with the given label under a
+// heading.
+type releaseNoteSection struct {
+	Label string
+	Title string
+}
+
+// A releaseNote is one merged PR since the last release.
+type releaseNote struct {
+	PR     int
+	Title  string
+	Labels []string
+}
+
+const defaultReleaseNotesFile = "RELEASE-NOTES.md"
+
+var prTrailerRe = regexp.MustCompile(`(?m)^GitHub-Pull-Request: \S+/pull/(\d+)\s*$`)
+
+// parseReleaseNotes extracts the merged PRs from git log output with one
+// record per commit, each being the subject and body separated by NUL and
+// terminated by SOH. Commits not made by us are skipped.
+func parseReleaseNotes(out string) []releaseNote {
+	var res []releaseNote
+	for _, rec := range strings.Split(out, "\x01") {
+		parts := strings.SplitN(strings.TrimSpace(rec), "\x00", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		m := prTrailerRe.FindStringSubmatch(parts[1])
+		if m == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(m[1])
+		res = append(res, releaseNote{PR: n, Title: parts[0]})
+	}
+	return res
+}
+
+// formatReleaseNotes renders the notes as a markdown section, sorted into
+// the configured sections by label, with unlabeled PRs last.
+func formatReleaseNotes(heading string, notes []releaseNote, sections []releaseNoteSection) string {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "## %s\n", heading)
+
+	used := make(map[int]bool)
+	for _, sec := range sections {
+		var lines []string
+		for _, n := range notes {
+			if used[n.PR] || !hasLabel(n.Labels, sec.Label) {
+				continue
+			}
+			used[n.PR] = true
+			lines = append(lines, fmt.Sprintf("- %s (#%d)", n.Title, n.PR))
+		}
+		if len(lines) > 0 {
+			fmt.Fprintf(buf, "\n### %s\n\n%s\n", sec.Title, strings.Join(lines, "\n"))
+		}
+	}
+
+	var other []string
+	for _, n := range notes {
+		if !used[n.PR] {
+			other = append(other, fmt.Sprintf("- %s (#%d)", n.Title, n.PR))
+		}
+	}
+	if len(other) > 0 {
+		if len(sections) > 0 {
+			fmt.Fprintf(buf, "\n### Other changes\n")
+		}
+		fmt.Fprintf(buf, "\n%s\n", strings.Join(other, "\n"))
+	}
+	return buf.String()
+}
+
+func hasLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseNotesPR assembles release notes from the PRs merged since the last
+// tag on the default branch into the release notes file, and opens a PR with
+// the change. It returns the URL of the PR.
+func (h *handler) releaseNotesPR(repo string) (string, error) {
+	if h.observing(repo) {
+		return "", fmt.Errorf("I'm only observing %s, so I don't push", repo)
+	}
+	var info repoInfo
+	if err := apiRequest("GET", fmt.Sprintf("%s/repos/%s", githubAPI, repo), nil, &info, h.username, h.token); err != nil {
+		return "", err
+	}
+	rs := h.settings.forRepo(repo)
+	file := rs.ReleaseNotesFile
+	if file == "" {
+		file = defaultReleaseNotesFile
+	}
+	base := info.DefaultBranch
+
+	defer h.lockRepo(repo)()
+
+	if _, err := os.Stat(filepath.Join(repo, ".git")); err != nil {
+		if err := h.clone(repo); err != nil {
+			return "", err
+		}
+	}
+	h.refreshRemote(repo)
+
+	s := newScript().in(repo)
+	s.run("git", "fetch", "-f", "--tags", "origin", fmt.Sprintf("%s:orig/%s", base, base))
+	if s.Error() != nil {
+		return "", fmt.Errorf("%s", s.output.String())
+	}
+
+	since := "orig/" + base
+	heading := "Unreleased"
+	t := newScript().in(repo)
+	if tag := t.run("git", "describe", "--tags", "--abbrev=0", "orig/"+base); t.Error() == nil {
+		since = tag + "..orig/" + base
+		heading = "Changes since " + tag
+	}
+	notes := parseReleaseNotes(s.run("git", "log", "--format=%s%x00%b%x01", since))
+	if len(notes) == 0 {
+		return "", fmt.Errorf("nothing merged since the last release")
+	}
+	for i := range notes {
+		var labels []struct {
+			Name string
+		}
+		url := fmt.Sprintf("%s/repos/%s/issues/%d/labels", githubAPI, repo, notes[i].PR)
+		if err := apiRequest("GET", url, nil, &labels, h.username, h.token); err == nil {
+			for _, l := range labels {
+				notes[i].Labels = append(notes[i].Labels, l.Name)
+			}
+		}
+	}
+
+	branch := "release-notes/" + time.Now().UTC().Format("2006-01-02")
+	s.run("git", "reset", "--hard")
+	setSparse(s, h.sparseFor(repo))
+	s.run("git", "checkout", "-B", branch, "orig/"+base)
+	if s.Error() != nil {
+		return "", fmt.Errorf("%s", s.output.String())
+	}
+
+	existing, _ := ioutil.ReadFile(filepath.Join(repo, file))
+	section := formatReleaseNotes(heading, notes, rs.ReleaseNoteSections)
+	if err := ioutil.WriteFile(filepath.Join(repo, file), []byte(section+"\n"+string(existing)), 0644); err != nil {
+		return "", err
+	}
+
+	s.run("git", "add", file)
+	s.run("git", "-c", "user.name="+h.username, "-c", "user.email="+noreplyEmail(h.username),
+		"commit", "-m", "Update release notes")
+	s.run("git", "push", "-f", "origin", branch)
+	s.run("git", "checkout", base)
+	if s.Error() != nil {
+		return "", fmt.Errorf("%s", s.output.String())
+	}
+
+	var res struct {
+		HTMLURL string `json:"html_url"`
+	}
+	req := map[string]string{
+		"title": "Update release notes",
+		"head":  branch,
+		"base":  base,
+		"body":  fmt.Sprintf("Release notes for %d merged pull requests.", len(notes)),
+	}
+	if err := apiRequest("POST", fmt.Sprintf("%s/repos/%s/pulls", githubAPI, repo), req, &res, h.username, h.token); err != nil {
+		return "", err
+	}
+	log.Printf("Opened release notes PR on %s: %s", repo, res.HTMLURL)
+	return res.HTMLURL, nil
+}
+
+func (h *handler) handleReleaseNotes(c comment) {
+	url, err := h.releaseNotesPR(c.Repository.FullName)
+	if err != nil {
+		c.post(releaseNotesFailedResponse(c, err.Error()), h.username, h.token)
+		return
+	}
+	c.post(releaseNotesResponse(c, url), h.username, h.token)
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestReleaseNotes(t *testing.T) {
+	out := "Add frobnicator\x00Long description.\n\nGitHub-Pull-Request: https://github.com/foo/bar/pull/12\n\x01\n" +
+		"Manual commit\x00\x01\n" +
+		"Fix crash on start\x00GitHub-Pull-Request: https://github.com/foo/bar/pull/10\nLGTM: alice\n\x01"
+	notes := parseReleaseNotes(out)
+	if len(notes) != 2 || notes[0].PR != 12 || notes[1].Title != "Fix crash on start" {
+		t.Fatalf("Unexpected notes %+v", notes)
+	}
+
+	notes[1].Labels = []string{"bug"}
+	expected := "## v1.1\n\n### Bug fixes\n\n- Fix crash on start (#10)\n\n### Other changes\n\n- Add frobnicator (#12)\n"
+	if s := formatReleaseNotes("v1.1", notes, []releaseNoteSection{{Label: "bug", Title: "Bug fixes"}}); s != expected {
+		t.Errorf("Unexpected release notes\n%s\nexpected\n%s", s, expected)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// repoConfigFile is the file in a repository's default branch where its
+// maintainers may configure how the repository is merged.
+const repoConfigFile = ".mergebot.yml"
+
+// A repoConfig is the contents of the repository configuration file. It
+// only covers settings that are safe to leave to the repository's
+// maintainers; anything with credentials or shell commands stays with the
+// operator.
+type repoConfig struct {
+	Strategy         string          // "squash", "rebase", "merge", "queue" or "train"
+	RequiredStatuses []string        `json:"required_statuses"` // contexts that must report success, besides those the operator requires
+	AllowedUsers     []string        `json:"allowed_users"`     // who may merge, besides the collaborators
+	Commands         map[string]bool // comment commands to turn on or off, like "lgtm: false"
+	Commit           struct {
+		Mode         string // "reflow" or "verbatim"
+		WrapWidth    int    `json:"wrap_width"`
+		AreaSubjects *bool  `json:"area_subjects"`
+	}
+}
+
+// parseRepoConfig parses the repository configuration file into the
+// settings it implies.
+func parseRepoConfig(data string) (repoSettings, error) {
+	var rs repoSettings
+	v, err := parseYAML(data)
+	if err != nil {
+		return rs, err
+	}
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return rs, err
+	}
+	var cfg repoConfig
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.DisallowUnknownFields()
+	if v != nil {
+		if err := dec.Decode(&cfg); err != nil {
+			return rs, err
+		}
+	}
+
+	switch cfg.Strategy {
+	case "":
+	case "squash":
+		rs.MergeTrain, rs.MergeQueue = new(bool), new(bool)
+	case strategyRebase, strategyMerge:
+		rs.MergeTrain, rs.MergeQueue = new(bool), new(bool)
+		rs.MergeStrategy = cfg.Strategy
+	case "train":
+		train := true
+		rs.MergeTrain, rs.MergeQueue = &train, new(bool)
+	case "queue":
+		queue := true
+		rs.MergeQueue = &queue
+	default:
+		return rs, fmt.Errorf("%q is not a merge strategy; use squash, rebase, merge, queue or train", cfg.Strategy)
+	}
+	switch cfg.Commit.Mode {
+	case "", messageReflow, messageVerbatim:
+	default:
+		return rs, fmt.Errorf("%q is not a message mode; use reflow or verbatim", cfg.Commit.Mode)
+	}
+	for name := range cfg.Commands {
+		if !gatedCommand(name) {
+			return rs, fmt.Errorf("%q is not a command that can be turned off", name)
+		}
+	}
+	rs.Features = cfg.Commands
+	rs.RequiredStatuses = cfg.RequiredStatuses
+	rs.AllowedUsers = cfg.AllowedUsers
+	rs.MessageMode = cfg.Commit.Mode
+	rs.WrapWidth = cfg.Commit.WrapWidth
+	rs.AreaSubjects = cfg.Commit.AreaSubjects
+	return rs, nil
+}
+
+// fetchRepoConfig returns the contents of the repository configuration
+// file, or an empty string if there is none.
+func fetchRepoConfig(repo, username, token string) (string, error) {
+	url := fmt.Sprintf("%s/repos/%s/contents/%s", githubAPI, repo, repoConfigFile)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(username, token)
+	req.Header.Set("Accept", "application/vnd.github.raw")
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode > 299 {
+		return "", fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	bs, err := ioutil.ReadAll(resp.Body)
+	return string(bs), err
+}
+
+// loadRepoConfig updates the settings for the repository from its
+// configuration file. When the file can't be used the requester is told
+// and false is returned; merging without it could go against the wishes
+// of the repository.
+func (h *handler) loadRepoConfig(c comment) bool {
+	repo := c.Repository.FullName
+	if !h.permissions.onGitHub(repo) {
+		// It would be read from the GitHub repository of the same name,
+		// which may belong to anyone.
+		log.Printf("Not reading %s of %s, which isn't on GitHub", repoConfigFile, repo)
+		c.post(repoConfigResponse(c, "it's only read from repositories on GitHub"), h.username, h.token)
+		return false
+	}
+	data, err := fetchRepoConfig(repo, h.username, h.token)
+	if err != nil {
+		log.Printf("Fetching %s of %s: %v", repoConfigFile, repo, err)
+		c.post(repoConfigResponse(c, err.Error()), h.username, h.token)
+		return false
+	}
+	rs, err := parseRepoConfig(data)
+	if err != nil {
+		log.Printf("Parsing %s of %s: %v", repoConfigFile, repo, err)
+		c.post(repoConfigResponse(c, err.Error()), h.username, h.token)
+		return false
+	}
+	h.settings.setRepoFile(repo, rs)
+	return true
+}
+
+// withRequiredStatuses adds a pending status for each required context
+// that hasn't reported yet.
+func withRequiredStatuses(statuses []status, required []string) []status {
+	seen := make(map[string]bool)
+	for _, st := range statuses {
+		seen[st.Context] = true
+	}
+	for _, ctx := range required {
+		if !seen[ctx] {
+			statuses = append(statuses, status{State: statePending, Context: ctx, Description: "Required, not reported yet"})
+			seen[ctx] = true
+		}
+	}
+	return statuses
+}
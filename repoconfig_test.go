@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRepoConfig(t *testing.T) {
+	rs, err := parseRepoConfig(`
+strategy: train
+required_statuses:
+  - ci/build
+  - ci/test
+allowed_users: [alice, bob]
+commit:
+  mode: verbatim
+  wrap_width: 72
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.MergeTrain == nil || !*rs.MergeTrain || len(rs.RequiredStatuses) != 2 || len(rs.AllowedUsers) != 2 || rs.MessageMode != messageVerbatim || rs.WrapWidth != 72 {
+		t.Errorf("Unexpected settings %+v", rs)
+	}
+
+	rs, err = parseRepoConfig("strategy: queue\n")
+	if err != nil || rs.MergeQueue == nil || !*rs.MergeQueue {
+		t.Errorf("Unexpected settings %+v for a queue, %v", rs, err)
+	}
+
+	rs, err = parseRepoConfig("strategy: rebase\n")
+	if err != nil || rs.MergeTrain == nil || *rs.MergeTrain || rs.MergeStrategy != strategyRebase {
+		t.Errorf("Unexpected settings %+v for rebasing, %v", rs, err)
+	}
+
+	rs, err = parseRepoConfig("commands:\n  lgtm: false\n")
+	if err != nil || len(rs.Features) != 1 || rs.Features["lgtm"] {
+		t.Errorf("Unexpected settings %+v for commands, %v", rs, err)
+	}
+
+	for _, bad := range []string{"strategy: octopus\n", "commands:\n  disable: false\n", "commands:\n  deploy: true\n", "commit:\n  mode: pretty\n", "clone_url: evil\n"} {
+		if _, err := parseRepoConfig(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestRepoFileSettings(t *testing.T) {
+	h := newHandler(nil, "bot", "", false)
+	h.settings.repos = map[string]repoSettings{
+		"foo/*":   {WrapWidth: 60, MessageMode: messageReflow},
+		"foo/bar": {WrapWidth: 80},
+	}
+	h.settings.setRepoFile("foo/bar", repoSettings{WrapWidth: 72, MessageMode: messageVerbatim, AllowedUsers: []string{"alice"}})
+
+	rs := h.settings.forRepo("foo/bar")
+	if rs.WrapWidth != 80 || rs.MessageMode != messageVerbatim {
+		t.Errorf("Unexpected settings %+v", rs)
+	}
+	if !h.isAllowed("foo/bar", "alice") {
+		t.Error("Expected alice to be allowed by the repository file")
+	}
+
+	statuses := withRequiredStatuses([]status{{State: stateSuccess, Context: "ci/build"}}, []string{"ci/build", "ci/test"})
+	if len(statuses) != 2 || statuses[1].Context != "ci/test" || statuses[1].State != statePending {
+		t.Errorf("Unexpected statuses %+v", statuses)
+	}
+}
+
+func TestRepoFileRequiredStatuses(t *testing.T) {
+	h := newHandler(nil, "bot", "", false)
+	h.settings.repos = map[string]repoSettings{
+		"foo/*": {RequiredStatuses: []string{"ci/build", "security"}},
+	}
+
+	cases := []struct {
+		file string
+		want []string
+	}{
+		{"required_statuses: []\n", []string{"ci/build", "security"}},
+		{"strategy: squash\n", []string{"ci/build", "security"}},
+		{"required_statuses:\n  - ci/test\n  - ci/build\n", []string{"ci/build", "security", "ci/test"}},
+	}
+	for _, tc := range cases {
+		rs, err := parseRepoConfig(tc.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.settings.setRepoFile("foo/bar", rs)
+		if got := h.settings.forRepo("foo/bar").RequiredStatuses; !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: expected required statuses %v, got %v", tc.file, tc.want, got)
+		}
+	}
+	if got := h.settings.forRepo("foo/baz").RequiredStatuses; !reflect.DeepEqual(got, []string{"ci/build", "security"}) {
+		t.Errorf("Expected the owner's statuses to be left alone, got %v", got)
+	}
+}
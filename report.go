@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A reportRow counts the merges for one value of the grouping dimension.
+type reportRow struct {
+	Key     string `json:"key"`
+	Merges  int    `json:"merges"`
+	Authors int    `json:"authors"`
+	Repos   int    `json:"repos"`
+}
+
+// groupKey returns the function extracting the grouping key from a record,
+// for the dimensions supported by the report.
+func groupKey(by string, teams map[string][]string) (func(mergeRecord) []string, error) {
+	switch by {
+	case "repo", "":
+		return func(r mergeRecord) []string { return []string{r.Repo} }, nil
+	case "strategy":
+		return func(r mergeRecord) []string { return []string{r.Strategy} }, nil
+	case "author":
+		return func(r mergeRecord) []string { return []string{r.Author} }, nil
+	case "team":
+		// A merge counts towards every team its author is a member of.
+		memberOf := make(map[string][]string)
+		for team, members := range teams {
+			for _, m := range members {
+				memberOf[m] = append(memberOf[m], team)
+			}
+		}
+		return func(r mergeRecord) []string {
+			if ts := memberOf[r.Author]; len(ts) > 0 {
+				return ts
+			}
+			return []string{"(none)"}
+		}, nil
+	case "day":
+		return func(r mergeRecord) []string { return []string{r.Time.UTC().Format("2006-01-02")} }, nil
+	case "week":
+		return func(r mergeRecord) []string {
+			y, w := r.Time.UTC().ISOWeek()
+			return []string{fmt.Sprintf("%d-W%02d", y, w)}
+		}, nil
+	case "month":
+		return func(r mergeRecord) []string { return []string{r.Time.UTC().Format("2006-01")} }, nil
+	default:
+		return nil, fmt.Errorf("unknown grouping %q", by)
+	}
+}
+
+func buildReport(records []mergeRecord, key func(mergeRecord) []string) []reportRow {
+	type acc struct {
+		merges  int
+		authors map[string]bool
+		repos   map[string]bool
+	}
+	accs := make(map[string]*acc)
+	for _, r := range records {
+		for _, k := range key(r) {
+			a, ok := accs[k]
+			if !ok {
+				a = &acc{authors: make(map[string]bool), repos: make(map[string]bool)}
+				accs[k] = a
+			}
+			a.merges++
+			a.authors[r.Author] = true
+			a.repos[r.Repo] = true
+		}
+	}
+
+	var res []reportRow
+	for k, a := range accs {
+		res = append(res, reportRow{Key: k, Merges: a.merges, Authors: len(a.authors), Repos: len(a.repos)})
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a].Key < res[b].Key })
+	return res
+}
+
+// serveReport answers /admin/report?by=team&window=720h&format=csv, also
+// accepting since= and until= as RFC 3339 times.
+func (a *adminAPI) serveReport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	until := time.Now()
+	since := time.Time{}
+	if v := q.Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		since = until.Add(-d)
+	}
+	for name, dst := range map[string]*time.Time{"since": &since, "until": &until} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			*dst = t
+		}
+	}
+
+	a.h.mut.Lock()
+	teams := a.h.teams
+	a.h.mut.Unlock()
+	key, err := groupKey(q.Get("by"), teams)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, err := readMerges(func(m mergeRecord) bool {
+		return !m.Time.Before(since) && m.Time.Before(until)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rows := buildReport(records, key)
+
+	switch q.Get("format") {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"key", "merges", "authors", "repos"})
+		for _, row := range rows {
+			cw.Write([]string{row.Key, strconv.Itoa(row.Merges), strconv.Itoa(row.Authors), strconv.Itoa(row.Repos)})
+		}
+		cw.Flush()
+	case "json", "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rows)
+	default:
+		http.Error(w, "Unknown format", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildReport(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	records := []mergeRecord{
+		{Time: t0, Repo: "a/x", Author: "alice", Strategy: "squash"},
+		{Time: t0, Repo: "a/y", Author: "alice", Strategy: "squash"},
+		{Time: t0.AddDate(0, 1, 0), Repo: "a/x", Author: "bob", Strategy: "squash"},
+		{Time: t0.AddDate(0, 1, 0), Repo: "a/x", Author: "carol", Strategy: "squash"},
+	}
+	teams := map[string][]string{"core": {"alice", "bob"}}
+
+	key, _ := groupKey("team", teams)
+	expected := []reportRow{{"(none)", 1, 1, 1}, {"core", 3, 2, 2}}
+	if rows := buildReport(records, key); !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Got %+v, expected %+v", rows, expected)
+	}
+
+	key, _ = groupKey("month", teams)
+	expected = []reportRow{{"2026-03", 2, 1, 2}, {"2026-04", 2, 2, 1}}
+	if rows := buildReport(records, key); !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Got %+v, expected %+v", rows, expected)
+	}
+}
@@ -1,50 +1,387 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
 )
 
+// withNotes appends the notes, if any, as a list below the response. A
+// response that was left out stays out.
+func withNotes(response string, notes []string) string {
+	if len(notes) == 0 || response == "" {
+		return response
+	}
+	return response + "\n\n- " + strings.Join(notes, "\n- ")
+}
+
 func noUserResponse(c comment) string {
-	return fmt.Sprintf("@%s: Couldn't retrieve your user information - not merging.", c.Sender.Login)
+	return custom("noUser", c, fmt.Sprintf("@%s: Couldn't retrieve your user information - not merging.", c.Sender.Login))
 }
 
 func thanksResponse(c comment, sha1 string) string {
-	return fmt.Sprintf(":ok_hand: Merged as %s. Thanks, @%s!", sha1, c.Issue.User.Login)
+	return custom("thanks", c, fmt.Sprintf(":ok_hand: Merged as %s. Thanks, @%s!", sha1, c.Issue.User.Login))
+}
+
+func waitingResponse(c comment, eta string, notes []string) string {
+	if eta != "" {
+		return custom("waiting", c, withNotes(fmt.Sprintf("@%s: Build status is `pending`. %s; I'll merge automatically when it's green!", c.Sender.Login, eta), notes))
+	}
+	return custom("waiting", c, withNotes(fmt.Sprintf("@%s: Build status is `pending`. I'll wait until it goes green and then merge!", c.Sender.Login), notes))
+}
+
+func headMovedResponse(c comment, want, got string) string {
+	return custom("headMoved", c, fmt.Sprintf("@%s: The PR was pushed to since you asked to merge it, moving its head from %s to %s, so I haven't merged it. Ask again to merge what's there now.", c.Sender.Login, want, got))
+}
+
+func mergeRecheckResponse(c comment, why string) string {
+	return custom("mergeRecheck", c, fmt.Sprintf("@%s: I haven't merged after all, as %s while the build was pending.", c.Sender.Login, why))
+}
+
+func recheckFailedResponse(c comment) string {
+	return custom("recheckFailed", c, fmt.Sprintf("@%s: I haven't merged after all, as I couldn't get the PR to check it again before merging. Ask again to retry.", c.Sender.Login))
+}
+
+func greetingResponse(c comment, text string) string {
+	return custom("greeting", c, text)
+}
+
+func pendingDroppedResponse(c comment, why string) string {
+	return custom("pendingDropped", c, fmt.Sprintf("@%s: I dropped the merge I was waiting to do when I restarted, as %s.", c.Sender.Login, why))
+}
+
+func checksRestartedResponse(c comment, eta string) string {
+	return custom("checksRestarted", c, fmt.Sprintf("@%s: The checks restarted. %s; I'll still merge automatically when they're green.", c.Sender.Login, eta))
 }
 
-func waitingResponse(c comment) string {
-	return fmt.Sprintf("@%s: Build status is `pending`. I'll wait until it goes green and then merge!", c.Sender.Login)
+func waitingApprovalResponse(c comment, missing int, notes []string) string {
+	return custom("waitingApproval", c, withNotes(fmt.Sprintf("@%s: Waiting for %d more approval(s). I'll merge once they're in and the build is green!", c.Sender.Login, missing), notes))
 }
 
-func badBuildResponse(c comment, status prState) string {
-	return fmt.Sprintf("@%s: Build status is `%s` -- refusing to merge.", c.Sender.Login, status)
+func badBuildResponse(c comment, status prState, notes []string) string {
+	return custom("badBuild", c, withNotes(fmt.Sprintf("@%s: Build status is `%s` -- refusing to merge.", c.Sender.Login, status), notes))
 }
 
 func timeoutResponse(c comment, timeout time.Duration) string {
-	return fmt.Sprintf("@%s: Patiently waited %v for the build status to turn green, but enough is enough.", c.Sender.Login, timeout)
+	return custom("timeout", c, fmt.Sprintf("@%s: Patiently waited %v for the build status to turn green, but enough is enough.", c.Sender.Login, timeout))
+}
+
+func approvalTimeoutResponse(c comment, timeout time.Duration, missing int) string {
+	return custom("approvalTimeout", c, fmt.Sprintf("@%s: The build is green, but I patiently waited %v for %d more approval(s) and enough is enough.", c.Sender.Login, timeout, missing))
 }
 
 func noAccessResponse(c comment) string {
-	return fmt.Sprintf(":hand: I'm sorry, @%s. I'm afraid I can't do that.", c.Sender.Login)
+	return custom("noAccess", c, fmt.Sprintf(":hand: I'm sorry, @%s. I'm afraid I can't do that.", c.Sender.Login))
 }
 
 func errorResponse(c comment, output string) string {
-	return fmt.Sprintf("@%s: Merge failed:\n\n```\n%s\n```\n", c.Sender.Login, output)
+	return custom("error", c, fmt.Sprintf("@%s: Merge failed:\n\n%s", c.Sender.Login, quoteOutput(output)))
 }
 
 func cloneFailedResponse(c comment, output string) string {
-	return fmt.Sprintf("@%s: Clone failed:\n\n```\n%s\n```\n", c.Sender.Login, output)
+	return custom("cloneFailed", c, fmt.Sprintf("@%s: Clone failed:\n\n%s", c.Sender.Login, quoteOutput(output)))
 }
 
 func notMergingResponse(c comment) string {
-	return fmt.Sprintf("@%s: Preventing merge for the time being. Push a new revision to reset!", c.Sender.Login)
+	return custom("notMerging", c, fmt.Sprintf("@%s: Preventing merge for the time being. Push a new revision to reset!", c.Sender.Login))
 }
 
 func alreadyPendingResponse(c comment) string {
-	return fmt.Sprintf("@%s: There's already a merge pending for this PR.", c.Sender.Login)
+	return custom("alreadyPending", c, fmt.Sprintf("@%s: There's already a merge pending for this PR.", c.Sender.Login))
 }
 
 func lgtmResponse(c comment) string {
-	return fmt.Sprintf("@%s: Noted! Need another LGTM or explicit merge command.", c.Sender.Login)
+	return custom("lgtm", c, fmt.Sprintf("@%s: Noted! Need another LGTM or explicit merge command.", c.Sender.Login))
+}
+
+func onboardedResponse(c comment, repo, result string) string {
+	return custom("onboarded", c, fmt.Sprintf("@%s: Now serving %s. %s", c.Sender.Login, repo, result))
+}
+
+func onboardFailedResponse(c comment, output string) string {
+	return custom("onboardFailed", c, fmt.Sprintf("@%s: Onboarding failed: %s", c.Sender.Login, output))
+}
+
+func badOptionResponse(c comment, output string) string {
+	return custom("badOption", c, fmt.Sprintf("@%s: I don't understand: %s.", c.Sender.Login, output))
+}
+
+func changeRequiredResponse(c comment, branch string) string {
+	return custom("changeRequired", c, fmt.Sprintf("@%s: Merges into `%s` need an approved change ticket. Use `--change=ID`.", c.Sender.Login, branch))
+}
+
+func changeNotApprovedResponse(c comment, reason string) string {
+	return custom("changeNotApproved", c, fmt.Sprintf("@%s: Not merging: %s.", c.Sender.Login, reason))
+}
+
+func policyDeniedResponse(c comment, reasons []string) string {
+	return custom("policyDenied", c, withNotes(fmt.Sprintf("@%s: The merge policy doesn't allow this merge:", c.Sender.Login), reasons))
+}
+
+func disabledResponse(c comment, command string) string {
+	return custom("disabled", c, fmt.Sprintf("@%s: The `%s` command is not enabled on this repository.", c.Sender.Login, command))
+}
+
+func delegateNoAccessResponse(c comment, delegate string) string {
+	return custom("delegateNoAccess", c, fmt.Sprintf(":hand: I'm sorry, @%s. I can't merge on behalf of @%s, who isn't allowed to merge here.", c.Sender.Login, delegate))
+}
+
+func releaseNotesResponse(c comment, url string) string {
+	return custom("releaseNotes", c, fmt.Sprintf("@%s: Opened %s with the release notes.", c.Sender.Login, url))
+}
+
+func releaseNotesFailedResponse(c comment, output string) string {
+	return custom("releaseNotesFailed", c, fmt.Sprintf("@%s: Couldn't make release notes: %s", c.Sender.Login, redactCredentials(output)))
+}
+
+func concurrencyQueuedResponse(c comment, group string) string {
+	return custom("concurrencyQueued", c, fmt.Sprintf("@%s: Another merge in the `%s` concurrency group is landing; this one is queued to follow it.", c.Sender.Login, group))
+}
+
+func waitClampedResponse(c comment, wait time.Duration) string {
+	return custom("waitClamped", c, fmt.Sprintf("@%s: I'll wait for the checks %s at most, not as long as you asked, as that's as long as merges may wait here.", c.Sender.Login, wait))
+}
+
+func queueStrategyResponse(c comment, strategy string) string {
+	return custom("queueStrategy", c, fmt.Sprintf("@%s: Merges here wait their turn in a queue, which only squashes, so I can't merge this PR with the %s strategy. Ask for a squash merge instead.", c.Sender.Login, strategy))
+}
+
+func trainQueuedResponse(c comment, position int) string {
+	return custom("trainQueued", c, fmt.Sprintf("@%s: Added to the merge train at position %d.", c.Sender.Login, position))
+}
+
+func trainEjectedResponse(c comment, reason string, details []string) string {
+	return custom("trainEjected", c, withNotes(fmt.Sprintf("@%s: Removed from the merge train as %s.", c.Sender.Login, reason), details))
+}
+
+func progressResponse(c comment, phase string, elapsed time.Duration) string {
+	return custom("progress", c, fmt.Sprintf("Merging for %s: %s... (%v so far)", c.Sender.Login, phase, elapsed))
+}
+
+func mergeTimeoutResponse(c comment, timeout time.Duration, phase string) string {
+	return custom("mergeTimeout", c, fmt.Sprintf("@%s: Gave up on the merge after %v while %s. Please try again later.", c.Sender.Login, timeout, phase))
+}
+
+func mergeInterruptedResponse(c comment, sha1 string) string {
+	return custom("mergeInterrupted", c, fmt.Sprintf("@%s: The merge was interrupted by a restart, and I can't tell whether %s was pushed. Please check the branch before merging again.", c.Sender.Login, sha1))
+}
+
+func baseNotAllowedResponse(c comment, base string) string {
+	return custom("baseNotAllowed", c, fmt.Sprintf("@%s: I don't merge into %s in this repository.", c.Sender.Login, base))
+}
+
+func nothingToMergeResponse(c comment, reason string) string {
+	return custom("nothingToMerge", c, fmt.Sprintf("@%s: Nothing to merge, as %s", c.Sender.Login, reason))
+}
+
+func staleBaseResponse(c comment, reason string) string {
+	return custom("staleBase", c, fmt.Sprintf("@%s: Not merging, as %s. Please update the branch and let CI run again.", c.Sender.Login, reason))
+}
+
+func repoConfigResponse(c comment, problem string) string {
+	return custom("repoConfig", c, fmt.Sprintf("@%s: Not merging, as I can't use %s: %s", c.Sender.Login, repoConfigFile, problem))
+}
+
+func notRepoAdminResponse(c comment) string {
+	return custom("notRepoAdmin", c, fmt.Sprintf(":no_entry_sign: @%s: Only admins of this repository can turn me off and on.", c.Sender.Login))
+}
+
+func botToggledResponse(c comment, enabled bool) string {
+	if enabled {
+		return custom("botEnabled", c, fmt.Sprintf("@%s: I'm back at your service on this repository.", c.Sender.Login))
+	}
+	return custom("botDisabled", c, fmt.Sprintf("@%s: I'll ignore this repository until an admin tells me to `enable` again. Merges already waiting are abandoned.", c.Sender.Login))
+}
+
+func toggleFailedResponse(c comment, output string) string {
+	return custom("toggleFailed", c, fmt.Sprintf("@%s: I couldn't save that: %s", c.Sender.Login, codeSpan(output)))
+}
+
+func maintenanceResponse(c comment, message string) string {
+	text := fmt.Sprintf(":construction: @%s: I'm down for maintenance. Your command is queued and I'll get to it when I'm back.", c.Sender.Login)
+	if message != "" {
+		text += "\n\n" + message
+	}
+	return custom("maintenance", c, text)
+}
+
+func retargetedResponse(c comment, from, to string, notes []string) string {
+	text := fmt.Sprintf("@%s: Retargeted from %s to %s.", c.Sender.Login, from, to)
+	if from == to {
+		text = fmt.Sprintf("@%s: Already targeting %s.", c.Sender.Login, to)
+	}
+	return custom("retargeted", c, withNotes(text, notes))
+}
+
+func retargetFailedResponse(c comment, branch, output string) string {
+	return custom("retargetFailed", c, fmt.Sprintf("@%s: Couldn't retarget to %s: %s", c.Sender.Login, branch, codeSpan(output)))
+}
+
+func prStatusResponse(c comment, lines []string) string {
+	return custom("prStatus", c, withNotes(fmt.Sprintf("@%s: Here's where this PR stands:", c.Sender.Login), lines))
+}
+
+func historyResponse(c comment, lines []string) string {
+	if len(lines) == 0 {
+		return custom("history", c, fmt.Sprintf("@%s: I have nothing on record for this PR.", c.Sender.Login))
+	}
+	return custom("history", c, withNotes(fmt.Sprintf("@%s: Here's what I have on record for this PR:", c.Sender.Login), lines))
+}
+
+func helpResponse(c comment, commands string) string {
+	return custom("help", c, fmt.Sprintf("@%s: Mention me with one of these commands:\n\n%s", c.Sender.Login, commands))
+}
+
+func reviewsRequiredResponse(c comment, problems []string) string {
+	return custom("reviewsRequired", c, withNotes(fmt.Sprintf(":no_entry_sign: @%s: Not merging, as the PR isn't approved as this repository requires:", c.Sender.Login), problems))
+}
+
+func dependenciesDeniedResponse(c comment, problems []string) string {
+	return custom("dependenciesDenied", c, withNotes(fmt.Sprintf(":no_entry_sign: @%s: Not merging, as the PR adds dependencies that aren't allowed:", c.Sender.Login), problems))
+}
+
+func dependencyReviewFailedResponse(c comment, output string) string {
+	return custom("dependencyReviewFailed", c, fmt.Sprintf("@%s: Not merging, as I couldn't review the dependencies: %s", c.Sender.Login, codeSpan(output)))
+}
+
+func artifactsResponse(c comment, sha1 string) string {
+	return custom("artifacts", c, fmt.Sprintf(":package: @%s: The build of %s produced:", c.Sender.Login, sha1))
+}
+
+func buildFailedResponse(c comment, sha1 string) string {
+	return custom("buildFailed", c, fmt.Sprintf(":x: @%s: The build of %s failed:", c.Sender.Login, sha1))
+}
+
+func artifactsTimeoutResponse(c comment, sha1 string, waited time.Duration) string {
+	return custom("artifactsTimeout", c, fmt.Sprintf("@%s: The build of %s didn't finish within %s.", c.Sender.Login, sha1, waited))
+}
+
+func prefsResponse(c comment, prefs []string) string {
+	if len(prefs) == 0 {
+		return custom("prefs", c, fmt.Sprintf("@%s: You're using the defaults.", c.Sender.Login))
+	}
+	return custom("prefs", c, withNotes(fmt.Sprintf("@%s: Your preferences are:", c.Sender.Login), prefs))
+}
+
+func linkCodeSentResponse(c comment, chat string, ttl time.Duration) string {
+	return custom("linkCodeSent", c, fmt.Sprintf("@%s: I've sent %s a code in chat. Post it here as `link CODE` within %s to link it to you.", c.Sender.Login, codeSpan(chat), ttl))
+}
+
+func linkedResponse(c comment, chat string) string {
+	return custom("linked", c, fmt.Sprintf("@%s: Linked chat handle %s to you.", c.Sender.Login, codeSpan(chat)))
+}
+
+func wouldMergeResponse(c comment, base, strategy string, notes []string) string {
+	return custom("wouldMerge", c, withNotes(fmt.Sprintf(":eyes: @%s: The gates pass, so I would merge this into `%s` now, by %s. I'm only observing this repository, though, so I haven't.", c.Sender.Login, base, strategy), notes))
+}
+
+func buildBrokeResponse(c comment, base, sha1 string) string {
+	mentions := "@" + strings.Join(dedupe([]string{c.Sender.Login, c.Issue.User.Login}), " @")
+	return custom("buildBroke", c, fmt.Sprintf(":rotating_light: %s: The build of `%s` broke at %s, where this was merged. If this is why, consider reverting it:", mentions, base, sha1))
+}
+
+func revertProposedResponse(c comment, url string) string {
+	return custom("revertProposed", c, fmt.Sprintf("The failures started with this merge, so I've opened %s to revert it. It won't be merged unless someone asks.", url))
+}
+
+func handedOffResponse(c comment, backup, back string) string {
+	return custom("handedOff", c, fmt.Sprintf("@%s: %s is away until %s, so this merge is yours to look after.", backup, c.Sender.Login, back))
+}
+
+var (
+	responseTemplates    map[string]*template.Template // response name -> template
+	responseTemplatesMut sync.RWMutex
+)
+
+// Response verbosity levels.
+const (
+	verbosityFull   = "full"   // conversational responses
+	verbosityTerse  = "terse"  // confirmations cut to their first sentence
+	verbositySilent = "silent" // no confirmations; only failures are posted
+)
+
+// confirmations are the responses saying that things go as requested, which
+// are cut short or left out at the lower verbosity levels.
+var confirmations = map[string]bool{
+	"concurrencyQueued": true,
+	"greeting":          true,
+	"lgtm":              true,
+	"notMerging":        true,
+	"progress":          true,
+	"releaseNotes":      true,
+	"thanks":            true,
+	"trainQueued":       true,
+	"waiting":           true,
+	"waitingApproval":   true,
+}
+
+// verbosityOf returns the response verbosity for the repository.
+var verbosityOf = func(repo string) string { return verbosityFull }
+
+// applyVerbosity returns the named response as it should be at the
+// verbosity level; empty if it's not to be posted at all.
+func applyVerbosity(name, text, verbosity string) string {
+	if !confirmations[name] {
+		return text
+	}
+	switch verbosity {
+	case verbositySilent:
+		return ""
+	case verbosityTerse:
+		if idx := strings.Index(text, "\n"); idx >= 0 {
+			text = text[:idx]
+		}
+		for _, end := range []string{". ", "! "} {
+			if idx := strings.Index(text, end); idx >= 0 {
+				text = text[:idx+1]
+			}
+		}
+	}
+	return text
+}
+
+// custom returns the named response as given by a custom template, if one
+// is loaded, or else the default text. The templates see the default text
+// as {{.Default}} along with {{.Sender}}, {{.Author}}, {{.Repo}} and
+// {{.Number}}.
+func custom(name string, c comment, def string) string {
+	verbosity := verbosityOf(c.Repository.FullName)
+	if v := prefsOf(c.Sender.Login).Verbosity; v != "" {
+		verbosity = v
+	}
+	return mentionsAsPreferred(applyVerbosity(name, customText(name, c, def), verbosity), c)
+}
+
+func customText(name string, c comment, def string) string {
+	responseTemplatesMut.RLock()
+	tmpl := responseTemplates[name]
+	responseTemplatesMut.RUnlock()
+	if tmpl == nil {
+		return def
+	}
+
+	buf := new(bytes.Buffer)
+	err := tmpl.Execute(buf, map[string]interface{}{
+		"Default": def,
+		"Sender":  c.Sender.Login,
+		"Author":  c.Issue.User.Login,
+		"Repo":    c.Repository.FullName,
+		"Number":  c.Issue.Number,
+	})
+	if err != nil {
+		log.Printf("Response template %s: %v", name, err)
+		return def
+	}
+	return buf.String()
+}
+
+// setResponseTemplates replaces the custom response templates.
+func setResponseTemplates(tmpls map[string]*template.Template) {
+	responseTemplatesMut.Lock()
+	responseTemplates = tmpls
+	responseTemplatesMut.Unlock()
 }
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestApplyVerbosity(t *testing.T) {
+	waiting := "@jb: Build status is `pending`. I'll wait until it goes green and then merge!"
+	failed := "@jb: Merge failed:\n\n```\nerror\n```\n"
+
+	cases := []struct {
+		name, text, verbosity, res string
+	}{
+		{"waiting", waiting, verbosityFull, waiting},
+		{"waiting", waiting, "", waiting},
+		{"waiting", waiting, verbosityTerse, "@jb: Build status is `pending`."},
+		{"waiting", waiting, verbositySilent, ""},
+		{"thanks", ":ok_hand: Merged as abc123. Thanks, @jb!", verbosityTerse, ":ok_hand: Merged as abc123."},
+		{"error", failed, verbosityTerse, failed},
+		{"error", failed, verbositySilent, failed},
+	}
+	for _, tc := range cases {
+		if res := applyVerbosity(tc.name, tc.text, tc.verbosity); res != tc.res {
+			t.Errorf("applyVerbosity(%s, %q, %s) = %q, expected %q", tc.name, tc.text, tc.verbosity, res, tc.res)
+		}
+	}
+
+	if res := withNotes("", []string{"note"}); res != "" {
+		t.Errorf("Expected a left out response to stay out, got %q", res)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// mergeablePoll is how long to wait between asking GitHub whether a PR is
+// mergeable, which it works out in the background after a change.
+var mergeablePoll = 2 * time.Second
+
+// handleRetarget changes the base branch of the PR to the one given, as in
+// "retarget release-1.2", and reports how the PR stands against it.
+func (h *handler) handleRetarget(c comment) {
+	defer h.lockRepo(c.Repository.FullName)()
+
+	repo := c.Repository.FullName
+	fields := strings.Fields(c.parseBody().command)
+	if len(fields) != 2 {
+		c.post(badOptionResponse(c, "say which branch to retarget to, as in `retarget release-1.2`"), h.username, h.token)
+		return
+	}
+	branch := fields[1]
+	if !h.baseAllowed(repo, branch) {
+		c.post(baseNotAllowedResponse(c, branch), h.username, h.token)
+		return
+	}
+
+	pr, err := c.getPR()
+	if err != nil {
+		log.Println("No pull request:", err)
+		return
+	}
+	from := pr.Base.Ref
+	if from != branch {
+		if err := apiRequest("PATCH", pr.URL, map[string]string{"base": branch}, nil, h.username, h.token); err != nil {
+			c.post(retargetFailedResponse(c, branch, err.Error()), h.username, h.token)
+			return
+		}
+		h.audit.record(auditEvent{Kind: "retarget", Repo: repo, PR: c.Issue.Number, User: c.Sender.Login, Detail: fmt.Sprintf("Retargeted from %s to %s", from, branch)})
+		log.Printf("Retargeted PR %d on %s from %s to %s for %s", c.Issue.Number, repo, from, branch, c.Sender.Login)
+	}
+
+	c.post(retargetedResponse(c, from, branch, h.retargetNotes(c, branch)), h.username, h.token)
+}
+
+// retargetNotes evaluates the PR against its new base: whether it
+// conflicts, whether the base drifted too far from it, and what CI says.
+func (h *handler) retargetNotes(c comment, branch string) []string {
+	var notes []string
+	pr, err := c.getPR()
+	for i := 0; err == nil && pr.Mergeable == nil && i < 5; i++ {
+		time.Sleep(mergeablePoll)
+		pr, err = c.getPR()
+	}
+	if err != nil {
+		log.Println("No pull request:", err)
+		return nil
+	}
+	if pr.Mergeable != nil && !*pr.Mergeable {
+		notes = append(notes, fmt.Sprintf("It conflicts with %s and needs to be updated.", branch))
+	}
+	if err := h.checkDrift(c.Repository.FullName, pr); err != nil {
+		notes = append(notes, fmt.Sprintf("It can't be merged until CI runs again, as %s.", err))
+	}
+	state, details := h.checkStatus(c.Repository.FullName, pr, nil)
+	notes = append(notes, fmt.Sprintf("Its checks are %s.", state))
+	return append(notes, details...)
+}
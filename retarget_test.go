@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetarget(t *testing.T) {
+	defer func(d time.Duration) { mergeablePoll = d }(mergeablePoll)
+	mergeablePoll = time.Millisecond
+
+	base, polls := "main", 0
+	var posted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/foo/bar/pulls/1" && r.Method == "PATCH":
+			var body struct{ Base string }
+			json.NewDecoder(r.Body).Decode(&body)
+			base, polls = body.Base, 0
+			w.Write([]byte(`{}`))
+		case r.URL.Path == "/repos/foo/bar/pulls/1":
+			// GitHub works out mergeability after a while.
+			mergeable := "null"
+			if polls++; polls > 1 {
+				mergeable = "false"
+			}
+			w.Write([]byte(`{"url": "` + "http://" + r.Host + r.URL.Path + `", "base": {"ref": "` + base + `"}, "mergeable": ` + mergeable + `}`))
+		case r.URL.Path == "/comments":
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			posted = append(posted, body.Body)
+		default:
+			ioutil.ReadAll(r.Body)
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	h := newHandler(nil, "bot", "", false)
+	h.settings.repos = map[string]repoSettings{"foo/bar": {AllowedUsers: []string{"alice"}, BaseBranches: []string{"main", "release-*"}}}
+	comment := func(login, body string) comment {
+		var c comment
+		c.Repository.FullName = "foo/bar"
+		c.Sender.Login = login
+		c.Comment.Body = body
+		c.Issue.Number = 1
+		c.Issue.PullRequest.URL = srv.URL + "/repos/foo/bar/pulls/1"
+		c.Issue.CommentsURL = srv.URL + "/comments"
+		return c
+	}
+
+	h.handleRetarget(comment("alice", "@bot retarget feature"))
+	h.handleRetarget(comment("alice", "@bot retarget"))
+	h.handleRetarget(comment("alice", "@bot retarget release-1.2"))
+	if base != "release-1.2" {
+		t.Errorf("Base is %s", base)
+	}
+	if len(posted) != 3 || !strings.Contains(posted[0], "don't merge into feature") || !strings.Contains(posted[1], "say which branch") {
+		t.Fatalf("Unexpected responses %q", posted)
+	}
+	if !strings.Contains(posted[2], "Retargeted from main to release-1.2") || !strings.Contains(posted[2], "conflicts with release-1.2") {
+		t.Errorf("Unexpected response %q", posted[2])
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// proposingReverts returns whether merges that break a watched build get a
+// PR reverting them. The PR is only proposed: someone still has to merge it.
+func (h *handler) proposingReverts(repo string) bool {
+	rs := h.settings.forRepo(repo)
+	return rs.ProposeRevert != nil && *rs.ProposeRevert && !h.observing(repo)
+}
+
+// pointsAtMerge returns whether every context that failed at the merge
+// passed at before, the base just before it, so that the failure started
+// with the merge rather than being there already or flaking in between.
+func (h *handler) pointsAtMerge(repo string, pr pr, before string, failed []status) bool {
+	if before == "" || len(failed) == 0 {
+		return false
+	}
+	pr.Head.SHA = before
+	pr.PullRequest.Head.SHA = before
+	pr.StatusesURL = fmt.Sprintf("%s/repos/%s/commits/%s/statuses", githubAPI, repo, before)
+	passed := make(map[string]bool)
+	for _, s := range h.reportedStatuses(repo, pr) {
+		if s.State == stateSuccess {
+			passed[s.Context] = true
+		}
+	}
+	for _, s := range failed {
+		if !passed[s.Context] {
+			return false
+		}
+	}
+	return true
+}
+
+// revertPR opens a PR reverting the merge of the PR as sha, with before as
+// the base just before it, and returns its URL. It's only done while sha is
+// still the head of the base, so the revert is exactly the tree of before.
+func (h *handler) revertPR(c comment, pr pr, before, sha string, failures []string) (string, error) {
+	repo := c.Repository.FullName
+	base := pr.Base.Ref
+
+	defer h.lockRepo(repo)()
+
+	if _, err := os.Stat(filepath.Join(repo, ".git")); err != nil {
+		if err := h.clone(repo); err != nil {
+			return "", err
+		}
+	}
+	h.refreshRemote(repo)
+
+	s := newScript().in(repo)
+	s.run("git", "fetch", "-f", "origin", fmt.Sprintf("%s:orig/%s", base, base))
+	head := s.run("git", "rev-parse", "orig/"+base)
+	if s.Error() != nil {
+		return "", fmt.Errorf("%s", s.output.String())
+	}
+	if head != sha {
+		return "", fmt.Errorf("%s has moved on from %s", base, sha)
+	}
+
+	title := pr.Title
+	if title == "" {
+		title = fmt.Sprintf("PR %d", c.Issue.Number)
+	}
+	msg := fmt.Sprintf("Revert %q\n\nThis reverts %s, the merge of #%d, which broke the build of %s.", title, sha, c.Issue.Number, base)
+	revert := s.run("git", "-c", "user.name="+h.username, "-c", "user.email="+noreplyEmail(h.username),
+		"commit-tree", "-p", sha, "-m", msg, before+"^{tree}")
+	branch := fmt.Sprintf("revert-%d", c.Issue.Number)
+	s.run("git", "push", "-f", "origin", revert+":refs/heads/"+branch)
+	if s.Error() != nil {
+		return "", fmt.Errorf("%s", s.output.String())
+	}
+
+	var res struct {
+		HTMLURL string `json:"html_url"`
+	}
+	req := map[string]string{
+		"title": fmt.Sprintf("Revert %q", title),
+		"head":  branch,
+		"base":  base,
+		"body": fmt.Sprintf("The build of `%s` broke at %s, where #%d was merged, having passed just before:\n\n* %s\n\nThis reverts it, should that be the way to fix the build. It won't be merged unless someone asks.",
+			base, sha, c.Issue.Number, strings.Join(failures, "\n* ")),
+	}
+	if err := apiRequest("POST", fmt.Sprintf("%s/repos/%s/pulls", githubAPI, repo), req, &res, h.username, h.token); err != nil {
+		return "", err
+	}
+	log.Printf("Opened revert PR for PR %d on %s: %s", c.Issue.Number, repo, res.HTMLURL)
+	return res.HTMLURL, nil
+}
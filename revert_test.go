@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProposeRevert(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(t.TempDir())
+
+	work, origin := t.TempDir(), filepath.Join(t.TempDir(), "origin.git")
+	w := newScript().in(work)
+	git := func(args ...string) string {
+		return w.run("git", append([]string{"-c", "user.name=Author", "-c", "user.email=author@example.com"}, args...)...)
+	}
+	commit := func(file, msg string) string {
+		os.WriteFile(filepath.Join(work, file), []byte(msg+"\n"), 0644)
+		git("add", file)
+		git("commit", "-q", "-m", msg)
+		return git("rev-parse", "HEAD")
+	}
+	git("init", "-q", "-b", "main")
+	before := commit("a.txt", "Initial")
+	merged := commit("b.txt", "Break the build")
+	newScript().run("git", "clone", "-q", "--bare", work, origin)
+	if w.Error() != nil {
+		t.Fatal(w.output.String())
+	}
+
+	var mut sync.Mutex
+	var posted []string
+	var opened []map[string]string
+	beforeState := "success"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		defer mut.Unlock()
+		switch r.URL.Path {
+		case "/repos/foo/bar/commits/" + merged + "/statuses":
+			w.Write([]byte(`[{"state": "failure", "context": "ci", "description": "Tests failed"}]`))
+		case "/repos/foo/bar/commits/" + before + "/statuses":
+			w.Write([]byte(`[{"state": "` + beforeState + `", "context": "ci"}]`))
+		case "/repos/foo/bar/pulls":
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			opened = append(opened, req)
+			w.Write([]byte(`{"html_url": "https://github.com/foo/bar/pull/6"}`))
+		case "/comments":
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			posted = append(posted, body.Body)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+	defer func(d time.Duration) { buildWatchPoll = d }(buildWatchPoll)
+	buildWatchPoll = time.Millisecond
+
+	h := newHandler(nil, "bot", "", false)
+	propose := true
+	h.settings.defaults.WatchBuild = duration{time.Minute}
+	h.settings.defaults.ProposeRevert = &propose
+	h.settings.defaults.CloneURL = origin
+	var c comment
+	c.Repository.FullName = "foo/bar"
+	c.Sender.Login = "alice"
+	c.Issue.Number = 5
+	c.Issue.CommentsURL = srv.URL + "/comments"
+	var p pr
+	p.Title = "Break the build"
+	p.Base.Ref = "main"
+
+	// Failing before the merge too doesn't point at it.
+	beforeState = "failure"
+	h.watchBuild(c, p, before, merged)
+	if len(opened) != 0 || len(posted) != 1 {
+		t.Fatalf("Expected only the breakage to be reported, got %q and %v", posted, opened)
+	}
+
+	beforeState = "success"
+	h.watchBuild(c, p, before, merged)
+	if len(opened) != 1 || opened[0]["head"] != "revert-5" || opened[0]["base"] != "main" || opened[0]["title"] != `Revert "Break the build"` {
+		t.Fatalf("Unexpected PRs opened %v", opened)
+	}
+	if len(posted) != 3 || !strings.Contains(posted[2], "https://github.com/foo/bar/pull/6") {
+		t.Errorf("Unexpected responses %q", posted)
+	}
+	o := newScript().in(origin)
+	if parent := o.run("git", "rev-parse", "revert-5^"); parent != merged {
+		t.Errorf("Revert is onto %s, want %s", parent, merged)
+	}
+	if diff := o.run("git", "diff", before, "revert-5"); diff != "" || o.Error() != nil {
+		t.Errorf("Revert doesn't restore the tree before the merge: %s", o.output.String())
+	}
+
+	// Once the base has moved on, reverting is for people to do.
+	commit("c.txt", "Meanwhile")
+	git("push", "-q", origin, "main")
+	h.watchBuild(c, p, before, merged)
+	if len(opened) != 1 {
+		t.Errorf("Expected no revert once the base moved on, got %v", opened)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// suggestReviewers requests reviews on a newly opened PR from the people most
+// likely to know the touched code, according to the repository settings.
+// The repository must be cloned.
+func (h *handler) suggestReviewers(p pr) {
+	rs := h.settings.forRepo(p.Repository.FullName)
+	if rs.SuggestReviewers == "" {
+		return
+	}
+	max := rs.MaxReviewers
+	if max < 1 {
+		max = 2
+	}
+
+	files, err := p.getFiles(h.username, h.token)
+	if err != nil {
+		log.Println("Getting PR files:", err)
+		return
+	}
+
+	base := p.PullRequest.Base.Ref
+	s := newScript().in(p.Repository.FullName)
+	s.run("git", "fetch", "-f", "origin", fmt.Sprintf("%s:orig/%s", base, base))
+	if s.Error() != nil {
+		log.Println("Fetching for reviewer suggestions:", s.output.String())
+		return
+	}
+
+	var users, teams []string
+	switch rs.SuggestReviewers {
+	case "codeowners":
+		users, teams = codeownersReviewers(p.Repository.FullName, "orig/"+base, files)
+	case "history":
+		users = h.historyReviewers(p, files, "orig/"+base)
+	default:
+		log.Printf("Unknown reviewer suggestion method %q for %s", rs.SuggestReviewers, p.Repository.FullName)
+		return
+	}
+
+	users = h.awayReviewers(p.Repository.FullName, users, time.Now())
+	users = limitReviewers(users, p.PullRequest.User.Login, max)
+	if len(users) == 0 && len(teams) == 0 {
+		return
+	}
+
+	req := map[string][]string{"reviewers": users, "team_reviewers": teams}
+	if err := apiRequest("POST", p.PullRequest.URL+"/requested_reviewers", req, nil, h.username, h.token); err != nil {
+		log.Println("Requesting reviewers:", err)
+		return
+	}
+	log.Printf("Requested reviews on %s PR %d from %v %v", p.Repository.FullName, p.Number, users, teams)
+}
+
+// limitReviewers returns at most max of the users, excluding the author.
+func limitReviewers(users []string, author string, max int) []string {
+	var res []string
+	for _, u := range users {
+		if u != author && len(res) < max {
+			res = append(res, u)
+		}
+	}
+	return res
+}
+
+// historyReviewers returns the GitHub users who most frequently committed
+// to the touched files on the given ref, most frequent first.
+func (h *handler) historyReviewers(p pr, files []prFile, ref string) []string {
+	counts := make(map[string]int)     // author email -> commits
+	commits := make(map[string]string) // author email -> a commit
+	t := newScript().in(p.Repository.FullName)
+	for _, f := range files {
+		out := t.run("git", "log", "-n", "20", "--format=%H %ae", ref, "--", f.Filename)
+		for _, line := range strings.Split(out, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				continue
+			}
+			counts[fields[1]]++
+			commits[fields[1]] = fields[0]
+		}
+	}
+
+	var emails []string
+	for email := range counts {
+		emails = append(emails, email)
+	}
+	sort.Slice(emails, func(a, b int) bool {
+		if counts[emails[a]] != counts[emails[b]] {
+			return counts[emails[a]] > counts[emails[b]]
+		}
+		return emails[a] < emails[b]
+	})
+
+	// Emails need to be resolved into GitHub logins, which the API does for
+	// us on a commit by that author.
+	var res []string
+	for _, email := range emails {
+		var commit struct {
+			Author *struct {
+				Login string
+			}
+		}
+		url := fmt.Sprintf("%s/repos/%s/commits/%s", githubAPI, p.Repository.FullName, commits[email])
+		if err := apiRequest("GET", url, nil, &commit, h.username, h.token); err != nil || commit.Author == nil {
+			continue
+		}
+		res = append(res, commit.Author.Login)
+		if len(res) > 5 {
+			break
+		}
+	}
+	return res
+}
+
+var codeownersLocations = []string{"CODEOWNERS", ".github/CODEOWNERS", "docs/CODEOWNERS"}
+
+// codeownersReviewers returns the users and teams owning the touched files
+// according to the CODEOWNERS file at rev, the base of the PR, in the clone
+// in dir. Whatever the clone has checked out may be another branch.
+func codeownersReviewers(dir, rev string, files []prFile) ([]string, []string) {
+	var rules []codeownersRule
+	for _, loc := range codeownersLocations {
+		s := newScript().in(dir)
+		out := s.run("git", "show", rev+":"+loc)
+		if s.Error() != nil {
+			continue
+		}
+		rules = parseCodeowners(strings.NewReader(out))
+		break
+	}
+
+	var users, teams []string
+	seen := make(map[string]bool)
+	for _, f := range files {
+		for _, owner := range matchCodeowners(rules, f.Filename) {
+			if seen[owner] {
+				continue
+			}
+			seen[owner] = true
+			if idx := strings.Index(owner, "/"); idx >= 0 {
+				teams = append(teams, owner[idx+1:])
+			} else {
+				users = append(users, owner)
+			}
+		}
+	}
+	return users, teams
+}
+
+type codeownersRule struct {
+	pattern string
+	owners  []string // without the leading @
+}
+
+func parseCodeowners(r io.Reader) []codeownersRule {
+	var res []codeownersRule
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		rule := codeownersRule{pattern: fields[0]}
+		for _, owner := range fields[1:] {
+			if strings.HasPrefix(owner, "@") {
+				rule.owners = append(rule.owners, owner[1:])
+			}
+		}
+		res = append(res, rule)
+	}
+	return res
+}
+
+// matchCodeowners returns the owners of the last rule matching the file, as
+// later rules take precedence.
+func matchCodeowners(rules []codeownersRule, file string) []string {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if codeownersMatch(rules[i].pattern, file) {
+			return rules[i].owners
+		}
+	}
+	return nil
+}
+
+func codeownersMatch(pattern, file string) bool {
+	if pattern == "*" {
+		return true
+	}
+	anchored := strings.HasPrefix(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	if strings.HasSuffix(pattern, "/") {
+		// A directory and everything below it
+		if anchored {
+			return strings.HasPrefix(file, pattern)
+		}
+		return strings.HasPrefix(file, pattern) || strings.Contains(file, "/"+pattern)
+	}
+
+	if ok, _ := path.Match(pattern, file); ok {
+		return true
+	}
+	if strings.HasPrefix(file, pattern+"/") {
+		return true
+	}
+	if !anchored && !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(file))
+		return ok
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchCodeowners(t *testing.T) {
+	rules := parseCodeowners(strings.NewReader(`
+# Comment
+*         @everyone
+*.js      @js-owner
+/docs/    @docs-owner @org/writers
+lib/      @lib-owner
+`))
+
+	cases := []struct {
+		file   string
+		owners []string
+	}{
+		{"main.go", []string{"everyone"}},
+		{"web/app.js", []string{"js-owner"}},
+		{"docs/index.md", []string{"docs-owner", "org/writers"}},
+		{"src/docs/index.md", []string{"everyone"}},
+		{"src/lib/a.go", []string{"lib-owner"}},
+	}
+	for _, tc := range cases {
+		if owners := matchCodeowners(rules, tc.file); !reflect.DeepEqual(owners, tc.owners) {
+			t.Errorf("Got owners %v for %s, expected %v", owners, tc.file, tc.owners)
+		}
+	}
+}
+
+func TestLimitReviewers(t *testing.T) {
+	if r := limitReviewers([]string{"a", "author", "b", "c"}, "author", 2); !reflect.DeepEqual(r, []string{"a", "b"}) {
+		t.Error("Unexpected reviewers", r)
+	}
+}
+
+func TestCodeownersFromBase(t *testing.T) {
+	dir := t.TempDir()
+	s := newScript().in(dir)
+	git := func(args ...string) string {
+		return s.run("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	}
+	git("init", "-q", "-b", "main")
+	os.MkdirAll(filepath.Join(dir, ".github"), 0755)
+	os.WriteFile(filepath.Join(dir, ".github", "CODEOWNERS"), []byte("*.go @gopher @org/backend\n"), 0644)
+	git("add", ".github")
+	git("commit", "-q", "-m", "Owners")
+	git("branch", "orig/main")
+	// The clone is left on another branch, with other owners.
+	git("checkout", "-q", "-b", "mergebot-train")
+	os.WriteFile(filepath.Join(dir, ".github", "CODEOWNERS"), []byte("*.go @intruder\n"), 0644)
+	git("commit", "-q", "-am", "Other owners")
+	if s.Error() != nil {
+		t.Fatal(s.output.String())
+	}
+
+	users, teams := codeownersReviewers(dir, "orig/main", []prFile{{Filename: "main.go"}})
+	if !reflect.DeepEqual(users, []string{"gopher"}) || !reflect.DeepEqual(teams, []string{"backend"}) {
+		t.Errorf("Expected the owners on the base, got %q and %q", users, teams)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type review struct {
+	User struct {
+		Login string
+	}
+	State       string
+	CommitID    string    `json:"commit_id"`
+	SubmittedAt time.Time `json:"submitted_at"`
+}
+
+func (p *pr) getReviews(username, token string) ([]review, error) {
+	var res []review
+	if err := apiRequest("GET", p.URL+"/reviews?per_page=100", nil, &res, username, token); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// latestReviews returns the most recent approving or change requesting
+// review by each user; plain comments do not change a user's verdict.
+func latestReviews(rs []review) []review {
+	latest := make(map[string]review)
+	for _, r := range rs {
+		switch r.State {
+		case "APPROVED", "CHANGES_REQUESTED", "DISMISSED":
+		default:
+			continue
+		}
+		if prev, ok := latest[r.User.Login]; !ok || !r.SubmittedAt.Before(prev.SubmittedAt) {
+			latest[r.User.Login] = r
+		}
+	}
+
+	var res []review
+	for _, r := range latest {
+		if r.State != "DISMISSED" {
+			res = append(res, r)
+		}
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a].User.Login < res[b].User.Login })
+	return res
+}
+
+// approvers returns the users whose latest review approves the PR.
+func approvers(rs []review) []string {
+	var res []string
+	for _, r := range latestReviews(rs) {
+		if r.State == "APPROVED" {
+			res = append(res, r.User.Login)
+		}
+	}
+	return res
+}
+
+// countedReviews returns the reviews except approvals by users who aren't
+// allowed to merge in the repository. On public repositories anyone may
+// approve, and a drive-by approval mustn't stand in for a required one.
+func (h *handler) countedReviews(repo string, rs []review) []review {
+	allowed := make(map[string]bool)
+	var res []review
+	for _, r := range rs {
+		if r.State == "APPROVED" {
+			ok, seen := allowed[r.User.Login]
+			if !seen {
+				ok = h.isAllowed(repo, r.User.Login)
+				allowed[r.User.Login] = ok
+			}
+			if !ok {
+				continue
+			}
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// freshApprovers returns the users whose latest review approves the PR and
+// is still valid, along with a note for each approval that is not: those
+// older than maxAge, if set, and with afterPush those given for another
+// commit than the head.
+func freshApprovers(rs []review, head string, maxAge time.Duration, afterPush bool, now time.Time) ([]string, []string) {
+	var res, notes []string
+	for _, r := range latestReviews(rs) {
+		if r.State != "APPROVED" {
+			continue
+		}
+		switch {
+		case afterPush && head != "" && r.CommitID != head:
+			notes = append(notes, fmt.Sprintf("The approval by @%s was given before the latest push and no longer counts.", r.User.Login))
+		case maxAge > 0 && now.Sub(r.SubmittedAt) > maxAge:
+			age := now.Sub(r.SubmittedAt).Truncate(time.Hour)
+			notes = append(notes, fmt.Sprintf("The approval by @%s is %v old (more than %v) and no longer counts.", r.User.Login, age, maxAge))
+		default:
+			res = append(res, r.User.Login)
+		}
+	}
+	return res, notes
+}
+
+// whenApproved returns true if the comment asks for the merge to wait for
+// the required approvals, as in "merge when approved".
+func (c *comment) whenApproved() bool {
+	return strings.Contains(strings.ToLower(c.parseBody().command), "when approved")
+}
+
+// missingApprovals returns how many more approvals the PR needs before a
+// "merge when approved" request may proceed, and notes on any approvals
+// that were disregarded as stale.
+func (h *handler) missingApprovals(c comment, pr pr) (int, []string) {
+	if !c.whenApproved() {
+		return 0, nil
+	}
+	rs := h.settings.forRepo(c.Repository.FullName)
+	need := rs.RequiredApprovals
+	if need < 1 {
+		need = 1
+	}
+	reviews, err := pr.getReviews(h.username, h.token)
+	if err != nil {
+		// We'll find out again on the next poll.
+		return need, nil
+	}
+	reviews = h.countedReviews(c.Repository.FullName, reviews)
+	afterPush := rs.FreshApprovals != nil && *rs.FreshApprovals
+	have, notes := freshApprovers(reviews, pr.Head.SHA, rs.ApprovalMaxAge.Duration, afterPush, time.Now())
+	if len(have) < need {
+		return need - len(have), notes
+	}
+	return 0, notes
+}
+
+// reviewProblems returns why the reviews don't allow merging: fewer valid
+// approvals than needed, changes requested by reviewers who haven't since
+// approved, and approvals that no longer count.
+func reviewProblems(rs []review, need int, head string, maxAge time.Duration, afterPush bool, now time.Time) []string {
+	have, notes := freshApprovers(rs, head, maxAge, afterPush, now)
+	var res []string
+	if len(have) < need {
+		res = append(res, fmt.Sprintf("It has %d of the %d approvals required.", len(have), need))
+	}
+	for _, r := range latestReviews(rs) {
+		if r.State == "CHANGES_REQUESTED" {
+			res = append(res, fmt.Sprintf("@%s requested changes.", r.User.Login))
+		}
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	return append(res, notes...)
+}
+
+// checkReviews returns why the reviews of the PR don't allow merging it,
+// where the repository requires reviews before any merge.
+func (h *handler) checkReviews(c comment, pr pr) ([]string, error) {
+	rs := h.settings.forRepo(c.Repository.FullName)
+	if rs.RequireReviews == nil || !*rs.RequireReviews {
+		return nil, nil
+	}
+	need := rs.RequiredApprovals
+	if need < 1 {
+		need = 1
+	}
+	reviews, err := pr.getReviews(h.username, h.token)
+	if err != nil {
+		return nil, err
+	}
+	reviews = h.countedReviews(c.Repository.FullName, reviews)
+	afterPush := rs.FreshApprovals != nil && *rs.FreshApprovals
+	return reviewProblems(reviews, need, pr.Head.SHA, rs.ApprovalMaxAge.Duration, afterPush, time.Now()), nil
+}
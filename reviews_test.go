@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApprovers(t *testing.T) {
+	t0 := time.Now()
+	mk := func(login, state string, at time.Duration) review {
+		var r review
+		r.User.Login = login
+		r.State = state
+		r.SubmittedAt = t0.Add(at)
+		return r
+	}
+
+	rs := []review{
+		mk("alice", "CHANGES_REQUESTED", 0),
+		mk("alice", "APPROVED", time.Minute),
+		mk("alice", "COMMENTED", 2*time.Minute),
+		mk("bob", "APPROVED", 0),
+		mk("bob", "CHANGES_REQUESTED", time.Minute),
+		mk("carol", "APPROVED", 0),
+		mk("carol", "DISMISSED", time.Minute),
+	}
+
+	if a := approvers(rs); !reflect.DeepEqual(a, []string{"alice"}) {
+		t.Error("Unexpected approvers", a)
+	}
+}
+
+func TestFreshApprovers(t *testing.T) {
+	now := time.Now()
+	mk := func(login, commit string, age time.Duration) review {
+		var r review
+		r.User.Login = login
+		r.State = "APPROVED"
+		r.CommitID = commit
+		r.SubmittedAt = now.Add(-age)
+		return r
+	}
+
+	rs := []review{
+		mk("alice", "head", time.Hour),
+		mk("bob", "old", time.Hour),
+		mk("carol", "head", 10*24*time.Hour),
+	}
+
+	fresh, notes := freshApprovers(rs, "head", 7*24*time.Hour, true, now)
+	if !reflect.DeepEqual(fresh, []string{"alice"}) || len(notes) != 2 {
+		t.Errorf("Unexpected result %v, %q", fresh, notes)
+	}
+
+	fresh, notes = freshApprovers(rs, "head", 0, false, now)
+	if len(fresh) != 3 || len(notes) != 0 {
+		t.Errorf("Unexpected result %v, %q", fresh, notes)
+	}
+}
+
+func TestReviewProblems(t *testing.T) {
+	now := time.Now()
+	mk := func(login, state, commit string, age time.Duration) review {
+		var r review
+		r.User.Login = login
+		r.State = state
+		r.CommitID = commit
+		r.SubmittedAt = now.Add(-age)
+		return r
+	}
+
+	tests := []struct {
+		name    string
+		reviews []review
+		need    int
+		want    []string
+	}{
+		{"approved", []review{mk("alice", "APPROVED", "head", time.Hour)}, 1, nil},
+		{"unreviewed", nil, 1, []string{"It has 0 of the 1 approvals required."}},
+		{"too few", []review{mk("alice", "APPROVED", "head", time.Hour)}, 2, []string{"It has 1 of the 2 approvals required."}},
+		{"changes requested", []review{
+			mk("alice", "APPROVED", "head", time.Hour),
+			mk("bob", "CHANGES_REQUESTED", "head", time.Hour),
+		}, 1, []string{"@bob requested changes."}},
+		{"changes since approved", []review{
+			mk("bob", "CHANGES_REQUESTED", "old", 2*time.Hour),
+			mk("bob", "APPROVED", "head", time.Hour),
+		}, 1, nil},
+		{"stale approval", []review{mk("alice", "APPROVED", "old", time.Hour)}, 1, []string{
+			"It has 0 of the 1 approvals required.",
+			"The approval by @alice was given before the latest push and no longer counts.",
+		}},
+	}
+	for _, test := range tests {
+		if got := reviewProblems(test.reviews, test.need, "head", 0, true, now); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckReviewsOnlyCollaborators(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[
+			{"user": {"login": "alice"}, "state": "APPROVED", "commit_id": "abc"},
+			{"user": {"login": "mallory"}, "state": "APPROVED", "commit_id": "abc"}
+		]`))
+	}))
+	defer srv.Close()
+
+	h := newHandler(nil, "bot", "token", false)
+	h.permissions.directory = map[string][]string{"foo/*": {"alice", "bob"}}
+	required := true
+	h.settings.repos = map[string]repoSettings{
+		"foo/bar": {RequireReviews: &required, RequiredApprovals: 2},
+	}
+	var c comment
+	c.Repository.FullName = "foo/bar"
+	var p pr
+	p.URL = srv.URL + "/repos/foo/bar/pulls/1"
+	p.Head.SHA = "abc"
+
+	problems, err := h.checkReviews(c, p)
+	if want := []string{"It has 1 of the 2 approvals required."}; err != nil || !reflect.DeepEqual(problems, want) {
+		t.Errorf("Expected the approval by a non-collaborator not to count, got %q, %v", problems, err)
+	}
+}
@@ -9,6 +9,15 @@ func TestReflow(t *testing.T) {
 		{"foo bar\nbaz quux", "foo bar\nbaz quux\n"},
 		{"foo bar\n  baz quux", "foo bar\n\n  baz quux\n"},
 		{"foo bar\n  baz quux\n  baz baz", "foo bar\n\n  baz quux\n  baz baz\n"},
+		{"ünïcödé wörds", "ünïcödé\nwörds\n"},
+		{"日本語の文章です。", "日本語の\n文章で\nす。\n"},
+		{"🎉🎉 🎉🎉 🎉", "🎉🎉 🎉\n🎉 🎉\n"},
+		{"aaaa 👨\u200d👩\u200d👧👨\u200d👩\u200d👧", "aaaa\n👨\u200d👩\u200d👧\n👨\u200d👩\u200d👧\n"},
+		{"aaaaa 👍🏽👍🏽", "aaaaa 👍🏽\n👍🏽\n"},
+		{"aaaa 日\u0301本\u0301", "aaaa 日\u0301\n本\u0301\n"},
+		{"aaaa 日\u20dd本\u20dd", "aaaa 日\u20dd\n本\u20dd\n"},
+		{"see https://example.com/long/path ok", "see\nhttps://example.com/long/path\nok\n"},
+		{"foo bar\n```\nbaz quux text\n```\nfoo bar baz", "foo bar\n\n```\nbaz quux text\n```\nfoo bar\nbaz\n"},
 	}
 
 	for _, tc := range cases {
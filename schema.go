@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// A settingRule constrains a setting beyond its type. Numbers and durations
+// are never negative.
+type settingRule struct {
+	enum   []string // what a string may be, besides empty for the default
+	max    float64  // the largest number allowed, if not zero
+	secret bool     // the default is left out of the schema
+}
+
+var settingRules = map[string]settingRule{
+	"forge":             {enum: []string{forgeGitHub, forgeGitLab, forgeGitea}},
+	"merge_strategy":    {enum: []string{strategySquash, strategyRebase, strategyMerge}},
+	"verbosity":         {enum: []string{verbosityFull, verbosityTerse, verbositySilent}},
+	"message_mode":      {enum: []string{messageReflow, messageVerbatim}},
+	"suggest_reviewers": {enum: []string{"history", "codeowners"}},
+	"away_reviewers":    {enum: []string{awaySkip, awaySubstitute}},
+	"versioning":        {enum: []string{"file", "tag"}},
+	"flake_threshold":   {max: 1},
+	"webhook_secret":    {secret: true},
+}
+
+// repoFileSettings are the settings the repository's own configuration
+// file may also set, under names of its own; see repoConfig.
+var repoFileSettings = map[string]bool{
+	"required_statuses": true,
+	"allowed_users":     true,
+	"features":          true,
+	"merge_strategy":    true,
+	"merge_train":       true,
+	"train_length":      true,
+	"message_mode":      true,
+	"wrap_width":        true,
+	"area_subjects":     true,
+}
+
+// settingsEnvPrefix starts the names of environment variables setting the
+// defaults, as MERGEBOT_DEFAULT_MAX_WAIT=2h.
+const settingsEnvPrefix = "MERGEBOT_DEFAULT_"
+
+// settingKey returns the key of the field of repoSettings in settings files.
+func settingKey(f reflect.StructField) string {
+	if name := strings.Split(f.Tag.Get("json"), ",")[0]; name != "" {
+		return name
+	}
+	return strings.ToLower(f.Name)
+}
+
+// settingFields returns the index of each field of repoSettings by key.
+func settingFields() map[string]int {
+	t := reflect.TypeOf(repoSettings{})
+	res := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		res[settingKey(t.Field(i))] = i
+	}
+	return res
+}
+
+// A settingSpec describes a setting in the schema.
+type settingSpec struct {
+	Key      string
+	Type     string // string, boolean, integer, number, duration, size, list, map or object
+	Doc      string
+	Default  interface{} // nil if unset
+	Enum     []string
+	Max      float64
+	RepoFile bool // may be set in the repository's configuration file too
+}
+
+// settingType returns the type of a setting as the schema names it.
+func settingType(t reflect.Type) string {
+	switch t {
+	case reflect.TypeOf(duration{}):
+		return "duration"
+	case reflect.TypeOf(byteSize(0)):
+		return "size"
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int64:
+		return "integer"
+	case reflect.Float64:
+		return "number"
+	case reflect.Slice:
+		return "list"
+	case reflect.Map:
+		return "map"
+	}
+	return "object"
+}
+
+// settingSpecs describes every setting, in the order of repoSettings, with
+// the defaults as given.
+func settingSpecs(defaults repoSettings) []settingSpec {
+	t := reflect.TypeOf(defaults)
+	v := reflect.ValueOf(defaults)
+	var res []settingSpec
+	for i := 0; i < t.NumField(); i++ {
+		key := settingKey(t.Field(i))
+		rule := settingRules[key]
+		spec := settingSpec{Key: key, Type: settingType(t.Field(i).Type), Doc: settingDocs[key], Enum: rule.enum, Max: rule.max, RepoFile: repoFileSettings[key]}
+		if f := v.Field(i); !f.IsZero() && !rule.secret {
+			spec.Default = f.Interface()
+		}
+		res = append(res, spec)
+	}
+	return res
+}
+
+// writeSettingsSchema writes the schema of the settings files as a JSON
+// Schema, for editors and other tooling, or as markdown documentation.
+func writeSettingsSchema(w io.Writer, format string, defaults repoSettings) error {
+	specs := settingSpecs(defaults)
+	switch format {
+	case "json":
+		props := make(map[string]interface{})
+		for _, s := range specs {
+			p := map[string]interface{}{"description": s.Doc}
+			switch s.Type {
+			case "duration":
+				p["type"] = "string"
+				p["pattern"] = `^([0-9.]+(ns|us|µs|ms|s|m|h))+$`
+			case "size":
+				p["type"] = []string{"string", "integer"}
+			case "list":
+				p["type"] = "array"
+			case "map", "object":
+				p["type"] = "object"
+			default:
+				p["type"] = s.Type
+			}
+			if s.Default != nil {
+				p["default"] = s.Default
+			}
+			if s.Enum != nil {
+				p["enum"] = s.Enum
+			}
+			if s.Type == "integer" || s.Type == "number" {
+				p["minimum"] = 0
+				if s.Max != 0 {
+					p["maximum"] = s.Max
+				}
+			}
+			if s.RepoFile {
+				p["x-repo-file"] = true
+			}
+			props[s.Key] = p
+		}
+		schema := map[string]interface{}{
+			"$schema":              "https://json-schema.org/draft/2020-12/schema",
+			"title":                "mergebot settings",
+			"description":          `Settings by repository, as "owner/name", or "owner/*" for all of the owner's.`,
+			"type":                 "object",
+			"additionalProperties": map[string]interface{}{"$ref": "#/$defs/repoSettings"},
+			"$defs": map[string]interface{}{
+				"repoSettings": map[string]interface{}{"type": "object", "additionalProperties": false, "properties": props},
+			},
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(schema)
+	case "markdown":
+		fmt.Fprintln(w, "| Setting | Type | Default | Description |")
+		fmt.Fprintln(w, "| --- | --- | --- | --- |")
+		for _, s := range specs {
+			def := ""
+			if s.Default != nil {
+				bs, _ := json.Marshal(s.Default)
+				def = "`" + string(bs) + "`"
+			}
+			doc := s.Doc
+			if s.Enum != nil {
+				doc += " (one of " + strings.Join(s.Enum, ", ") + ")"
+			}
+			if s.RepoFile {
+				doc += "; also in " + repoConfigFile
+			}
+			fmt.Fprintf(w, "| `%s` | %s | %s | %s |\n", s.Key, s.Type, def, strings.Replace(doc, "|", `\|`, -1))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown schema format %q; use json or markdown", format)
+}
+
+// A settingProblem is a setting with a value that isn't allowed.
+type settingProblem struct {
+	key string
+	msg string
+}
+
+// validateSettings returns the problems with the values of the settings.
+func validateSettings(rs repoSettings) []settingProblem {
+	t := reflect.TypeOf(rs)
+	v := reflect.ValueOf(rs)
+	var res []settingProblem
+	for i := 0; i < t.NumField(); i++ {
+		key := settingKey(t.Field(i))
+		rule := settingRules[key]
+		var n float64
+		switch f := v.Field(i).Interface().(type) {
+		case string:
+			if f != "" && rule.enum != nil && !contains(rule.enum, f) {
+				res = append(res, settingProblem{key, fmt.Sprintf("%q is not one of %s", f, strings.Join(rule.enum, ", "))})
+			}
+			continue
+		case duration:
+			n = float64(f.Duration)
+		case byteSize:
+			n = float64(f)
+		case int:
+			n = float64(f)
+		case float64:
+			n = f
+		default:
+			continue
+		}
+		if n < 0 {
+			res = append(res, settingProblem{key, "may not be negative"})
+		} else if rule.max != 0 && n > rule.max {
+			res = append(res, settingProblem{key, fmt.Sprintf("may be at most %v", rule.max)})
+		}
+	}
+	return res
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSettings parses the settings file with the name, which is JSON,
+// YAML or TOML by its extension, and validates the settings. Errors say
+// where in the file the trouble is, as name:line, as closely as can be
+// told.
+func parseSettings(name string, data []byte) (map[string]repoSettings, error) {
+	bs := data
+	isJSON := true
+	var v interface{}
+	var err error
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yml", ".yaml":
+		isJSON = false
+		v, err = parseYAML(string(data))
+	case ".toml":
+		isJSON = false
+		v, err = parseTOML(string(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if !isJSON {
+		if bs, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+
+	repos := make(map[string]repoSettings)
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&repos); err != nil {
+		return nil, settingsDecodeError(name, data, isJSON, err)
+	}
+	if repos == nil {
+		repos = make(map[string]repoSettings) // an empty YAML file
+	}
+
+	type problem struct {
+		line int
+		text string
+	}
+	var problems []problem
+	for repo, rs := range repos {
+		for _, p := range validateSettings(rs) {
+			line := settingLine(data, repo, p.key)
+			problems = append(problems, problem{line, fmt.Sprintf("%s: %s: %s: %s", settingsAt(name, line), repo, p.key, p.msg)})
+		}
+	}
+	if len(problems) == 0 {
+		return repos, nil
+	}
+	sort.Slice(problems, func(i, j int) bool {
+		if problems[i].line != problems[j].line {
+			return problems[i].line < problems[j].line
+		}
+		return problems[i].text < problems[j].text
+	})
+	var texts []string
+	for _, p := range problems {
+		texts = append(texts, p.text)
+	}
+	return nil, errors.New(strings.Join(texts, "\n"))
+}
+
+// settingsDecodeError says where in the file the error decoding it is. The
+// offsets of JSON errors only apply to files that are JSON themselves.
+func settingsDecodeError(name string, data []byte, isJSON bool, err error) error {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		if isJSON {
+			return fmt.Errorf("%s: %v", settingsAt(name, lineAt(data, e.Offset)), e)
+		}
+	case *json.UnmarshalTypeError:
+		key := e.Field[strings.LastIndex(e.Field, ".")+1:]
+		line := settingLine(data, "", key)
+		if isJSON {
+			line = lineAt(data, e.Offset)
+		}
+		return fmt.Errorf("%s: %s: expected %s, not %s", settingsAt(name, line), key, e.Type, e.Value)
+	}
+	if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+		key := strings.Trim(strings.TrimPrefix(msg, "json: unknown field "), `"`)
+		return fmt.Errorf("%s: %s is not a setting", settingsAt(name, settingLine(data, "", key)), key)
+	}
+	return fmt.Errorf("%s: %v", name, err)
+}
+
+// settingsAt returns the place in the settings file, with the line if
+// known.
+func settingsAt(name string, line int) string {
+	if line == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s:%d", name, line)
+}
+
+// lineAt returns the line of the offset in data.
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return 1 + bytes.Count(data[:offset], []byte("\n"))
+}
+
+// settingLine returns the line where the key is given for the repository,
+// or first given if the repository is empty, or 0 if it can't be found. It
+// goes by the text, whatever the format.
+func settingLine(data []byte, repo, key string) int {
+	lines := strings.Split(string(data), "\n")
+	start := 0
+	if repo != "" {
+		for i, l := range lines {
+			if strings.Contains(l, `"`+repo+`"`) || strings.Contains(l, `'`+repo+`'`) || strings.HasPrefix(strings.TrimSpace(l), repo+":") {
+				start = i
+				break
+			}
+		}
+	}
+	for i := start; i < len(lines); i++ {
+		l := strings.TrimSpace(lines[i])
+		for _, prefix := range []string{`"` + key + `"`, `'` + key + `'`, key + ":", key + " ", key + "="} {
+			if strings.HasPrefix(l, prefix) {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+// settingsFromEnv sets the defaults given in the environment, as
+// MERGEBOT_DEFAULT_MAX_WAIT=2h. Values are JSON, though strings needn't be
+// quoted.
+func settingsFromEnv(environ []string, defaults *repoSettings) error {
+	fields := settingFields()
+	dv := reflect.ValueOf(defaults).Elem()
+	var problems []string
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, settingsEnvPrefix) {
+			continue
+		}
+		eq := strings.Index(kv, "=")
+		if eq < 0 {
+			continue
+		}
+		name, value := kv[:eq], kv[eq+1:]
+		i, ok := fields[strings.ToLower(strings.TrimPrefix(name, settingsEnvPrefix))]
+		if !ok {
+			problems = append(problems, name+": not a setting")
+			continue
+		}
+		ptr := dv.Field(i).Addr().Interface()
+		if err := json.Unmarshal([]byte(value), ptr); err != nil {
+			quoted, _ := json.Marshal(value)
+			if json.Unmarshal(quoted, ptr) != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			}
+		}
+	}
+	for _, p := range validateSettings(*defaults) {
+		problems = append(problems, fmt.Sprintf("%s: %s", p.key, p.msg))
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSettings(t *testing.T) {
+	want := map[string]repoSettings{"foo/bar": {MaxWait: duration{2 * time.Hour}, AllowedUsers: []string{"alice"}, MergeStrategy: strategyRebase}}
+	for name, data := range map[string]string{
+		"settings.json": `{"foo/bar": {"max_wait": "2h", "allowed_users": ["alice"], "merge_strategy": "rebase"}}`,
+		"settings.yml":  "foo/bar:\n  max_wait: 2h\n  allowed_users: [alice]\n  merge_strategy: rebase\n",
+		"settings.toml": "[\"foo/bar\"]\nmax_wait = \"2h\"\nallowed_users = [\"alice\"]\nmerge_strategy = \"rebase\"\n",
+	} {
+		repos, err := parseSettings(name, []byte(data))
+		if err != nil || !reflect.DeepEqual(repos, want) {
+			t.Errorf("parseSettings(%s) = %+v, %v", name, repos, err)
+		}
+	}
+	if repos, err := parseSettings("settings.yaml", nil); err != nil || len(repos) != 0 {
+		t.Errorf("Expected no settings from an empty file, got %v, %v", repos, err)
+	}
+
+	cases := []struct {
+		name, data, err string
+	}{
+		{"settings.json", "{\n  \"foo/bar\": {\n    \"max_wait\": \"2h\",\n  }\n}", "settings.json:4: invalid character '}'"},
+		{"settings.json", "{\n  \"foo/bar\": {\n    \"max_reviewers\": \"two\"\n  }\n}", "settings.json:3: max_reviewers: expected int, not string"},
+		{"settings.json", "{\n  \"foo/bar\": {\n    \"max_wiat\": \"2h\"\n  }\n}", "settings.json:3: max_wiat is not a setting"},
+		{"settings.yml", "foo/baz:\n  verbosity: full\nfoo/bar:\n  max_wait: 2h\n  verbosity: loud\n", `settings.yml:5: foo/bar: verbosity: "loud" is not one of full, terse, silent`},
+		{"settings.yml", "foo/bar:\n  max_wait: 2h\n    train_length: 2\n", "settings.yml: line 3: unexpected indentation"},
+		{"settings.toml", "[\"foo/bar\"]\ntrain_length = -1\nflake_threshold = 2.5\n", "settings.toml:2: foo/bar: train_length: may not be negative\nsettings.toml:3: foo/bar: flake_threshold: may be at most 1"},
+		{"settings.toml", "[\"foo/bar\"]\nobserve = \"yes\"\n", "settings.toml:2: observe: expected bool, not string"},
+	}
+	for _, tc := range cases {
+		_, err := parseSettings(tc.name, []byte(tc.data))
+		if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+			t.Errorf("parseSettings(%s, %q) = %v, expected %s", tc.name, tc.data, err, tc.err)
+		}
+	}
+}
+
+func TestSettingsFromEnv(t *testing.T) {
+	defaults := repoSettings{MaxWait: duration{time.Hour}}
+	env := []string{"PATH=/bin", "MERGEBOT_DEFAULT_MAX_WAIT=2h", "MERGEBOT_DEFAULT_ALLOWED_USERS=[\"alice\"]", "MERGEBOT_DEFAULT_VERBOSITY=terse", "MERGEBOT_DEFAULT_OBSERVE=true", "MERGEBOT_DEFAULT_TRAIN_LENGTH=3"}
+	if err := settingsFromEnv(env, &defaults); err != nil {
+		t.Fatal(err)
+	}
+	if defaults.MaxWait.Duration != 2*time.Hour || !reflect.DeepEqual(defaults.AllowedUsers, []string{"alice"}) || defaults.Verbosity != verbosityTerse || defaults.Observe == nil || !*defaults.Observe || defaults.TrainLength != 3 {
+		t.Errorf("Unexpected defaults %+v", defaults)
+	}
+
+	for _, kv := range []string{"MERGEBOT_DEFAULT_MAX_WIAT=2h", "MERGEBOT_DEFAULT_MAX_WAIT=soon", "MERGEBOT_DEFAULT_TRAIN_LENGTH=three", "MERGEBOT_DEFAULT_VERBOSITY=loud"} {
+		if err := settingsFromEnv([]string{kv}, &repoSettings{}); err == nil {
+			t.Errorf("Expected an error for %s", kv)
+		}
+	}
+}
+
+func TestSettingsSchema(t *testing.T) {
+	// The docs are generated from the comments on repoSettings, which
+	// every setting must have.
+	for key := range settingFields() {
+		if settingDocs[key] == "" {
+			t.Errorf("%s has no documentation; comment it and run go generate", key)
+		}
+	}
+
+	var buf bytes.Buffer
+	defaults := repoSettings{MaxWait: duration{time.Hour}, WebhookSecret: "hush"}
+	if err := writeSettingsSchema(&buf, "json", defaults); err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Defs struct {
+			RepoSettings struct {
+				Properties map[string]struct {
+					Type        interface{}
+					Default     interface{}
+					Enum        []string
+					Maximum     float64
+					Description string
+					RepoFile    bool `json:"x-repo-file"`
+				}
+			}
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	props := schema.Defs.RepoSettings.Properties
+	if p := props["max_wait"]; p.Type != "string" || p.Default != "1h0m0s" || p.Description != settingDocs["max_wait"] {
+		t.Errorf("Unexpected max_wait %+v", p)
+	}
+	if p := props["verbosity"]; !reflect.DeepEqual(p.Enum, []string{"full", "terse", "silent"}) {
+		t.Errorf("Unexpected verbosity %+v", p)
+	}
+	if p := props["flake_threshold"]; p.Type != "number" || p.Maximum != 1 {
+		t.Errorf("Unexpected flake_threshold %+v", p)
+	}
+	if p := props["merge_strategy"]; !p.RepoFile {
+		t.Errorf("Expected merge_strategy to be settable in %s", repoConfigFile)
+	}
+	if strings.Contains(buf.String(), "hush") {
+		t.Error("The webhook secret made it into the schema")
+	}
+
+	buf.Reset()
+	if err := writeSettingsSchema(&buf, "markdown", defaults); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "| `max_wait` | duration | `\"1h0m0s\"` | how long to wait for pending statuses |\n") {
+		t.Errorf("Unexpected markdown:\n%s", buf.String())
+	}
+	if err := writeSettingsSchema(&buf, "xml", defaults); err == nil {
+		t.Error("Expected an unknown format to be refused")
+	}
+}
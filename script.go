@@ -2,15 +2,75 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"strings"
 )
 
+// gitBinary is the git scripts run, by name in the PATH or a path.
+var gitBinary = "git"
+
+// gitBaseEnv is the environment commands run by scripts start from, before
+// gitEnv and their own variables; nil for the environment of the bot.
+var gitBaseEnv []string
+
+// setGitCommand configures the git scripts run: the binary, variables to
+// add to the environment of every command, given as comma separated
+// NAME=VALUE pairs, and with clean, a base environment of just what git
+// needs to find itself, its configuration and SSH keys.
+func setGitCommand(binary, env string, clean bool) error {
+	if binary != "" {
+		if _, err := exec.LookPath(binary); err != nil {
+			return err
+		}
+		gitBinary = binary
+	}
+	for _, kv := range strings.Split(env, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		if strings.Index(kv, "=") <= 0 {
+			return fmt.Errorf("%q is not NAME=VALUE", kv)
+		}
+		gitEnv = append(gitEnv, kv)
+	}
+	if clean {
+		gitBaseEnv = []string{}
+		for _, name := range []string{"PATH", "HOME", "TMPDIR", "SSH_AUTH_SOCK"} {
+			if v, ok := os.LookupEnv(name); ok {
+				gitBaseEnv = append(gitBaseEnv, name+"="+v)
+			}
+		}
+	}
+	return nil
+}
+
+// commandEnv returns the environment of a command with its own variables.
+// Author and committer identities from the bot's environment are left out,
+// so that commits are made as the command says and no other way.
+func commandEnv(env []string) []string {
+	base := gitBaseEnv
+	if base == nil {
+		base = os.Environ()
+	}
+	res := make([]string, 0, len(base)+len(gitEnv)+len(env))
+	for _, kv := range base {
+		if strings.HasPrefix(kv, "GIT_AUTHOR_") || strings.HasPrefix(kv, "GIT_COMMITTER_") {
+			continue
+		}
+		res = append(res, kv)
+	}
+	return append(append(res, gitEnv...), env...)
+}
+
 type script struct {
 	output *bytes.Buffer
 	err    error
+	ctx    context.Context // kills the running command when done, if set
+	dir    string          // working directory of the commands, if not the current one
 }
 
 func newScript() *script {
@@ -19,6 +79,21 @@ func newScript() *script {
 	}
 }
 
+// newScriptContext returns a script whose commands are killed, along with
+// any processes they started, once the context is done.
+func newScriptContext(ctx context.Context) *script {
+	s := newScript()
+	s.ctx = ctx
+	return s
+}
+
+// in makes the commands run in the directory, such as a clone, instead of
+// the current directory, which is shared by everything running at once.
+func (s *script) in(dir string) *script {
+	s.dir = dir
+	return s
+}
+
 func (s *script) Error() error {
 	return s.err
 }
@@ -28,7 +103,7 @@ func (s *script) run(bin string, args ...string) string {
 		return ""
 	}
 
-	cmd := exec.Command(bin, args...)
+	cmd := command(bin, args...)
 	return s.runCmd(cmd)
 }
 
@@ -37,11 +112,32 @@ func (s *script) runPipe(stdin io.Reader, bin string, args ...string) string {
 		return ""
 	}
 
-	cmd := exec.Command(bin, args...)
+	cmd := command(bin, args...)
 	cmd.Stdin = stdin
 	return s.runCmd(cmd)
 }
 
+// runPipeEnv is like runPipe, adding env to the environment of just this
+// command rather than of the whole process.
+func (s *script) runPipeEnv(env []string, stdin io.Reader, bin string, args ...string) string {
+	if s.err != nil {
+		return ""
+	}
+
+	cmd := command(bin, args...)
+	cmd.Stdin = stdin
+	cmd.Env = env
+	return s.runCmd(cmd)
+}
+
+// command returns the command, running the configured git for git.
+func command(bin string, args ...string) *exec.Cmd {
+	if bin == "git" {
+		bin = gitBinary
+	}
+	return exec.Command(bin, args...)
+}
+
 func (s *script) runCmd(cmd *exec.Cmd) string {
 	cmdLine := new(bytes.Buffer)
 	for i, arg := range cmd.Args {
@@ -54,14 +150,51 @@ func (s *script) runCmd(cmd *exec.Cmd) string {
 			cmdLine.WriteString(arg)
 		}
 	}
-	fmt.Fprintln(s.output, "$", cmdLine.String())
+	fmt.Fprintln(s.output, "$", secrets.redact(cmdLine.String()))
 
-	bs, err := cmd.CombinedOutput()
+	cmd.Dir = s.dir
+	cmd.Env = commandEnv(cmd.Env)
+
+	bs, err := s.combinedOutput(cmd)
 	if err != nil {
 		s.err = err
 	}
-	s.output.Write(bs)
+	s.output.WriteString(secrets.redact(string(bs)))
 
 	out := strings.TrimRight(string(bs), " \r\n\t")
 	return out
 }
+
+func (s *script) combinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	if s.ctx == nil {
+		return cmd.CombinedOutput()
+	}
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	cmd.Stdout = buf
+	cmd.Stderr = buf
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	// A hung git may have started ssh or a credential helper holding on to
+	// the output, so the whole process group goes.
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			killProcessGroup(cmd)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+	if ctxErr := s.ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
+	return buf.Bytes(), err
+}
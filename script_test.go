@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScriptContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// The subshell keeps the output open; it must be killed too.
+	s := newScriptContext(ctx)
+	t0 := time.Now()
+	s.run("sh", "-c", "sleep 10 & sleep 10")
+	if s.Error() != context.DeadlineExceeded {
+		t.Error("Expected deadline exceeded, got", s.Error())
+	}
+	if d := time.Since(t0); d > 5*time.Second {
+		t.Error("Command was not killed in time:", d)
+	}
+
+	s = newScriptContext(context.Background())
+	if out := s.run("echo", "hello"); out != "hello" || s.Error() != nil {
+		t.Errorf("Unexpected result %q, %v", out, s.Error())
+	}
+}
+
+func TestScriptDirEnv(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir()}
+	done := make(chan error)
+	for i, dir := range dirs {
+		go func(i int, dir string) {
+			s := newScript().in(dir)
+			s.runPipeEnv([]string{fmt.Sprintf("WHO=%d", i)}, nil, "sh", "-c", `echo "$WHO" > who`)
+			if s.run("cat", "who") != fmt.Sprint(i) {
+				done <- fmt.Errorf("%s: %s", dir, s.output)
+				return
+			}
+			// The variable was for that command only.
+			if out := s.run("sh", "-c", `echo "$WHO"`); out != "" {
+				done <- fmt.Errorf("WHO=%s leaked to the next command", out)
+				return
+			}
+			done <- s.Error()
+		}(i, dir)
+	}
+	for range dirs {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat("who"); err == nil {
+		t.Error("Command ran in the current directory")
+	}
+}
+
+func TestGitCommand(t *testing.T) {
+	defer func(bin string, base, env []string) { gitBinary, gitBaseEnv, gitEnv = bin, base, env }(gitBinary, gitBaseEnv, gitEnv)
+	gitEnv = nil
+	t.Setenv("GIT_AUTHOR_NAME", "Operator")
+	t.Setenv("MERGEBOT_TEST_INHERITED", "yes")
+
+	// The bot's identity never makes it into commands.
+	s := newScript()
+	if out := s.run("sh", "-c", `echo "$GIT_AUTHOR_NAME:$MERGEBOT_TEST_INHERITED"`); out != ":yes" {
+		t.Errorf("Expected just the inherited variable, got %q", out)
+	}
+	if out := s.runPipeEnv([]string{"GIT_AUTHOR_NAME=Alice"}, nil, "sh", "-c", `echo "$GIT_AUTHOR_NAME"`); out != "Alice" {
+		t.Errorf("Expected the command's author, got %q", out)
+	}
+
+	if err := setGitCommand("", "A=1, B=x=y", true); err != nil {
+		t.Fatal(err)
+	}
+	if out := s.run("sh", "-c", `echo "$A $B $MERGEBOT_TEST_INHERITED"`); out != "1 x=y" || s.Error() != nil {
+		t.Errorf("Expected the configured environment only, got %q, %v", out, s.Error())
+	}
+
+	for _, tc := range []struct {
+		binary, env string
+	}{
+		{"no-such-git", ""},
+		{"", "A"},
+		{"", "=1"},
+	} {
+		if err := setGitCommand(tc.binary, tc.env, false); err == nil {
+			t.Errorf("Expected an error for %q, %q", tc.binary, tc.env)
+		}
+	}
+
+	// Scripts run the configured git.
+	fake := filepath.Join(t.TempDir(), "fake-git")
+	if err := ioutil.WriteFile(fake, []byte("#!/bin/sh\necho fake \"$@\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := setGitCommand(fake, "", false); err != nil {
+		t.Fatal(err)
+	}
+	if out := newScript().run("git", "status"); out != "fake status" {
+		t.Errorf("Expected the configured git to run, got %q", out)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// selfTestPoll is how often the self test checks on a merge it's waiting
+// for.
+var selfTestPoll = 5 * time.Second
+
+// A selfTest exercises what a merge takes, end to end, against a sandbox
+// repository: it opens a PR, asks for it to be merged as a comment would,
+// then undoes the merge with a revert PR merged the same way. It runs in
+// this process with the settings given, so the sandbox's webhook must not
+// point at a running bot as well.
+type selfTest struct {
+	h    *handler
+	repo string
+	out  io.Writer
+
+	base     string   // the default branch, merged into
+	before   string   // the head of base before the test
+	path     string   // the file the test PR adds
+	branches []string // pushed by the test, deleted afterwards
+}
+
+// runSelfTest runs the self test on the repository, printing how each step
+// went, and returns whether they all passed.
+func (h *handler) runSelfTest(repo string, out io.Writer) bool {
+	t := &selfTest{h: h, repo: repo, out: out, path: fmt.Sprintf("mergebot-selftest/%d.txt", time.Now().Unix())}
+	defer t.cleanup()
+
+	var number, revert int
+	steps := []struct {
+		name string
+		run  func() (string, error)
+	}{
+		{"open a PR", func() (res string, err error) {
+			number, err = t.openPR()
+			return fmt.Sprintf("#%d", number), err
+		}},
+		{"merge it", func() (string, error) { return t.merge(number) }},
+		{"check it's on the base", func() (string, error) { return "", t.checkFile(true) }},
+		{"revert it", func() (res string, err error) {
+			revert, err = t.revert(number)
+			return fmt.Sprintf("#%d", revert), err
+		}},
+		{"merge the revert", func() (string, error) { return t.merge(revert) }},
+		{"check it's gone from the base", func() (string, error) { return "", t.checkFile(false) }},
+	}
+	for _, step := range steps {
+		detail, err := step.run()
+		if err != nil {
+			fmt.Fprintf(out, "FAIL %s: %v\n", step.name, err)
+			return false
+		}
+		if detail != "" {
+			detail = ": " + detail
+		}
+		fmt.Fprintf(out, "ok   %s%s\n", step.name, detail)
+	}
+	fmt.Fprintln(out, "PASS")
+	return true
+}
+
+// api makes a request against the sandbox repository.
+func (t *selfTest) api(method, path string, in, out interface{}) error {
+	return apiRequest(method, fmt.Sprintf("%s/repos/%s%s", githubAPI, t.repo, path), in, out, t.h.username, t.h.token)
+}
+
+// head returns where the base branch is.
+func (t *selfTest) head() (string, error) {
+	var ref struct {
+		Object struct {
+			SHA string
+		}
+	}
+	err := t.api("GET", "/git/ref/heads/"+t.base, nil, &ref)
+	return ref.Object.SHA, err
+}
+
+// openPR pushes a branch adding a file to the default branch and opens a
+// PR for it, returning its number.
+func (t *selfTest) openPR() (int, error) {
+	var repo struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := t.api("GET", "", nil, &repo); err != nil {
+		return 0, err
+	}
+	t.base = repo.DefaultBranch
+	var err error
+	if t.before, err = t.head(); err != nil {
+		return 0, err
+	}
+
+	branch := fmt.Sprintf("mergebot-selftest-%d", time.Now().Unix())
+	if err := t.api("POST", "/git/refs", map[string]string{"ref": "refs/heads/" + branch, "sha": t.before}, nil); err != nil {
+		return 0, err
+	}
+	t.branches = append(t.branches, branch)
+	file := map[string]string{
+		"message": "Add a self test file",
+		"content": base64.StdEncoding.EncodeToString([]byte("Added by the self test of the merge bot; it's reverted by the end of it.\n")),
+		"branch":  branch,
+	}
+	if err := t.api("PUT", "/contents/"+t.path, file, nil); err != nil {
+		return 0, err
+	}
+
+	var pr struct {
+		Number int
+	}
+	req := map[string]string{
+		"title": "Self test of the merge bot",
+		"head":  branch,
+		"base":  t.base,
+		"body":  "This PR is merged and reverted again by `mergebot selftest`.",
+	}
+	err = t.api("POST", "/pulls", req, &pr)
+	return pr.Number, err
+}
+
+// merge comments asking the bot to merge the PR and runs the command as the
+// webhook would, waiting for it to finish, and returns where the base is
+// after.
+func (t *selfTest) merge(number int) (string, error) {
+	c, err := t.comment(number, fmt.Sprintf("@%s merge", t.h.username))
+	if err != nil {
+		return "", err
+	}
+	t.h.rerun(c)
+	for t.h.isPending(c) {
+		time.Sleep(selfTestPoll)
+	}
+
+	var pr struct {
+		State string
+	}
+	if err := t.api("GET", fmt.Sprintf("/pulls/%d", number), nil, &pr); err != nil {
+		return "", err
+	}
+	if pr.State != "closed" {
+		return "", fmt.Errorf("not merged; the bot said: %s", t.lastComment(c))
+	}
+	head, err := t.head()
+	if err == nil && head == t.before {
+		err = fmt.Errorf("closed, but %s didn't move", t.base)
+	}
+	return "as " + head, err
+}
+
+// comment posts the body on the PR, returning it as the webhook would get
+// it.
+func (t *selfTest) comment(number int, body string) (comment, error) {
+	var c comment
+	if err := t.api("POST", fmt.Sprintf("/issues/%d/comments", number), map[string]string{"body": body}, &c.Comment); err != nil {
+		return c, err
+	}
+	if err := t.api("GET", fmt.Sprintf("/issues/%d", number), nil, &c.Issue); err != nil {
+		return c, err
+	}
+	c.Action = "created"
+	c.Repository.FullName = t.repo
+	c.Sender.Login = c.Comment.User.Login
+	return c, nil
+}
+
+// lastComment returns the latest comment on the PR of c, which after a
+// failed merge is the bot's saying why.
+func (t *selfTest) lastComment(c comment) string {
+	var comments []struct {
+		Body string
+	}
+	if err := apiRequest("GET", c.Issue.CommentsURL+"?per_page=100", nil, &comments, t.h.username, t.h.token); err != nil || len(comments) == 0 {
+		return "nothing"
+	}
+	return strings.TrimSpace(comments[len(comments)-1].Body)
+}
+
+// revert opens a PR reverting the merge of the PR, as proposed when a merge
+// breaks the build, returning its number.
+func (t *selfTest) revert(number int) (int, error) {
+	c, err := t.comment(number, "Reverting this merge as the self test goes on.")
+	if err != nil {
+		return 0, err
+	}
+	pr, err := c.getPR()
+	if err != nil {
+		return 0, err
+	}
+	merged, err := t.head()
+	if err != nil {
+		return 0, err
+	}
+	u, err := t.h.revertPR(c, pr, t.before, merged, []string{"the self test, which reverts what it merged"})
+	if err != nil {
+		return 0, err
+	}
+	t.branches = append(t.branches, fmt.Sprintf("revert-%d", number))
+	return strconv.Atoi(u[strings.LastIndex(u, "/")+1:])
+}
+
+// checkFile returns an error unless the file the test PR adds is on the
+// base branch, or isn't if it shouldn't be.
+func (t *selfTest) checkFile(want bool) error {
+	err := t.api("GET", fmt.Sprintf("/contents/%s?ref=%s", t.path, t.base), nil, nil)
+	switch {
+	case want && err != nil:
+		return fmt.Errorf("%s isn't on %s: %v", t.path, t.base, err)
+	case !want && err == nil:
+		return fmt.Errorf("%s is still on %s", t.path, t.base)
+	case !want && !isNotFound(err):
+		return err
+	}
+	return nil
+}
+
+// cleanup deletes the branches the test pushed.
+func (t *selfTest) cleanup() {
+	for _, branch := range t.branches {
+		if err := t.api("DELETE", "/git/refs/heads/"+branch, nil, nil); err != nil && !isNotFound(err) {
+			fmt.Fprintf(t.out, "Deleting branch %s: %v\n", branch, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSelfTestMergeRefused(t *testing.T) {
+	var mut sync.Mutex
+	var requests, comments []string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		defer mut.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/repos/foo/sandbox/contents/") || r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/repos/foo/sandbox/git/refs/heads/") {
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /repos/foo/sandbox":
+			fmt.Fprint(w, `{"default_branch": "main"}`)
+		case "GET /repos/foo/sandbox/git/ref/heads/main":
+			fmt.Fprint(w, `{"object": {"sha": "abc123"}}`)
+		case "POST /repos/foo/sandbox/git/refs":
+		case "POST /repos/foo/sandbox/pulls":
+			fmt.Fprint(w, `{"number": 7}`)
+		case "POST /repos/foo/sandbox/issues/7/comments":
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			comments = append(comments, body.Body)
+			fmt.Fprintf(w, `{"id": %d, "body": %q, "user": {"login": "bot"}}`, len(comments), body.Body)
+		case "GET /repos/foo/sandbox/issues/7":
+			fmt.Fprintf(w, `{"number": 7, "comments_url": "%s/repos/foo/sandbox/issues/7/comments", "pull_request": {"url": "%s/repos/foo/sandbox/pulls/7"}}`, srv.URL, srv.URL)
+		case "GET /repos/foo/sandbox/issues/7/comments":
+			var res []map[string]string
+			for _, c := range comments {
+				res = append(res, map[string]string{"body": c})
+			}
+			json.NewEncoder(w).Encode(res)
+		case "GET /repos/foo/sandbox/pulls/7":
+			fmt.Fprint(w, `{"state": "open"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	// The token's user isn't allowed to merge in the sandbox.
+	h := newHandler(nil, "bot", "token", false)
+	var out bytes.Buffer
+	if h.runSelfTest("foo/sandbox", &out) {
+		t.Fatalf("Expected the self test to fail, got:\n%s", out.String())
+	}
+	want := "ok   open a PR: #7\nFAIL merge it: not merged; the bot said: :hand: I'm sorry, @bot. I'm afraid I can't do that.\n"
+	if got := out.String(); got != want {
+		t.Errorf("Expected output\n%s\ngot\n%s", want, got)
+	}
+	if len(comments) != 2 || comments[0] != "@bot merge" {
+		t.Errorf("Unexpected comments %q", comments)
+	}
+	if last := requests[len(requests)-1]; !strings.HasPrefix(last, "DELETE /repos/foo/sandbox/git/refs/heads/mergebot-selftest-") {
+		t.Errorf("Expected the branch to be deleted, last request was %s", last)
+	}
+}
@@ -0,0 +1,66 @@
+// Code generated by gensettingdocs.go from the comments on repoSettings; DO NOT EDIT.
+
+package main
+
+// settingDocs describes the settings, by key.
+var settingDocs = map[string]string{
+	"forge":                 "\"github\" (the default), \"gitlab\" or \"gitea\", which need CloneURL set",
+	"clone_url":             "template expanding {repo}, {owner} and {name}",
+	"status_url":            "internal status source expanding {repo}, {number} and {sha}",
+	"ci_sources":            "external CI systems consulted in addition to the statuses",
+	"base_branches":         "patterns of the branches PRs may be merged into; any if unset",
+	"required_statuses":     "contexts that must report success, even before they've reported",
+	"allowed_users":         "who may merge, besides the collaborators",
+	"repo_config":           "read settings from .mergebot.yml in the repository",
+	"path_contexts":         "contexts required by changed paths",
+	"max_wait":              "how long to wait for pending statuses",
+	"max_poll":              "the longest interval between status polls",
+	"max_wait_cap":          "how far MaxWait may be extended while CI progresses",
+	"disk_quota":            "largest repository to clone, like \"2G\"",
+	"sparse_checkout":       "check out only the files PRs touch, for monorepos",
+	"webhook_secret":        "verifies webhook deliveries, instead of -secret",
+	"merge_timeout":         "how long a merge may take before it's abandoned",
+	"progress_interval":     "how often to report the phase of a slow merge; unset for never",
+	"flaky_checks":          "failed checks to retry before giving up",
+	"flake_threshold":       "flake rate above which a check is retried regardless of pattern",
+	"failure_grace":         "context, or \"*\" for any -> how long a failure may take to recover",
+	"max_base_drift":        "commits the base may gain after branching before CI must run again",
+	"merge_strategy":        "\"squash\" (the default), \"rebase\" or \"merge\" for \"merge\" commands",
+	"observe":               "say what would be merged without ever pushing, to trial the bot",
+	"merge_api":             "squash through GitHub's merge API rather than pushing, for protected branches the bot may not push to",
+	"merge_train":           "validate queued merges speculatively in trains",
+	"train_length":          "how many PRs to validate at once",
+	"merge_queue":           "land merges one at a time in the order asked for, each validated on top of the one before; only squashes",
+	"concurrency_group":     "repositories in the same group, such as those sharing a deployment pipeline, land one merge at a time",
+	"required_approvals":    "approvals to wait for on \"merge when approved\", and to require with RequireReviews",
+	"require_reviews":       "refuse merges without the required approvals or with changes requested",
+	"approval_max_age":      "approvals older than this don't count",
+	"fresh_approvals":       "approvals given before the latest push don't count",
+	"verbosity":             "\"full\" (the default), \"terse\" or \"silent\" responses",
+	"wrap_width":            "width of commit message bodies given in merge comments",
+	"message_mode":          "\"reflow\" (the default) or \"verbatim\" for messages given in merge comments",
+	"area_subjects":         "derive the subject from the PR title, prefixed by the area of the changes",
+	"change_gate":           "change tickets required for some branches",
+	"deployment_gate":       "deployments required before merging into some branches",
+	"dependency_gate":       "licenses and vulnerabilities refused in added dependencies",
+	"artifacts":             "builds of merges to report on the PRs",
+	"watch_build":           "how long to watch merges for breaking the build of their base; unset for not at all",
+	"propose_revert":        "open a PR reverting a merge that broke the watched build, where it passed just before",
+	"track_failures":        "open an issue, assigned to the requester, when a merge fails on git or is denied",
+	"policy":                "Rego file whose data.mergebot.deny rules gate merges",
+	"size_labels":           "applied by number of changed lines",
+	"area_labels":           "label -> path prefixes",
+	"suggest_reviewers":     "\"history\", \"codeowners\" or empty for none",
+	"max_reviewers":         "how many reviewers to suggest at most, 2 if unset",
+	"away_reviewers":        "\"skip\" to not request reviews from users who are away, \"substitute\" to request them from their backups",
+	"features":              "feature or command name -> enabled",
+	"milestone_summary":     "open an issue listing the merges when a milestone is closed",
+	"versioning":            "\"file\" to bump VersionFile, \"tag\" to report the next tag, by PR labels",
+	"version_file":          "the file holding the version, VERSION if unset",
+	"version_labels":        "label -> \"major\", \"minor\" or \"patch\"",
+	"release_notes_file":    "where release notes are added, RELEASE-NOTES.md if unset",
+	"release_note_sections": "by label, in order",
+	"greet":                 "welcome first time contributors",
+	"greeting":              "template for the welcome comment",
+	"required_checks":       "mentioned in the welcome comment",
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:generate go run gensettingdocs.go
+
+// repoSettings are the settings that may be given per repository. Zero
+// valued fields are unset and inherit the value from the level above; the
+// levels are the global defaults, "owner/*" and "owner/name".
+type repoSettings struct {
+	Forge     string     `json:"forge"`      // "github" (the default), "gitlab" or "gitea", which need CloneURL set
+	CloneURL  string     `json:"clone_url"`  // template expanding {repo}, {owner} and {name}
+	StatusURL string     `json:"status_url"` // internal status source expanding {repo}, {number} and {sha}
+	CISources []ciSource `json:"ci_sources"` // external CI systems consulted in addition to the statuses
+
+	BaseBranches []string `json:"base_branches"` // patterns of the branches PRs may be merged into; any if unset
+
+	RequiredStatuses []string `json:"required_statuses"` // contexts that must report success, even before they've reported
+	AllowedUsers     []string `json:"allowed_users"`     // who may merge, besides the collaborators
+	RepoConfig       *bool    `json:"repo_config"`       // read settings from .mergebot.yml in the repository
+
+	PathContexts []pathRule `json:"path_contexts"` // contexts required by changed paths
+
+	MaxWait    duration `json:"max_wait"`     // how long to wait for pending statuses
+	MaxPoll    duration `json:"max_poll"`     // the longest interval between status polls
+	MaxWaitCap duration `json:"max_wait_cap"` // how far MaxWait may be extended while CI progresses
+
+	DiskQuota      byteSize `json:"disk_quota"`      // largest repository to clone, like "2G"
+	SparseCheckout *bool    `json:"sparse_checkout"` // check out only the files PRs touch, for monorepos
+
+	WebhookSecret string `json:"webhook_secret"` // verifies webhook deliveries, instead of -secret
+
+	MergeTimeout     duration `json:"merge_timeout"`     // how long a merge may take before it's abandoned
+	ProgressInterval duration `json:"progress_interval"` // how often to report the phase of a slow merge; unset for never
+
+	FlakyChecks    []flakyRule `json:"flaky_checks"`    // failed checks to retry before giving up
+	FlakeThreshold float64     `json:"flake_threshold"` // flake rate above which a check is retried regardless of pattern
+
+	FailureGrace map[string]duration `json:"failure_grace"` // context, or "*" for any -> how long a failure may take to recover
+
+	MaxBaseDrift int `json:"max_base_drift"` // commits the base may gain after branching before CI must run again
+
+	MergeStrategy string `json:"merge_strategy"` // "squash" (the default), "rebase" or "merge" for "merge" commands
+	Observe       *bool  `json:"observe"`        // say what would be merged without ever pushing, to trial the bot
+	MergeAPI      *bool  `json:"merge_api"`      // squash through GitHub's merge API rather than pushing, for protected branches the bot may not push to
+
+	MergeTrain  *bool `json:"merge_train"`  // validate queued merges speculatively in trains
+	TrainLength int   `json:"train_length"` // how many PRs to validate at once
+	MergeQueue  *bool `json:"merge_queue"`  // land merges one at a time in the order asked for, each validated on top of the one before; only squashes
+
+	ConcurrencyGroup string `json:"concurrency_group"` // repositories in the same group, such as those sharing a deployment pipeline, land one merge at a time
+
+	RequiredApprovals int      `json:"required_approvals"` // approvals to wait for on "merge when approved", and to require with RequireReviews
+	RequireReviews    *bool    `json:"require_reviews"`    // refuse merges without the required approvals or with changes requested
+	ApprovalMaxAge    duration `json:"approval_max_age"`   // approvals older than this don't count
+	FreshApprovals    *bool    `json:"fresh_approvals"`    // approvals given before the latest push don't count
+
+	Verbosity string `json:"verbosity"` // "full" (the default), "terse" or "silent" responses
+
+	WrapWidth    int    `json:"wrap_width"`    // width of commit message bodies given in merge comments
+	MessageMode  string `json:"message_mode"`  // "reflow" (the default) or "verbatim" for messages given in merge comments
+	AreaSubjects *bool  `json:"area_subjects"` // derive the subject from the PR title, prefixed by the area of the changes
+
+	ChangeGate     *changeGate      `json:"change_gate"`     // change tickets required for some branches
+	DeploymentGate *deploymentGate  `json:"deployment_gate"` // deployments required before merging into some branches
+	DependencyGate *dependencyGate  `json:"dependency_gate"` // licenses and vulnerabilities refused in added dependencies
+	Artifacts      *artifactHandoff `json:"artifacts"`       // builds of merges to report on the PRs
+	WatchBuild     duration         `json:"watch_build"`     // how long to watch merges for breaking the build of their base; unset for not at all
+	ProposeRevert  *bool            `json:"propose_revert"`  // open a PR reverting a merge that broke the watched build, where it passed just before
+	TrackFailures  *bool            `json:"track_failures"`  // open an issue, assigned to the requester, when a merge fails on git or is denied
+	Policy         string           // Rego file whose data.mergebot.deny rules gate merges
+
+	SizeLabels []sizeLabel         `json:"size_labels"` // applied by number of changed lines
+	AreaLabels map[string][]string `json:"area_labels"` // label -> path prefixes
+
+	SuggestReviewers string `json:"suggest_reviewers"` // "history", "codeowners" or empty for none
+	MaxReviewers     int    `json:"max_reviewers"`     // how many reviewers to suggest at most, 2 if unset
+	AwayReviewers    string `json:"away_reviewers"`    // "skip" to not request reviews from users who are away, "substitute" to request them from their backups
+
+	Features map[string]bool // feature or command name -> enabled
+
+	MilestoneSummary *bool `json:"milestone_summary"` // open an issue listing the merges when a milestone is closed
+
+	Versioning    string            // "file" to bump VersionFile, "tag" to report the next tag, by PR labels
+	VersionFile   string            `json:"version_file"`   // the file holding the version, VERSION if unset
+	VersionLabels map[string]string `json:"version_labels"` // label -> "major", "minor" or "patch"
+
+	ReleaseNotesFile    string               `json:"release_notes_file"`    // where release notes are added, RELEASE-NOTES.md if unset
+	ReleaseNoteSections []releaseNoteSection `json:"release_note_sections"` // by label, in order
+
+	Greet          *bool    // welcome first time contributors
+	Greeting       string   // template for the welcome comment
+	RequiredChecks []string `json:"required_checks"` // mentioned in the welcome comment
+}
+
+// duration is a time.Duration that is JSON encoded as a string like "3h".
+type duration struct {
+	time.Duration
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *duration) UnmarshalJSON(bs []byte) error {
+	var s string
+	if err := json.Unmarshal(bs, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// settings holds the global defaults and the per repository overrides.
+type settings struct {
+	defaults repoSettings
+	repos    map[string]repoSettings // "owner/name" or "owner/*"
+	files    map[string]repoSettings // "owner/name" -> from the repository's configuration file
+	mut      sync.RWMutex
+}
+
+// loadSettings reads the per repository overrides from the given JSON, YAML
+// or TOML file, which contains an object keyed by repository name.
+func loadSettings(path string, defaults repoSettings) (*settings, error) {
+	s := &settings{defaults: defaults, repos: make(map[string]repoSettings)}
+	if path == "" {
+		return s, nil
+	}
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if s.repos, err = parseSettings(path, bs); err != nil {
+		return nil, err
+	}
+	redactSettings(s.repos)
+	return s, nil
+}
+
+// redactSettings registers the secrets in the settings to be masked.
+func redactSettings(repos map[string]repoSettings) {
+	for _, rs := range repos {
+		secrets.add(rs.WebhookSecret)
+	}
+}
+
+// replace sets new per repository overrides.
+func (s *settings) replace(repos map[string]repoSettings) {
+	redactSettings(repos)
+	s.mut.Lock()
+	s.repos = repos
+	s.mut.Unlock()
+}
+
+// setRepoFile sets the settings from the configuration file of the
+// repository, which apply over "owner/*" but under "owner/name".
+func (s *settings) setRepoFile(repo string, rs repoSettings) {
+	s.mut.Lock()
+	if s.files == nil {
+		s.files = make(map[string]repoSettings)
+	}
+	s.files[repo] = rs
+	s.mut.Unlock()
+}
+
+// feature returns the most specific setting for the feature, if any.
+func (s *settings) feature(repo, feature string) (bool, bool) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	for _, scope := range repoScopes(repo) {
+		if v, ok := s.repos[scope].Features[feature]; ok {
+			return v, true
+		}
+		// The repository's file ranks between its own settings and its
+		// owner's, as in forRepo.
+		if v, ok := s.files[repo].Features[feature]; ok && scope == repo {
+			return v, true
+		}
+	}
+	v, ok := s.defaults.Features[feature]
+	return v, ok
+}
+
+// forRepo returns the effective settings for the given repository.
+func (s *settings) forRepo(repo string) repoSettings {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	res := s.defaults
+	if idx := strings.Index(repo, "/"); idx > 0 {
+		overlay(&res, s.repos[repo[:idx]+"/*"])
+	}
+	// The repository's file may require more statuses, but never fewer
+	// than the operator does.
+	file := s.files[repo]
+	required := file.RequiredStatuses
+	file.RequiredStatuses = nil
+	overlay(&res, file)
+	res.RequiredStatuses = appendMissing(res.RequiredStatuses, required)
+	overlay(&res, s.repos[repo])
+	return res
+}
+
+// appendMissing returns a new list of the items followed by those of more
+// that it doesn't already have.
+func appendMissing(items, more []string) []string {
+	if len(more) == 0 {
+		return items
+	}
+	res := stringset(append([]string(nil), items...))
+	for _, item := range more {
+		res = res.add(item)
+	}
+	return res
+}
+
+// overlay sets every field in dst for which over has a non zero value.
+func overlay(dst *repoSettings, over repoSettings) {
+	dv := reflect.ValueOf(dst).Elem()
+	ov := reflect.ValueOf(over)
+	for i := 0; i < ov.NumField(); i++ {
+		if f := ov.Field(i); !f.IsZero() {
+			dv.Field(i).Set(f)
+		}
+	}
+}
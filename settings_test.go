@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSettingsForRepo(t *testing.T) {
+	s := &settings{
+		defaults: repoSettings{MaxWait: duration{30 * time.Minute}, MaxPoll: duration{time.Minute}},
+		repos: map[string]repoSettings{
+			"foo/*":   {MaxWait: duration{time.Hour}},
+			"foo/bar": {MaxWait: duration{3 * time.Hour}},
+		},
+	}
+
+	cases := []struct {
+		repo string
+		wait time.Duration
+	}{
+		{"other/repo", 30 * time.Minute},
+		{"foo/baz", time.Hour},
+		{"foo/bar", 3 * time.Hour},
+	}
+	for _, tc := range cases {
+		rs := s.forRepo(tc.repo)
+		if rs.MaxWait.Duration != tc.wait || rs.MaxPoll.Duration != time.Minute {
+			t.Errorf("Unexpected settings %+v for %s", rs, tc.repo)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// deliveryRepo returns the repository an event is about, so that it can be
+// checked against that repository's secret. The body isn't trusted yet;
+// a forged name only gets the delivery checked against another secret.
+func deliveryRepo(body []byte) string {
+	var ev struct {
+		Repository struct {
+			FullName string `json:"full_name"`
+		}
+	}
+	json.Unmarshal(body, &ev)
+	return ev.Repository.FullName
+}
+
+// verifySignature checks the HMAC of the body GitHub signs deliveries
+// with: SHA-256 in X-Hub-Signature-256, or SHA-1 in X-Hub-Signature from
+// GitHub Enterprise versions that don't send the former. Without a secret
+// nothing verifies.
+func verifySignature(body []byte, secret string, header http.Header) bool {
+	if secret == "" {
+		return false
+	}
+	if sig := header.Get("X-Hub-Signature-256"); sig != "" {
+		return checkMAC(body, secret, sig, "sha256=", sha256.New)
+	}
+	if sig := header.Get("X-Hub-Signature"); sig != "" {
+		return checkMAC(body, secret, sig, "sha1=", sha1.New)
+	}
+	return false
+}
+
+func checkMAC(body []byte, secret, sig, prefix string, h func() hash.Hash) bool {
+	if !strings.HasPrefix(sig, prefix) {
+		return false
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(sig, prefix))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
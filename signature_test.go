@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"repository": {"full_name": "foo/bar"}}`)
+	sign := func(secret string) (string, string) {
+		m256 := hmac.New(sha256.New, []byte(secret))
+		m256.Write(body)
+		m1 := hmac.New(sha1.New, []byte(secret))
+		m1.Write(body)
+		return fmt.Sprintf("sha256=%x", m256.Sum(nil)), fmt.Sprintf("sha1=%x", m1.Sum(nil))
+	}
+	good256, good1 := sign("secret")
+	bad256, bad1 := sign("wrong")
+
+	tests := []struct {
+		secret     string
+		sig256, s1 string
+		ok         bool
+	}{
+		{"secret", good256, good1, true},
+		{"secret", good256, "", true},
+		{"secret", "", good1, true}, // older GitHub Enterprise
+		{"secret", bad256, good1, false},
+		{"secret", "", bad1, false},
+		{"secret", "", "", false},
+		{"secret", good1, "", false},
+		{"", "", "", false},
+		{"", good256, good1, false},
+	}
+	for i, test := range tests {
+		header := make(http.Header)
+		if test.sig256 != "" {
+			header.Set("X-Hub-Signature-256", test.sig256)
+		}
+		if test.s1 != "" {
+			header.Set("X-Hub-Signature", test.s1)
+		}
+		if ok := verifySignature(body, test.secret, header); ok != test.ok {
+			t.Errorf("%d: verifySignature = %v; want %v", i, ok, test.ok)
+		}
+	}
+
+	if repo := deliveryRepo(body); repo != "foo/bar" {
+		t.Errorf("deliveryRepo = %q", repo)
+	}
+}
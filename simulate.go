@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"time"
+)
+
+// A simulatedDecision compares the logged decision on a past merge request
+// with the decision of a proposed policy.
+type simulatedDecision struct {
+	Time    time.Time `json:"time"`
+	Repo    string    `json:"repo"`
+	PR      int       `json:"pr"`
+	Before  []string  `json:"before"` // deny reasons at the time
+	After   []string  `json:"after"`  // deny reasons with the proposed policy
+	Outcome string    `json:"outcome"`
+}
+
+// A simulation reports how a proposed policy would have decided the merge
+// requests in the decision log.
+type simulation struct {
+	Evaluated int                 `json:"evaluated"`
+	Unchanged int                 `json:"unchanged"`
+	Changed   []simulatedDecision `json:"changed"`
+	Errors    []string            `json:"errors,omitempty"`
+}
+
+// readPolicyDecisions returns the logged decisions for which keep returns
+// true, oldest first.
+func readPolicyDecisions(keep func(policyDecision) bool) ([]policyDecision, error) {
+	policyLogMut.Lock()
+	defer policyLogMut.Unlock()
+
+	var res []policyDecision
+	err := readStateLines(policyLogName, func(line []byte) {
+		var d policyDecision
+		if err := json.Unmarshal(line, &d); err != nil {
+			return
+		}
+		if keep == nil || keep(d) {
+			res = append(res, d)
+		}
+	})
+	return res, err
+}
+
+// outcome describes the change from the old to the new deny reasons.
+func outcome(before, after []string) string {
+	switch {
+	case len(before) == 0 && len(after) == 0:
+		return "unchanged"
+	case len(before) == 0:
+		return "newly blocked"
+	case len(after) == 0:
+		return "newly allowed"
+	case reflect.DeepEqual(before, after):
+		return "unchanged"
+	default:
+		return "blocked for other reasons"
+	}
+}
+
+// simulate evaluates the policy file against the logged decisions.
+func simulate(policy string, decisions []policyDecision) simulation {
+	var sim simulation
+	for _, d := range decisions {
+		after, err := evaluatePolicy(policy, d.Input)
+		if err != nil {
+			sim.Errors = append(sim.Errors, err.Error())
+			continue
+		}
+		sim.Evaluated++
+		o := outcome(d.Deny, after)
+		if o == "unchanged" {
+			sim.Unchanged++
+			continue
+		}
+		sim.Changed = append(sim.Changed, simulatedDecision{
+			Time:    d.Time,
+			Repo:    d.Input.Repo,
+			PR:      d.Input.PR,
+			Before:  d.Deny,
+			After:   after,
+			Outcome: o,
+		})
+	}
+	return sim
+}
+
+// serveSimulate answers POST /admin/simulate?window=720h&repo=owner/name,
+// with the proposed Rego policy as the request body.
+func (a *adminAPI) serveSimulate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST Expected", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+
+	since := time.Time{}
+	if v := q.Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		since = time.Now().Add(-d)
+	}
+	repo := q.Get("repo")
+
+	bs, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fd, err := ioutil.TempFile("", "policy-*.rego")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(fd.Name())
+	_, err = fd.Write(bs)
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	decisions, err := readPolicyDecisions(func(d policyDecision) bool {
+		return !d.Time.Before(since) && (repo == "" || d.Input.Repo == repo)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(simulate(fd.Name(), decisions))
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestSimulationOutcome(t *testing.T) {
+	cases := []struct {
+		before, after []string
+		outcome       string
+	}{
+		{nil, nil, "unchanged"},
+		{[]string{}, nil, "unchanged"},
+		{[]string{"frozen"}, []string{"frozen"}, "unchanged"},
+		{nil, []string{"frozen"}, "newly blocked"},
+		{[]string{"frozen"}, nil, "newly allowed"},
+		{[]string{"frozen"}, []string{"needs approval"}, "blocked for other reasons"},
+	}
+	for _, tc := range cases {
+		if o := outcome(tc.before, tc.after); o != tc.outcome {
+			t.Errorf("outcome(%q, %q) = %q, expected %q", tc.before, tc.after, o, tc.outcome)
+		}
+	}
+}
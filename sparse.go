@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// sparsePattern returns the sparse checkout pattern matching just the file.
+func sparsePattern(file string) string {
+	r := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`)
+	return "/" + r.Replace(file)
+}
+
+// sparseFor returns the sparse checkout patterns for working on the PRs in
+// the repository: the files they change, and those we read or write
+// ourselves. It returns nil, for a full checkout, unless the repository has
+// sparse checkouts enabled or if the changed files can't be listed.
+func (h *handler) sparseFor(repo string, prs ...pr) []string {
+	rs := h.settings.forRepo(repo)
+	if rs.SparseCheckout == nil || !*rs.SparseCheckout {
+		return nil
+	}
+
+	files := append([]string(nil), codeownersLocations...)
+	versionFile := rs.VersionFile
+	if versionFile == "" {
+		versionFile = defaultVersionFile
+	}
+	notesFile := rs.ReleaseNotesFile
+	if notesFile == "" {
+		notesFile = defaultReleaseNotesFile
+	}
+	files = append(files, versionFile, notesFile)
+	for _, p := range prs {
+		changed, err := p.getFiles(h.username, h.token)
+		if err != nil {
+			log.Println("Getting PR files for a sparse checkout:", err)
+			return nil
+		}
+		for _, f := range changed {
+			files = append(files, f.Filename)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var res []string
+	for _, f := range files {
+		if !seen[f] {
+			seen[f] = true
+			res = append(res, sparsePattern(f))
+		}
+	}
+	return res
+}
+
+// setSparse limits the worktree of the clone the script runs in to the
+// patterns, or checks out everything again if there are none. Git still
+// writes out any file a merge conflicts on, so the squash sees conflicts
+// outside the patterns too.
+func setSparse(s *script, patterns []string) {
+	if len(patterns) == 0 {
+		t := newScriptContext(s.ctx).in(s.dir)
+		if t.run("git", "config", "--bool", "core.sparseCheckout") == "true" {
+			s.run("git", "sparse-checkout", "disable")
+		}
+		return
+	}
+	s.runPipe(strings.NewReader(strings.Join(patterns, "\n")+"\n"), "git", "sparse-checkout", "set", "--no-cone", "--stdin")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSparsePattern(t *testing.T) {
+	tests := map[string]string{
+		"main.go":          "/main.go",
+		"a/b/c.txt":        "/a/b/c.txt",
+		"!important":       "/!important",
+		"#notes":           "/#notes",
+		"what?[1]*":        `/what\?\[1]\*`,
+		`back\slash`:       `/back\\slash`,
+		"docs/CODEOWNERS":  "/docs/CODEOWNERS",
+		"with space/x y.z": "/with space/x y.z",
+	}
+	for in, want := range tests {
+		if got := sparsePattern(in); got != want {
+			t.Errorf("sparsePattern(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
+
+func TestSetSparse(t *testing.T) {
+	dir := t.TempDir()
+	s := newScript().in(dir)
+	git := func(args ...string) string {
+		return s.run("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	}
+	git("init", "-q", "-b", "main")
+	for _, f := range []string{"a/one.txt", "b/two.txt", "what?.txt"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(f)), 0755)
+		ioutil.WriteFile(filepath.Join(dir, f), []byte(f), 0644)
+	}
+	git("add", ".")
+	git("commit", "-q", "-m", "Initial")
+
+	exists := func(f string) bool {
+		_, err := os.Stat(filepath.Join(dir, f))
+		return err == nil
+	}
+	setSparse(s, []string{sparsePattern("a/one.txt"), sparsePattern("what?.txt")})
+	if s.Error() != nil {
+		t.Fatal(s.output.String())
+	}
+	if !exists("a/one.txt") || !exists("what?.txt") || exists("b/two.txt") {
+		t.Error("Unexpected sparse checkout:", s.run("git", "ls-files", "-t"))
+	}
+
+	setSparse(s, nil)
+	if s.Error() != nil {
+		t.Fatal(s.output.String())
+	}
+	if !exists("b/two.txt") {
+		t.Error("Sparse checkout not disabled")
+	}
+}
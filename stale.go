@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// staleness decides when a pending status has been pending for so long that
+// whatever was supposed to complete it has probably died.
+type staleness struct {
+	thresholds map[string]time.Duration // context name, or "*" for any context
+	ignore     bool                     // ignore stale statuses instead of failing them
+}
+
+func (s staleness) threshold(context string) time.Duration {
+	if d, ok := s.thresholds[context]; ok {
+		return d
+	}
+	return s.thresholds["*"]
+}
+
+// apply returns the statuses with stale pending ones either failed or
+// removed, and a note explaining each such decision.
+func (s staleness) apply(ss []status, now time.Time) ([]status, []string) {
+	var res []status
+	var notes []string
+	for _, st := range ss {
+		limit := s.threshold(st.Context)
+		if st.State != statePending || limit <= 0 || st.UpdatedAt.IsZero() {
+			res = append(res, st)
+			continue
+		}
+
+		age := now.Sub(st.UpdatedAt)
+		if age < limit {
+			res = append(res, st)
+			continue
+		}
+
+		age = age.Truncate(time.Minute)
+		if s.ignore {
+			notes = append(notes, fmt.Sprintf("`%s` has been pending for %v (more than %v) and was ignored as stale.", st.Context, age, limit))
+			continue
+		}
+		notes = append(notes, fmt.Sprintf("`%s` has been pending for %v (more than %v) and was considered failed as stale.", st.Context, age, limit))
+		st.State = stateFailure
+		res = append(res, st)
+	}
+	return res, notes
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStalenessApply(t *testing.T) {
+	now := time.Now()
+	ss := []status{
+		{State: statePending, Context: "slow", UpdatedAt: now.Add(-3 * time.Hour)},
+		{State: statePending, Context: "fast", UpdatedAt: now.Add(-3 * time.Hour)},
+		{State: stateSuccess, Context: "done", UpdatedAt: now.Add(-3 * time.Hour)},
+	}
+
+	s := staleness{thresholds: map[string]time.Duration{"*": time.Hour, "slow": 4 * time.Hour}}
+	res, notes := s.apply(ss, now)
+	if len(res) != 3 || res[0].State != statePending || res[1].State != stateFailure || res[2].State != stateSuccess {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	if len(notes) != 1 {
+		t.Errorf("Expected one note, not %q", notes)
+	}
+
+	s.ignore = true
+	res, notes = s.apply(ss, now)
+	if len(res) != 2 || res[1].Context != "done" || len(notes) != 1 {
+		t.Errorf("Unexpected result %+v, %q", res, notes)
+	}
+}
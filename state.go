@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// stateDir is where we keep state that should survive a restart, with the
+// file store, and the state that's always files, such as clones of
+// configuration and logs of long output.
+var stateDir = "state"
+
+// store keeps the state that should survive a restart.
+var store stateStore = fileStore{}
+
+// loadState decodes the named JSON state into v. Missing state leaves v
+// untouched and is not an error.
+func loadState(name string, v interface{}) error {
+	bs, err := store.read(name)
+	if err != nil || bs == nil {
+		return err
+	}
+	return json.Unmarshal(bs, v)
+}
+
+// saveState atomically replaces the named JSON state with v.
+func saveState(name string, v interface{}) error {
+	bs, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.write(name, bs)
+}
+
+// appendState appends v as a line of JSON to the named state. Callers
+// serialize access to it themselves.
+func appendState(name string, v interface{}) error {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return store.appendLine(name, bs, false)
+}
+
+// appendSynced appends like appendState, but only returns once the line
+// is durable, for logs read back after a crash.
+func appendSynced(name string, v interface{}) error {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return store.appendLine(name, bs, true)
+}
+
+// readStateLines calls fn for every line of the named state. Missing state
+// has no lines.
+func readStateLines(name string, fn func([]byte)) error {
+	return store.readLines(name, fn)
+}
+
+// removeState removes the named state. Missing state is not an error.
+func removeState(name string) error {
+	return store.remove(name)
+}
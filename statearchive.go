@@ -0,0 +1,251 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The state archive is a gzipped tar of the state directory, for moving the
+// bot to another host: the pending merges, runtime overrides, the cached
+// configuration and the merge, policy and attestation history. A manifest
+// with checksums comes first so that a damaged archive is not half
+// imported.
+const (
+	archiveManifestName = "MANIFEST.json"
+	archiveVersion      = 1
+)
+
+type archiveManifest struct {
+	Version  int               `json:"version"`
+	Exported time.Time         `json:"exported"`
+	Files    map[string]string `json:"files"` // slash separated path -> SHA-256
+}
+
+// stateFiles returns the files in the state directory, by slash separated
+// path relative to it. Temporary files of interrupted saves are left out.
+func stateFiles() ([]string, error) {
+	var files []string
+	err := filepath.Walk(stateDir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == stateDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !fi.Mode().IsRegular() || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(stateDir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	return files, err
+}
+
+func fileSum(p string) (string, error) {
+	fd, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fd); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// exportState writes the state archive. The bot should be stopped, or in
+// maintenance mode, for the state to be consistent.
+func exportState(w io.Writer) error {
+	files, err := stateFiles()
+	if err != nil {
+		return err
+	}
+	man := archiveManifest{Version: archiveVersion, Exported: time.Now().UTC(), Files: make(map[string]string)}
+	for _, f := range files {
+		if man.Files[f], err = fileSum(filepath.Join(stateDir, filepath.FromSlash(f))); err != nil {
+			return err
+		}
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	bs, err := json.MarshalIndent(man, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: archiveManifestName, Mode: 0600, Size: int64(len(bs)), ModTime: man.Exported}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(bs); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := addArchiveFile(tw, f); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func addArchiveFile(tw *tar.Writer, name string) error {
+	fd, err := os.Open(filepath.Join(stateDir, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: fi.Size(), ModTime: fi.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, fd)
+	return err
+}
+
+// importState unpacks a state archive into the state directory, which must
+// not have any state of its own yet. Files are unpacked to a staging
+// directory and moved in place once all checksums match.
+func importState(r io.Reader) error {
+	if files, err := stateFiles(); err != nil {
+		return err
+	} else if len(files) > 0 {
+		return fmt.Errorf("state directory %s is not empty", stateDir)
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return err
+	}
+	if hdr.Name != archiveManifestName {
+		return fmt.Errorf("not a state archive: starts with %s", hdr.Name)
+	}
+	var man archiveManifest
+	if err := json.NewDecoder(tr).Decode(&man); err != nil {
+		return fmt.Errorf("manifest: %v", err)
+	}
+	if man.Version != archiveVersion {
+		return fmt.Errorf("unsupported state archive version %d", man.Version)
+	}
+
+	staging := stateDir + ".import"
+	if err := os.MkdirAll(staging, 0700); err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	seen := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(hdr.Name)
+		want, ok := man.Files[name]
+		if !ok || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+			return fmt.Errorf("unexpected file %s in archive", hdr.Name)
+		}
+		dst := filepath.Join(staging, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return err
+		}
+		if err := extractFile(tr, dst, hdr.ModTime); err != nil {
+			return err
+		}
+		if sum, err := fileSum(dst); err != nil {
+			return err
+		} else if sum != want {
+			return fmt.Errorf("checksum mismatch for %s", name)
+		}
+		seen[name] = true
+	}
+	for name := range man.Files {
+		if !seen[name] {
+			return fmt.Errorf("file %s missing from archive", name)
+		}
+	}
+
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(staging)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.Rename(filepath.Join(staging, e.Name()), filepath.Join(stateDir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFile(r io.Reader, dst string, mtime time.Time) error {
+	fd, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fd, r); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, mtime, mtime)
+}
+
+// runStateArchive exports to or imports from the named archive file, as
+// asked for on the command line. Archives are of the state directory, so
+// other state stores, which the bot wouldn't read it from, can't be used.
+func runStateArchive(driver, exportPath, importPath string) error {
+	if driver != storeFile && driver != "" {
+		return fmt.Errorf("only the %s state store can be exported or imported, not %s", storeFile, driver)
+	}
+	if exportPath != "" {
+		fd, err := os.Create(exportPath)
+		if err != nil {
+			return err
+		}
+		if err := exportState(fd); err != nil {
+			fd.Close()
+			return err
+		}
+		return fd.Close()
+	}
+	fd, err := os.Open(importPath)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return importState(fd)
+}
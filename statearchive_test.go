@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStateArchive(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+
+	files := map[string]string{
+		pendingStateName:                      `{"foo/bar#1": {}}`,
+		mergeLogName:                          "{}\n{}\n",
+		filepath.Join("config", "teams.json"): `{}`,
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(stateDir, name)), 0700)
+		if err := ioutil.WriteFile(filepath.Join(stateDir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ioutil.WriteFile(filepath.Join(stateDir, "features.json.tmp"), []byte("partial"), 0600)
+
+	buf := new(bytes.Buffer)
+	if err := exportState(buf); err != nil {
+		t.Fatal(err)
+	}
+	archive := buf.Bytes()
+
+	if err := importState(bytes.NewReader(archive)); err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Error("Expected import into existing state to fail, got", err)
+	}
+
+	stateDir = filepath.Join(t.TempDir(), "state")
+	if err := importState(bytes.NewReader(archive)); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		bs, err := ioutil.ReadFile(filepath.Join(stateDir, name))
+		if err != nil || string(bs) != content {
+			t.Errorf("%s: expected %q, got %q, %v", name, content, bs, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(stateDir, "features.json.tmp")); !os.IsNotExist(err) {
+		t.Error("Expected temporary files to be left out")
+	}
+
+	stateDir = filepath.Join(t.TempDir(), "state")
+	if err := importState(bytes.NewReader(archive[:len(archive)/2])); err == nil {
+		t.Error("Expected a truncated archive to fail")
+	}
+	if files, _ := stateFiles(); len(files) != 0 {
+		t.Errorf("Expected nothing imported from a truncated archive, got %v", files)
+	}
+}
+
+func TestStateArchiveOtherStores(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "state.tar.gz")
+	for _, driver := range []string{storeMemory, storeSQL} {
+		if err := runStateArchive(driver, archive, ""); err == nil {
+			t.Errorf("Expected exporting the %s store to fail", driver)
+		}
+		if err := runStateArchive(driver, "", archive); err == nil {
+			t.Errorf("Expected importing into the %s store to fail", driver)
+		}
+	}
+	if _, err := os.Stat(archive); err == nil {
+		t.Error("Expected no archive to be written")
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// A stateStore keeps named state of two kinds: JSON documents, replaced
+// whole, such as the pending merges and preferences, and logs of JSON
+// lines, appended to, such as the audit and merge logs. Small deployments
+// keep it in files, large ones in a database, and tests in memory.
+type stateStore interface {
+	read(name string) ([]byte, error)                     // nil if there's no such state
+	write(name string, bs []byte) error                   // atomically
+	appendLine(name string, line []byte, sync bool) error // durably before returning, with sync
+	readLines(name string, fn func([]byte)) error         // in the order appended
+	remove(name string) error                             // not an error if there's no such state
+}
+
+// Store drivers.
+const (
+	storeFile   = "file"   // files in the state directory, the default
+	storeMemory = "memory" // lost on restart, for tests and trials
+	storeSQL    = "sql"    // a database/sql database, with its driver built in
+)
+
+// openStore returns the store with the driver; the SQL driver connects to
+// the database named by sqlDriver and dsn.
+func openStore(driver, sqlDriver, dsn string) (stateStore, error) {
+	switch driver {
+	case storeFile, "":
+		return fileStore{}, nil
+	case storeMemory:
+		return newMemoryStore(), nil
+	case storeSQL:
+		return openSQLStore(sqlDriver, dsn)
+	}
+	return nil, fmt.Errorf("unknown state store %q; use %s, %s or %s", driver, storeFile, storeMemory, storeSQL)
+}
+
+// fileStore keeps each state as a file in stateDir, one line per entry for
+// logs.
+type fileStore struct{}
+
+func (fileStore) read(name string) ([]byte, error) {
+	bs, err := ioutil.ReadFile(filepath.Join(stateDir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return bs, err
+}
+
+func (fileStore) write(name string, bs []byte) error {
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(stateDir, name)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, bs, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (fileStore) appendLine(name string, line []byte, sync bool) error {
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(filepath.Join(stateDir, name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if _, err := fd.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if sync {
+		return fd.Sync()
+	}
+	return nil
+}
+
+func (fileStore) readLines(name string, fn func([]byte)) error {
+	fd, err := os.Open(filepath.Join(stateDir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	sc := bufio.NewScanner(fd)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		fn(sc.Bytes())
+	}
+	return sc.Err()
+}
+
+func (fileStore) remove(name string) error {
+	err := os.Remove(filepath.Join(stateDir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// memoryStore keeps state in memory only.
+type memoryStore struct {
+	mut   sync.Mutex
+	docs  map[string][]byte
+	lines map[string][][]byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{docs: make(map[string][]byte), lines: make(map[string][][]byte)}
+}
+
+func (m *memoryStore) read(name string) ([]byte, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if bs, ok := m.docs[name]; ok {
+		return append([]byte(nil), bs...), nil
+	}
+	if lines, ok := m.lines[name]; ok {
+		return append(bytes.Join(lines, []byte("\n")), '\n'), nil
+	}
+	return nil, nil
+}
+
+func (m *memoryStore) write(name string, bs []byte) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.docs[name] = append([]byte(nil), bs...)
+	return nil
+}
+
+func (m *memoryStore) appendLine(name string, line []byte, sync bool) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.lines[name] = append(m.lines[name], append([]byte(nil), line...))
+	return nil
+}
+
+func (m *memoryStore) readLines(name string, fn func([]byte)) error {
+	m.mut.Lock()
+	lines := m.lines[name]
+	m.mut.Unlock()
+	for _, line := range lines {
+		fn(line)
+	}
+	return nil
+}
+
+func (m *memoryStore) remove(name string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	delete(m.docs, name)
+	delete(m.lines, name)
+	return nil
+}
+
+// sqlStore keeps state in a database, with documents in a table of their
+// own and the lines of logs numbered in order in another. Only the driver
+// the binary is built with, as with the postgres build tag, can be used.
+type sqlStore struct {
+	db     *sql.DB
+	dollar bool       // placeholders are $1, $2, ... rather than ?
+	mut    sync.Mutex // serializes appends, which number the lines
+}
+
+func openSQLStore(driver, dsn string) (*sqlStore, error) {
+	if driver == "" || dsn == "" {
+		return nil, fmt.Errorf("the SQL state store needs a driver and data source name")
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%v (drivers built in: %s)", err, strings.Join(sql.Drivers(), ", "))
+	}
+	s := &sqlStore{db: db, dollar: driver == "postgres" || driver == "pgx"}
+	for _, stmt := range []string{
+		"CREATE TABLE IF NOT EXISTS mergebot_state (name VARCHAR(255) PRIMARY KEY, data TEXT NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS mergebot_state_lines (name VARCHAR(255) NOT NULL, seq BIGINT NOT NULL, data TEXT NOT NULL, PRIMARY KEY (name, seq))",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// query returns the query with its ? placeholders as the driver wants them.
+func (s *sqlStore) query(q string) string {
+	if !s.dollar {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *sqlStore) read(name string) ([]byte, error) {
+	var data string
+	err := s.db.QueryRow(s.query("SELECT data FROM mergebot_state WHERE name = ?"), name).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
+func (s *sqlStore) write(name string, bs []byte) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(s.query("DELETE FROM mergebot_state WHERE name = ?"), name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.query("INSERT INTO mergebot_state (name, data) VALUES (?, ?)"), name, string(bs)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// appendLine commits each line, so it's durable whether or not it's to be
+// synced.
+func (s *sqlStore) appendLine(name string, line []byte, sync bool) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var seq int64
+	if err := tx.QueryRow(s.query("SELECT COALESCE(MAX(seq), 0) FROM mergebot_state_lines WHERE name = ?"), name).Scan(&seq); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.query("INSERT INTO mergebot_state_lines (name, seq, data) VALUES (?, ?, ?)"), name, seq+1, string(line)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) readLines(name string, fn func([]byte)) error {
+	rows, err := s.db.Query(s.query("SELECT data FROM mergebot_state_lines WHERE name = ? ORDER BY seq"), name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		fn([]byte(data))
+	}
+	return rows.Err()
+}
+
+func (s *sqlStore) remove(name string) error {
+	if _, err := s.db.Exec(s.query("DELETE FROM mergebot_state WHERE name = ?"), name); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(s.query("DELETE FROM mergebot_state_lines WHERE name = ?"), name)
+	return err
+}
@@ -0,0 +1,7 @@
+//go:build postgres
+
+package main
+
+// Building with the postgres tag makes PostgreSQL available to the sql
+// state store, as -state-sql-driver postgres.
+import _ "github.com/lib/pq"
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestStateStores(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	defer func(s stateStore) { store = s }(store)
+
+	drivers := []string{storeMemory, storeFile}
+	sqlDriver, dsn := os.Getenv("MERGEBOT_TEST_SQL_DRIVER"), os.Getenv("MERGEBOT_TEST_SQL_DSN")
+	if dsn != "" {
+		drivers = append(drivers, storeSQL)
+	}
+	for _, driver := range drivers {
+		stateDir = t.TempDir()
+		var err error
+		if store, err = openStore(driver, sqlDriver, dsn); err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		store.remove("test.json")
+		store.remove("test.jsonl")
+
+		var doc map[string]int
+		if err := loadState("test.json", &doc); err != nil || doc != nil {
+			t.Errorf("%s: expected no state, got %v, %v", driver, doc, err)
+		}
+		if err := saveState("test.json", map[string]int{"a": 1}); err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		if err := saveState("test.json", map[string]int{"b": 2}); err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		if err := loadState("test.json", &doc); err != nil || !reflect.DeepEqual(doc, map[string]int{"b": 2}) {
+			t.Errorf("%s: expected the last state saved, got %v, %v", driver, doc, err)
+		}
+
+		for i, v := range []string{"one", "two", "three"} {
+			appendTo := appendState
+			if i == 1 {
+				appendTo = appendSynced
+			}
+			if err := appendTo("test.jsonl", v); err != nil {
+				t.Fatalf("%s: %v", driver, err)
+			}
+		}
+		var lines []string
+		if err := readStateLines("test.jsonl", func(line []byte) { lines = append(lines, string(line)) }); err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		if want := []string{`"one"`, `"two"`, `"three"`}; !reflect.DeepEqual(lines, want) {
+			t.Errorf("%s: expected lines %q, got %q", driver, want, lines)
+		}
+
+		for _, name := range []string{"test.json", "test.jsonl", "missing.json"} {
+			if err := removeState(name); err != nil {
+				t.Errorf("%s: removing %s: %v", driver, name, err)
+			}
+		}
+		doc = nil
+		lines = nil
+		loadState("test.json", &doc)
+		readStateLines("test.jsonl", func(line []byte) { lines = append(lines, string(line)) })
+		if doc != nil || lines != nil {
+			t.Errorf("%s: expected removed state to be gone, got %v and %q", driver, doc, lines)
+		}
+	}
+
+	if _, err := openStore("floppy", "", ""); err == nil {
+		t.Error("Expected an unknown store to be refused")
+	}
+	if _, err := openStore(storeSQL, "", ""); err == nil {
+		t.Error("Expected the sql store to need a database")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// areaSubject returns the commit subject for the PR title with the area of
+// the changes as prefix, as in "lib/model: Fix the thing". Titles that
+// already have a prefix are left alone, as are changes without an area.
+func areaSubject(title string, files []prFile) string {
+	title = strings.TrimSpace(title)
+	if allowedCommitSubjectRe.MatchString(title) {
+		return title
+	}
+	if area := primaryArea(files); area != "" {
+		return area + ": " + title
+	}
+	return title
+}
+
+// primaryArea returns the directory the changes are in. That's the common
+// directory of all changed files when there is one, and otherwise the top
+// level directory with the most changed lines.
+func primaryArea(files []prFile) string {
+	if len(files) == 0 {
+		return ""
+	}
+
+	common := path.Dir(files[0].Filename)
+	for _, f := range files[1:] {
+		for common != "." && !strings.HasPrefix(f.Filename, common+"/") {
+			common = path.Dir(common)
+		}
+	}
+	if common != "." {
+		return common
+	}
+
+	changed := make(map[string]int)
+	for _, f := range files {
+		if idx := strings.Index(f.Filename, "/"); idx > 0 {
+			changed[f.Filename[:idx]] += f.Additions + f.Deletions + 1
+		}
+	}
+	var dirs []string
+	for dir := range changed {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if changed[dirs[i]] != changed[dirs[j]] {
+			return changed[dirs[i]] > changed[dirs[j]]
+		}
+		return dirs[i] < dirs[j]
+	})
+	if len(dirs) == 0 {
+		return ""
+	}
+	return dirs[0]
+}
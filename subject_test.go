@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestAreaSubject(t *testing.T) {
+	cases := []struct {
+		title   string
+		files   []string
+		changes []int
+		subject string
+	}{
+		{"Fix the thing", []string{"lib/model/model.go", "lib/model/model_test.go"}, nil, "lib/model: Fix the thing"},
+		{"Fix the thing", []string{"lib/model/model.go", "lib/db/db.go"}, nil, "lib: Fix the thing"},
+		{"Fix the thing", []string{"cmd/foo/main.go", "lib/db/db.go"}, []int{10, 200}, "lib: Fix the thing"},
+		{"Fix the thing", []string{"README.md"}, nil, "Fix the thing"},
+		{"lib/db: Fix the thing ", []string{"lib/model/model.go"}, nil, "lib/db: Fix the thing"},
+	}
+	for _, tc := range cases {
+		var files []prFile
+		for i, name := range tc.files {
+			f := prFile{Filename: name}
+			if tc.changes != nil {
+				f.Additions = tc.changes[i]
+			}
+			files = append(files, f)
+		}
+		if s := areaSubject(tc.title, files); s != tc.subject {
+			t.Errorf("areaSubject(%q, %v) = %q, expected %q", tc.title, tc.files, s, tc.subject)
+		}
+	}
+}
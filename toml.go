@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseTOML parses the subset of TOML that settings files are written in:
+// tables, including dotted ones like ["owner/name".change_gate], with
+// "key = value" pairs of strings, numbers, booleans and single line arrays
+// of them, and comments. Inline tables, arrays of tables and multi-line
+// strings and arrays are refused rather than misread. The result is made of
+// map[string]interface{}, []interface{} and scalars, like a decoded JSON
+// value.
+func parseTOML(data string) (map[string]interface{}, error) {
+	root := make(map[string]interface{})
+	table := root
+	defined := make(map[string]bool) // tables with headers, which may be given once
+	for i, text := range strings.Split(data, "\n") {
+		num := i + 1
+		text = strings.TrimSpace(stripTOMLComment(text))
+		switch {
+		case text == "":
+			continue
+		case strings.HasPrefix(text, "[["):
+			return nil, fmt.Errorf("line %d: arrays of tables are not supported", num)
+		case strings.HasPrefix(text, "["):
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("line %d: unterminated table header", num)
+			}
+			keys, err := splitTOMLKey(strings.TrimSpace(text[1 : len(text)-1]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", num, err)
+			}
+			header := strings.Join(keys, ".")
+			if defined[header] {
+				return nil, fmt.Errorf("line %d: table %s is given twice", num, header)
+			}
+			defined[header] = true
+			table = root
+			for _, key := range keys {
+				next, ok := table[key]
+				if !ok {
+					next = make(map[string]interface{})
+					table[key] = next
+				}
+				if table, ok = next.(map[string]interface{}); !ok {
+					return nil, fmt.Errorf("line %d: %s is not a table", num, key)
+				}
+			}
+			continue
+		}
+
+		eq := indexUnquoted(text, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected \"key = value\"", num)
+		}
+		keys, err := splitTOMLKey(strings.TrimSpace(text[:eq]))
+		if err != nil || len(keys) != 1 {
+			return nil, fmt.Errorf("line %d: expected a key before =", num)
+		}
+		if _, dup := table[keys[0]]; dup {
+			return nil, fmt.Errorf("line %d: %s is given twice", num, keys[0])
+		}
+		v, err := tomlValue(strings.TrimSpace(text[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", num, err)
+		}
+		table[keys[0]] = v
+	}
+	return root, nil
+}
+
+// splitTOMLKey splits a dotted key into its bare or quoted parts.
+func splitTOMLKey(s string) ([]string, error) {
+	var keys []string
+	for {
+		var key string
+		switch {
+		case strings.HasPrefix(s, "\""):
+			end := closingQuote(s)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated key %s", s)
+			}
+			var err error
+			if key, err = unquoteTOML(s[:end+1]); err != nil {
+				return nil, err
+			}
+			s = s[end+1:]
+		case strings.HasPrefix(s, "'"):
+			end := strings.IndexByte(s[1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated key %s", s)
+			}
+			key, s = s[1:end+1], s[end+2:]
+		default:
+			end := strings.IndexAny(s, ". ")
+			if end < 0 {
+				end = len(s)
+			}
+			key, s = s[:end], s[end:]
+			for _, r := range key {
+				if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+					return nil, fmt.Errorf("%q must be quoted to be a key", key)
+				}
+			}
+			if key == "" {
+				return nil, fmt.Errorf("empty key")
+			}
+		}
+		keys = append(keys, key)
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return keys, nil
+		}
+		if !strings.HasPrefix(s, ".") {
+			return nil, fmt.Errorf("unexpected %s after key", s)
+		}
+		s = strings.TrimSpace(s[1:])
+	}
+}
+
+// stripTOMLComment removes a comment, which starts with # outside of
+// quotes.
+func stripTOMLComment(text string) string {
+	if i := indexUnquoted(text, '#'); i >= 0 {
+		return text[:i]
+	}
+	return text
+}
+
+// indexUnquoted returns the index of the first c outside of quotes in the
+// text, or -1.
+func indexUnquoted(text string, c byte) int {
+	quote := byte(0)
+	for i := 0; i < len(text); i++ {
+		switch b := text[i]; {
+		case quote == '"' && b == '\\':
+			i++ // the escaped character can't end the string
+		case quote != 0:
+			if b == quote {
+				quote = 0
+			}
+		case b == '"' || b == '\'':
+			quote = b
+		case b == c:
+			return i
+		}
+	}
+	return -1
+}
+
+// closingQuote returns the index of the quote ending the basic string s
+// starts with, or -1.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// unquoteTOML returns the value of the basic string s, which must be all of
+// it, with only the escapes TOML has.
+func unquoteTOML(s string) (string, error) {
+	if end := closingQuote(s); end != len(s)-1 {
+		return "", fmt.Errorf("malformed string %s", s)
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		c := s[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch e := s[i]; e {
+		case 'b':
+			b.WriteByte('\b')
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'f':
+			b.WriteByte('\f')
+		case 'r':
+			b.WriteByte('\r')
+		case '"', '\\':
+			b.WriteByte(e)
+		case 'u', 'U':
+			n := 4
+			if e == 'U' {
+				n = 8
+			}
+			if i+n > len(s)-2 {
+				return "", fmt.Errorf("short \\%c escape in %s", e, s)
+			}
+			r, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(r)) {
+				return "", fmt.Errorf("invalid \\%c escape in %s", e, s)
+			}
+			b.WriteRune(rune(r))
+			i += n
+		default:
+			return "", fmt.Errorf("invalid escape \\%c in %s", e, s)
+		}
+	}
+	return b.String(), nil
+}
+
+func tomlValue(s string) (interface{}, error) {
+	switch {
+	case s == "":
+		return nil, fmt.Errorf("missing value")
+	case strings.HasPrefix(s, "\"\"\""), strings.HasPrefix(s, "'''"):
+		return nil, fmt.Errorf("multi-line strings are not supported")
+	case strings.HasPrefix(s, "\""):
+		return unquoteTOML(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("unterminated string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("arrays must be on one line")
+		}
+		res := []interface{}{}
+		for _, item := range splitTOMLArray(s[1 : len(s)-1]) {
+			v, err := tomlValue(item)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, v)
+		}
+		return res, nil
+	case strings.HasPrefix(s, "{"):
+		return nil, fmt.Errorf("inline tables are not supported; use a [table]")
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	}
+	n := strings.Replace(s, "_", "", -1)
+	if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(n, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("%s is not a value; strings must be quoted", s)
+}
+
+// splitTOMLArray splits the inside of an array at the commas outside of
+// quotes, dropping a trailing one.
+func splitTOMLArray(s string) []string {
+	var res []string
+	quote := byte(0)
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			res = append(res, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		res = append(res, last)
+	}
+	return res
+}
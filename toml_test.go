@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	cases := []struct {
+		in   string
+		json string
+	}{
+		{"", `{}`},
+		{"# settings\n[\"foo/bar\"]\nmax_wait = \"2h\" # comment\ntrain_length = 1_000\nflake_threshold = 0.5\nobserve = true\n", `{"foo/bar":{"flake_threshold":0.5,"max_wait":"2h","observe":true,"train_length":1000}}`},
+		{"[\"foo/*\"]\nallowed_users = [\"alice\", 'bob', ]\nbase_branches = []\n", `{"foo/*":{"allowed_users":["alice","bob"],"base_branches":[]}}`},
+		{"['foo/bar'.change_gate]\nbranches = [\"main\"]\n[\"foo/bar\"]\ngreeting = \"Hi # there, \\\"you\\\"\"\n", `{"foo/bar":{"change_gate":{"branches":["main"]},"greeting":"Hi # there, \"you\""}}`},
+		{"[defaults]\nkey-name = 'C:\\path'\n", `{"defaults":{"key-name":"C:\\path"}}`},
+		{"[\"a=b\"]\n\"c = d\" = \"e\\u00e9\\U0001F389\\t\"\n", `{"a=b":{"c = d":"eé🎉\t"}}`},
+	}
+	for _, tc := range cases {
+		v, err := parseTOML(tc.in)
+		if err != nil {
+			t.Errorf("parseTOML(%q): %v", tc.in, err)
+			continue
+		}
+		bs, _ := json.Marshal(v)
+		if string(bs) != tc.json {
+			t.Errorf("parseTOML(%q) = %s, expected %s", tc.in, bs, tc.json)
+		}
+	}
+
+	for _, in := range []string{"[a]\nb = 1\nb = 2\n", "[a]\n[a]\n", "[a]\nb = bare\n", "[a]\nb = [1,\n2]\n", "[[a]]\n", "[a]\nb = { c = 1 }\n", "[a\n", "[a/b]\n", "[a]\njust text\n", "[a]\nb = \"\\x41\"\n", "[a]\nb = \"\\a\"\n", "[a]\nb = \"\\u12\"\n", "[a]\nb = \"c\" d\n", "[\"a\\q\"]\n"} {
+		if _, err := parseTOML(in); err == nil {
+			t.Errorf("Expected an error parsing %q", in)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+const trackingStateName = "tracking.json"
+
+// trackingStore keeps the issues opened to track failed merges, so that
+// failures don't get lost in the comment threads of their PRs.
+type trackingStore struct {
+	mut    sync.Mutex
+	Issues map[string]int `json:"issues"` // "owner/name#number" of the PR -> issue number
+}
+
+func loadTrackingStore() (*trackingStore, error) {
+	s := &trackingStore{Issues: make(map[string]int)}
+	if err := loadState(trackingStateName, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *trackingStore) get(key string) (int, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	n, ok := s.Issues[key]
+	return n, ok
+}
+
+func (s *trackingStore) set(key string, issue int) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.Issues[key] = issue
+	if err := saveState(trackingStateName, s); err != nil {
+		log.Println("Saving tracking issues:", err)
+	}
+}
+
+// take returns the issue tracking the PR, forgetting it.
+func (s *trackingStore) take(key string) (int, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	n, ok := s.Issues[key]
+	if !ok {
+		return 0, false
+	}
+	delete(s.Issues, key)
+	if err := saveState(trackingStateName, s); err != nil {
+		log.Println("Saving tracking issues:", err)
+	}
+	return n, true
+}
+
+// tracking returns whether failed merges in the repository get tracking
+// issues.
+func (h *handler) tracking(repo string) bool {
+	rs := h.settings.forRepo(repo)
+	return h.tracked != nil && rs.TrackFailures != nil && *rs.TrackFailures && !h.observing(repo)
+}
+
+// trackFailure opens an issue assigned to the requester about the merge of
+// the PR failing for the reason, with the markdown detail, or comments on
+// the one already open for it, reopening it if need be.
+func (h *handler) trackFailure(c comment, pr pr, reason, detail string) {
+	repo := c.Repository.FullName
+	if !h.tracking(repo) || !onGitHub(pr.Forge) {
+		return
+	}
+	text := fmt.Sprintf("Merging #%d for @%s failed: %s.", c.Issue.Number, c.Sender.Login, reason)
+	if detail != "" {
+		text += "\n\n" + detail
+	}
+	if c.Comment.HTMLURL != "" {
+		text += fmt.Sprintf("\n\nAsked for in %s.", c.Comment.HTMLURL)
+	}
+
+	key := pendingKey(c)
+	if issue, ok := h.tracked.get(key); ok {
+		u := fmt.Sprintf("%s/repos/%s/issues/%d", githubAPI, repo, issue)
+		if err := apiRequest("POST", u+"/comments", map[string]string{"body": text}, nil, h.username, h.token); err != nil {
+			log.Printf("Updating tracking issue %d on %s: %v", issue, repo, err)
+			return
+		}
+		req := map[string]interface{}{"state": "open", "assignees": []string{c.Sender.Login}}
+		if err := apiRequest("PATCH", u, req, nil, h.username, h.token); err != nil {
+			log.Printf("Reopening tracking issue %d on %s: %v", issue, repo, err)
+		}
+		return
+	}
+
+	title := pr.Title
+	if title == "" {
+		title = fmt.Sprintf("PR %d", c.Issue.Number)
+	}
+	req := map[string]interface{}{
+		"title":     fmt.Sprintf("Merge of #%d failed: %s", c.Issue.Number, title),
+		"body":      text + "\n\nThis issue is closed when the PR is merged or closed.",
+		"assignees": []string{c.Sender.Login},
+	}
+	var res struct {
+		Number int
+	}
+	if err := apiRequest("POST", fmt.Sprintf("%s/repos/%s/issues", githubAPI, repo), req, &res, h.username, h.token); err != nil {
+		log.Printf("Opening tracking issue for PR %d on %s: %v", c.Issue.Number, repo, err)
+		return
+	}
+	h.tracked.set(key, res.Number)
+}
+
+// closeTracking closes the issue tracking failed merges of the PR, if any,
+// saying why.
+func (h *handler) closeTracking(repo string, number int, why string) {
+	if h.tracked == nil {
+		return
+	}
+	issue, ok := h.tracked.take(fmt.Sprintf("%s#%d", repo, number))
+	if !ok {
+		return
+	}
+	u := fmt.Sprintf("%s/repos/%s/issues/%d", githubAPI, repo, issue)
+	if err := apiRequest("POST", u+"/comments", map[string]string{"body": why}, nil, h.username, h.token); err != nil {
+		log.Printf("Commenting on tracking issue %d on %s: %v", issue, repo, err)
+	}
+	if err := apiRequest("PATCH", u, map[string]string{"state": "closed"}, nil, h.username, h.token); err != nil {
+		log.Printf("Closing tracking issue %d on %s: %v", issue, repo, err)
+	}
+}
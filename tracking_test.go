@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTrackFailures(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+
+	var requests []string
+	var bodies []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		bodies = append(bodies, body)
+		if r.URL.Path == "/repos/foo/bar/issues" {
+			w.Write([]byte(`{"number": 12}`))
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	h := newHandler(nil, "bot", "", false)
+	var c comment
+	c.Repository.FullName = "foo/bar"
+	c.Sender.Login = "alice"
+	c.Issue.Number = 5
+	var p pr
+	p.Title = "Add b"
+
+	h.trackFailure(c, p, "git couldn't merge it", "")
+	if len(requests) != 0 {
+		t.Fatalf("Expected no issue unless configured, got %v", requests)
+	}
+
+	track := true
+	h.settings.defaults.TrackFailures = &track
+	h.trackFailure(c, p, "git couldn't merge it", "```\nCONFLICT (content): Merge conflict in b.txt\n```")
+	h.trackFailure(c, p, "the merge policy doesn't allow it", withNotes("Reasons:", []string{"No Fridays"}))
+	h.closeTracking("foo/bar", 5, "#5 was merged as abc123.")
+	h.closeTracking("foo/bar", 5, "#5 was closed.")
+
+	want := []string{
+		"POST /repos/foo/bar/issues",
+		"POST /repos/foo/bar/issues/12/comments",
+		"PATCH /repos/foo/bar/issues/12",
+		"POST /repos/foo/bar/issues/12/comments",
+		"PATCH /repos/foo/bar/issues/12",
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Fatalf("Unexpected requests %v", requests)
+	}
+	if title := bodies[0]["title"]; title != "Merge of #5 failed: Add b" {
+		t.Errorf("Unexpected title %q", title)
+	}
+	if body := bodies[0]["body"].(string); !strings.HasPrefix(body, "Merging #5 for @alice failed: git couldn't merge it.") || !strings.Contains(body, "Merge conflict in b.txt") {
+		t.Errorf("Unexpected body %q", body)
+	}
+	if assignees := bodies[0]["assignees"]; !reflect.DeepEqual(assignees, []interface{}{"alice"}) {
+		t.Errorf("Unexpected assignees %v", assignees)
+	}
+	if body := bodies[1]["body"].(string); !strings.Contains(body, "No Fridays") {
+		t.Errorf("Unexpected update %q", body)
+	}
+	if bodies[2]["state"] != "open" || bodies[4]["state"] != "closed" || bodies[3]["body"] != "#5 was merged as abc123." {
+		t.Errorf("Unexpected updates %v", bodies[2:])
+	}
+
+	// Nothing is tracked once the PR is merged.
+	loaded, err := loadTrackingStore()
+	if err != nil || len(loaded.Issues) != 0 {
+		t.Errorf("Expected no issues tracked, got %v, %v", loaded.Issues, err)
+	}
+}
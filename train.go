@@ -0,0 +1,443 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultTrainLength = 3
+
+// maxTrainPushFailures is how many times in a row landing candidates may
+// fail before the cars that were to land are ejected, rather than retried
+// forever.
+const maxTrainPushFailures = 3
+
+// A train lands queued PRs on a branch by validating speculative candidate
+// branches stacking them, A, A+B, A+B+C, in parallel and then landing the
+// longest prefix of candidates that turned out green.
+type train struct {
+	repo    string
+	base    string
+	queue   []trainCar
+	running bool
+
+	pushFailures int // failed attempts in a row to land candidates
+}
+
+type trainCar struct {
+	c        comment
+	pr       pr
+	plan     mergePlan
+	deadline time.Time // for its checks, as for any pending merge
+}
+
+// A trainCandidate is a pushed branch with the squashed commits of the
+// first cars of the train.
+type trainCandidate struct {
+	branch string
+	sha    string
+}
+
+// trainEnabled returns whether merges on the repository go through a train.
+func (h *handler) trainEnabled(repo string) bool {
+	rs := h.settings.forRepo(repo)
+	return (rs.MergeTrain != nil && *rs.MergeTrain || h.queueEnabled(repo)) && !h.observing(repo) // trains push their candidates
+}
+
+// queueEnabled returns whether merges in the repository are queued, which
+// is a train of one car: they land one at a time in the order they were
+// asked for, each validated on top of the one before.
+func (h *handler) queueEnabled(repo string) bool {
+	rs := h.settings.forRepo(repo)
+	return rs.MergeQueue != nil && *rs.MergeQueue
+}
+
+// enqueueTrain adds the PR to the train for its base branch, starting the
+// train if it isn't running. It must be called with the repository locked,
+// which guards the train.
+func (h *handler) enqueueTrain(c comment, pr pr) {
+	plan, ok := h.planMerge(c, pr)
+	if !ok {
+		return
+	}
+
+	key := c.Repository.FullName + ":" + pr.Base.Ref
+	h.mut.Lock()
+	t := h.trains[key]
+	if t == nil {
+		t = &train{repo: c.Repository.FullName, base: pr.Base.Ref}
+		h.trains[key] = t
+	}
+	h.mut.Unlock()
+	maxWait, _, _ := h.waitTime(c)
+	now := time.Now()
+	t.queue = append(t.queue, trainCar{c: c, pr: pr, plan: plan, deadline: now.Add(maxWait)})
+	h.markPending(pendingMerge{Comment: c, Started: now, Deadline: now.Add(maxWait), Train: true}, true)
+	c.post(trainQueuedResponse(c, len(t.queue)), h.username, h.token)
+	h.mergeStatus(c, pr, statePending, "Queued in the merge train.")
+
+	if !t.running {
+		t.running = true
+		go h.runTrain(t)
+	}
+}
+
+func (h *handler) runTrain(t *train) {
+	for {
+		if h.maintenance.active() {
+			// Trains wait out maintenance like delayed merges.
+			time.Sleep(h.settings.forRepo(t.repo).MaxPoll.Duration)
+			continue
+		}
+		unlock := h.lockRepo(t.repo)
+		if len(t.queue) == 0 {
+			t.running = false
+			unlock()
+			return
+		}
+		length := h.settings.forRepo(t.repo).TrainLength
+		if h.queueEnabled(t.repo) {
+			length = 1
+		} else if length < 1 {
+			length = defaultTrainLength
+		}
+		if length > len(t.queue) {
+			length = len(t.queue)
+		}
+		cars := append([]trainCar(nil), t.queue[:length]...)
+
+		candidates, failed, err := h.buildCandidates(t, cars)
+		if err != nil {
+			if moved, ok := err.(*headMovedError); ok {
+				car := cars[failed]
+				h.ejectCar(t, car, "head moved", nil, headMovedResponse(car.c, moved.want, moved.got))
+			} else if failed >= 0 {
+				car := cars[failed]
+				h.ejectCar(t, car, "conflict", nil, trainEjectedResponse(car.c, "it doesn't apply on top of the PRs ahead of it", conflictLines(err.Error())))
+			} else {
+				log.Printf("Merge train on %s %s: %v", t.repo, t.base, err)
+			}
+			unlock()
+			if failed < 0 {
+				// Probably something temporary with the remote.
+				time.Sleep(time.Minute)
+			}
+			continue
+		}
+		unlock()
+
+		results := h.validateCandidates(t, cars, candidates)
+
+		unlockGroup := h.lockGroup(t.repo, nil)
+		unlock = h.lockRepo(t.repo)
+		if h.maintenance.active() {
+			// Nothing lands during maintenance; the cars are validated
+			// again once it's over.
+			h.deleteCandidates(t, candidates)
+		} else {
+			h.landCandidates(t, cars, candidates, results)
+		}
+		unlock()
+		unlockGroup()
+	}
+}
+
+// buildCandidates pushes a candidate branch for each prefix of the cars. If
+// a car can't be squashed on top of the ones before it, its index is
+// returned along with the error.
+func (h *handler) buildCandidates(t *train, cars []trainCar) ([]trainCandidate, int, error) {
+	if _, err := os.Stat(filepath.Join(t.repo, ".git")); err != nil {
+		if err := h.clone(t.repo); err != nil {
+			return nil, -1, err
+		}
+	}
+	h.refreshRemote(t.repo)
+
+	s := newScript().in(t.repo)
+	refs := []fetchRef{{remote: t.base, local: "orig/" + t.base}}
+	var prs []pr
+	for _, car := range cars {
+		refs = append(refs, prRef(car.pr))
+		prs = append(prs, car.pr)
+	}
+	fetchRefs(s, refs...)
+	for i, car := range cars {
+		// What's merged is what was asked for, not what was pushed since.
+		if err := checkHead(s, car.pr); err != nil {
+			return nil, i, err
+		}
+	}
+	s.run("git", "reset", "--hard")
+	setSparse(s, h.sparseFor(t.repo, prs...))
+	s.run("git", "checkout", "-B", "mergebot-train", "orig/"+t.base)
+	s.run("git", "clean", "-fxd")
+	if s.Error() != nil {
+		return nil, -1, fmt.Errorf("%s", s.output.String())
+	}
+
+	var res []trainCandidate
+	for i, car := range cars {
+		sha, err := squashCommit(s, car.pr, car.plan)
+		if err == nil && s.Error() != nil {
+			err = fmt.Errorf("%s", s.output.String())
+		}
+		if err != nil {
+			newScript().in(t.repo).run("git", "reset", "--hard")
+			return nil, i, err
+		}
+		branch := fmt.Sprintf("mergebot/train/%s/%d", t.base, i+1)
+		s.run("git", "push", "-f", "origin", sha+":refs/heads/"+branch)
+		if s.Error() != nil {
+			return nil, -1, fmt.Errorf("%s", s.output.String())
+		}
+		res = append(res, trainCandidate{branch: branch, sha: sha})
+	}
+	return res, -1, nil
+}
+
+// A candidateResult is the outcome of validating a candidate.
+type candidateResult struct {
+	state  prState
+	notes  []string
+	failed []status // the failed statuses, if any
+}
+
+// validateCandidates waits for the statuses of all candidates to be
+// decided, or for the deadline of the car of the first undecided one to
+// pass, as nothing after it can land then.
+func (h *handler) validateCandidates(t *train, cars []trainCar, candidates []trainCandidate) []candidateResult {
+	rs := h.settings.forRepo(t.repo)
+	wait := time.Second
+
+	shas := make([]string, len(candidates))
+	for i, cand := range candidates {
+		shas[i] = cand.sha
+	}
+	events, stop := h.ci.watch(t.repo, shas...)
+	defer stop()
+
+	results := make([]candidateResult, len(candidates))
+	for {
+		decided, expired := true, false
+		for i, cand := range candidates {
+			p := cars[i].pr
+			p.StatusesURL = fmt.Sprintf("%s/repos/%s/commits/%s/statuses", githubAPI, t.repo, cand.sha)
+			p.Head.SHA = cand.sha
+			statuses, notes := h.staleness.apply(h.getStatuses(t.repo, p), time.Now())
+			results[i] = candidateResult{state: overallStatus(statuses, nil), notes: notes}
+			for _, st := range statuses {
+				if st.State == stateFailure || st.State == stateError {
+					results[i].failed = append(results[i].failed, st)
+				}
+			}
+			if results[i].state == statePending {
+				if decided && !time.Now().Before(cars[i].deadline) {
+					expired = true
+				}
+				decided = false
+			}
+		}
+		if decided || expired || h.maintenance.active() {
+			return results
+		}
+
+		if waitForCI(events, wait) {
+			wait = rs.MaxPoll.Duration
+		} else if wait < rs.MaxPoll.Duration {
+			wait *= 2
+		}
+	}
+}
+
+// greenPrefix returns how many of the leading candidates succeeded.
+func greenPrefix(results []candidateResult) int {
+	for i, r := range results {
+		if r.state != stateSuccess {
+			return i
+		}
+	}
+	return len(results)
+}
+
+// landCandidates fast forwards the base branch to the longest green
+// candidate and ejects the car that broke the train, if any. The cars after
+// that stay queued for the next round. Must be called with the repository
+// locked.
+func (h *handler) landCandidates(t *train, cars []trainCar, candidates []trainCandidate, results []candidateResult) {
+	defer h.deleteCandidates(t, candidates)
+
+	landed := greenPrefix(results)
+	// The PRs may have been stopped, closed or pushed to while their
+	// candidates were validated. The cars behind one that shouldn't land
+	// after all stay queued for the next round.
+	rechecked := true
+	for i := 0; i < landed; i++ {
+		if why := h.recheckCar(t, cars[i]); why != "" {
+			if t.queued(cars[i]) {
+				h.ejectCar(t, cars[i], "recheck", nil, trainEjectedResponse(cars[i].c, why, nil))
+			}
+			landed, rechecked = i, false
+			break
+		}
+	}
+	if landed > 0 {
+		s := newScript().in(t.repo)
+		s.run("git", "push", "origin", candidates[landed-1].sha+":refs/heads/"+t.base)
+		if s.Error() != nil {
+			log.Printf("Merge train on %s %s failed to land:\n%s", t.repo, t.base, s.output.String())
+			t.pushFailures++
+			if t.pushFailures < maxTrainPushFailures {
+				// Most likely the base branch moved; the next round
+				// rebuilds the candidates on top of it.
+				return
+			}
+			t.pushFailures = 0
+			for _, car := range cars[:landed] {
+				h.ejectCar(t, car, "push", nil, trainEjectedResponse(car.c, fmt.Sprintf("%s couldn't be updated after %d attempts", t.base, maxTrainPushFailures), nil))
+			}
+			return
+		}
+		t.pushFailures = 0
+
+		// The head of each candidate is the squashed commit of its last
+		// car.
+		for i := 0; i < landed; i++ {
+			car := cars[i]
+			h.removeCar(t, car)
+			h.trainStats.landed(t.repo, car.pr)
+			h.completeMerge(car.c, car.pr, candidates[i].sha, car.plan, results[i].notes)
+		}
+	}
+
+	if landed < len(cars) && rechecked {
+		car := cars[landed]
+		res := results[landed]
+		if res.state == statePending {
+			h.ejectCar(t, car, "timeout", nil, trainEjectedResponse(car.c, "its checks didn't finish in time", res.notes))
+		} else {
+			var contexts []string
+			for _, st := range res.failed {
+				contexts = append(contexts, st.Context)
+			}
+			h.ejectCar(t, car, "checks", contexts, trainEjectedResponse(car.c, "checks failed", append(failedCheckLines(res.failed), res.notes...)))
+		}
+		if behind := len(t.queue); behind > 0 {
+			log.Printf("Revalidating %d PRs behind the ejected PR %d on %s %s", behind, car.pr.Number, t.repo, t.base)
+		}
+	}
+}
+
+// recheckCar returns why the car shouldn't land after all, or nothing if
+// it should, with what may have changed since it was queued checked again
+// on the PR as it is now.
+func (h *handler) recheckCar(t *train, car trainCar) string {
+	if !t.queued(car) {
+		return "it was taken out of the train"
+	}
+	cur, err := car.c.getPR()
+	if err != nil {
+		log.Printf("Merge train on %s %s: PR %d: %v", t.repo, t.base, car.pr.Number, err)
+		return "the PR couldn't be checked again before landing"
+	}
+	if why := h.recheckMerge(car.c, car.pr, cur); why != "" {
+		return why
+	}
+	if cur.headSHA() != car.pr.headSHA() {
+		return "the head moved"
+	}
+	for _, st := range cur.getStatuses(t.repo, h.username, h.token) {
+		if st.Context == "st-review" && st.State == stateFailure {
+			return "it was stopped"
+		}
+	}
+	return ""
+}
+
+// queued returns whether the car is still in the train.
+func (t *train) queued(car trainCar) bool {
+	for _, other := range t.queue {
+		if other.c.Issue.Number == car.c.Issue.Number {
+			return true
+		}
+	}
+	return false
+}
+
+// leaveTrain takes the PR out of any train of the repository without
+// landing it, as when it's stopped or closed. It must be called with the
+// repository locked.
+func (h *handler) leaveTrain(repo string, number int) {
+	h.mut.Lock()
+	var trains []*train
+	for key, t := range h.trains {
+		if strings.HasPrefix(key, repo+":") {
+			trains = append(trains, t)
+		}
+	}
+	h.mut.Unlock()
+	for _, t := range trains {
+		for _, car := range t.queue {
+			if car.c.Issue.Number == number {
+				h.removeCar(t, car)
+				log.Printf("Took PR %d out of the merge train on %s %s", number, t.repo, t.base)
+				break
+			}
+		}
+	}
+}
+
+// failedCheckLines describes each failed status for a response.
+func failedCheckLines(failed []status) []string {
+	var res []string
+	for _, st := range failed {
+		line := fmt.Sprintf("`%s` is `%s`", st.Context, st.State)
+		if st.Description != "" {
+			line += ": " + st.Description
+		}
+		if st.TargetURL != "" {
+			line += fmt.Sprintf(" ([details](%s))", st.TargetURL)
+		}
+		res = append(res, line)
+	}
+	return res
+}
+
+// conflictLines returns the lines about conflicts in git merge output.
+func conflictLines(output string) []string {
+	var res []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "CONFLICT") {
+			res = append(res, codeSpan(strings.TrimSpace(line)))
+		}
+	}
+	return res
+}
+
+// ejectCar removes the car from the train, telling the requester why, and
+// counts the ejection for the reason and failed contexts.
+func (h *handler) ejectCar(t *train, car trainCar, reason string, contexts []string, response string) {
+	h.removeCar(t, car)
+	h.trainStats.ejected(t.repo, car.pr, reason, contexts)
+	car.c.post(response, h.username, h.token)
+	log.Printf("Ejected PR %d from the merge train on %s %s", car.pr.Number, t.repo, t.base)
+}
+
+func (h *handler) removeCar(t *train, car trainCar) {
+	for i := range t.queue {
+		if t.queue[i].c.Issue.Number == car.c.Issue.Number {
+			t.queue = append(t.queue[:i], t.queue[i+1:]...)
+			break
+		}
+	}
+	h.unmarkPending(car.c)
+}
+
+func (h *handler) deleteCandidates(t *train, candidates []trainCandidate) {
+	for _, cand := range candidates {
+		newScript().in(t.repo).run("git", "push", "origin", ":refs/heads/"+cand.branch)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGreenPrefix(t *testing.T) {
+	cases := []struct {
+		states []prState
+		landed int
+	}{
+		{[]prState{stateSuccess, stateSuccess, stateSuccess}, 3},
+		{[]prState{stateSuccess, stateFailure, stateSuccess}, 1},
+		{[]prState{statePending, stateSuccess}, 0},
+		{nil, 0},
+	}
+	for _, tc := range cases {
+		var results []candidateResult
+		for _, st := range tc.states {
+			results = append(results, candidateResult{state: st})
+		}
+		if n := greenPrefix(results); n != tc.landed {
+			t.Errorf("greenPrefix(%v) = %d, expected %d", tc.states, n, tc.landed)
+		}
+	}
+}
+
+func TestTrainStats(t *testing.T) {
+	s := &trainStats{repos: make(map[string]*ejectionStats)}
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+
+	var a, b pr
+	a.Number, a.Head.SHA = 1, "aaa"
+	b.Number, b.Head.SHA = 2, "bbb"
+
+	s.ejected("foo/bar", a, "checks", []string{"ci"})
+	s.ejected("foo/bar", b, "checks", []string{"ci"})
+	s.landed("foo/bar", a) // same revision; flaky
+	b.Head.SHA = "ccc"
+	s.landed("foo/bar", b) // fixed by a new push
+
+	rs := s.repos["foo/bar"]
+	if rs.Ejections != 2 || rs.Flaky != 1 || rs.Contexts["ci"].Flaky != 1 || len(rs.Pending) != 0 {
+		t.Errorf("Unexpected statistics %+v", rs)
+	}
+}
+
+func TestConflictLines(t *testing.T) {
+	out := "$ git merge --squash --no-commit pr-2\nAuto-merging foo.go\nCONFLICT (content): Merge conflict in foo.go\nSquash commit -- not updating HEAD\n"
+	if lines := conflictLines(out); len(lines) != 1 || lines[0] != "`CONFLICT (content): Merge conflict in foo.go`" {
+		t.Errorf("Unexpected conflict lines %q", lines)
+	}
+}
+
+func TestBuildCandidatesHeadMoved(t *testing.T) {
+	work, origin := t.TempDir(), filepath.Join(t.TempDir(), "origin.git")
+	w := newScript().in(work)
+	git := func(args ...string) string {
+		return w.run("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	}
+	git("init", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "Initial")
+	git("checkout", "-q", "-b", "fix")
+	os.WriteFile(filepath.Join(work, "fix.txt"), []byte("fixed\n"), 0644)
+	git("add", "fix.txt")
+	git("commit", "-q", "-m", "Fix it")
+	asked := git("rev-parse", "HEAD")
+	// Force-pushed after the merge was asked for.
+	os.WriteFile(filepath.Join(work, "fix.txt"), []byte("unreviewed\n"), 0644)
+	git("commit", "-q", "--amend", "-am", "Fix it")
+	pushed := git("rev-parse", "HEAD")
+	newScript().run("git", "clone", "-q", "--bare", work, origin)
+	git("push", "-q", origin, "fix:refs/pull/7/head")
+	clone := filepath.Join(t.TempDir(), "clone")
+	newScript().run("git", "clone", "-q", origin, clone)
+	if w.Error() != nil {
+		t.Fatal(w.output.String())
+	}
+
+	var p pr
+	p.Number = 7
+	p.Base.Ref = "main"
+	p.Head.SHA = asked
+	h := newHandler(nil, "bot", "token", false)
+	tr := &train{repo: clone, base: "main"}
+	cars := []trainCar{{pr: p, plan: mergePlan{user: user{Name: "Merger", Email: "merger@example.com"}}}}
+	_, failed, err := h.buildCandidates(tr, cars)
+	moved, ok := err.(*headMovedError)
+	if !ok || failed != 0 || moved.want != asked || moved.got != pushed {
+		t.Fatalf("Expected car 0 to have moved from %s to %s, got %d, %v", asked, pushed, failed, err)
+	}
+	if out := newScript().in(origin).run("git", "for-each-ref", "refs/heads/mergebot"); out != "" {
+		t.Errorf("Pushed candidates %s", out)
+	}
+}
+
+func TestLandCandidatesRecheck(t *testing.T) {
+	state, review := "open", "success"
+	var comments []string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/foo/bar/pulls/1":
+			w.Write([]byte(`{"state": "` + state + `", "base": {"ref": "main"}, "head": {"sha": "abc"}, "statuses_url": "` + srv.URL + `/statuses"}`))
+		case r.URL.Path == "/statuses":
+			w.Write([]byte(`[{"state": "` + review + `", "context": "st-review"}]`))
+		case strings.HasSuffix(r.URL.Path, "/comments"):
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			comments = append(comments, body.Body)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+
+	h := newHandler([]string{"alice"}, "bot", "token", false)
+	var c comment
+	c.Repository.FullName = "foo/bar"
+	c.Sender.Login = "alice"
+	c.Issue.Number = 1
+	c.Issue.PullRequest.URL = srv.URL + "/repos/foo/bar/pulls/1"
+	c.Issue.CommentsURL = srv.URL + "/repos/foo/bar/issues/1/comments"
+	var p pr
+	p.Number = 1
+	p.Base.Ref = "main"
+	p.Head.SHA = "abc"
+	car := trainCar{c: c, pr: p}
+	// Not a clone, so any push fails.
+	tr := &train{repo: t.TempDir(), base: "main"}
+	h.trains["foo/bar:main"] = tr
+	candidates := []trainCandidate{{branch: "mergebot/train/main/1", sha: "def"}}
+	green := []candidateResult{{state: stateSuccess}}
+
+	cases := []struct {
+		state, review string
+		ejected       string
+	}{
+		{"closed", "success", "the PR was closed"},
+		{"open", "failure", "it was stopped"},
+	}
+	for _, tc := range cases {
+		state, review, comments = tc.state, tc.review, nil
+		tr.queue = []trainCar{car}
+		h.landCandidates(tr, []trainCar{car}, candidates, green)
+		if len(tr.queue) != 0 || tr.pushFailures != 0 || len(comments) != 1 || !strings.Contains(comments[0], tc.ejected) {
+			t.Errorf("%s PR with st-review %s: expected ejection as %s without landing, got %d queued, %d push failures, %q", tc.state, tc.review, tc.ejected, len(tr.queue), tr.pushFailures, comments)
+		}
+	}
+
+	// Stopped while its candidate was validated.
+	state, review, comments = "open", "success", nil
+	tr.queue = []trainCar{car}
+	h.leaveTrain("foo/bar", 1)
+	h.landCandidates(tr, []trainCar{car}, candidates, green)
+	if len(tr.queue) != 0 || tr.pushFailures != 0 || len(comments) != 0 {
+		t.Errorf("Expected a PR taken out of the train not to land, got %d push failures, %q", tr.pushFailures, comments)
+	}
+
+	// The base branch can't be pushed to.
+	tr.queue = []trainCar{car}
+	for i := 1; i <= maxTrainPushFailures; i++ {
+		h.landCandidates(tr, []trainCar{car}, candidates, green)
+		if i < maxTrainPushFailures && (len(tr.queue) != 1 || tr.pushFailures != i) {
+			t.Errorf("Expected attempt %d to be retried, got %d queued, %d push failures", i, len(tr.queue), tr.pushFailures)
+		}
+	}
+	if len(tr.queue) != 0 || len(comments) != 1 || !strings.Contains(comments[0], "main couldn't be updated") {
+		t.Errorf("Expected ejection after %d failed pushes, got %d queued, %q", maxTrainPushFailures, len(tr.queue), comments)
+	}
+}
+
+func TestValidateCandidatesDeadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/statuses") {
+			w.Write([]byte(`[{"state": "pending", "context": "ci"}]`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	defer func(u string) { githubAPI = u }(githubAPI)
+	githubAPI = srv.URL
+
+	h := newHandler(nil, "bot", "token", false)
+	h.settings.repos = map[string]repoSettings{
+		"foo/bar": {MaxWait: duration{time.Hour}, MaxPoll: duration{10 * time.Millisecond}},
+	}
+	tr := &train{repo: "foo/bar", base: "main"}
+	candidates := []trainCandidate{{sha: "aaa"}, {sha: "bbb"}}
+
+	// The wait asked for with the merge, rather than the repository's.
+	t0 := time.Now()
+	cars := []trainCar{{deadline: t0.Add(100 * time.Millisecond)}, {deadline: t0.Add(time.Hour)}}
+	results := h.validateCandidates(tr, cars, candidates)
+	if elapsed := time.Since(t0); elapsed < 100*time.Millisecond || elapsed > 10*time.Second || results[0].state != statePending {
+		t.Errorf("Expected to give up on the first car at its deadline, got %s after %v", results[0].state, elapsed)
+	}
+
+	// Nothing is waited for during maintenance.
+	h.maintenance = &maintenance{On: true}
+	t0 = time.Now()
+	cars = []trainCar{{deadline: t0.Add(time.Hour)}, {deadline: t0.Add(time.Hour)}}
+	h.validateCandidates(tr, cars, candidates)
+	if elapsed := time.Since(t0); elapsed > 10*time.Second {
+		t.Errorf("Waited for %v during maintenance", elapsed)
+	}
+}
+
+func TestTrainPausedForMaintenance(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+	stateDir = t.TempDir()
+	h := newHandler(nil, "bot", "token", false)
+	h.settings.repos = map[string]repoSettings{
+		"foo/bar": {MaxPoll: duration{10 * time.Millisecond}},
+	}
+	h.maintenance = &maintenance{On: true}
+	tr := &train{repo: "foo/bar", base: "main", running: true}
+
+	done := make(chan struct{})
+	go func() {
+		h.runTrain(tr)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("Expected the train to wait out maintenance")
+	default:
+	}
+
+	if err := h.maintenance.set(false, ""); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the train to run after maintenance")
+	}
+	if tr.running {
+		t.Error("Expected the empty train to stop")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const trainStatsName = "train-stats.json"
+
+// ejectionStats count the ejections from merge trains on a repository. An
+// ejection is considered flaky when the same revision of the PR later lands
+// unchanged, as whatever failed evidently wasn't the PR's fault.
+type ejectionStats struct {
+	Ejections int                        `json:"ejections"`
+	Flaky     int                        `json:"flaky"`
+	Reasons   map[string]int             `json:"reasons"`  // "checks", "conflict" or "timeout" -> ejections
+	Contexts  map[string]*contextStats   `json:"contexts"` // failed context -> ejections
+	Pending   map[string]ejectedRevision `json:"pending"`  // PR number -> last ejection, until it lands
+}
+
+type contextStats struct {
+	Ejections int `json:"ejections"`
+	Flaky     int `json:"flaky"`
+}
+
+type ejectedRevision struct {
+	SHA      string   `json:"sha"`
+	Contexts []string `json:"contexts"`
+}
+
+// trainStats tracks the ejection statistics for all repositories, keeping
+// them across restarts.
+type trainStats struct {
+	mut   sync.Mutex
+	repos map[string]*ejectionStats
+}
+
+func loadTrainStats() (*trainStats, error) {
+	s := &trainStats{repos: make(map[string]*ejectionStats)}
+	if err := loadState(trainStatsName, &s.repos); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *trainStats) forRepo(repo string) *ejectionStats {
+	rs := s.repos[repo]
+	if rs == nil {
+		rs = &ejectionStats{}
+		s.repos[repo] = rs
+	}
+	if rs.Reasons == nil {
+		rs.Reasons = make(map[string]int)
+	}
+	if rs.Contexts == nil {
+		rs.Contexts = make(map[string]*contextStats)
+	}
+	if rs.Pending == nil {
+		rs.Pending = make(map[string]ejectedRevision)
+	}
+	return rs
+}
+
+func (s *trainStats) ejected(repo string, p pr, reason string, contexts []string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	rs := s.forRepo(repo)
+	rs.Ejections++
+	rs.Reasons[reason]++
+	for _, ctx := range contexts {
+		cs := rs.Contexts[ctx]
+		if cs == nil {
+			cs = &contextStats{}
+			rs.Contexts[ctx] = cs
+		}
+		cs.Ejections++
+	}
+	rs.Pending[prKey(p)] = ejectedRevision{SHA: p.Head.SHA, Contexts: contexts}
+	s.save()
+}
+
+func (s *trainStats) landed(repo string, p pr) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	rs := s.forRepo(repo)
+	ej, ok := rs.Pending[prKey(p)]
+	if !ok {
+		return
+	}
+	delete(rs.Pending, prKey(p))
+	if ej.SHA != "" && ej.SHA == p.Head.SHA {
+		rs.Flaky++
+		for _, ctx := range ej.Contexts {
+			if cs := rs.Contexts[ctx]; cs != nil {
+				cs.Flaky++
+			}
+		}
+	}
+	s.save()
+}
+
+func (s *trainStats) save() {
+	if err := saveState(trainStatsName, s.repos); err != nil {
+		log.Println("Saving train statistics:", err)
+	}
+}
+
+func prKey(p pr) string {
+	return strconv.Itoa(p.Number)
+}
+
+// serveTrainStats answers /admin/train-stats with the ejection statistics
+// for all repositories, or the one given as repo=.
+func (a *adminAPI) serveTrainStats(w http.ResponseWriter, r *http.Request) {
+	s := a.h.trainStats
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if repo := r.URL.Query().Get("repo"); repo != "" {
+		json.NewEncoder(w).Encode(s.repos[repo])
+		return
+	}
+	json.NewEncoder(w).Encode(s.repos)
+}
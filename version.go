@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The version bump levels, from least to most significant.
+var bumpLevels = []string{"patch", "minor", "major"}
+
+// defaultVersionLabels maps PR labels to bump levels unless configured
+// otherwise.
+var defaultVersionLabels = map[string]string{
+	"major": "major",
+	"minor": "minor",
+	"patch": "patch",
+}
+
+const defaultVersionFile = "VERSION"
+
+var bumpTrailerRe = regexp.MustCompile(`(?m)^Version-Bump: (\w+)\s*$`)
+
+func bumpRank(level string) int {
+	for i, l := range bumpLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// highestBump returns the most significant of the levels, or "" if none.
+func highestBump(levels []string) string {
+	res := ""
+	for _, l := range levels {
+		if bumpRank(l) > bumpRank(res) {
+			res = l
+		}
+	}
+	return res
+}
+
+// labelBump returns the bump level for a PR with the given labels.
+func labelBump(labels []string, mapping map[string]string) string {
+	if len(mapping) == 0 {
+		mapping = defaultVersionLabels
+	}
+	var levels []string
+	for _, l := range labels {
+		levels = append(levels, mapping[l])
+	}
+	return highestBump(levels)
+}
+
+// bumpVersion increments the version by the level, keeping any "v" prefix
+// and dropping any pre-release or build suffix.
+func bumpVersion(version, level string) (string, error) {
+	prefix := ""
+	if strings.HasPrefix(version, "v") {
+		prefix, version = "v", version[1:]
+	}
+	if idx := strings.IndexAny(version, "-+"); idx >= 0 {
+		version = version[:idx]
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%q is not a semantic version", prefix+version)
+	}
+	var n [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a semantic version", prefix+version)
+		}
+		n[i] = v
+	}
+
+	switch level {
+	case "major":
+		n = [3]int{n[0] + 1, 0, 0}
+	case "minor":
+		n = [3]int{n[0], n[1] + 1, 0}
+	case "patch":
+		n[2]++
+	default:
+		return "", fmt.Errorf("unknown version bump %q", level)
+	}
+	return fmt.Sprintf("%s%d.%d.%d", prefix, n[0], n[1], n[2]), nil
+}
+
+// prBump returns the bump level given by the labels on the PR.
+func (h *handler) prBump(repo string, number int) string {
+	var labels []struct {
+		Name string
+	}
+	url := fmt.Sprintf("%s/repos/%s/issues/%d/labels", githubAPI, repo, number)
+	if err := apiRequest("GET", url, nil, &labels, h.username, h.token); err != nil {
+		return ""
+	}
+	var names []string
+	for _, l := range labels {
+		names = append(names, l.Name)
+	}
+	return labelBump(names, h.settings.forRepo(repo).VersionLabels)
+}
+
+// versionAfterMerge bumps the version file or computes the next tag after a
+// merge into branch, as configured, returning a note on the result. The
+// clone of the repository must have the merged branch checked out.
+func (h *handler) versionAfterMerge(repo, branch, level string) (string, error) {
+	rs := h.settings.forRepo(repo)
+	switch rs.Versioning {
+	case "":
+		return "", nil
+
+	case "file":
+		if level == "" {
+			return "", nil
+		}
+		file := rs.VersionFile
+		if file == "" {
+			file = defaultVersionFile
+		}
+		bs, err := ioutil.ReadFile(filepath.Join(repo, file))
+		if err != nil {
+			return "", err
+		}
+		next, err := bumpVersion(strings.TrimSpace(string(bs)), level)
+		if err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(filepath.Join(repo, file), []byte(next+"\n"), 0644); err != nil {
+			return "", err
+		}
+		s := newScript().in(repo)
+		s.run("git", "add", file)
+		s.run("git", "commit", "-m", "Bump version to "+next)
+		s.run("git", "push", "origin", branch)
+		if s.Error() != nil {
+			return "", fmt.Errorf("%s", s.output.String())
+		}
+		return fmt.Sprintf("Bumped the version to %s.", next), nil
+
+	case "tag":
+		s := newScript().in(repo)
+		tag := s.run("git", "describe", "--tags", "--abbrev=0", "HEAD")
+		if s.Error() != nil {
+			return "", nil // no release yet
+		}
+		var levels []string
+		for _, m := range bumpTrailerRe.FindAllStringSubmatch(s.run("git", "log", "--format=%b", tag+"..HEAD"), -1) {
+			levels = append(levels, m[1])
+		}
+		bump := highestBump(levels)
+		if bump == "" {
+			return "", nil
+		}
+		next, err := bumpVersion(tag, bump)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("The next release will be %s.", next), nil
+
+	default:
+		return "", fmt.Errorf("unknown versioning %q", rs.Versioning)
+	}
+}
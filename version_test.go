@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestBumpVersion(t *testing.T) {
+	cases := []struct {
+		version, level, next string
+	}{
+		{"1.2.3", "patch", "1.2.4"},
+		{"v1.2.3", "minor", "v1.3.0"},
+		{"v1.2.3-rc1", "major", "v2.0.0"},
+	}
+	for _, tc := range cases {
+		if next, err := bumpVersion(tc.version, tc.level); err != nil || next != tc.next {
+			t.Errorf("bumpVersion(%q, %q) = %q, %v; expected %q", tc.version, tc.level, next, err, tc.next)
+		}
+	}
+	if _, err := bumpVersion("1.2", "patch"); err == nil {
+		t.Error("Expected error for non semantic version")
+	}
+}
+
+func TestLabelBump(t *testing.T) {
+	if b := labelBump([]string{"bug", "patch", "minor"}, nil); b != "minor" {
+		t.Error("Expected minor, got", b)
+	}
+	if b := labelBump([]string{"bug"}, nil); b != "" {
+		t.Error("Expected no bump, got", b)
+	}
+	if b := labelBump([]string{"breaking"}, map[string]string{"breaking": "major"}); b != "major" {
+		t.Error("Expected major, got", b)
+	}
+}
@@ -1,8 +1,6 @@
 package main
 
 import (
-	"crypto/hmac"
-	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -15,40 +13,71 @@ import (
 
 type prHandler func(p pr)
 type commentHandler func(c comment)
+type milestoneHandler func(m milestoneEvent)
+type ciHandler func(e ciEvent)
+type pushHandler func(e pushEvent)
 
 // The webhook listens on addr for commands to username and send them to the outbox.
 type webhook struct {
-	addr            string
-	secret          string
-	username        string
-	token           string
-	commentHandlers map[string]commentHandler
-	prHandlers      []prHandler
-	listener        net.Listener
+	addr              string
+	secret            string
+	secretFor         func(repo string) string // the secret of the repository, if not secret
+	forgeFor          func(repo string) string // where the repository is hosted, for other forges' events
+	username          string
+	token             string
+	commentHandlers   map[string]commentHandler
+	prHandlers        []prHandler
+	milestoneHandlers []milestoneHandler
+	ciHandlers        []ciHandler
+	pushHandlers      []pushHandler
+	maintenance       *maintenance // queues events instead while on, if set
+	listener          net.Listener
+	mux               *http.ServeMux
 }
 
 func newWebhook(addr, secret, username, token string) *webhook {
-	return &webhook{
+	h := &webhook{
 		addr:            addr,
 		secret:          secret,
 		username:        username,
 		token:           token,
 		commentHandlers: make(map[string]commentHandler),
+		mux:             http.NewServeMux(),
 	}
+	// Registered once, as Serve may be restarted.
+	h.mux.Handle("/", h)
+	return h
 }
 
 func (h *webhook) handlePR(fn prHandler) {
 	h.prHandlers = append(h.prHandlers, fn)
 }
 
+func (h *webhook) handleMilestone(fn milestoneHandler) {
+	h.milestoneHandlers = append(h.milestoneHandlers, fn)
+}
+
+// handleCI registers fn for status, check run and check suite events.
+func (h *webhook) handleCI(fn ciHandler) {
+	h.ciHandlers = append(h.ciHandlers, fn)
+}
+
+// handlePush registers fn for push events.
+func (h *webhook) handlePush(fn pushHandler) {
+	h.pushHandlers = append(h.pushHandlers, fn)
+}
+
 func (h *webhook) handleComment(prefix string, fn commentHandler) {
 	h.commentHandlers[prefix] = fn
 }
 
-func (h *webhook) Serve() {
-	s := http.NewServeMux()
-	s.Handle("/", h)
+// handleHTTP serves requests for the given path with something other than
+// the webhook receiver.
+func (h *webhook) handleHTTP(pattern string, handler http.Handler) {
+	h.mux.Handle(pattern, handler)
+}
 
+func (h *webhook) Serve() {
 	l, err := net.Listen("tcp", h.addr)
 	if err != nil {
 		log.Println("Listen:", err)
@@ -57,7 +86,7 @@ func (h *webhook) Serve() {
 
 	log.Println("Web hook receiver listening on", l.Addr())
 	h.listener = l
-	http.Serve(l, h)
+	http.Serve(l, h.mux)
 }
 
 func (h *webhook) Stop() {
@@ -76,26 +105,117 @@ func (h *webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if event := r.Header.Get("X-Gitlab-Event"); event != "" {
+		h.serveGitLab(w, r, event, body)
+		return
+	}
+	// Gitea also sends X-Github-Event, but signs differently.
+	if event := giteaEventHeader(r.Header); event != "" {
+		h.serveGitea(w, r, event, body)
+		return
+	}
+
+	// Unsigned deliveries and those that don't match the secret of the
+	// repository get 401 Unauthorized.
+	repo := deliveryRepo(body)
+	if !verifySignature(body, h.secretOf(repo), r.Header) {
+		log.Printf("Rejected %s delivery for %q from %s with an incorrect or missing signature", r.Header.Get("X-Github-Event"), repo, r.RemoteAddr)
+		metrics.add("deliveries_rejected", 1)
+		http.Error(w, "Incorrect Secret", http.StatusUnauthorized)
+		return
+	}
+	// GitHub Enterprise Server says which instance a delivery is from.
+	if host := r.Header.Get("X-Github-Enterprise-Host"); host != "" && !strings.EqualFold(host, gitHost(githubAPI)) {
+		log.Printf("Rejected %s delivery for %q from GitHub Enterprise host %s, not %s", r.Header.Get("X-Github-Event"), repo, host, gitHost(githubAPI))
+		http.Error(w, "Wrong GitHub Enterprise host", http.StatusBadRequest)
+		return
+	}
+	h.accept(w, r.Header.Get("X-Github-Event"), body)
+}
 
-	// The signature is a SHA1 HMAC of the request body.
-	mac := hmac.New(sha1.New, []byte(h.secret))
-	mac.Write(body)
-	sig := fmt.Sprintf("sha1=%x", mac.Sum(nil))
+// secretOf returns the webhook secret of the repository.
+func (h *webhook) secretOf(repo string) string {
+	if h.secretFor != nil {
+		return h.secretFor(repo)
+	}
+	return h.secret
+}
 
-	// If it doesn't match the included header, return 401 Unauthorized
-	// and abort.
-	if hubSig := r.Header.Get("X-Hub-Signature"); hubSig != sig {
-		log.Printf("Incorrect signature; %s != %s", hubSig, sig)
+// serveGitLab receives a GitLab event, handling it as the GitHub event it
+// amounts to.
+func (h *webhook) serveGitLab(w http.ResponseWriter, r *http.Request, event string, body []byte) {
+	repo := gitlabDeliveryRepo(body)
+	verified := verifyGitLabToken(h.secretOf(repo), r.Header)
+	h.serveTranslated(w, r, forgeGitLab, "GitLab", event, repo, verified, body)
+}
+
+// serveGitea receives a Gitea or Forgejo event, handling it as the GitHub
+// event it amounts to.
+func (h *webhook) serveGitea(w http.ResponseWriter, r *http.Request, event string, body []byte) {
+	repo := deliveryRepo(body)
+	verified := verifyGiteaSignature(body, h.secretOf(repo), r.Header)
+	h.serveTranslated(w, r, forgeGitea, "Gitea", event, repo, verified, body)
+}
+
+// serveTranslated accepts a verified event from another forge once
+// translated. Only repositories set up as on that forge take its events,
+// so a repository there can't pass for one on GitHub of the same name.
+func (h *webhook) serveTranslated(w http.ResponseWriter, r *http.Request, name, title, event, repo string, verified bool, body []byte) {
+	if !verified {
+		log.Printf("Rejected %s %s delivery for %q from %s with an incorrect or missing secret", title, event, repo, r.RemoteAddr)
+		metrics.add("deliveries_rejected", 1)
 		http.Error(w, "Incorrect Secret", http.StatusUnauthorized)
 		return
 	}
+	f, ok := forges[name].(translator)
+	if !ok || h.forgeFor == nil || h.forgeFor(repo) != name {
+		log.Printf("Rejected %s %s delivery for %q, which isn't set up as on %s", title, event, repo, title)
+		http.Error(w, "Not a "+title+" repository", http.StatusBadRequest)
+		return
+	}
+	eventType, translated, err := f.translate(event, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if eventType == "" {
+		return // nothing we act on
+	}
+	h.accept(w, eventType, translated)
+}
 
-	switch eventType := r.Header.Get("X-Github-Event"); eventType {
+// accept handles a verified event, unless it's queued for later.
+func (h *webhook) accept(w http.ResponseWriter, eventType string, body []byte) {
+	if !json.Valid(body) {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if h.maintenance.active() {
+		queued, err := h.maintenance.queue(eventType, body)
+		if err != nil {
+			log.Println("Queueing event:", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if queued {
+			h.maintenanceBanner(eventType, body)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+	}
+
+	if err := h.dispatch(eventType, body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// dispatch hands the event to the registered handlers.
+func (h *webhook) dispatch(eventType string, body []byte) error {
+	switch eventType {
 	case "issue_comment":
 		var c comment
 		if err := json.Unmarshal(body, &c); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			return err
 		}
 
 		body := c.parseBody()
@@ -127,8 +247,7 @@ func (h *webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "pull_request":
 		var p pr
 		if err := json.Unmarshal(body, &p); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			return err
 		}
 
 		log.Printf("Handling pull request %d", p.Number)
@@ -136,7 +255,63 @@ func (h *webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			fn(p)
 		}
 
+	case "milestone":
+		var m milestoneEvent
+		if err := json.Unmarshal(body, &m); err != nil {
+			return err
+		}
+
+		log.Printf("Handling milestone %s on %s", m.Milestone.Title, m.Repository.FullName)
+		for _, fn := range h.milestoneHandlers {
+			fn(m)
+		}
+
+	case "status", "check_run", "check_suite":
+		var e ciEvent
+		if err := json.Unmarshal(body, &e); err != nil {
+			return err
+		}
+
+		for _, fn := range h.ciHandlers {
+			fn(e)
+		}
+
+	case "push":
+		var e pushEvent
+		if err := json.Unmarshal(body, &e); err != nil {
+			return err
+		}
+
+		for _, fn := range h.pushHandlers {
+			fn(e)
+		}
+
 	default:
 		log.Printf("Unknown event type %q, ignored", eventType)
 	}
+	return nil
+}
+
+// replay handles an event queued during maintenance.
+func (h *webhook) replay(eventType string, body []byte) {
+	if err := h.dispatch(eventType, body); err != nil {
+		log.Printf("Replaying %s event: %v", eventType, err)
+	}
+}
+
+// maintenanceBanner answers commands received during maintenance, saying
+// they'll be handled when it's over.
+func (h *webhook) maintenanceBanner(eventType string, body []byte) {
+	if eventType != "issue_comment" {
+		return
+	}
+	var c comment
+	if err := json.Unmarshal(body, &c); err != nil {
+		return
+	}
+	if c.parseBody().recipient != h.username {
+		return
+	}
+	log.Printf("Queued comment by %s on %s during maintenance", c.Sender.Login, c.Repository.FullName)
+	c.post(maintenanceResponse(c, h.maintenance.banner()), h.username, h.token)
 }
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML parses the subset of YAML that configuration files are written
+// in: block mappings and sequences, flow sequences of scalars, comments and
+// plain, single and double quoted scalars. The result is made of
+// map[string]interface{}, []interface{} and scalars, like a decoded JSON
+// value.
+func parseYAML(data string) (interface{}, error) {
+	p := &yamlParser{}
+	for i, text := range strings.Split(data, "\n") {
+		text = strings.TrimRight(stripYAMLComment(text), " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't be used for indentation", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	v, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return v, nil
+}
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	if isSequenceItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	res := []interface{}{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent || (l.indent == indent && !isSequenceItem(l.text)) {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+		}
+
+		item := strings.TrimLeft(l.text[1:], " ")
+		var v interface{}
+		var err error
+		switch {
+		case item == "":
+			p.pos++
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				v, err = p.block(p.lines[p.pos].indent)
+			}
+		case isSequenceItem(item) || isMappingEntry(item):
+			// The item is a nested block starting on the same line.
+			p.lines[p.pos] = yamlLine{num: l.num, indent: indent + len(l.text) - len(item), text: item}
+			v, err = p.block(p.lines[p.pos].indent)
+		default:
+			v, err = yamlScalar(item)
+			if err != nil {
+				err = fmt.Errorf("line %d: %v", l.num, err)
+			}
+			p.pos++
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	res := make(map[string]interface{})
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+		}
+		key, value, ok := splitYAMLEntry(l.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", l.num)
+		}
+		if _, dup := res[key]; dup {
+			return nil, fmt.Errorf("line %d: %s is given twice", l.num, key)
+		}
+		p.pos++
+
+		var v interface{}
+		var err error
+		if value == "" {
+			// A nested block, or null. Sequences may be indented as
+			// much as the key they belong to.
+			if p.pos < len(p.lines) {
+				next := p.lines[p.pos]
+				if next.indent > indent || (next.indent == indent && isSequenceItem(next.text)) {
+					v, err = p.block(next.indent)
+				}
+			}
+		} else {
+			v, err = yamlScalar(value)
+			if err != nil {
+				err = fmt.Errorf("line %d: %v", l.num, err)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		res[key] = v
+	}
+	return res, nil
+}
+
+// isMappingEntry returns whether the text starts a "key: value" entry.
+func isMappingEntry(text string) bool {
+	_, _, ok := splitYAMLEntry(text)
+	return ok
+}
+
+// splitYAMLEntry splits "key: value" at the first colon followed by space
+// or the end of the line, outside of quotes.
+func splitYAMLEntry(text string) (string, string, bool) {
+	quote := rune(0)
+	for i, r := range text {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case i == 0 && (r == '"' || r == '\''):
+			quote = r
+		case r == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			key, err := yamlScalar(strings.TrimSpace(text[:i]))
+			if err != nil {
+				return "", "", false
+			}
+			return fmt.Sprint(key), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// stripYAMLComment removes a comment, which starts with # at the start of
+// the line or after whitespace, outside of quotes.
+func stripYAMLComment(text string) string {
+	quote := rune(0)
+	for i, r := range text {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			if i == 0 || strings.ContainsRune(" :-[,", rune(text[i-1])) {
+				quote = r
+			}
+		case r == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+func yamlScalar(s string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(s, "\""):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("unterminated string %s", s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("unterminated sequence %s", s)
+		}
+		res := []interface{}{}
+		if inner := strings.TrimSpace(s[1 : len(s)-1]); inner != "" {
+			for _, item := range strings.Split(inner, ",") {
+				v, err := yamlScalar(strings.TrimSpace(item))
+				if err != nil {
+					return nil, err
+				}
+				res = append(res, v)
+			}
+		}
+		return res, nil
+	case s == "{}":
+		return map[string]interface{}{}, nil
+	case strings.HasPrefix(s, "{"), s == "|", s == ">", strings.HasPrefix(s, "|-"), strings.HasPrefix(s, ">-"), strings.HasPrefix(s, "&"), strings.HasPrefix(s, "*"):
+		return nil, fmt.Errorf("unsupported YAML %s", s)
+	}
+
+	switch s {
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	case "null", "Null", "NULL", "~":
+		return nil, nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	return s, nil
+}
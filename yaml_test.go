@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	cases := []struct {
+		in   string
+		json string
+	}{
+		{"", "null"},
+		{"a: 1\nb: two # comment\nc: 'it''s'\nd: \"x: #y\"\ne: true\nf: ~\n", `{"a":1,"b":"two","c":"it's","d":"x: #y","e":true,"f":null}`},
+		{"# header\n---\nlist:\n  - a\n  - b\nflow: [1, x, \"y\"]\n", `{"flow":[1,"x","y"],"list":["a","b"]}`},
+		{"list:\n- a\n- b\nnext: c\n", `{"list":["a","b"],"next":"c"}`},
+		{"outer:\n  inner:\n    deep: 1.5\n  url: https://example.com/x\n", `{"outer":{"inner":{"deep":1.5},"url":"https://example.com/x"}}`},
+		{"items:\n  - name: a\n    value: 1\n  - name: b\n", `{"items":[{"name":"a","value":1},{"name":"b"}]}`},
+		{"- - 1\n  - 2\n- 3\n", `[[1,2],3]`},
+	}
+	for _, tc := range cases {
+		v, err := parseYAML(tc.in)
+		if err != nil {
+			t.Errorf("parseYAML(%q): %v", tc.in, err)
+			continue
+		}
+		bs, _ := json.Marshal(v)
+		if string(bs) != tc.json {
+			t.Errorf("parseYAML(%q) = %s, expected %s", tc.in, bs, tc.json)
+		}
+	}
+
+	for _, in := range []string{"a: 1\n  b: 2\n", "just text\n", "a: 1\na: 2\n", "a: |\n  text\n", "a:\n\t- b\n"} {
+		if _, err := parseYAML(in); err == nil {
+			t.Errorf("Expected an error parsing %q", in)
+		}
+	}
+}